@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/scttfrdmn/ark/internal/agent/outbox"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// breakOutbox points s's audit outbox at a closed store, so that recording
+// an event fails
+func breakOutbox(t *testing.T, s *server) {
+	t.Helper()
+	dir := t.TempDir()
+	st, err := store.New(filepath.Join(dir, "outbox.db"), store.Options{KeyFile: filepath.Join(dir, "outbox.key")})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	st.Close()
+	s.audit = outbox.New(st, getBackendURL)
+}
+
+// The backend's user_id is a user's UUID, which the agent does not know, so
+// audit events carry the OS user in their details instead
+func TestRecordAuditPutsOSUserInDetails(t *testing.T) {
+	t.Setenv("USER", "researcher")
+	s := newTestServer(t)
+
+	if err := s.recordAudit(map[string]interface{}{
+		"action":        "s3:CreateBucket",
+		"resource_type": "s3:bucket",
+		"resource_id":   "lab-data",
+		"status":        "success",
+		"details":       map[string]interface{}{"profile": "default"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.recordAudit(map[string]interface{}{
+		"action":        "s3:ListBuckets",
+		"resource_type": "s3:bucket",
+		"resource_id":   "*",
+		"status":        "success",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	queued, err := s.store.QueuedAuditEvents(10)
+	if err != nil || len(queued) != 2 {
+		t.Fatalf("queued = %v, %v", queued, err)
+	}
+	for _, q := range queued {
+		var event struct {
+			UserID  *string                `json:"user_id"`
+			Details map[string]interface{} `json:"details"`
+		}
+		if err := json.Unmarshal(q.Event, &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.UserID != nil {
+			t.Errorf("event has user_id %q: %s", *event.UserID, q.Event)
+		}
+		if event.Details["os_user"] != "researcher" {
+			t.Errorf("event details lack the OS user: %s", q.Event)
+		}
+	}
+}
+
+func TestRequestFailsWhenAuditEventCannotBeRecorded(t *testing.T) {
+	s := newTestServer(t)
+	breakOutbox(t, s)
+
+	w := httptest.NewRecorder()
+	s.handleRekeyCredentials(w, httptest.NewRequest(http.MethodPost, "/api/credentials/rekey", strings.NewReader("{}")))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500: %s", w.Code, w.Body)
+	}
+}
+
+// A fail-open decision that cannot be audited is not made
+func TestDegradedGateRefusesWhenAuditEventCannotBeRecorded(t *testing.T) {
+	s := newTestServer(t)
+	backend := newPolicyBackend(t, training.GateFailOpen)
+	backend.enroll(t, s)
+	details := map[string]interface{}{"bucket_name": "lab-data"}
+	if allowed, _, err := s.checkTrainingGate("s3:CreateBucket", "s3:bucket", details); !allowed || err != nil {
+		t.Fatalf("gate = %v, %v", allowed, err)
+	}
+
+	backend.Close()
+	breakOutbox(t, s)
+	allowed, _, err := s.checkTrainingGate("s3:DeleteBucket", "s3:bucket", details)
+	if allowed || err == nil || errors.Is(err, errGateUnavailable) {
+		t.Fatalf("gate = %v, %v; want refused for the audit failure", allowed, err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// loadOrCreateToken reads the API token, creating a new one if the file is
+// missing or readable by other users
+func loadOrCreateToken(path string) (string, error) {
+	info, err := os.Stat(path)
+	switch {
+	case err == nil:
+		if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+			slog.Warn("agent token is accessible to other users; generating a new one",
+				"path", path,
+				"mode", info.Mode().Perm().String(),
+			)
+			break
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read agent token: %w", err)
+		}
+		if token := strings.TrimSpace(string(data)); token != "" {
+			return token, nil
+		}
+	case !os.IsNotExist(err):
+		return "", fmt.Errorf("stat agent token: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate agent token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	// Replace rather than rewrite, so a loosely permissioned file is not reused
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(token+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("write agent token: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("write agent token: %w", err)
+	}
+
+	slog.Info("agent token created", "path", path)
+	return token, nil
+}
+
+// requireToken rejects requests without the agent's bearer token. Requests
+// on the Unix socket are already limited to the owning user.
+func requireToken(token string) func(http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fromSocket(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			got := []byte(r.Header.Get("Authorization"))
+			if subtle.ConstantTimeCompare(got, expected) != 1 {
+				reason := "invalid token"
+				if len(got) == 0 {
+					reason = "missing token"
+				}
+				slog.Warn("rejected unauthenticated request",
+					"reason", reason,
+					"method", r.Method,
+					"path", r.URL.Path,
+					"remote_addr", r.RemoteAddr,
+					"origin", r.Header.Get("Origin"),
+					"user_agent", r.UserAgent(),
+					"request_id", middleware.GetReqID(r.Context()),
+				)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="ark-agent"`)
+				writeJSON(w, http.StatusUnauthorized, map[string]string{
+					"error": "unauthorized: missing or invalid agent token",
+				})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the default logger's output to a buffer for the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+		wantLog       string
+	}{
+		{"missing", "", http.StatusUnauthorized, "reason=\"missing token\""},
+		{"wrong", "Bearer not-the-token", http.StatusUnauthorized, "reason=\"invalid token\""},
+		{"without scheme", "secret-token", http.StatusUnauthorized, "reason=\"invalid token\""},
+		{"valid", "Bearer secret-token", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			req := httptest.NewRequest(http.MethodGet, "/api/credentials", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			requireToken("secret-token")(okHandler).ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			got := logs.String()
+			if tt.wantLog == "" {
+				if got != "" {
+					t.Errorf("accepted request was logged: %s", got)
+				}
+				return
+			}
+			if !strings.Contains(got, "rejected unauthenticated request") || !strings.Contains(got, tt.wantLog) || !strings.Contains(got, "path=/api/credentials") {
+				t.Errorf("log = %q, want the rejection with %s", got, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestRequireTokenTrustsSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no Unix sockets")
+	}
+	// Socket paths are limited to about 100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "ark-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock, err := listenSocket(filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := &http.Server{Handler: requireToken("secret-token")(okHandler), ConnContext: markSocketConn}
+	go srv.Serve(sock)
+	go srv.Serve(tcp)
+	t.Cleanup(func() { srv.Close() })
+
+	viaSocket := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", filepath.Join(dir, "agent.sock"))
+		},
+	}}
+	resp, err := viaSocket.Get("http://agent/api/credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("socket request without a token: status %d, want 200", resp.StatusCode)
+	}
+
+	resp, err = http.Get("http://" + tcp.Addr().String() + "/api/credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("TCP request without a token: status %d, want 401", resp.StatusCode)
+	}
+}
+
+func checkTokenMode(t *testing.T, path string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Fatalf("token file mode = %v, want 0600", mode)
+	}
+}
+
+func TestLoadOrCreateToken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.token")
+
+	token, err := loadOrCreateToken(path)
+	if err != nil || len(token) != 64 {
+		t.Fatalf("create token = %q, %v", token, err)
+	}
+	if runtime.GOOS != "windows" {
+		checkTokenMode(t, path)
+	}
+
+	again, err := loadOrCreateToken(path)
+	if err != nil || again != token {
+		t.Fatalf("reload token = %q, %v; want %q", again, err, token)
+	}
+
+	// An empty file holds no token
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token, err = loadOrCreateToken(path); err != nil || len(token) != 64 {
+		t.Fatalf("token from empty file = %q, %v", token, err)
+	}
+}
+
+// A token other users can read may have been read by them, so it is replaced
+func TestLoadOrCreateTokenReplacesExposedToken(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced")
+	}
+	path := filepath.Join(t.TempDir(), "agent.token")
+	if err := os.WriteFile(path, []byte("exposed-token\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	logs := captureLogs(t)
+
+	token, err := loadOrCreateToken(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token == "exposed-token" || len(token) != 64 {
+		t.Fatalf("token = %q, want a new one", token)
+	}
+	checkTokenMode(t, path)
+	data, err := os.ReadFile(path)
+	if err != nil || strings.TrimSpace(string(data)) != token {
+		t.Fatalf("token file holds %q, %v; want %q", data, err, token)
+	}
+	if !strings.Contains(logs.String(), "agent token is accessible to other users") {
+		t.Errorf("replacement not logged: %s", logs)
+	}
+}
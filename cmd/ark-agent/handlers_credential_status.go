@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// Credential states reported by the status endpoint
+const (
+	credentialStateOK          = "ok"
+	credentialStateExpiring    = "expiring"
+	credentialStateExpired     = "expired"
+	credentialStateRotationDue = "rotation_due"
+	credentialStateInvalid     = "invalid"
+)
+
+// expiringWindow is how close to expiry temporary credentials are flagged
+const expiringWindow = 1 * time.Hour
+
+// credentialStatus describes the lifecycle state of a stored profile
+type credentialStatus struct {
+	Profile     string     `json:"profile"`
+	Type        string     `json:"type"`
+	Region      string     `json:"region,omitempty"`
+	AccountID   string     `json:"account_id,omitempty"`
+	ARN         string     `json:"arn,omitempty"`
+	StoredAt    *time.Time `json:"stored_at,omitempty"`
+	ValidatedAt *time.Time `json:"validated_at,omitempty"`
+	Expiration  *time.Time `json:"expiration,omitempty"`
+	AgeDays     *int       `json:"age_days,omitempty"`
+	MaxAgeDays  int        `json:"max_age_days"`
+	State       string     `json:"state"`
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// handleCredentialStatus reports when each profile was stored and
+// validated, which account it belongs to and whether it has expired or is
+// due for rotation. ?profile= limits the report to one profile and
+// ?validate=true checks the credentials with STS first.
+func (s *server) handleCredentialStatus(w http.ResponseWriter, r *http.Request) {
+	profiles, err := s.store.ListCredentials()
+	if err != nil {
+		slog.Error("failed to list credentials", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to list credentials",
+		})
+		return
+	}
+
+	if name := r.URL.Query().Get("profile"); name != "" {
+		creds, ok := profiles[name]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{
+				"error": "Profile not found",
+			})
+			return
+		}
+		profiles = map[string]*store.Credentials{name: creds}
+	}
+
+	validate := r.URL.Query().Get("validate") == "true"
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]credentialStatus, 0, len(names))
+	for _, name := range names {
+		creds := profiles[name]
+
+		var validationErr error
+		if validate {
+			if updated, err := s.validateProfile(r.Context(), name, r.Header.Get(mfaCodeHeader)); err != nil {
+				validationErr = err
+			} else {
+				creds = updated
+			}
+		}
+
+		status := s.credentialStatus(name, creds)
+		if validationErr != nil {
+			status.State = credentialStateInvalid
+			status.Warnings = append(status.Warnings, "validation failed: "+validationErr.Error())
+		}
+		result = append(result, status)
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// credentialStatus computes the lifecycle state of a profile
+func (s *server) credentialStatus(profile string, creds *store.Credentials) credentialStatus {
+	status := credentialStatus{
+		Profile:    profile,
+		Type:       creds.Type(),
+		Region:     creds.Region,
+		AccountID:  creds.AccountID,
+		ARN:        creds.ARN,
+		MaxAgeDays: int(s.maxKeyAge / (24 * time.Hour)),
+		State:      credentialStateOK,
+	}
+	if !creds.StoredAt.IsZero() {
+		status.StoredAt = &creds.StoredAt
+	}
+	if !creds.ValidatedAt.IsZero() {
+		status.ValidatedAt = &creds.ValidatedAt
+	}
+	if !creds.Expiration.IsZero() {
+		status.Expiration = &creds.Expiration
+	}
+
+	switch {
+	case creds.Expired():
+		status.State = credentialStateExpired
+		status.Warnings = append(status.Warnings,
+			fmt.Sprintf("credentials expired at %s", creds.Expiration.Format(time.RFC3339)))
+	case !creds.Expiration.IsZero() && time.Until(creds.Expiration) < expiringWindow:
+		status.State = credentialStateExpiring
+		status.Warnings = append(status.Warnings,
+			fmt.Sprintf("credentials expire at %s", creds.Expiration.Format(time.RFC3339)))
+	}
+
+	if creds.LongTerm() {
+		if creds.StoredAt.IsZero() {
+			status.Warnings = append(status.Warnings,
+				"key age unknown (stored before age tracking); store or rotate the keys to start tracking")
+		} else {
+			age := int(time.Since(creds.StoredAt) / (24 * time.Hour))
+			status.AgeDays = &age
+			if time.Since(creds.StoredAt) > s.maxKeyAge && status.State == credentialStateOK {
+				status.State = credentialStateRotationDue
+				status.Warnings = append(status.Warnings,
+					fmt.Sprintf("access keys are %d days old (maximum %d); rotate them", age, status.MaxAgeDays))
+			}
+		}
+	}
+
+	if creds.ValidatedAt.IsZero() && creds.Type() == store.CredentialTypeStatic {
+		status.Warnings = append(status.Warnings, "credentials have never been validated")
+	}
+
+	return status
+}
+
+// validateProfile checks a profile's credentials with STS GetCallerIdentity
+// and records the identity and validation time on the stored profile
+func (s *server) validateProfile(ctx context.Context, profile, mfaCode string) (*store.Credentials, error) {
+	resolved, err := s.resolver.Resolve(ctx, profile, mfaCode)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := aws.NewClientFromCredentials(ctx, resolved, "")
+	if err != nil {
+		return nil, err
+	}
+
+	identity, err := client.ValidateCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update the stored record, not resolved, which may be a cached session
+	creds, err := s.store.UpdateCredential(profile, func(c *store.Credentials) {
+		c.AccountID = identity.AccountID
+		c.ARN = identity.ARN
+		c.ValidatedAt = time.Now().UTC()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("record validation: %w", err)
+	}
+
+	slog.Info("credentials validated", "profile", profile, "account_id", identity.AccountID, "arn", identity.ARN)
+
+	return creds, nil
+}
+
+// checkCredentialAge sends an audit event, once per key, for long-term
+// access keys that have passed the configured rotation age
+func (s *server) checkCredentialAge(ctx context.Context) {
+	profiles, err := s.store.ListCredentials()
+	if err != nil {
+		slog.Warn("failed to list profiles for rotation check", "error", err)
+		return
+	}
+
+	for profile, creds := range profiles {
+		if !creds.LongTerm() || creds.StoredAt.IsZero() || !creds.RotationAlertedAt.IsZero() {
+			continue
+		}
+
+		age := time.Since(creds.StoredAt)
+		if age <= s.maxKeyAge {
+			continue
+		}
+
+		slog.Warn("access keys past rotation age",
+			"profile", profile,
+			"age_days", int(age/(24*time.Hour)),
+		)
+
+		// Left unmarked if not audited, so the alert is retried
+		if err := s.recordAudit(map[string]interface{}{
+			"action":        "credentials:RotationDue",
+			"resource_type": "credentials:profile",
+			"resource_id":   profile,
+			"status":        "failure",
+			"details": map[string]interface{}{
+				"account_id":   creds.AccountID,
+				"arn":          creds.ARN,
+				"stored_at":    creds.StoredAt,
+				"age_days":     int(age / (24 * time.Hour)),
+				"max_age_days": int(s.maxKeyAge / (24 * time.Hour)),
+			},
+		}); err != nil {
+			continue
+		}
+
+		// Only for the key alerted about; the profile may have been rotated
+		// since it was listed
+		alertedKey := creds.AccessKeyID
+		_, err := s.store.UpdateCredential(profile, func(c *store.Credentials) {
+			if c.AccessKeyID == alertedKey {
+				c.RotationAlertedAt = time.Now().UTC()
+			}
+		})
+		if err != nil {
+			slog.Warn("failed to record rotation alert", "error", err, "profile", profile)
+		}
+	}
+}
@@ -1,24 +1,42 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/scttfrdmn/ark/internal/agent/aws"
 	"github.com/scttfrdmn/ark/internal/agent/store"
 )
 
-// handleSetCredentials stores AWS credentials for a profile
+// setCredentialsRequest is the body of POST /api/credentials
+type setCredentialsRequest struct {
+	Profile           string    `json:"profile"`
+	AccessKeyID       string    `json:"access_key_id"`
+	SecretAccessKey   string    `json:"secret_access_key"`
+	SessionToken      string    `json:"session_token,omitempty"`
+	Expiration        time.Time `json:"expiration,omitempty"`
+	Region            string    `json:"region"`
+	RoleARN           string    `json:"role_arn,omitempty"`
+	SourceProfile     string    `json:"source_profile,omitempty"`
+	ExternalID        string    `json:"external_id,omitempty"`
+	MFASerial         string    `json:"mfa_serial,omitempty"`
+	RoleSessionName   string    `json:"role_session_name,omitempty"`
+	CredentialProcess string    `json:"credential_process,omitempty"`
+	Validate          bool      `json:"validate,omitempty"`
+}
+
+// handleSetCredentials stores AWS credentials for a profile. A profile
+// carries access keys, names a role to assume from a source profile, or
+// names a credential_process command that prints credentials.
 func (s *server) handleSetCredentials(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Profile         string `json:"profile"`
-		AccessKeyID     string `json:"access_key_id"`
-		SecretAccessKey string `json:"secret_access_key"`
-		SessionToken    string `json:"session_token,omitempty"`
-		Region          string `json:"region"`
-	}
+	var req setCredentialsRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]string{
@@ -28,9 +46,35 @@ func (s *server) handleSetCredentials(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate required fields
-	if req.Profile == "" || req.AccessKeyID == "" || req.SecretAccessKey == "" {
+	if req.Profile == "" {
 		writeJSON(w, http.StatusBadRequest, map[string]string{
-			"error": "profile, access_key_id, and secret_access_key are required",
+			"error": "profile is required",
+		})
+		return
+	}
+
+	if req.RoleARN != "" {
+		if req.SourceProfile == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "source_profile is required with role_arn",
+			})
+			return
+		}
+		if req.SourceProfile == req.Profile {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "source_profile cannot be the profile itself",
+			})
+			return
+		}
+		if _, err := s.store.GetCredential(req.SourceProfile); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "source profile not found: " + req.SourceProfile,
+			})
+			return
+		}
+	} else if req.CredentialProcess == "" && (req.AccessKeyID == "" || req.SecretAccessKey == "") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "access_key_id and secret_access_key, role_arn and source_profile, or credential_process are required",
 		})
 		return
 	}
@@ -46,10 +90,42 @@ func (s *server) handleSetCredentials(w http.ResponseWriter, r *http.Request) {
 		SecretAccessKey: req.SecretAccessKey,
 		SessionToken:    req.SessionToken,
 		Region:          req.Region,
-		Expiration:      time.Time{}, // No expiration for long-term credentials
+		Expiration:      req.Expiration, // Zero for long-term credentials
+		RoleARN:         req.RoleARN,
+		SourceProfile:   req.SourceProfile,
+		ExternalID:      req.ExternalID,
+		MFASerial:       req.MFASerial,
+		RoleSessionName: req.RoleSessionName,
+		StoredAt:        time.Now().UTC(),
+
+		CredentialProcess: req.CredentialProcess,
+	}
+
+	// Optionally confirm the credentials work before replacing anything
+	if req.Validate {
+		identity, err := s.checkCredentials(r.Context(), req.Profile, &creds, r.Header.Get(mfaCodeHeader))
+		if err != nil {
+			var mfaErr *aws.MFARequiredError
+			if errors.As(err, &mfaErr) {
+				writeCredentialError(w, req.Profile, err)
+				return
+			}
+			slog.Warn("credential validation failed", "error", err, "profile", req.Profile)
+			status := http.StatusBadRequest
+			if errors.Is(err, aws.ErrSTSUnavailable) {
+				status = http.StatusBadGateway
+			}
+			writeJSON(w, status, map[string]string{
+				"error": err.Error(),
+			})
+			return
+		}
+		creds.AccountID = identity.AccountID
+		creds.ARN = identity.ARN
+		creds.ValidatedAt = time.Now().UTC()
 	}
 
-	// Store in BoltDB
+	// Store in the credential backend
 	if err := s.store.SetCredential(req.Profile, creds); err != nil {
 		slog.Error("failed to store credentials", "error", err, "profile", req.Profile)
 		writeJSON(w, http.StatusInternalServerError, map[string]string{
@@ -58,14 +134,90 @@ func (s *server) handleSetCredentials(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	slog.Info("credentials stored", "profile", req.Profile, "region", req.Region)
+	// Any cached session was issued for the previous settings
+	if err := s.resolver.InvalidateSession(req.Profile); err != nil {
+		slog.Warn("failed to invalidate cached session", "error", err, "profile", req.Profile)
+	}
+
+	slog.Info("credentials stored",
+		"profile", req.Profile,
+		"type", creds.Type(),
+		"region", req.Region,
+		"account_id", creds.AccountID,
+	)
 
 	writeJSON(w, http.StatusOK, map[string]string{
-		"status":  "success",
-		"profile": req.Profile,
+		"status":     "success",
+		"profile":    req.Profile,
+		"account_id": creds.AccountID,
+		"arn":        creds.ARN,
 	})
 }
 
+// checkCredentials runs STS GetCallerIdentity against credentials that have
+// not been stored yet. Role profiles are assumed from their source profile
+// first, so the check covers the trust policy as well, and credential_process
+// profiles run their command.
+func (s *server) checkCredentials(ctx context.Context, profile string, creds *store.Credentials, mfaCode string) (*aws.CallerIdentity, error) {
+	target := creds
+	if creds.Type() == store.CredentialTypeProcess {
+		var err error
+		target, err = aws.RunCredentialProcess(ctx, creds.CredentialProcess, aws.DefaultProcessTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if creds.Type() == store.CredentialTypeRole {
+		if creds.MFASerial != "" && mfaCode == "" {
+			return nil, &aws.MFARequiredError{Profile: profile, SerialNumber: creds.MFASerial}
+		}
+
+		sourceCode := mfaCode
+		if creds.MFASerial != "" {
+			sourceCode = ""
+		}
+		source, err := s.resolver.Resolve(ctx, creds.SourceProfile, sourceCode)
+		if err != nil {
+			return nil, err
+		}
+
+		client, err := aws.NewClientFromCredentials(ctx, source, creds.Region)
+		if err != nil {
+			return nil, err
+		}
+		target, err = aws.AssumeRole(ctx, client, aws.AssumeRoleInput{
+			RoleARN:         creds.RoleARN,
+			RoleSessionName: "ark-validate",
+			ExternalID:      creds.ExternalID,
+			MFASerial:       creds.MFASerial,
+			MFACode:         mfaCode,
+		})
+		if errors.Is(err, aws.ErrInvalidMFACode) {
+			return nil, &aws.MFARequiredError{Profile: profile, SerialNumber: creds.MFASerial, Rejected: true}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("assume role %s: %w", creds.RoleARN, err)
+		}
+	}
+
+	client, err := aws.NewClientFromCredentials(ctx, target, creds.Region)
+	if err != nil {
+		return nil, err
+	}
+	return client.ValidateCredentials(ctx)
+}
+
+// credentialProfile is a stored profile as listed by GET /api/credentials,
+// without its secrets
+type credentialProfile struct {
+	Profile     string     `json:"profile"`
+	Type        string     `json:"type"`
+	Region      string     `json:"region"`
+	AccountID   string     `json:"account_id,omitempty"`
+	ARN         string     `json:"arn,omitempty"`
+	ValidatedAt *time.Time `json:"validated_at,omitempty"`
+}
+
 // handleListCredentials lists all stored credential profiles
 func (s *server) handleListCredentials(w http.ResponseWriter, r *http.Request) {
 	profiles, err := s.store.ListCredentials()
@@ -78,17 +230,19 @@ func (s *server) handleListCredentials(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Convert to response format
-	type profileInfo struct {
-		Profile string `json:"profile"`
-		Region  string `json:"region"`
-	}
-
-	var result []profileInfo
+	var result []credentialProfile
 	for profile, creds := range profiles {
-		result = append(result, profileInfo{
-			Profile: profile,
-			Region:  creds.Region,
-		})
+		info := credentialProfile{
+			Profile:   profile,
+			Type:      creds.Type(),
+			Region:    creds.Region,
+			AccountID: creds.AccountID,
+			ARN:       creds.ARN,
+		}
+		if !creds.ValidatedAt.IsZero() {
+			info.ValidatedAt = &creds.ValidatedAt
+		}
+		result = append(result, info)
 	}
 
 	writeJSON(w, http.StatusOK, result)
@@ -122,9 +276,390 @@ func (s *server) handleDeleteCredentials(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := s.resolver.InvalidateSession(profile); err != nil {
+		slog.Warn("failed to invalidate cached session", "error", err, "profile", profile)
+	}
+
 	slog.Info("credentials deleted", "profile", profile)
 
 	writeJSON(w, http.StatusOK, map[string]string{
 		"status": "success",
 	})
 }
+
+// rekeyRequest is the body of POST /api/credentials/rekey
+type rekeyRequest struct {
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// handleRekeyCredentials re-wraps all credential records under a new key
+func (s *server) handleRekeyCredentials(w http.ResponseWriter, r *http.Request) {
+	var req rekeyRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	oldSource := s.store.KeySource()
+	if err := s.store.Rekey(req.Passphrase); err != nil {
+		slog.Error("failed to rekey credentials", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to rekey credentials",
+		})
+		return
+	}
+
+	slog.Info("credentials rekeyed", "from", oldSource, "to", s.store.KeySource())
+
+	if err := s.recordAudit(map[string]interface{}{
+		"action":        "credentials:Rekey",
+		"resource_type": "agent:credentials",
+		"resource_id":   "",
+		"status":        "success",
+		"details": map[string]interface{}{
+			"from_source": oldSource,
+			"to_source":   s.store.KeySource(),
+		},
+	}); err != nil {
+		writeAuditError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":     "success",
+		"key_source": s.store.KeySource(),
+	})
+}
+
+// migrateRequest is the body of POST /api/credentials/migrate
+type migrateRequest struct {
+	To string `json:"to"`
+}
+
+// handleMigrateCredentials moves all profiles to another credential backend
+// and makes it the active backend
+func (s *server) handleMigrateCredentials(w http.ResponseWriter, r *http.Request) {
+	var req migrateRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	if req.To == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "to is required",
+		})
+		return
+	}
+
+	from := s.store.Backend()
+	to, err := s.store.OpenBackend(req.To)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if from.Name() == to.Name() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "credentials are already stored in " + to.Name(),
+		})
+		return
+	}
+
+	count, err := store.MigrateCredentials(from, to)
+	var cleanup *store.CleanupError
+	if errors.As(err, &cleanup) {
+		// Every profile is in the new backend; stale copies are only untidy
+		slog.Warn("migrated credentials left in old backend", "error", err, "from", from.Name(), "profiles", cleanup.Profiles)
+	} else if err != nil {
+		slog.Error("failed to migrate credentials", "error", err, "from", from.Name(), "to", to.Name())
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to migrate credentials: " + err.Error(),
+		})
+		return
+	}
+
+	s.store.SetBackend(to)
+
+	slog.Info("credentials migrated", "from", from.Name(), "to", to.Name(), "profiles", count)
+
+	resp := map[string]interface{}{
+		"status":   "success",
+		"from":     from.Name(),
+		"to":       to.Name(),
+		"migrated": count,
+	}
+	if cleanup != nil {
+		resp["warning"] = "could not remove the old copies of some profiles from " + from.Name() + ": " + cleanup.Err.Error()
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// importedInfo is a profile created by an import
+type importedInfo struct {
+	Profile string `json:"profile"`
+	Type    string `json:"type"`
+	Region  string `json:"region,omitempty"`
+}
+
+// skippedInfo is an AWS profile an import left alone
+type skippedInfo struct {
+	Profile string `json:"profile"`
+	Reason  string `json:"reason"`
+}
+
+// importRequest is the body of POST /api/credentials/import
+type importRequest struct {
+	AWSProfiles []string `json:"aws_profiles"`
+	All         bool     `json:"all"`
+	Overwrite   bool     `json:"overwrite"`
+}
+
+// handleImportCredentials creates profiles from the AWS shared config and
+// credentials files
+func (s *server) handleImportCredentials(w http.ResponseWriter, r *http.Request) {
+	var req importRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	if !req.All && len(req.AWSProfiles) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "aws_profiles or all is required",
+		})
+		return
+	}
+
+	configPath, credentialsPath, err := aws.SharedConfigPaths()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	shared, err := aws.LoadSharedProfiles(configPath, credentialsPath)
+	if err != nil {
+		slog.Error("failed to read AWS shared config", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Failed to read AWS config: " + err.Error(),
+		})
+		return
+	}
+
+	names := req.AWSProfiles
+	if req.All {
+		names = make([]string, 0, len(shared))
+		for name := range shared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	imported := []importedInfo{}
+	skipped := []skippedInfo{}
+
+	for _, name := range names {
+		profile, ok := shared[name]
+		if !ok {
+			skipped = append(skipped, skippedInfo{Profile: name, Reason: "not found in AWS config"})
+			continue
+		}
+		if !profile.Usable() {
+			skipped = append(skipped, skippedInfo{Profile: name, Reason: "no keys, role, SSO settings or credential_process"})
+			continue
+		}
+		if !req.Overwrite {
+			if _, err := s.store.GetCredential(name); err == nil {
+				skipped = append(skipped, skippedInfo{Profile: name, Reason: "profile already exists"})
+				continue
+			}
+		}
+
+		creds := profile.Credentials()
+		creds.StoredAt = time.Now().UTC()
+		if err := s.store.SetCredential(name, creds); err != nil {
+			slog.Error("failed to store imported profile", "error", err, "profile", name)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "Failed to store profile " + name,
+			})
+			return
+		}
+		imported = append(imported, importedInfo{Profile: name, Type: creds.Type(), Region: creds.Region})
+	}
+
+	slog.Info("AWS profiles imported", "imported", len(imported), "skipped", len(skipped))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "success",
+		"imported": imported,
+		"skipped":  skipped,
+	})
+}
+
+// mfaCodeHeader carries a one-time MFA code from the CLI
+const mfaCodeHeader = "X-Ark-MFA-Code"
+
+// resolveCredentials resolves a profile for an AWS operation, writing the
+// error response itself when it fails. Profiles with an MFA device and no
+// cached session get a structured mfa_required response so the CLI can
+// prompt for a code and retry with it in the X-Ark-MFA-Code header.
+func (s *server) resolveCredentials(w http.ResponseWriter, r *http.Request, profile string) (*store.Credentials, bool) {
+	creds, err := s.resolver.Resolve(r.Context(), profile, r.Header.Get(mfaCodeHeader))
+	if err != nil {
+		writeCredentialError(w, profile, err)
+		return nil, false
+	}
+	return creds, true
+}
+
+// writeCredentialError writes the response for a failure to obtain or
+// validate credentials
+func writeCredentialError(w http.ResponseWriter, profile string, err error) {
+	var mfaErr *aws.MFARequiredError
+	var ssoErr *aws.SSOLoginRequiredError
+	switch {
+	case errors.As(err, &ssoErr):
+		slog.Info("SSO login required", "profile", ssoErr.Profile, "start_url", ssoErr.StartURL)
+		writeJSON(w, http.StatusUnauthorized, map[string]interface{}{
+			"status":    "blocked",
+			"reason":    "sso_login_required",
+			"profile":   ssoErr.Profile,
+			"start_url": ssoErr.StartURL,
+			"error":     ssoErr.Error(),
+		})
+	case errors.As(err, &mfaErr):
+		slog.Info("MFA code required", "profile", mfaErr.Profile, "rejected", mfaErr.Rejected)
+		resp := map[string]interface{}{
+			"status":     "blocked",
+			"reason":     "mfa_required",
+			"profile":    mfaErr.Profile,
+			"mfa_serial": mfaErr.SerialNumber,
+		}
+		if mfaErr.Rejected {
+			resp["error"] = aws.ErrInvalidMFACode.Error()
+		}
+		writeJSON(w, http.StatusUnauthorized, resp)
+	case errors.Is(err, store.ErrProfileNotFound):
+		slog.Error("failed to get credentials", "error", err, "profile", profile)
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Credentials not found for profile: " + profile,
+		})
+	default:
+		slog.Error("failed to get credentials", "error", err, "profile", profile)
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": "Failed to obtain credentials: " + err.Error(),
+		})
+	}
+}
+
+// handleRotateCredentials replaces a profile's IAM access key, sending an
+// audit event for every step (including rollback steps)
+func (s *server) handleRotateCredentials(w http.ResponseWriter, r *http.Request) {
+	profile := chi.URLParam(r, "profile")
+
+	creds, err := s.store.GetCredential(profile)
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Profile not found",
+		})
+		return
+	}
+	if !creds.LongTerm() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "only profiles with long-term access keys can be rotated",
+		})
+		return
+	}
+
+	// Waiting for a new key to propagate can outlast the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(2 * time.Minute)); err != nil {
+		slog.Warn("failed to extend write deadline", "error", err)
+	}
+
+	save := func(c store.Credentials) error { return s.store.SetCredential(profile, c) }
+	var steps []aws.RotationStep
+	var auditErr error
+
+	rotated, err := aws.RotateAccessKey(r.Context(), creds, aws.RotateOptions{
+		Save:    save,
+		Restore: save,
+		OnStep: func(step aws.RotationStep) {
+			steps = append(steps, step)
+			slog.Info("key rotation step",
+				"profile", profile,
+				"step", step.Name,
+				"access_key_id", step.AccessKeyID,
+				"status", step.Status,
+			)
+			if err := s.recordAudit(map[string]interface{}{
+				"action":        step.Name,
+				"resource_type": "iam:access-key",
+				"resource_id":   step.AccessKeyID,
+				"status":        step.Status,
+				"details": map[string]interface{}{
+					"profile":    profile,
+					"account_id": creds.AccountID,
+					"rotation":   true,
+					"error":      step.Error,
+				},
+			}); err != nil {
+				auditErr = err
+			}
+		},
+	})
+
+	// Sessions minted from the old key are revoked along with it
+	if rotated != nil {
+		if err := s.resolver.InvalidateSession(profile); err != nil {
+			slog.Warn("failed to invalidate cached session", "error", err, "profile", profile)
+		}
+	}
+
+	// The rotation cannot be stopped between steps, so a step that could not
+	// be audited fails the request once it is over
+	if auditErr != nil {
+		writeAuditError(w)
+		return
+	}
+
+	if err != nil {
+		slog.Error("key rotation failed", "error", err, "profile", profile)
+		code, status := http.StatusBadGateway, "failure"
+		if rotated != nil {
+			// The new key is in place; only cleanup of the old one failed
+			code, status = http.StatusOK, "partial"
+		}
+		writeJSON(w, code, map[string]interface{}{
+			"status":  status,
+			"profile": profile,
+			"error":   err.Error(),
+			"rotated": rotated != nil,
+			"steps":   steps,
+		})
+		return
+	}
+
+	slog.Info("access key rotated", "profile", profile, "access_key_id", rotated.AccessKeyID)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":            "success",
+		"profile":           profile,
+		"rotated":           true,
+		"old_access_key_id": creds.AccessKeyID,
+		"new_access_key_id": rotated.AccessKeyID,
+		"steps":             steps,
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// enrollmentConfigKey is the agent store key of the pinned backend key
+const enrollmentConfigKey = "enrollment"
+
+// errNotEnrolled means there is no pinned key to verify decisions with
+var errNotEnrolled = errors.New("agent is not enrolled with the backend; run 'ark agent enroll'")
+
+// enrollment is the backend policy signing key the agent has pinned
+type enrollment struct {
+	Algorithm   string    `json:"algorithm"`
+	KeyID       string    `json:"key_id"`
+	PublicKey   string    `json:"public_key"`  // base64
+	Fingerprint string    `json:"fingerprint"` // see training.Fingerprint
+	BackendURL  string    `json:"backend_url"`
+	EnrolledAt  time.Time `json:"enrolled_at"`
+}
+
+// key decodes the pinned key
+func (e enrollment) key() (ed25519.PublicKey, error) {
+	return training.PublicKey{Algorithm: e.Algorithm, KeyID: e.KeyID, PublicKey: e.PublicKey}.Key()
+}
+
+// enrollRequest is the body of POST /api/enrollment
+type enrollRequest struct {
+	// Fingerprint is the fingerprint the backend's key must have, as
+	// published by the institution. Whatever answers at the backend URL
+	// is not trusted to vouch for its own key.
+	Fingerprint string `json:"fingerprint"`
+
+	// Force replaces a pinned key the backend no longer uses
+	Force bool `json:"force,omitempty"`
+}
+
+// policyKey returns the pinned key policy decisions must be signed with
+func (s *server) policyKey() (ed25519.PublicKey, error) {
+	var e enrollment
+	if err := s.store.GetConfig(enrollmentConfigKey, &e); err != nil {
+		return nil, errNotEnrolled
+	}
+	pub, err := e.key()
+	if err != nil {
+		return nil, fmt.Errorf("pinned policy key: %w", err)
+	}
+	return pub, nil
+}
+
+// handleGetEnrollment returns the pinned backend key
+func (s *server) handleGetEnrollment(w http.ResponseWriter, r *http.Request) {
+	var e enrollment
+	if err := s.store.GetConfig(enrollmentConfigKey, &e); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Agent is not enrolled",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, e)
+}
+
+// handleEnroll fetches the backend's policy signing key and pins it if it
+// has the fingerprint given. A different pinned key is only replaced with
+// force.
+func (s *server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	var req enrollRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	if req.Fingerprint == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "fingerprint is required: the SHA-256 of the backend's policy key, as published by your institution",
+		})
+		return
+	}
+
+	backendURL := getBackendURL()
+	key, err := fetchPolicyKey(backendURL)
+	if err != nil {
+		slog.Error("failed to fetch policy signing key", "error", err, "backend", backendURL)
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	pub, err := key.Key()
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": fmt.Sprintf("backend policy key: %v", err),
+		})
+		return
+	}
+	fingerprint := training.Fingerprint(pub)
+	if training.NormalizeFingerprint(req.Fingerprint) != fingerprint {
+		slog.Warn("backend policy key does not have the expected fingerprint", "backend", backendURL, "key_id", key.KeyID, "fingerprint", fingerprint, "expected", req.Fingerprint)
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error":       fmt.Sprintf("the backend's policy key has fingerprint %s, not %s", fingerprint, req.Fingerprint),
+			"key_id":      key.KeyID,
+			"fingerprint": fingerprint,
+		})
+		return
+	}
+
+	var previous enrollment
+	enrolled := s.store.GetConfig(enrollmentConfigKey, &previous) == nil
+	if enrolled && previous.KeyID != key.KeyID && !req.Force {
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error":         fmt.Sprintf("the backend's policy key %s differs from the pinned key %s", key.KeyID, previous.KeyID),
+			"key_id":        key.KeyID,
+			"pinned_key_id": previous.KeyID,
+		})
+		return
+	}
+
+	e := enrollment{
+		Algorithm:   key.Algorithm,
+		KeyID:       key.KeyID,
+		PublicKey:   key.PublicKey,
+		Fingerprint: fingerprint,
+		BackendURL:  backendURL,
+		EnrolledAt:  time.Now().UTC(),
+	}
+	if err := s.store.SetConfig(enrollmentConfigKey, e); err != nil {
+		slog.Error("failed to store enrollment", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to store enrollment",
+		})
+		return
+	}
+
+	slog.Info("enrolled with backend", "backend", backendURL, "key_id", key.KeyID, "fingerprint", fingerprint)
+
+	details := map[string]interface{}{"backend_url": backendURL, "fingerprint": fingerprint}
+	if enrolled {
+		details["previous_key_id"] = previous.KeyID
+	}
+	if err := s.recordAudit(map[string]interface{}{
+		"action":        "agent:Enroll",
+		"resource_type": "agent:policy_key",
+		"resource_id":   key.KeyID,
+		"status":        "success",
+		"details":       details,
+	}); err != nil {
+		writeAuditError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, e)
+}
+
+// fetchPolicyKey gets the backend's policy signing key
+func fetchPolicyKey(backendURL string) (*training.PublicKey, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(backendURL + "/api/policies/public-key")
+	if err != nil {
+		return nil, fmt.Errorf("backend unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend returned %d for its policy key", resp.StatusCode)
+	}
+
+	var key training.PublicKey
+	if err := json.NewDecoder(resp.Body).Decode(&key); err != nil {
+		return nil, fmt.Errorf("decode policy key: %w", err)
+	}
+	if _, err := key.Key(); err != nil {
+		return nil, fmt.Errorf("backend policy key: %w", err)
+	}
+	return &key, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// enroll posts an enrollment request and returns the response status
+func enroll(t *testing.T, s *server, req enrollRequest) int {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	s.handleEnroll(rec, httptest.NewRequest(http.MethodPost, "/api/enrollment", bytes.NewReader(body)))
+	return rec.Code
+}
+
+// pinnedKeyID returns the key ID of the pinned key, if any
+func pinnedKeyID(s *server) string {
+	var e enrollment
+	if err := s.store.GetConfig(enrollmentConfigKey, &e); err != nil {
+		return ""
+	}
+	return e.KeyID
+}
+
+func TestEnrollRequiresFingerprint(t *testing.T) {
+	s := newTestServer(t)
+	backend := newPolicyBackend(t, training.GateCached)
+	fingerprint := backend.signer.Fingerprint()
+
+	if code := enroll(t, s, enrollRequest{}); code != http.StatusBadRequest {
+		t.Fatalf("enroll without a fingerprint = %d, want 400", code)
+	}
+	if code := enroll(t, s, enrollRequest{Fingerprint: strings.Repeat("0", 64)}); code != http.StatusConflict {
+		t.Fatalf("enroll with another key's fingerprint = %d, want 409", code)
+	}
+	if pinnedKeyID(s) != "" {
+		t.Fatal("pinned a key whose fingerprint was not confirmed")
+	}
+
+	// As a person may copy it
+	if code := enroll(t, s, enrollRequest{Fingerprint: strings.ToUpper(fingerprint)}); code != http.StatusOK {
+		t.Fatalf("enroll = %d, want 200", code)
+	}
+	if got, want := pinnedKeyID(s), backend.signer.PublicKey().KeyID; got != want {
+		t.Fatalf("pinned key %q, want %q", got, want)
+	}
+}
+
+func TestReenrollRequiresFingerprintEvenWithForce(t *testing.T) {
+	s := newTestServer(t)
+	old := newPolicyBackend(t, training.GateCached)
+	if code := enroll(t, s, enrollRequest{Fingerprint: old.signer.Fingerprint()}); code != http.StatusOK {
+		t.Fatalf("enroll = %d", code)
+	}
+
+	// The backend URL now answers with another key
+	replaced := newPolicyBackend(t, training.GateCached)
+	if code := enroll(t, s, enrollRequest{Fingerprint: old.signer.Fingerprint(), Force: true}); code != http.StatusConflict {
+		t.Fatalf("forced enroll with the old fingerprint = %d, want 409", code)
+	}
+	if code := enroll(t, s, enrollRequest{Fingerprint: replaced.signer.Fingerprint()}); code != http.StatusConflict {
+		t.Fatalf("enroll with a new key but without force = %d, want 409", code)
+	}
+	if got, want := pinnedKeyID(s), old.signer.PublicKey().KeyID; got != want {
+		t.Fatalf("pinned key %q, want the old key %q", got, want)
+	}
+
+	if code := enroll(t, s, enrollRequest{Fingerprint: replaced.signer.Fingerprint(), Force: true}); code != http.StatusOK {
+		t.Fatalf("forced enroll with the new fingerprint = %d, want 200", code)
+	}
+	if got, want := pinnedKeyID(s), replaced.signer.PublicKey().KeyID; got != want {
+		t.Fatalf("pinned key %q, want the new key %q", got, want)
+	}
+}
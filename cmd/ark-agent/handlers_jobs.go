@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+)
+
+// handleListJobs lists the agent's jobs, newest first. Finished jobs are
+// kept for a week.
+func (s *server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"jobs": s.jobs.List(),
+	})
+}
+
+// handleGetJob reports a job's status and progress
+func (s *server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Get(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// handleCancelJob stops a running job. The job finishes as canceled once
+// its work has stopped.
+func (s *server) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	err := s.jobs.Cancel(id)
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+		return
+	case errors.Is(err, jobs.ErrFinished):
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error": "Job has already finished",
+		})
+		return
+	}
+
+	job, _ := s.jobs.Get(id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// handleJobEvents streams a job's status and progress as server-sent
+// events, one "job" event per change, until the job finishes or the client
+// goes away
+func (s *server) handleJobEvents(w http.ResponseWriter, r *http.Request) {
+	updates, err := s.jobs.Watch(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Job not found",
+		})
+		return
+	}
+
+	// A job can take far longer than the server's write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.Warn("failed to clear write deadline", "error", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for job := range updates {
+		data, err := json.Marshal(job)
+		if err != nil {
+			slog.Error("failed to encode job", "error", err, "job", job.ID)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "event: job\ndata: %s\n\n", data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -1,28 +1,60 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
-	"time"
+	"strings"
 
 	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+	"github.com/scttfrdmn/ark/internal/config"
 )
 
+// createBucketRequest is the body of POST /api/s3/buckets
+type createBucketRequest struct {
+	BucketName string `json:"bucket_name"`
+	Region     string `json:"region"`
+	Encryption struct {
+		Type     string `json:"type"`
+		KMSKeyID string `json:"kms_key_id,omitempty"`
+	} `json:"encryption"`
+	VersioningEnabled bool              `json:"versioning_enabled"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	Profile           string            `json:"profile"`
+}
+
+// bucketBaselineFailure is the response when a new bucket failed the
+// baseline and was deleted, or could not be
+type bucketBaselineFailure struct {
+	Status     string           `json:"status"` // failure
+	Error      string           `json:"error"`
+	BucketName string           `json:"bucket_name"`
+	RolledBack bool             `json:"rolled_back"`
+	Steps      []store.SagaStep `json:"steps"`
+}
+
+// bucketBaseline is the baseline for new buckets: the controls every bucket
+// gets, and what the configuration adds to them
+func bucketBaseline(c config.BucketBaselineConfig) aws.BucketBaseline {
+	return aws.BucketBaseline{
+		BlockPublicAccess:   true,
+		BucketOwnerEnforced: true,
+		RequireTLS:          true,
+		LoggingBucket:       c.LoggingBucket,
+		LoggingPrefix:       c.LoggingPrefix,
+		RequiredTags:        c.RequiredTags,
+		Tags:                c.Tags,
+		Rollback:            c.Rollback(),
+	}
+}
+
 // handleCreateBucket handles S3 bucket creation requests
 func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		BucketName string `json:"bucket_name"`
-		Region     string `json:"region"`
-		Encryption struct {
-			Type     string `json:"type"`
-			KMSKeyID string `json:"kms_key_id,omitempty"`
-		} `json:"encryption"`
-		VersioningEnabled bool   `json:"versioning_enabled"`
-		Profile           string `json:"profile"`
-	}
+	var req createBucketRequest
 
 	// Parse request
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -41,31 +73,34 @@ func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the baseline's required tags before creating anything
+	if missing := s.bucketBaseline.MissingTags(req.Tags); len(missing) > 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+			"error":        "missing required tags: " + strings.Join(missing, ", "),
+			"missing_tags": missing,
+		})
+		return
+	}
+
 	// Default profile
 	if req.Profile == "" {
 		req.Profile = "default"
 	}
 
-	// Get credentials from store
-	creds, err := s.store.GetCredential(req.Profile)
-	if err != nil {
-		slog.Error("failed to get credentials", "error", err, "profile", req.Profile)
-		writeJSON(w, http.StatusNotFound, map[string]string{
-			"error": "Credentials not found for profile: " + req.Profile,
-		})
+	// Resolve credentials (assuming a role or prompting for MFA if needed)
+	creds, ok := s.resolveCredentials(w, r, req.Profile)
+	if !ok {
 		return
 	}
 
 	// Check training gate with backend
-	allowed, requiredModules, err := s.checkTrainingGate("s3:CreateBucket", map[string]interface{}{
+	allowed, requiredModules, err := s.checkTrainingGate("s3:CreateBucket", "s3:bucket", map[string]interface{}{
 		"bucket_name": req.BucketName,
 		"region":      req.Region,
 	})
 	if err != nil {
 		slog.Error("failed to check training gate", "error", err)
-		writeJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": "Failed to check training requirements",
-		})
+		writeGateError(w, err)
 		return
 	}
 
@@ -77,7 +112,7 @@ func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 		)
 
 		// Send audit log for blocked operation
-		go s.sendAuditLog(r.Context(), map[string]interface{}{
+		if err := s.recordAudit(map[string]interface{}{
 			"action":        "s3:CreateBucket",
 			"resource_type": "s3:bucket",
 			"resource_id":   req.BucketName,
@@ -86,7 +121,10 @@ func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 				"region":           req.Region,
 				"required_modules": requiredModules,
 			},
-		})
+		}); err != nil {
+			writeAuditError(w)
+			return
+		}
 
 		writeJSON(w, http.StatusForbidden, map[string]interface{}{
 			"status":           "blocked",
@@ -125,8 +163,45 @@ func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 		EncryptionType:    req.Encryption.Type,
 		KMSKeyID:          req.Encryption.KMSKeyID,
 		VersioningEnabled: req.VersioningEnabled,
+		Tags:              req.Tags,
+		Baseline:          s.bucketBaseline,
+		Journal:           &aws.SagaJournal{Store: s.store, Profile: req.Profile},
 	})
 
+	details := map[string]interface{}{
+		"region":     req.Region,
+		"encryption": req.Encryption.Type,
+		"versioning": req.VersioningEnabled,
+		"tags":       req.Tags,
+	}
+	if output != nil {
+		details["region"] = output.Region
+		details["steps"] = output.Steps
+	}
+
+	// A bucket kept despite a failed baseline step is created, but flagged
+	if err != nil && output != nil && output.BaselineIncomplete {
+		slog.Warn("bucket created without meeting the baseline",
+			"error", err,
+			"bucket", req.BucketName,
+		)
+
+		details["error"] = err.Error()
+		if err := s.recordAudit(map[string]interface{}{
+			"action":        "s3:CreateBucket",
+			"resource_type": "s3:bucket",
+			"resource_id":   req.BucketName,
+			"status":        "partial",
+			"details":       details,
+		}); err != nil {
+			writeAuditError(w)
+			return
+		}
+
+		writeJSON(w, http.StatusCreated, output)
+		return
+	}
+
 	if err != nil {
 		slog.Error("failed to create bucket",
 			"error", err,
@@ -134,22 +209,43 @@ func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 		)
 
 		// Send audit log for failed operation
-		go s.sendAuditLog(r.Context(), map[string]interface{}{
+		details["error"] = err.Error()
+		if output != nil {
+			details["rolled_back"] = output.RolledBack
+		}
+		if err := s.recordAudit(map[string]interface{}{
 			"action":        "s3:CreateBucket",
 			"resource_type": "s3:bucket",
 			"resource_id":   req.BucketName,
 			"status":        "failure",
-			"details": map[string]interface{}{
-				"region":     req.Region,
-				"encryption": req.Encryption.Type,
-				"versioning": req.VersioningEnabled,
-				"error":      err.Error(),
-			},
-		})
-
-		writeJSON(w, http.StatusInternalServerError, map[string]string{
-			"error": err.Error(),
-		})
+			"details":       details,
+		}); err != nil {
+			writeAuditError(w)
+			return
+		}
+
+		switch {
+		case output != nil:
+			writeJSON(w, http.StatusBadGateway, bucketBaselineFailure{
+				Status:     "failure",
+				Error:      err.Error(),
+				BucketName: output.BucketName,
+				RolledBack: output.RolledBack,
+				Steps:      output.Steps,
+			})
+		case errors.Is(err, aws.ErrBucketExists):
+			writeJSON(w, http.StatusConflict, map[string]string{
+				"error": "Bucket already exists",
+			})
+		case errors.Is(err, aws.ErrMissingTags):
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": err.Error(),
+			})
+		default:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": err.Error(),
+			})
+		}
 		return
 	}
 
@@ -159,18 +255,17 @@ func (s *server) handleCreateBucket(w http.ResponseWriter, r *http.Request) {
 		"location", output.Location,
 	)
 
-	// Send audit log to backend (non-blocking)
-	go s.sendAuditLog(r.Context(), map[string]interface{}{
+	// Record the operation before reporting it
+	if err := s.recordAudit(map[string]interface{}{
 		"action":        "s3:CreateBucket",
 		"resource_type": "s3:bucket",
 		"resource_id":   output.BucketName,
 		"status":        "success",
-		"details": map[string]interface{}{
-			"region":     output.Region,
-			"encryption": req.Encryption.Type,
-			"versioning": req.VersioningEnabled,
-		},
-	})
+		"details":       details,
+	}); err != nil {
+		writeAuditError(w)
+		return
+	}
 
 	writeJSON(w, http.StatusCreated, output)
 }
@@ -195,79 +290,33 @@ func getCurrentUser() string {
 	return "unknown"
 }
 
-// checkTrainingGate checks with backend if user is allowed to perform action
-func (s *server) checkTrainingGate(action string, resourceDetails map[string]interface{}) (bool, []map[string]interface{}, error) {
-	backendURL := getBackendURL()
-	url := backendURL + "/api/policies/check"
-
-	reqBody := map[string]interface{}{
-		"user_id":          getCurrentUser(),
-		"action":           action,
-		"resource_type":    "s3:bucket",
-		"resource_details": resourceDetails,
-	}
-
-	bodyBytes, err := json.Marshal(reqBody)
-	if err != nil {
-		return false, nil, err
-	}
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewReader(bodyBytes))
-	if err != nil {
-		// If backend is unavailable, log warning and allow (graceful degradation)
-		slog.Warn("backend unavailable for policy check, allowing operation", "error", err)
-		return true, nil, nil
-	}
-	defer resp.Body.Close()
-
-	var result struct {
-		Action          string                   `json:"action"`
-		Reason          string                   `json:"reason,omitempty"`
-		RequiredModules []map[string]interface{} `json:"required_modules,omitempty"`
-		Message         string                   `json:"message"`
+// recordAudit stores an audit log entry in the outbox, from which it is
+// delivered to the backend. Call it before reporting the operation, and
+// fail the request if it returns an error: an operation must not go
+// unaudited. The entry's user_id would have to be the ID of a backend user,
+// which the agent does not know, so the OS user goes in the details as
+// os_user instead.
+func (s *server) recordAudit(logData map[string]interface{}) error {
+	details, _ := logData["details"].(map[string]interface{})
+	if details == nil {
+		details = map[string]interface{}{}
+		logData["details"] = details
 	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		slog.Warn("failed to decode policy response", "error", err)
-		return true, nil, nil
+	if _, ok := details["os_user"]; !ok {
+		details["os_user"] = getCurrentUser()
 	}
 
-	if result.Action == "block" {
-		return false, result.RequiredModules, nil
+	if err := s.audit.Record(logData); err != nil {
+		slog.Error("failed to record audit event", "error", err, "action", logData["action"], "resource_id", logData["resource_id"])
+		return fmt.Errorf("record audit event: %w", err)
 	}
-
-	return true, nil, nil
+	return nil
 }
 
-// sendAuditLog sends an audit log entry to the backend (non-blocking)
-func (s *server) sendAuditLog(ctx interface{}, logData map[string]interface{}) {
-	backendURL := getBackendURL()
-	url := backendURL + "/api/audit/log"
-
-	// Add user_id if not present
-	if _, ok := logData["user_id"]; !ok {
-		logData["user_id"] = getCurrentUser()
-	}
-
-	bodyBytes, err := json.Marshal(logData)
-	if err != nil {
-		slog.Warn("failed to marshal audit log", "error", err)
-		return
-	}
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Post(url, "application/json", bytes.NewReader(bodyBytes))
-	if err != nil {
-		slog.Warn("failed to send audit log to backend", "error", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		slog.Warn("backend returned non-200 for audit log", "status", resp.StatusCode)
-		return
-	}
-
-	slog.Debug("audit log sent to backend successfully")
+// writeAuditError writes the response for an operation whose audit event
+// could not be recorded
+func writeAuditError(w http.ResponseWriter) {
+	writeJSON(w, http.StatusInternalServerError, map[string]string{
+		"error": "Failed to record the audit event",
+	})
 }
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+)
+
+// s3Request is an S3 operation on behalf of a profile. It carries what the
+// training gate and the audit log need to know about the operation.
+type s3Request struct {
+	action     string
+	object     bool // the resource is an object rather than a bucket
+	resourceID string
+	profile    string
+	details    map[string]interface{}
+}
+
+// resourceType is the audit log's resource type for the operation
+func (op s3Request) resourceType() string {
+	if op.object {
+		return "s3:object"
+	}
+	return "s3:bucket"
+}
+
+// s3Client resolves the profile's credentials, checks the training gate and
+// creates an AWS client. On failure it writes the response itself, and
+// audits blocked operations.
+func (s *server) s3Client(w http.ResponseWriter, r *http.Request, op s3Request) (*aws.Client, bool) {
+	creds, ok := s.resolveCredentials(w, r, op.profile)
+	if !ok {
+		return nil, false
+	}
+
+	allowed, requiredModules, err := s.checkTrainingGate(op.action, op.resourceType(), op.details)
+	if err != nil {
+		slog.Error("failed to check training gate", "error", err)
+		writeGateError(w, err)
+		return nil, false
+	}
+
+	if !allowed {
+		slog.Info("operation blocked by training gate",
+			"user", getCurrentUser(),
+			"action", op.action,
+			"resource", op.resourceID,
+		)
+
+		details := map[string]interface{}{"required_modules": requiredModules}
+		for k, v := range op.details {
+			details[k] = v
+		}
+		if err := s.recordAudit(map[string]interface{}{
+			"action":        op.action,
+			"resource_type": op.resourceType(),
+			"resource_id":   op.resourceID,
+			"status":        "blocked",
+			"details":       details,
+		}); err != nil {
+			writeAuditError(w)
+			return nil, false
+		}
+
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"status":           "blocked",
+			"reason":           "training_required",
+			"required_modules": requiredModules,
+		})
+		return nil, false
+	}
+
+	client, err := aws.NewClientFromCredentials(r.Context(), creds, "")
+	if err != nil {
+		slog.Error("failed to create AWS client", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to initialize AWS client",
+		})
+		return nil, false
+	}
+	return client, true
+}
+
+// auditS3 records the audit event for a completed S3 operation
+func (s *server) auditS3(op s3Request, err error) error {
+	status := "success"
+	details := map[string]interface{}{}
+	for k, v := range op.details {
+		details[k] = v
+	}
+	if err != nil {
+		status = "failure"
+		details["error"] = err.Error()
+	}
+
+	return s.recordAudit(map[string]interface{}{
+		"action":        op.action,
+		"resource_type": op.resourceType(),
+		"resource_id":   op.resourceID,
+		"status":        status,
+		"details":       details,
+	})
+}
+
+// writeS3Error writes the response for a failed S3 operation
+func writeS3Error(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, aws.ErrNoSuchBucket):
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Bucket not found",
+		})
+	case errors.Is(err, aws.ErrNoSuchKey):
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Object not found",
+		})
+	case errors.Is(err, aws.ErrBucketNotEmpty):
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error": "Bucket is not empty; delete its objects first or use force",
+		})
+	default:
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": err.Error(),
+		})
+	}
+}
+
+// queryProfile returns the ?profile= query parameter, or "default"
+func queryProfile(r *http.Request) string {
+	if profile := r.URL.Query().Get("profile"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+// handleListBuckets lists the buckets in the profile's account
+func (s *server) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	profile := queryProfile(r)
+	op := s3Request{
+		action:  "s3:ListBuckets",
+		profile: profile,
+		details: map[string]interface{}{"profile": profile},
+	}
+
+	client, ok := s.s3Client(w, r, op)
+	if !ok {
+		return
+	}
+
+	buckets, err := aws.ListBuckets(r.Context(), client)
+	if err != nil {
+		slog.Error("failed to list buckets", "error", err, "profile", profile)
+	} else {
+		op.details["count"] = len(buckets)
+	}
+	if err := s.auditS3(op, err); err != nil {
+		writeAuditError(w)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, buckets)
+}
+
+// handleDescribeBucket reports a bucket's region, encryption, versioning,
+// public access block, tags and policy
+func (s *server) handleDescribeBucket(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	profile := queryProfile(r)
+	op := s3Request{
+		action:     "s3:DescribeBucket",
+		resourceID: name,
+		profile:    profile,
+		details:    map[string]interface{}{"bucket_name": name, "profile": profile},
+	}
+
+	client, ok := s.s3Client(w, r, op)
+	if !ok {
+		return
+	}
+
+	details, err := aws.DescribeBucket(r.Context(), client, name)
+	if err != nil {
+		slog.Error("failed to describe bucket", "error", err, "bucket", name)
+	}
+	if err := s.auditS3(op, err); err != nil {
+		writeAuditError(w)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, details)
+}
+
+// handleDeleteBucket deletes a bucket. With ?force=true, the bucket is
+// emptied of every object version and delete marker and then deleted by a
+// job, since emptying a large bucket takes far longer than a request.
+func (s *server) handleDeleteBucket(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	profile := queryProfile(r)
+	force := r.URL.Query().Get("force") == "true"
+	op := s3Request{
+		action:     "s3:DeleteBucket",
+		resourceID: name,
+		profile:    profile,
+		details:    map[string]interface{}{"bucket_name": name, "profile": profile, "force": force},
+	}
+
+	client, ok := s.s3Client(w, r, op)
+	if !ok {
+		return
+	}
+
+	if force {
+		// Check the bucket exists before starting a job for it
+		region, err := aws.BucketRegion(r.Context(), client, name)
+		if err != nil {
+			writeS3Error(w, err)
+			return
+		}
+
+		params := deleteBucketJob{Profile: profile, Bucket: name, Region: region}
+		job, err := s.jobs.Start(deleteBucketJobType, params.description(), params, s.deleteBucketWork(params))
+		if err != nil {
+			slog.Error("failed to start bucket deletion", "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "Failed to start bucket deletion",
+			})
+			return
+		}
+
+		slog.Info("bucket deletion started", "job", job.ID, "bucket", name)
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	slog.Info("deleting S3 bucket", "bucket", name)
+
+	output, err := aws.DeleteBucket(r.Context(), client, name, false)
+	if output != nil {
+		op.details["region"] = output.Region
+	}
+	if err != nil {
+		slog.Error("failed to delete bucket", "error", err, "bucket", name)
+	} else {
+		slog.Info("bucket deleted", "bucket", name)
+	}
+	if err := s.auditS3(op, err); err != nil {
+		writeAuditError(w)
+		return
+	}
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, output)
+}
+
+// deleteBucketJobType is the job type of forced bucket deletions
+const deleteBucketJobType = "s3-delete-bucket"
+
+// deleteBucketJob is what a forced bucket deletion runs, kept with the job
+// so that it carries on if the agent restarts
+type deleteBucketJob struct {
+	Profile string `json:"profile"`
+	Bucket  string `json:"bucket"`
+	Region  string `json:"region"`
+}
+
+func (p deleteBucketJob) description() string {
+	return fmt.Sprintf("delete s3://%s and everything in it", p.Bucket)
+}
+
+// deleteBucketWork returns the work of a forced bucket deletion
+func (s *server) deleteBucketWork(p deleteBucketJob) jobs.Func {
+	// The job can outlive the session the request was checked with
+	client := aws.NewClientFromProvider(s.resolver.Provider(p.Profile), p.Region)
+	op := s3Request{
+		action:     "s3:DeleteBucket",
+		resourceID: p.Bucket,
+		profile:    p.Profile,
+		details:    map[string]interface{}{"bucket_name": p.Bucket, "profile": p.Profile, "force": true},
+	}
+
+	return func(ctx context.Context, update func(jobs.Progress)) (interface{}, error) {
+		update(jobs.Progress{Message: "emptying bucket"})
+		output, err := aws.DeleteBucket(ctx, client, p.Bucket, true)
+		if output != nil {
+			op.details["region"] = output.Region
+			op.details["objects_deleted"] = output.ObjectsDeleted
+		}
+		if ctx.Err() != nil {
+			op.details["canceled"] = true
+		}
+		if err != nil {
+			slog.Error("failed to delete bucket", "error", err, "bucket", p.Bucket)
+		} else {
+			slog.Info("bucket deleted", "bucket", p.Bucket, "objects_deleted", output.ObjectsDeleted)
+		}
+		if auditErr := s.auditS3(op, err); auditErr != nil {
+			return nil, errors.Join(err, auditErr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return output, nil
+	}
+}
+
+// resumeDeleteBucket carries on with a forced bucket deletion interrupted
+// by the agent stopping. Emptying starts over with what is left.
+func (s *server) resumeDeleteBucket(jobID string, params json.RawMessage) (jobs.Func, error) {
+	var p deleteBucketJob
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	return s.deleteBucketWork(p), nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// ssoLogin is a device-code login waiting for the user to approve it
+type ssoLogin struct {
+	startURL     string
+	ssoRegion    string
+	registration *aws.SSORegistration
+	auth         *aws.DeviceAuthorization
+}
+
+// ssoLogins tracks pending logins by ID. Device codes stay in the agent;
+// the CLI only ever sees the login ID and the user code.
+type ssoLogins struct {
+	mu      sync.Mutex
+	pending map[string]*ssoLogin
+}
+
+func newSSOLogins() *ssoLogins {
+	return &ssoLogins{pending: make(map[string]*ssoLogin)}
+}
+
+func (l *ssoLogins) add(login *ssoLogin) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(buf)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop abandoned logins
+	for key, pending := range l.pending {
+		if time.Now().After(pending.auth.ExpiresAt) {
+			delete(l.pending, key)
+		}
+	}
+	l.pending[id] = login
+	return id, nil
+}
+
+func (l *ssoLogins) get(id string) (*ssoLogin, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	login, ok := l.pending[id]
+	return login, ok
+}
+
+func (l *ssoLogins) remove(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending, id)
+}
+
+// startSSOLoginRequest is the body of POST /api/sso/login
+type startSSOLoginRequest struct {
+	StartURL  string `json:"start_url"`
+	SSORegion string `json:"sso_region"`
+	Profile   string `json:"profile"`
+}
+
+// handleStartSSOLogin starts an IAM Identity Center device-code login. The
+// start URL and SSO region come from the request or from an existing SSO
+// profile.
+func (s *server) handleStartSSOLogin(w http.ResponseWriter, r *http.Request) {
+	var req startSSOLoginRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	// Fill in settings from the profile being logged in, if it exists
+	var accountID, roleName string
+	if req.Profile != "" {
+		if creds, err := s.store.GetCredential(req.Profile); err == nil && creds.Type() == store.CredentialTypeSSO {
+			if req.StartURL == "" {
+				req.StartURL = creds.SSOStartURL
+			}
+			if req.SSORegion == "" {
+				req.SSORegion = creds.SSORegion
+			}
+			accountID, roleName = creds.SSOAccountID, creds.SSORoleName
+		}
+	}
+
+	if req.StartURL == "" || req.SSORegion == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "start_url and sso_region are required",
+		})
+		return
+	}
+
+	client := aws.NewSSOClient(req.SSORegion)
+	registration, err := s.resolver.SSORegistration(r.Context(), client)
+	if err != nil {
+		slog.Error("failed to register OIDC client", "error", err, "sso_region", req.SSORegion)
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": "Failed to register with IAM Identity Center: " + err.Error(),
+		})
+		return
+	}
+
+	auth, err := client.StartDeviceAuthorization(r.Context(), registration, req.StartURL)
+	if err != nil {
+		slog.Error("failed to start device authorization", "error", err, "start_url", req.StartURL)
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": "Failed to start SSO login: " + err.Error(),
+		})
+		return
+	}
+
+	id, err := s.sso.add(&ssoLogin{
+		startURL:     req.StartURL,
+		ssoRegion:    req.SSORegion,
+		registration: registration,
+		auth:         auth,
+	})
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to start SSO login",
+		})
+		return
+	}
+
+	slog.Info("SSO login started", "start_url", req.StartURL, "sso_region", req.SSORegion)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"login_id":                  id,
+		"start_url":                 req.StartURL,
+		"sso_region":                req.SSORegion,
+		"user_code":                 auth.UserCode,
+		"verification_uri":          auth.VerificationURI,
+		"verification_uri_complete": auth.VerificationURIComplete,
+		"interval":                  auth.Interval,
+		"expires_at":                auth.ExpiresAt,
+		"account_id":                accountID,
+		"role_name":                 roleName,
+	})
+}
+
+// handlePollSSOLogin makes one token request for a pending login. Once the
+// user has approved it, the token is cached and the reachable roles are
+// returned.
+func (s *server) handlePollSSOLogin(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	login, ok := s.sso.get(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Login not found or expired",
+		})
+		return
+	}
+
+	client := aws.NewSSOClient(login.ssoRegion)
+	token, err := client.CreateToken(r.Context(), login.registration, login.auth)
+	switch {
+	case errors.Is(err, aws.ErrAuthorizationPending):
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "pending"})
+		return
+	case errors.Is(err, aws.ErrSlowDown):
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "slow_down"})
+		return
+	case errors.Is(err, aws.ErrDeviceCodeExpired), errors.Is(err, aws.ErrAuthorizationDenied):
+		s.sso.remove(id)
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	case err != nil:
+		slog.Error("failed to create SSO token", "error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": "Failed to complete SSO login: " + err.Error(),
+		})
+		return
+	}
+	s.sso.remove(id)
+
+	if err := s.resolver.SaveSSOToken(login.startURL, token); err != nil {
+		slog.Error("failed to cache SSO token", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to store SSO token",
+		})
+		return
+	}
+
+	roles, err := client.ListRoles(r.Context(), token)
+	if err != nil {
+		slog.Error("failed to list SSO roles", "error", err)
+		writeJSON(w, http.StatusBadGateway, map[string]string{
+			"error": "Failed to list accounts and roles: " + err.Error(),
+		})
+		return
+	}
+
+	slog.Info("SSO login completed",
+		"start_url", login.startURL,
+		"roles", len(roles),
+		"expires_at", token.ExpiresAt,
+	)
+
+	if err := s.recordAudit(map[string]interface{}{
+		"action":        "sso:Login",
+		"resource_type": "sso:start-url",
+		"resource_id":   login.startURL,
+		"status":        "success",
+		"details": map[string]interface{}{
+			"sso_region": login.ssoRegion,
+			"expires_at": token.ExpiresAt,
+		},
+	}); err != nil {
+		writeAuditError(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "complete",
+		"start_url":  login.startURL,
+		"expires_at": token.ExpiresAt,
+		"roles":      roles,
+	})
+}
+
+// setSSOProfileRequest is the body of POST /api/sso/profiles
+type setSSOProfileRequest struct {
+	Profile   string `json:"profile"`
+	StartURL  string `json:"start_url"`
+	SSORegion string `json:"sso_region"`
+	AccountID string `json:"account_id"`
+	RoleName  string `json:"role_name"`
+	Region    string `json:"region"`
+}
+
+// handleSetSSOProfile stores an SSO profile for an account and role and
+// fetches its first role credentials to confirm access
+func (s *server) handleSetSSOProfile(w http.ResponseWriter, r *http.Request) {
+	var req setSSOProfileRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+
+	if req.Profile == "" || req.StartURL == "" || req.SSORegion == "" || req.AccountID == "" || req.RoleName == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "profile, start_url, sso_region, account_id and role_name are required",
+		})
+		return
+	}
+	if req.Region == "" {
+		req.Region = req.SSORegion
+	}
+
+	// Keep the sso-session name of an imported profile
+	var ssoSession string
+	if existing, err := s.store.GetCredential(req.Profile); err == nil {
+		ssoSession = existing.SSOSession
+	}
+
+	creds := store.Credentials{
+		Region:       req.Region,
+		SSOSession:   ssoSession,
+		SSOStartURL:  req.StartURL,
+		SSORegion:    req.SSORegion,
+		SSOAccountID: req.AccountID,
+		SSORoleName:  req.RoleName,
+		AccountID:    req.AccountID,
+		StoredAt:     time.Now().UTC(),
+	}
+
+	if err := s.store.SetCredential(req.Profile, creds); err != nil {
+		slog.Error("failed to store SSO profile", "error", err, "profile", req.Profile)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to store profile",
+		})
+		return
+	}
+	if err := s.resolver.InvalidateSession(req.Profile); err != nil {
+		slog.Warn("failed to invalidate cached session", "error", err, "profile", req.Profile)
+	}
+
+	session, ok := s.resolveCredentials(w, r, req.Profile)
+	if !ok {
+		return
+	}
+
+	slog.Info("SSO profile stored",
+		"profile", req.Profile,
+		"account_id", req.AccountID,
+		"role", req.RoleName,
+	)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":     "success",
+		"profile":    req.Profile,
+		"account_id": req.AccountID,
+		"role_name":  req.RoleName,
+		"expiration": session.Expiration,
+	})
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// syncRequest is the body of POST /api/s3/sync. One of source and
+// destination is an s3://bucket/prefix URL and the other an absolute local
+// directory.
+type syncRequest struct {
+	Profile     string   `json:"profile"`
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	Delete      bool     `json:"delete,omitempty"`
+	DryRun      bool     `json:"dry_run,omitempty"`
+	Workers     int      `json:"workers,omitempty"`
+	PartSizeMB  int64    `json:"part_size_mb,omitempty"`
+}
+
+// handleStartSync starts a sync between a local directory and an S3 prefix
+// as a job. The job's result lists the plan; with dry_run nothing is
+// changed.
+func (s *server) handleStartSync(w http.ResponseWriter, r *http.Request) {
+	var req syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	if req.Profile == "" {
+		req.Profile = "default"
+	}
+	if req.Workers < 0 || req.Workers > aws.MaxTransferWorkers {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("workers must be between 1 and %d", aws.MaxTransferWorkers),
+		})
+		return
+	}
+	if req.PartSizeMB < 0 || (req.PartSizeMB > 0 && req.PartSizeMB<<20 < aws.MinPartSize) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("part size must be at least %d MiB", aws.MinPartSize>>20),
+		})
+		return
+	}
+	if _, err := aws.NewSyncFilter(req.Include, req.Exclude); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	input := aws.SyncInput{
+		Include:  req.Include,
+		Exclude:  req.Exclude,
+		Delete:   req.Delete,
+		DryRun:   req.DryRun,
+		PartSize: req.PartSizeMB << 20,
+		Workers:  req.Workers,
+		Store:    s.store,
+		Profile:  req.Profile,
+	}
+	local := req.Destination
+	if bucket, prefix, ok := parseS3URL(req.Source); ok {
+		input.Direction = store.TransferDownload
+		input.Bucket, input.Prefix = bucket, prefix
+	} else if bucket, prefix, ok := parseS3URL(req.Destination); ok {
+		input.Direction = store.TransferUpload
+		input.Bucket, input.Prefix = bucket, prefix
+		local = req.Source
+	} else {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "one of source and destination must be an s3://bucket/prefix URL",
+		})
+		return
+	}
+	if strings.HasPrefix(local, "s3://") || !filepath.IsAbs(local) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "the local side must be an absolute directory path: " + local,
+		})
+		return
+	}
+	input.LocalDir = filepath.Clean(local)
+
+	// A download creates its directory; an upload needs one
+	info, err := os.Stat(input.LocalDir)
+	if (err == nil && !info.IsDir()) || (err != nil && input.Direction == store.TransferUpload) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Not a directory: " + input.LocalDir,
+		})
+		return
+	}
+
+	op := syncOp(input, req.Profile)
+	client, ok := s.s3Client(w, r, op)
+	if !ok {
+		return
+	}
+	region, err := aws.BucketRegion(r.Context(), client, input.Bucket)
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+
+	// Two syncs of the same directory and prefix would trip over each
+	// other; a dry run changes nothing, so it can run alongside
+	id := syncID(input)
+	s.transfers.mu.Lock()
+	defer s.transfers.mu.Unlock()
+	if jobID, running := s.transfers.running(s.jobs, id); running && !input.DryRun {
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error":  "The same sync is already running",
+			"job_id": jobID,
+		})
+		return
+	}
+
+	params := syncJob{
+		Direction: input.Direction,
+		Profile:   req.Profile,
+		LocalDir:  input.LocalDir,
+		Bucket:    input.Bucket,
+		Prefix:    input.Prefix,
+		Region:    region,
+		Include:   input.Include,
+		Exclude:   input.Exclude,
+		Delete:    input.Delete,
+		DryRun:    input.DryRun,
+		PartSize:  input.PartSize,
+		Workers:   input.Workers,
+	}
+
+	// A dry run is quick to repeat, so it is not resumed
+	var resumable interface{}
+	if !input.DryRun {
+		resumable = params
+	}
+	job, err := s.jobs.Start(syncJobType, params.description(), resumable, s.syncWork(params))
+	if err != nil {
+		slog.Error("failed to start sync", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to start sync",
+		})
+		return
+	}
+	if !input.DryRun {
+		s.transfers.jobs[id] = job.ID
+	}
+
+	slog.Info("sync started", "job", job.ID, "description", job.Description)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// syncOp describes a sync for authorization and audit
+func syncOp(input aws.SyncInput, profile string) s3Request {
+	return s3Request{
+		action:     "s3:Sync",
+		resourceID: input.Bucket + "/" + input.Prefix,
+		profile:    profile,
+		details: map[string]interface{}{
+			"bucket_name": input.Bucket,
+			"prefix":      input.Prefix,
+			"local_dir":   input.LocalDir,
+			"direction":   input.Direction,
+			"delete":      input.Delete,
+			"profile":     profile,
+		},
+	}
+}
+
+// syncID identifies a sync in s.transfers
+func syncID(input aws.SyncInput) string {
+	return store.TransferID("sync:"+input.Direction, input.Profile, input.LocalDir, input.Bucket, input.Prefix)
+}
+
+// syncJobType is the job type of syncs
+const syncJobType = "s3-sync"
+
+// syncJob is what a sync job runs, kept with the job so that it resumes if
+// the agent restarts
+type syncJob struct {
+	Direction string   `json:"direction"`
+	Profile   string   `json:"profile"`
+	LocalDir  string   `json:"local_dir"`
+	Bucket    string   `json:"bucket"`
+	Prefix    string   `json:"prefix"`
+	Region    string   `json:"region"`
+	Include   []string `json:"include,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+	Delete    bool     `json:"delete,omitempty"`
+	DryRun    bool     `json:"dry_run,omitempty"`
+	PartSize  int64    `json:"part_size,omitempty"`
+	Workers   int      `json:"workers,omitempty"`
+}
+
+func (p syncJob) input(st *store.Store) aws.SyncInput {
+	return aws.SyncInput{
+		Direction: p.Direction,
+		LocalDir:  p.LocalDir,
+		Bucket:    p.Bucket,
+		Prefix:    p.Prefix,
+		Include:   p.Include,
+		Exclude:   p.Exclude,
+		Delete:    p.Delete,
+		DryRun:    p.DryRun,
+		PartSize:  p.PartSize,
+		Workers:   p.Workers,
+		Store:     st,
+		Profile:   p.Profile,
+	}
+}
+
+func (p syncJob) description() string {
+	remote := fmt.Sprintf("s3://%s/%s", p.Bucket, p.Prefix)
+	description := fmt.Sprintf("sync %s to %s", p.LocalDir, remote)
+	if p.Direction == store.TransferDownload {
+		description = fmt.Sprintf("sync %s to %s", remote, p.LocalDir)
+	}
+	if p.DryRun {
+		description = "plan " + description
+	}
+	return description
+}
+
+// syncWork returns the work of a sync job. The caller holds
+// s.transfers.mu and records the job in s.transfers.
+func (s *server) syncWork(p syncJob) jobs.Func {
+	input := p.input(s.store)
+	op := syncOp(input, p.Profile)
+	client := aws.NewClientFromProvider(s.resolver.Provider(p.Profile), p.Region)
+
+	return func(ctx context.Context, update func(jobs.Progress)) (interface{}, error) {
+		if !input.DryRun {
+			defer func() {
+				s.transfers.mu.Lock()
+				delete(s.transfers.jobs, syncID(input))
+				s.transfers.mu.Unlock()
+			}()
+		}
+		return s.runSync(ctx, client, input, op, update)
+	}
+}
+
+// resumeSync picks up a sync job interrupted by the agent stopping. The
+// plan is worked out again, so only what is still to do is done.
+func (s *server) resumeSync(jobID string, params json.RawMessage) (jobs.Func, error) {
+	var p syncJob
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	work := s.syncWork(p)
+
+	s.transfers.mu.Lock()
+	s.transfers.jobs[syncID(p.input(s.store))] = jobID
+	s.transfers.mu.Unlock()
+	return work, nil
+}
+
+// runSync is the work of a sync job. Everything the sync changed is
+// summarized in a single audit event.
+func (s *server) runSync(ctx context.Context, client *aws.Client, input aws.SyncInput, op s3Request, update func(jobs.Progress)) (interface{}, error) {
+	update(jobs.Progress{Message: "comparing files"})
+	input.Progress = func(done, total int64, actionsDone, actions int) {
+		update(jobs.Progress{
+			BytesDone:  done,
+			BytesTotal: total,
+			Message:    fmt.Sprintf("%d of %d changes", actionsDone, actions),
+		})
+	}
+
+	output, err := aws.Sync(ctx, client, input)
+	if err != nil {
+		slog.Error("sync failed", "error", err, "bucket", input.Bucket, "prefix", input.Prefix, "local_dir", input.LocalDir)
+	} else {
+		slog.Info("sync complete", "bucket", input.Bucket, "prefix", input.Prefix, "local_dir", input.LocalDir,
+			"changes", len(output.Plan), "dry_run", input.DryRun)
+	}
+
+	// A dry run changes nothing, so there is nothing to audit
+	if !input.DryRun {
+		if output != nil {
+			op.details["uploaded"] = output.Uploaded
+			op.details["downloaded"] = output.Downloaded
+			op.details["deleted"] = output.Deleted
+			op.details["unchanged"] = output.Unchanged
+			op.details["bytes"] = output.Bytes
+			op.details["failed"] = len(output.Failures)
+		}
+		if ctx.Err() != nil {
+			op.details["canceled"] = true
+		}
+		if auditErr := s.auditS3(op, err); auditErr != nil {
+			err = errors.Join(err, auditErr)
+		}
+	}
+
+	if output == nil {
+		return nil, err
+	}
+	return output, err
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// transferRequest is the body of POST /api/s3/transfers. One of source and
+// destination is an s3://bucket/key URL and the other an absolute local
+// path. A key ending in "/" or a local directory takes the other side's
+// file name.
+type transferRequest struct {
+	Profile     string `json:"profile"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Workers     int    `json:"workers,omitempty"`
+	PartSizeMB  int64  `json:"part_size_mb,omitempty"`
+}
+
+// transfer is a resolved transfer request
+type transfer struct {
+	direction string // store.TransferUpload or store.TransferDownload
+	profile   string
+	localPath string
+	bucket    string
+	key       string
+}
+
+// id identifies the transfer's saved state, so that repeating a request
+// resumes it
+func (t transfer) id() string {
+	return store.TransferID(t.direction, t.profile, t.localPath, t.bucket, t.key)
+}
+
+// activeTransfers maps the IDs of running transfers to their jobs, so the
+// same transfer is not run twice at once
+type activeTransfers struct {
+	mu   sync.Mutex
+	jobs map[string]string
+}
+
+func newActiveTransfers() *activeTransfers {
+	return &activeTransfers{jobs: make(map[string]string)}
+}
+
+// running returns the job running the transfer with the given ID, if it
+// has not finished. A job cancelled before it started never removes its
+// entry, so the job's status decides. The caller holds a.mu.
+func (a *activeTransfers) running(m *jobs.Manager, id string) (string, bool) {
+	jobID, ok := a.jobs[id]
+	if !ok {
+		return "", false
+	}
+	if job, err := m.Get(jobID); err != nil || job.Status.Done() {
+		delete(a.jobs, id)
+		return "", false
+	}
+	return jobID, true
+}
+
+// parseS3URL splits s3://bucket/key
+func parseS3URL(s string) (bucket, key string, ok bool) {
+	rest, found := strings.CutPrefix(s, "s3://")
+	if !found {
+		return "", "", false
+	}
+	bucket, key, _ = strings.Cut(rest, "/")
+	return bucket, key, bucket != ""
+}
+
+// resolveTransfer works out the direction and both ends of a transfer
+func resolveTransfer(req transferRequest) (*transfer, error) {
+	t := &transfer{profile: req.Profile}
+	local := req.Destination
+	if bucket, key, ok := parseS3URL(req.Source); ok {
+		t.direction = store.TransferDownload
+		t.bucket, t.key = bucket, key
+	} else if bucket, key, ok := parseS3URL(req.Destination); ok {
+		t.direction = store.TransferUpload
+		t.bucket, t.key = bucket, key
+		local = req.Source
+	} else {
+		return nil, fmt.Errorf("one of source and destination must be an s3://bucket/key URL")
+	}
+	if strings.HasPrefix(local, "s3://") {
+		return nil, fmt.Errorf("copies between S3 locations are not supported")
+	}
+	if !filepath.IsAbs(local) {
+		return nil, fmt.Errorf("local path must be absolute: %s", local)
+	}
+	t.localPath = filepath.Clean(local)
+
+	if t.direction == store.TransferUpload {
+		if t.key == "" || strings.HasSuffix(t.key, "/") {
+			t.key += filepath.Base(t.localPath)
+		}
+		return t, nil
+	}
+
+	if t.key == "" || strings.HasSuffix(t.key, "/") {
+		return nil, fmt.Errorf("source must name an object, not a prefix")
+	}
+	if info, err := os.Stat(t.localPath); (err == nil && info.IsDir()) || strings.HasSuffix(local, string(filepath.Separator)) {
+		t.localPath = filepath.Join(t.localPath, path.Base(t.key))
+	}
+	return t, nil
+}
+
+// handleStartTransfer starts copying a file to or from S3 as a job. A
+// transfer that was interrupted resumes from its saved state when it is
+// requested again.
+func (s *server) handleStartTransfer(w http.ResponseWriter, r *http.Request) {
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Invalid request body",
+		})
+		return
+	}
+	if req.Profile == "" {
+		req.Profile = "default"
+	}
+	if req.Workers < 0 || req.Workers > aws.MaxTransferWorkers {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("workers must be between 1 and %d", aws.MaxTransferWorkers),
+		})
+		return
+	}
+	if req.PartSizeMB < 0 || (req.PartSizeMB > 0 && req.PartSizeMB<<20 < aws.MinPartSize) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("part size must be at least %d MiB", aws.MinPartSize>>20),
+		})
+		return
+	}
+
+	t, err := resolveTransfer(req)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Catch a missing source or destination directory now rather than in
+	// the job
+	if t.direction == store.TransferUpload {
+		info, err := os.Stat(t.localPath)
+		if err != nil || info.IsDir() {
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "Source is not a file: " + t.localPath,
+			})
+			return
+		}
+	} else if info, err := os.Stat(filepath.Dir(t.localPath)); err != nil || !info.IsDir() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Destination directory does not exist: " + filepath.Dir(t.localPath),
+		})
+		return
+	}
+
+	op := transferOp(t)
+	client, ok := s.s3Client(w, r, op)
+	if !ok {
+		return
+	}
+
+	// Transfers go to the bucket's own region
+	region, err := aws.BucketRegion(r.Context(), client, t.bucket)
+	if err != nil {
+		writeS3Error(w, err)
+		return
+	}
+	if t.direction == store.TransferDownload {
+		regionClient := aws.NewClientFromProvider(s.resolver.Provider(t.profile), region)
+		if _, err := aws.StatObject(r.Context(), regionClient, t.bucket, t.key); err != nil {
+			writeS3Error(w, err)
+			return
+		}
+	}
+
+	id := t.id()
+	s.transfers.mu.Lock()
+	defer s.transfers.mu.Unlock()
+	if jobID, running := s.transfers.running(s.jobs, id); running {
+		writeJSON(w, http.StatusConflict, map[string]string{
+			"error":  "The same transfer is already running",
+			"job_id": jobID,
+		})
+		return
+	}
+
+	params := transferJob{
+		Direction: t.direction,
+		Profile:   t.profile,
+		LocalPath: t.localPath,
+		Bucket:    t.bucket,
+		Key:       t.key,
+		Region:    region,
+		PartSize:  req.PartSizeMB << 20,
+		Workers:   req.Workers,
+	}
+	work, resumed := s.transferWork(params)
+	job, err := s.jobs.Start(transferJobType, params.description(), params, work)
+	if err != nil {
+		slog.Error("failed to start transfer", "error", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{
+			"error": "Failed to start transfer",
+		})
+		return
+	}
+	s.transfers.jobs[id] = job.ID
+
+	slog.Info("transfer started", "job", job.ID, "description", job.Description, "resumed", resumed)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// transferOp describes a transfer for authorization and audit
+func transferOp(t *transfer) s3Request {
+	op := s3Request{
+		action:     "s3:PutObject",
+		object:     true,
+		resourceID: t.bucket + "/" + t.key,
+		profile:    t.profile,
+		details: map[string]interface{}{
+			"bucket_name": t.bucket,
+			"key":         t.key,
+			"local_path":  t.localPath,
+			"profile":     t.profile,
+		},
+	}
+	if t.direction == store.TransferDownload {
+		op.action = "s3:GetObject"
+	}
+	return op
+}
+
+// transferJobType is the job type of S3 transfers
+const transferJobType = "s3-transfer"
+
+// transferJob is what a transfer job runs, kept with the job so that it
+// resumes if the agent restarts
+type transferJob struct {
+	Direction string `json:"direction"`
+	Profile   string `json:"profile"`
+	LocalPath string `json:"local_path"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	Region    string `json:"region"`
+	PartSize  int64  `json:"part_size,omitempty"`
+	Workers   int    `json:"workers,omitempty"`
+}
+
+func (p transferJob) transfer() *transfer {
+	return &transfer{
+		direction: p.Direction,
+		profile:   p.Profile,
+		localPath: p.LocalPath,
+		bucket:    p.Bucket,
+		key:       p.Key,
+	}
+}
+
+func (p transferJob) description() string {
+	if p.Direction == store.TransferDownload {
+		return fmt.Sprintf("download s3://%s/%s to %s", p.Bucket, p.Key, p.LocalPath)
+	}
+	return fmt.Sprintf("upload %s to s3://%s/%s", p.LocalPath, p.Bucket, p.Key)
+}
+
+// transferWork returns the work of a transfer job, and whether it carries
+// on from a transfer that was interrupted. The caller holds
+// s.transfers.mu and records the job in s.transfers.
+func (s *server) transferWork(p transferJob) (jobs.Func, bool) {
+	t := p.transfer()
+	id := t.id()
+	op := transferOp(t)
+	opts := aws.Resumable(s.store, id, t.profile, aws.TransferOptions{
+		PartSize: p.PartSize,
+		Workers:  p.Workers,
+	})
+
+	// The job can outlive the session the request was checked with, so it
+	// resolves the profile again as its credentials expire
+	client := aws.NewClientFromProvider(s.resolver.Provider(t.profile), p.Region)
+
+	return func(ctx context.Context, update func(jobs.Progress)) (interface{}, error) {
+		defer func() {
+			s.transfers.mu.Lock()
+			delete(s.transfers.jobs, id)
+			s.transfers.mu.Unlock()
+		}()
+		return s.runTransfer(ctx, client, t, id, op, opts, update)
+	}, opts.State != nil
+}
+
+// resumeTransfer picks up a transfer job interrupted by the agent stopping
+func (s *server) resumeTransfer(jobID string, params json.RawMessage) (jobs.Func, error) {
+	var p transferJob
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+	work, _ := s.transferWork(p)
+
+	s.transfers.mu.Lock()
+	s.transfers.jobs[p.transfer().id()] = jobID
+	s.transfers.mu.Unlock()
+	return work, nil
+}
+
+// runTransfer is the work of a transfer job
+func (s *server) runTransfer(ctx context.Context, client *aws.Client, t *transfer, id string, op s3Request, opts aws.TransferOptions, update func(jobs.Progress)) (interface{}, error) {
+	opts.Progress = func(done, total int64) {
+		update(jobs.Progress{BytesDone: done, BytesTotal: total})
+	}
+
+	var output *aws.TransferOutput
+	var err error
+	if t.direction == store.TransferUpload {
+		output, err = aws.Upload(ctx, client, t.localPath, t.bucket, t.key, opts)
+	} else {
+		output, err = aws.Download(ctx, client, t.bucket, t.key, t.localPath, opts)
+	}
+
+	kept := aws.EndTransfer(ctx, client, s.store, id, err)
+	if ctx.Err() != nil {
+		op.details["canceled"] = true
+	}
+
+	if output != nil {
+		op.details["size"] = output.Size
+		op.details["parts"] = output.Parts
+		op.details["parts_resumed"] = output.PartsResumed
+	}
+	if err != nil {
+		slog.Error("transfer failed", "error", err, "bucket", t.bucket, "key", t.key, "local_path", t.localPath)
+	} else {
+		slog.Info("transfer complete", "bucket", t.bucket, "key", t.key, "local_path", t.localPath, "size", output.Size)
+	}
+	if auditErr := s.auditS3(op, err); auditErr != nil {
+		return nil, errors.Join(err, auditErr)
+	}
+	if err != nil {
+		if kept {
+			err = fmt.Errorf("%w; the parts transferred so far were kept, and repeating the transfer resumes it", err)
+		}
+		return nil, err
+	}
+	return output, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+)
+
+// socketConnKey marks request contexts for connections on the Unix socket
+type socketConnKey struct{}
+
+// listenSocket listens on the agent's Unix socket. Only the owner may
+// connect, so requests on it need no token. The caller holds the agent
+// lock, so any existing socket file was left behind by a crashed agent.
+func listenSocket(path string) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("restrict socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// markSocketConn is the http.Server ConnContext hook that tags Unix socket
+// connections
+func markSocketConn(ctx context.Context, c net.Conn) context.Context {
+	if _, ok := c.(*net.UnixConn); ok {
+		return context.WithValue(ctx, socketConnKey{}, true)
+	}
+	return ctx
+}
+
+// fromSocket reports whether a request arrived on the Unix socket
+func fromSocket(ctx context.Context) bool {
+	onSocket, _ := ctx.Value(socketConnKey{}).(bool)
+	return onSocket
+}
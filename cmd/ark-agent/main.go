@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -15,8 +16,14 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/daemon"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
 	"github.com/scttfrdmn/ark/internal/agent/lockfile"
+	"github.com/scttfrdmn/ark/internal/agent/outbox"
 	"github.com/scttfrdmn/ark/internal/agent/store"
+	"github.com/scttfrdmn/ark/internal/config"
+	"github.com/scttfrdmn/ark/internal/openapi"
 )
 
 var (
@@ -25,13 +32,24 @@ var (
 	buildDate = "unknown"
 )
 
-const (
-	defaultPort = "8737"
-	defaultHost = "127.0.0.1" // localhost only for security
-)
-
 type server struct {
-	store *store.Store
+	store     *store.Store
+	resolver  *aws.Resolver
+	maxKeyAge time.Duration // rotation age for long-term access keys
+	sso       *ssoLogins
+	token     string // bearer token required on API requests
+	jobs      *jobs.Manager
+	transfers *activeTransfers
+	audit     *outbox.Outbox // audit events on their way to the backend
+
+	// bucketBaseline is applied to every bucket the agent creates
+	bucketBaseline aws.BucketBaseline
+
+	// localGateMode decides operations the backend cannot until it has
+	// sent its own mode (see config.TrainingConfig); gateCacheTTL is how
+	// long the cached gate mode reuses an allow decision
+	localGateMode string
+	gateCacheTTL  time.Duration
 }
 
 func main() {
@@ -60,9 +78,21 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Acquire lock to ensure single instance
-	lockPath := filepath.Join(dataDir, "agent.lock")
+	// Load user configuration (defaults apply if the file does not exist)
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("failed to load config", "error", err)
+		os.Exit(1)
+	}
+
+	// Acquire lock to ensure single instance. The lock also tells the CLI
+	// where the socket is.
+	lockPath := filepath.Join(dataDir, daemon.LockFileName)
+	socketPath := filepath.Join(dataDir, daemon.SocketFileName)
 	lock := lockfile.New(lockPath)
+	if cfg.Agent.ListenSocket() {
+		lock.SetSocket(socketPath)
+	}
 	if err := lock.Acquire(); err != nil {
 		slog.Error("failed to acquire lock", "error", err)
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -78,36 +108,105 @@ func main() {
 
 	slog.Info("lock acquired", "path", lockPath)
 
-	// Open database
+	// Open database. The passphrase is removed from the environment so it
+	// is not inherited by any child processes.
 	dbPath := filepath.Join(dataDir, "agent.db")
-	db, err := store.New(dbPath)
+	passphrase := os.Getenv("ARK_AGENT_PASSPHRASE")
+	os.Unsetenv("ARK_AGENT_PASSPHRASE")
+	db, err := store.New(dbPath, store.Options{
+		KeyFile:           filepath.Join(dataDir, "agent.key"),
+		Passphrase:        passphrase,
+		CredentialBackend: cfg.Agent.CredentialBackend,
+	})
 	if err != nil {
 		slog.Error("failed to open database", "error", err, "path", dbPath)
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	slog.Info("database opened", "path", dbPath)
+	slog.Info("database opened",
+		"path", dbPath,
+		"key_source", db.KeySource(),
+		"credential_backend", db.Backend().Name(),
+	)
+
+	// API token for local TCP callers; the CLI reads it to authenticate,
+	// other local users cannot since it is 0600
+	token, err := loadOrCreateToken(filepath.Join(dataDir, daemon.TokenFileName))
+	if err != nil {
+		slog.Error("failed to load agent token", "error", err)
+		os.Exit(1)
+	}
+
+	// Background work, including jobs, stops when the agent shuts down
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
 
 	// Create server
-	srv := &server{store: db}
-	addr := fmt.Sprintf("%s:%s", defaultHost, getEnv("AGENT_PORT", defaultPort))
+	srv := &server{
+		store:     db,
+		resolver:  aws.NewResolver(db),
+		maxKeyAge: cfg.Agent.CredentialMaxAge(),
+		sso:       newSSOLogins(),
+		token:     token,
+		jobs: jobs.NewManager(bgCtx, jobs.Options{
+			Store:         db,
+			MaxConcurrent: cfg.Agent.JobLimit(),
+		}),
+		transfers: newActiveTransfers(),
+		audit:     outbox.New(db, getBackendURL),
+
+		bucketBaseline: bucketBaseline(cfg.S3.BucketBaseline),
+		localGateMode:  cfg.Training.Gate(),
+		gateCacheTTL:   cfg.Training.GateCacheTTL(),
+	}
+
+	router := srv.setupRouter()
+
+	// Pick up jobs that were running when the agent last stopped
+	srv.jobs.Register(transferJobType, srv.resumeTransfer)
+	srv.jobs.Register(syncJobType, srv.resumeSync)
+	srv.jobs.Register(deleteBucketJobType, srv.resumeDeleteBucket)
+	if err := srv.jobs.Recover(); err != nil {
+		slog.Warn("failed to recover jobs", "error", err)
+	}
+
+	// Sagas recorded from here on belong to this run, so take the list of
+	// interrupted ones before serving
+	interrupted, err := srv.store.ListSagas()
+	if err != nil {
+		slog.Error("failed to list interrupted operations", "error", err)
+	}
+
+	go srv.audit.Run(bgCtx)
+	go srv.recoverSagas(bgCtx, interrupted)
+	go srv.refreshSessions(bgCtx)
+	go srv.monitorCredentialAge(bgCtx)
+
 	httpSrv := &http.Server{
-		Addr:         addr,
-		Handler:      srv.setupRouter(),
+		Handler:      router,
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ConnContext:  markSocketConn,
 	}
 
-	// Start server in goroutine
-	serverErr := make(chan error, 1)
-	go func() {
-		slog.Info("agent listening", "addr", addr)
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			serverErr <- err
-		}
-	}()
+	listeners, err := listen(cfg.Agent, socketPath)
+	if err != nil {
+		slog.Error("server failed to start", "error", err)
+		os.Exit(1)
+	}
+
+	// Start serving in goroutines
+	serverErr := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			slog.Info("agent listening", "network", ln.Addr().Network(), "addr", ln.Addr().String())
+			if err := httpSrv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				serverErr <- err
+			}
+		}(ln)
+	}
 
 	// Wait for interrupt signal or server error
 	quit := make(chan os.Signal, 1)
@@ -115,7 +214,7 @@ func main() {
 
 	select {
 	case err := <-serverErr:
-		slog.Error("server failed to start", "error", err)
+		slog.Error("server failed", "error", err)
 		os.Exit(1)
 	case sig := <-quit:
 		slog.Info("shutdown signal received", "signal", sig.String())
@@ -134,7 +233,55 @@ func main() {
 	slog.Info("agent stopped")
 }
 
-func (s *server) setupRouter() http.Handler {
+// listen opens the agent's Unix socket and TCP port as configured. When
+// both are enabled, a busy TCP port (e.g. another user's agent on a shared
+// host) is not fatal: the agent serves on its socket alone.
+func listen(cfg config.AgentConfig, socketPath string) ([]net.Listener, error) {
+	var listeners []net.Listener
+
+	if cfg.ListenSocket() {
+		ln, err := listenSocket(socketPath)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+
+	if cfg.ListenTCP() {
+		addr := cfg.Address()
+		if err := requireLoopback(addr); err != nil {
+			return nil, err
+		}
+		ln, err := net.Listen("tcp", addr)
+		switch {
+		case err == nil:
+			listeners = append(listeners, ln)
+		case len(listeners) > 0:
+			slog.Warn("TCP port unavailable; serving on the Unix socket only", "addr", addr, "error", err)
+		default:
+			return nil, fmt.Errorf("listen on %s: %w", addr, err)
+		}
+	}
+
+	return listeners, nil
+}
+
+// requireLoopback rejects listen addresses reachable from other hosts
+func requireLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid agent address %s: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("agent.host must be a loopback address, got %s", host)
+	}
+	return nil
+}
+
+func (s *server) setupRouter() chi.Router {
 	r := chi.NewRouter()
 
 	// Middleware stack
@@ -144,34 +291,71 @@ func (s *server) setupRouter() http.Handler {
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Heartbeat("/ping"))
 
-	// CORS configuration (localhost only)
+	// CORS configuration (localhost only). API calls authenticate with the
+	// agent token rather than cookies, so credentials are not allowed.
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:*", "http://127.0.0.1:*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", mfaCodeHeader},
 		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
+		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 
-	// API routes
+	// API routes (all require the agent token)
 	r.Route("/api", func(r chi.Router) {
+		r.Use(requireToken(s.token))
+
+		// OpenAPI document describing these routes
+		r.Get("/openapi.json", openapi.Handler(apiSpec()))
+
 		// System endpoints
 		r.Route("/system", func(r chi.Router) {
 			r.Get("/health", s.handleHealth)
 			r.Get("/version", s.handleVersion)
 		})
 
+		// Backend enrollment (the pinned policy signing key)
+		r.Route("/enrollment", func(r chi.Router) {
+			r.Get("/", s.handleGetEnrollment)
+			r.Post("/", s.handleEnroll)
+		})
+
 		// Credentials management
 		r.Route("/credentials", func(r chi.Router) {
 			r.Post("/", s.handleSetCredentials)
 			r.Get("/", s.handleListCredentials)
+			r.Get("/status", s.handleCredentialStatus)
 			r.Delete("/{profile}", s.handleDeleteCredentials)
+			r.Post("/{profile}/rotate", s.handleRotateCredentials)
+			r.Post("/rekey", s.handleRekeyCredentials)
+			r.Post("/migrate", s.handleMigrateCredentials)
+			r.Post("/import", s.handleImportCredentials)
+		})
+
+		// AWS IAM Identity Center (SSO) login
+		r.Route("/sso", func(r chi.Router) {
+			r.Post("/login", s.handleStartSSOLogin)
+			r.Post("/login/{id}/poll", s.handlePollSSOLogin)
+			r.Post("/profiles", s.handleSetSSOProfile)
 		})
 
 		// S3 operations
 		r.Route("/s3", func(r chi.Router) {
 			r.Post("/buckets", s.handleCreateBucket)
+			r.Get("/buckets", s.handleListBuckets)
+			r.Get("/buckets/{name}", s.handleDescribeBucket)
+			r.Delete("/buckets/{name}", s.handleDeleteBucket)
+			r.Post("/transfers", s.handleStartTransfer)
+			r.Post("/sync", s.handleStartSync)
+		})
+
+		// Long-running operations
+		r.Route("/jobs", func(r chi.Router) {
+			r.Get("/", s.handleListJobs)
+			r.Get("/{id}", s.handleGetJob)
+			r.Get("/{id}/events", s.handleJobEvents)
+			r.Delete("/{id}", s.handleCancelJob)
 		})
 
 		// Agent configuration endpoints (future)
@@ -184,6 +368,81 @@ func (s *server) setupRouter() http.Handler {
 	return r
 }
 
+// refreshSessions periodically renews role sessions before they expire
+func (s *server) refreshSessions(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.resolver.RefreshSessions(ctx)
+		}
+	}
+}
+
+// monitorCredentialAge periodically reports access keys past rotation age
+func (s *server) monitorCredentialAge(ctx context.Context) {
+	s.checkCredentialAge(ctx)
+
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkCredentialAge(ctx)
+		}
+	}
+}
+
+// recoverSagas compensates for operations that were cut off when the agent
+// last stopped, sending one audit event for each. sagas is taken before the
+// agent starts serving, so that operations started since are left alone. A
+// saga whose profile cannot get credentials (e.g. it needs an MFA code) is
+// left for the next start.
+func (s *server) recoverSagas(ctx context.Context, sagas map[string]store.SagaState) {
+	for id, state := range sagas {
+		if _, err := s.resolver.Resolve(ctx, state.Profile, ""); err != nil {
+			slog.Warn("cannot recover interrupted operation yet", "error", err,
+				"operation", state.Operation, "resource", state.ResourceID, "profile", state.Profile)
+			continue
+		}
+
+		client := aws.NewClientFromProvider(s.resolver.Provider(state.Profile), state.Region)
+		steps, err := aws.RecoverSaga(ctx, client, s.store, id, state)
+		details := map[string]interface{}{
+			"recovered": true,
+			"profile":   state.Profile,
+			"region":    state.Region,
+			"steps":     steps,
+		}
+		if err != nil {
+			details["error"] = err.Error()
+			slog.Error("failed to roll back interrupted operation", "error", err,
+				"operation", state.Operation, "resource", state.ResourceID)
+		} else {
+			slog.Info("rolled back interrupted operation", "operation", state.Operation, "resource", state.ResourceID)
+		}
+
+		// The operation did not complete, whether or not it was undone
+		if err := s.recordAudit(map[string]interface{}{
+			"action":        state.Operation,
+			"resource_type": state.ResourceType,
+			"resource_id":   state.ResourceID,
+			"status":        "failure",
+			"details":       details,
+		}); err != nil {
+			slog.Error("interrupted operation left unaudited", "error", err,
+				"operation", state.Operation, "resource", state.ResourceID)
+		}
+	}
+}
+
 // loggerMiddleware logs HTTP requests with structured logging
 func loggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -210,6 +469,16 @@ func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
 		"version": version,
 		"time":    time.Now().UTC().Format(time.RFC3339),
 	}
+
+	// Audit events the backend does not have yet
+	if queued, rejected, err := s.store.AuditBacklog(); err == nil {
+		resp["audit_queued"] = queued
+		resp["audit_rejected"] = rejected
+	}
+
+	// Without a pinned key, policy decisions cannot be verified
+	_, err := s.policyKey()
+	resp["enrolled"] = err == nil
 	writeJSON(w, http.StatusOK, resp)
 }
 
@@ -231,12 +500,13 @@ func writeJSON(w http.ResponseWriter, status int, data interface{}) {
 	}
 }
 
-// getEnv gets an environment variable or returns a default value
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// loadConfig loads the Ark configuration file
+func loadConfig() (*config.Config, error) {
+	path, err := config.GetConfigPath()
+	if err != nil {
+		return nil, err
 	}
-	return defaultValue
+	return config.Load(path)
 }
 
 // getDataDir returns the agent data directory
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/scttfrdmn/ark/internal/agent/aws"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+	"github.com/scttfrdmn/ark/internal/openapi"
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// apiSpec describes every route set up in setupRouter. The agent refuses to
+// start if the two disagree, so add an operation here with each new route.
+func apiSpec() *openapi.Document {
+	doc := openapi.New("Ark Agent API",
+		"Local agent that stores AWS credentials and performs AWS operations on the user's behalf. "+
+			"Requests over the agent's Unix socket need no token; TCP requests need the token in ~/.ark/agent.token.",
+		version)
+	doc.Servers = []openapi.Server{{URL: "http://127.0.0.1:8737", Description: "Default agent address"}}
+	doc.Components.SecuritySchemes = map[string]*openapi.SecurityScheme{
+		"agentToken": {Type: "http", Scheme: "bearer", Description: "Contents of ~/.ark/agent.token"},
+	}
+	doc.Security = []map[string][]string{{"agentToken": {}}}
+
+	errorSchema := openapi.Object(map[string]*openapi.Schema{"error": openapi.String()})
+	errorSchema.Required = []string{"error"}
+	doc.Components.Schemas["Error"] = errorSchema
+	errRef := openapi.Ref("Error")
+
+	doc.Components.Schemas["Blocked"] = openapi.Object(map[string]*openapi.Schema{
+		"status":           openapi.Enum("blocked"),
+		"reason":           openapi.Enum("mfa_required", "sso_login_required", "training_required"),
+		"error":            openapi.String(),
+		"profile":          openapi.String(),
+		"mfa_serial":       openapi.String(),
+		"start_url":        openapi.String(),
+		"required_modules": openapi.ArrayOf(doc.Component("Module", training.Module{})),
+	})
+	blockedRef := openapi.Ref("Blocked")
+	gateUnavailable := openapi.Reply("The backend could not check training requirements, and the gate mode (the one the backend last sent, or training.gate_mode) refuses the operation without it", errRef)
+
+	mfaHeader := openapi.HeaderParam(mfaCodeHeader, "One-time code from the profile's MFA device, after an mfa_required response")
+	profileParam := openapi.PathParam("profile", "Credential profile name")
+	success := openapi.Object(map[string]*openapi.Schema{"status": openapi.Enum("success")})
+
+	// Responses shared by operations that use a profile's credentials
+	credentialErrors := map[string]*openapi.Response{
+		"401": openapi.Reply("The profile needs an MFA code or a new SSO login", blockedRef),
+		"404": openapi.Reply("Profile not found", errRef),
+		"502": openapi.Reply("AWS rejected the credentials or could not be reached", errRef),
+	}
+	commonErrors := map[string]*openapi.Response{
+		"400": openapi.Reply("Invalid request", errRef),
+		"500": openapi.Reply("Internal error", errRef),
+	}
+	withErrors := func(responses map[string]*openapi.Response, errs map[string]*openapi.Response) map[string]*openapi.Response {
+		for _, set := range []map[string]*openapi.Response{errs, commonErrors} {
+			for code, resp := range set {
+				if _, ok := responses[code]; !ok {
+					responses[code] = resp
+				}
+			}
+		}
+		return responses
+	}
+
+	// API description
+	doc.Add(http.MethodGet, "/api/openapi.json", &openapi.Operation{
+		Summary:   "This OpenAPI document",
+		Tags:      []string{"system"},
+		Responses: map[string]*openapi.Response{"200": openapi.Reply("OpenAPI 3 document", &openapi.Schema{Type: "object"})},
+	})
+
+	// System endpoints
+	doc.Add(http.MethodGet, "/api/system/health", &openapi.Operation{
+		Summary: "Check that the agent is running",
+		Description: "audit_queued counts audit events waiting to be delivered to the backend; " +
+			"audit_rejected counts events the backend refused, which are kept in the agent's database. " +
+			"enrolled is false until the agent has pinned the backend's policy signing key.",
+		Tags: []string{"system"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Agent is healthy", openapi.Object(map[string]*openapi.Schema{
+				"status":         openapi.Enum("healthy"),
+				"version":        openapi.String(),
+				"time":           openapi.DateTime(),
+				"audit_queued":   openapi.Integer(),
+				"audit_rejected": openapi.Integer(),
+				"enrolled":       openapi.Boolean(),
+			})),
+			"401": openapi.Reply("Missing or invalid agent token", errRef),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/system/version", &openapi.Operation{
+		Summary: "Agent build information",
+		Tags:    []string{"system"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Build information", openapi.Object(map[string]*openapi.Schema{
+				"version":   openapi.String(),
+				"commit":    openapi.String(),
+				"buildDate": openapi.String(),
+			})),
+		},
+	})
+
+	// Credentials management
+	doc.Add(http.MethodPost, "/api/credentials", &openapi.Operation{
+		Summary:     "Store a credential profile",
+		Description: "Set access keys, a role to assume from a source profile, or a credential_process command. With validate, the credentials are checked with STS before they are stored.",
+		Tags:        []string{"credentials"},
+		Parameters:  []openapi.Parameter{mfaHeader},
+		RequestBody: openapi.JSON(doc.Component("SetCredentialsRequest", setCredentialsRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Profile stored", openapi.Object(map[string]*openapi.Schema{
+				"status":     openapi.Enum("success"),
+				"profile":    openapi.String(),
+				"account_id": openapi.String(),
+				"arn":        openapi.String(),
+			})),
+		}, credentialErrors),
+	})
+	doc.Add(http.MethodGet, "/api/credentials", &openapi.Operation{
+		Summary: "List stored profiles, without their secrets",
+		Tags:    []string{"credentials"},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Stored profiles", openapi.ArrayOf(doc.Component("CredentialProfile", credentialProfile{}))),
+		}, nil),
+	})
+	doc.Add(http.MethodGet, "/api/credentials/status", &openapi.Operation{
+		Summary: "Report the lifecycle state of stored profiles",
+		Tags:    []string{"credentials"},
+		Parameters: []openapi.Parameter{
+			openapi.QueryParam("profile", "Limit the report to one profile", openapi.String()),
+			openapi.QueryParam("validate", "Check the credentials with STS first", openapi.Enum("true", "false")),
+			mfaHeader,
+		},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Profile states", openapi.ArrayOf(doc.Component("CredentialStatus", credentialStatus{}))),
+			"404": openapi.Reply("Profile not found", errRef),
+		}, nil),
+	})
+	doc.Add(http.MethodDelete, "/api/credentials/{profile}", &openapi.Operation{
+		Summary:    "Delete a stored profile",
+		Tags:       []string{"credentials"},
+		Parameters: []openapi.Parameter{profileParam},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Profile deleted", success),
+			"404": openapi.Reply("Profile not found", errRef),
+		}, nil),
+	})
+	rotation := openapi.Object(map[string]*openapi.Schema{
+		"status":            openapi.Enum("success", "partial", "failure"),
+		"profile":           openapi.String(),
+		"rotated":           openapi.Boolean(),
+		"old_access_key_id": openapi.String(),
+		"new_access_key_id": openapi.String(),
+		"error":             openapi.String(),
+		"steps":             openapi.ArrayOf(doc.Component("RotationStep", aws.RotationStep{})),
+	})
+	doc.Components.Schemas["Rotation"] = rotation
+	doc.Add(http.MethodPost, "/api/credentials/{profile}/rotate", &openapi.Operation{
+		Summary:     "Rotate a profile's IAM access key",
+		Description: "Creates a new key, verifies it, stores it and deletes the old one. A rotation whose only failure was deleting the old key has status partial.",
+		Tags:        []string{"credentials"},
+		Parameters:  []openapi.Parameter{profileParam},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Key rotated", openapi.Ref("Rotation")),
+			"404": openapi.Reply("Profile not found", errRef),
+			"502": openapi.Reply("Rotation failed and was rolled back", openapi.Ref("Rotation")),
+		}, nil),
+	})
+	doc.Add(http.MethodPost, "/api/credentials/rekey", &openapi.Operation{
+		Summary:     "Re-encrypt stored credentials under a new key",
+		Tags:        []string{"credentials"},
+		RequestBody: openapi.JSON(openapi.SchemaOf(rekeyRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Credentials re-encrypted", openapi.Object(map[string]*openapi.Schema{
+				"status":     openapi.Enum("success"),
+				"key_source": openapi.String(),
+			})),
+		}, nil),
+	})
+	doc.Add(http.MethodPost, "/api/credentials/migrate", &openapi.Operation{
+		Summary:     "Move stored credentials to another backend",
+		Tags:        []string{"credentials"},
+		RequestBody: openapi.JSON(openapi.SchemaOf(migrateRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Credentials migrated", openapi.Object(map[string]*openapi.Schema{
+				"status":   openapi.Enum("success"),
+				"from":     openapi.String(),
+				"to":       openapi.String(),
+				"migrated": openapi.Integer(),
+				"warning":  openapi.String(),
+			})),
+		}, nil),
+	})
+	doc.Add(http.MethodPost, "/api/credentials/import", &openapi.Operation{
+		Summary:     "Import profiles from the AWS CLI configuration",
+		Tags:        []string{"credentials"},
+		RequestBody: openapi.JSON(openapi.SchemaOf(importRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Import result", openapi.Object(map[string]*openapi.Schema{
+				"status":   openapi.Enum("success"),
+				"imported": openapi.ArrayOf(openapi.SchemaOf(importedInfo{})),
+				"skipped":  openapi.ArrayOf(openapi.SchemaOf(skippedInfo{})),
+			})),
+		}, nil),
+	})
+
+	// AWS IAM Identity Center (SSO) login
+	doc.Add(http.MethodPost, "/api/sso/login", &openapi.Operation{
+		Summary:     "Start a device-code login",
+		Description: "The start URL and SSO region may be omitted when profile names an existing SSO profile.",
+		Tags:        []string{"sso"},
+		RequestBody: openapi.JSON(openapi.SchemaOf(startSSOLoginRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Login started; show the user code and verification URI", openapi.Object(map[string]*openapi.Schema{
+				"login_id":                  openapi.String(),
+				"start_url":                 openapi.String(),
+				"sso_region":                openapi.String(),
+				"user_code":                 openapi.String(),
+				"verification_uri":          openapi.String(),
+				"verification_uri_complete": openapi.String(),
+				"interval":                  openapi.Integer(),
+				"expires_at":                openapi.DateTime(),
+				"account_id":                openapi.String(),
+				"role_name":                 openapi.String(),
+			})),
+			"502": openapi.Reply("Identity Center could not be reached", errRef),
+		}, nil),
+	})
+	doc.Add(http.MethodPost, "/api/sso/login/{id}/poll", &openapi.Operation{
+		Summary:    "Check whether the user has approved a login",
+		Tags:       []string{"sso"},
+		Parameters: []openapi.Parameter{openapi.PathParam("id", "Login ID from the start response")},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Login complete", openapi.Object(map[string]*openapi.Schema{
+				"status":     openapi.Enum("complete"),
+				"start_url":  openapi.String(),
+				"expires_at": openapi.DateTime(),
+				"roles":      openapi.ArrayOf(openapi.SchemaOf(aws.SSORole{})),
+			})),
+			"202": openapi.Reply("Not approved yet; poll again after the interval", openapi.Object(map[string]*openapi.Schema{
+				"status": openapi.Enum("pending", "slow_down"),
+			})),
+			"404": openapi.Reply("Login not found or expired", errRef),
+			"502": openapi.Reply("Identity Center could not be reached", errRef),
+		}, nil),
+	})
+	doc.Add(http.MethodPost, "/api/sso/profiles", &openapi.Operation{
+		Summary:     "Store an SSO profile for an account and role",
+		Tags:        []string{"sso"},
+		RequestBody: openapi.JSON(openapi.SchemaOf(setSSOProfileRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Profile stored", openapi.Object(map[string]*openapi.Schema{
+				"status":     openapi.Enum("success"),
+				"profile":    openapi.String(),
+				"account_id": openapi.String(),
+				"role_name":  openapi.String(),
+				"expiration": openapi.DateTime(),
+			})),
+			"401": openapi.Reply("No SSO session for the start URL", blockedRef),
+			"502": openapi.Reply("Identity Center could not be reached", errRef),
+		}, nil),
+	})
+
+	// Backend enrollment
+	enrollmentRef := doc.Component("Enrollment", enrollment{})
+	doc.Add(http.MethodGet, "/api/enrollment", &openapi.Operation{
+		Summary: "The backend policy signing key the agent has pinned",
+		Tags:    []string{"enrollment"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Pinned key", enrollmentRef),
+			"404": openapi.Reply("Agent is not enrolled", errRef),
+		},
+	})
+	doc.Add(http.MethodPost, "/api/enrollment", &openapi.Operation{
+		Summary: "Enroll with the backend",
+		Description: "Fetches the backend's policy signing key and pins it if its fingerprint, the SHA-256 of the key in hex, is the one given, " +
+			"as published by the institution. Every training gate decision must be signed with the pinned key; " +
+			"until the agent is enrolled, decisions are taken unsigned and operations refused when the backend cannot decide. " +
+			"A different pinned key is only replaced with force, and also only if it has the fingerprint given.",
+		Tags:        []string{"enrollment"},
+		RequestBody: openapi.JSON(doc.Component("EnrollRequest", enrollRequest{})),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Enrolled", enrollmentRef),
+			"400": openapi.Reply("Invalid request, or no fingerprint", errRef),
+			"409": openapi.Reply("The backend's key does not have the fingerprint given, or differs from the pinned key", openapi.Object(map[string]*openapi.Schema{
+				"error":         openapi.String(),
+				"key_id":        openapi.String(),
+				"fingerprint":   openapi.String(),
+				"pinned_key_id": openapi.String(),
+			})),
+			"500": openapi.Reply("Internal error", errRef),
+			"502": openapi.Reply("The backend could not be reached or returned no valid key", errRef),
+		},
+	})
+
+	// S3 operations
+	doc.Add(http.MethodPost, "/api/s3/buckets", &openapi.Operation{
+		Summary:     "Create a bucket",
+		Description: "Subject to the training gate, which blocks the request until the required modules are complete. The new bucket is brought up to the configured baseline; if a step fails, the bucket is deleted (502), or kept with baseline_incomplete set when the baseline is configured to flag rather than roll back.",
+		Tags:        []string{"s3"},
+		Parameters:  []openapi.Parameter{mfaHeader},
+		RequestBody: openapi.JSON(doc.Component("CreateBucketRequest", createBucketRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"201": openapi.Reply("Bucket created", doc.Component("Bucket", aws.CreateBucketOutput{})),
+			"400": openapi.Reply("Invalid request, or missing tags the baseline requires", errRef),
+			"409": openapi.Reply("Bucket already exists", errRef),
+			"403": openapi.Reply("Blocked by the training gate", blockedRef),
+			"503": gateUnavailable,
+			"502": openapi.Reply("A baseline step failed and the bucket was rolled back, or AWS rejected the request", doc.Component("BucketBaselineFailure", bucketBaselineFailure{})),
+		}, credentialErrors),
+	})
+	queryProfileParam := openapi.QueryParam("profile", "Credential profile to use (default: default)", openapi.String())
+	bucketParam := openapi.PathParam("name", "Bucket name")
+	doc.Add(http.MethodGet, "/api/s3/buckets", &openapi.Operation{
+		Summary:    "List the buckets in the profile's account",
+		Tags:       []string{"s3"},
+		Parameters: []openapi.Parameter{queryProfileParam, mfaHeader},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Buckets", openapi.ArrayOf(doc.Component("BucketSummary", aws.BucketSummary{}))),
+			"403": openapi.Reply("Blocked by the training gate", blockedRef),
+			"503": gateUnavailable,
+		}, credentialErrors),
+	})
+	doc.Add(http.MethodGet, "/api/s3/buckets/{name}", &openapi.Operation{
+		Summary:    "Describe a bucket's region, encryption, versioning, public access block, tags and policy",
+		Tags:       []string{"s3"},
+		Parameters: []openapi.Parameter{bucketParam, queryProfileParam, mfaHeader},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Bucket configuration", doc.Component("BucketDetails", aws.BucketDetails{})),
+			"403": openapi.Reply("Blocked by the training gate", blockedRef),
+			"503": gateUnavailable,
+			"404": openapi.Reply("Profile or bucket not found", errRef),
+		}, credentialErrors),
+	})
+	jobRef := doc.Component("Job", jobs.Job{})
+	doc.Add(http.MethodDelete, "/api/s3/buckets/{name}", &openapi.Operation{
+		Summary:     "Delete a bucket",
+		Description: "With force, the bucket is emptied and deleted by a job; follow it under /api/jobs/{id}.",
+		Tags:        []string{"s3"},
+		Parameters: []openapi.Parameter{
+			bucketParam,
+			queryProfileParam,
+			openapi.QueryParam("force", "Delete every object version and delete marker first", openapi.Enum("true", "false")),
+			mfaHeader,
+		},
+		Responses: withErrors(map[string]*openapi.Response{
+			"200": openapi.Reply("Bucket deleted", doc.Component("DeletedBucket", aws.DeleteBucketOutput{})),
+			"202": openapi.Reply("Forced deletion started; the job's result is a DeletedBucket", jobRef),
+			"403": openapi.Reply("Blocked by the training gate", blockedRef),
+			"503": gateUnavailable,
+			"404": openapi.Reply("Profile or bucket not found", errRef),
+			"409": openapi.Reply("Bucket is not empty and force was not set", errRef),
+		}, credentialErrors),
+	})
+	doc.Add(http.MethodPost, "/api/s3/transfers", &openapi.Operation{
+		Summary: "Copy a file to or from S3",
+		Description: "Starts a job that copies between a local path and an s3://bucket/key URL. Large files are transferred in parts; " +
+			"repeating the request for an interrupted transfer resumes it. The job's result is a TransferResult.",
+		Tags:        []string{"s3"},
+		Parameters:  []openapi.Parameter{mfaHeader},
+		RequestBody: openapi.JSON(doc.Component("TransferRequest", transferRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"202": openapi.Reply("Transfer started", jobRef),
+			"403": openapi.Reply("Blocked by the training gate", blockedRef),
+			"503": gateUnavailable,
+			"404": openapi.Reply("Profile, bucket or object not found", errRef),
+			"409": openapi.Reply("The same transfer is already running", openapi.Object(map[string]*openapi.Schema{
+				"error":  openapi.String(),
+				"job_id": openapi.String(),
+			})),
+		}, credentialErrors),
+	})
+	doc.Components.Schemas["TransferResult"] = openapi.SchemaOf(aws.TransferOutput{})
+	doc.Add(http.MethodPost, "/api/s3/sync", &openapi.Operation{
+		Summary: "Sync a local directory with an S3 prefix",
+		Description: "Starts a job that copies new and changed files from source to destination, comparing size, modification time and ETag. " +
+			"With delete, files missing from the source are deleted from the destination. With dry_run, the job only works out the plan. " +
+			"The job's result is a SyncResult; a sync that changes anything is summarized in one audit event.",
+		Tags:        []string{"s3"},
+		Parameters:  []openapi.Parameter{mfaHeader},
+		RequestBody: openapi.JSON(doc.Component("SyncRequest", syncRequest{})),
+		Responses: withErrors(map[string]*openapi.Response{
+			"202": openapi.Reply("Sync started", jobRef),
+			"403": openapi.Reply("Blocked by the training gate", blockedRef),
+			"503": gateUnavailable,
+			"404": openapi.Reply("Profile or bucket not found", errRef),
+			"409": openapi.Reply("The same sync is already running", openapi.Object(map[string]*openapi.Schema{
+				"error":  openapi.String(),
+				"job_id": openapi.String(),
+			})),
+		}, credentialErrors),
+	})
+	doc.Components.Schemas["SyncResult"] = openapi.SchemaOf(aws.SyncOutput{})
+
+	// Jobs
+	jobParam := openapi.PathParam("id", "Job ID")
+	doc.Add(http.MethodGet, "/api/jobs", &openapi.Operation{
+		Summary: "List jobs",
+		Description: "Lists jobs newest first. Jobs are kept in the agent's database: one that was queued or running when the agent stopped " +
+			"resumes when it starts again if it can (transfers and syncs), and fails otherwise. Finished jobs are kept for a week.",
+		Tags: []string{"jobs"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Jobs", openapi.Object(map[string]*openapi.Schema{
+				"jobs": openapi.ArrayOf(jobRef),
+			})),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/jobs/{id}", &openapi.Operation{
+		Summary:    "A job's status and progress",
+		Tags:       []string{"jobs"},
+		Parameters: []openapi.Parameter{jobParam},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Job", jobRef),
+			"404": openapi.Reply("Job not found", errRef),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/jobs/{id}/events", &openapi.Operation{
+		Summary: "Follow a job's progress",
+		Description: "Streams server-sent events: a \"job\" event whose data is the Job now and after every change, " +
+			"ending once the job has finished. Changes that arrive faster than the client reads them are merged.",
+		Tags:       []string{"jobs"},
+		Parameters: []openapi.Parameter{jobParam},
+		Responses: map[string]*openapi.Response{
+			"200": {
+				Description: "Event stream",
+				Content:     map[string]*openapi.MediaType{"text/event-stream": {Schema: openapi.String()}},
+			},
+			"404": openapi.Reply("Job not found", errRef),
+		},
+	})
+	doc.Add(http.MethodDelete, "/api/jobs/{id}", &openapi.Operation{
+		Summary: "Cancel a job",
+		Description: "A queued job is canceled at once; a running one finishes as canceled once its work has stopped. " +
+			"A canceled transfer aborts its multipart upload or removes its partial download.",
+		Tags:       []string{"jobs"},
+		Parameters: []openapi.Parameter{jobParam},
+		Responses: map[string]*openapi.Response{
+			"202": openapi.Reply("Cancellation requested", jobRef),
+			"404": openapi.Reply("Job not found", errRef),
+			"409": openapi.Reply("Job has already finished", errRef),
+		},
+	})
+
+	return doc
+}
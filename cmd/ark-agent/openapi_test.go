@@ -0,0 +1,16 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scttfrdmn/ark/internal/openapi"
+)
+
+// Every route must be in the OpenAPI document, and every documented route
+// must exist
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	srv := &server{}
+	if err := openapi.CheckRoutes(srv.setupRouter(), apiSpec()); err != nil {
+		t.Fatal(err)
+	}
+}
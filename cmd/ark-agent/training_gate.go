@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// errGateUnavailable is returned when the training gate refuses an
+// operation because the backend could not decide it
+var errGateUnavailable = errors.New("training requirements could not be checked")
+
+// gateModeConfigKey is the agent store key of the gate mode the backend
+// sent with its last decision
+const gateModeConfigKey = "training_gate_mode"
+
+// gateCacheKey is the cache key of the user's last allow decision for an
+// action
+func gateCacheKey(user, action string) string {
+	return "training_gate:" + user + ":" + action
+}
+
+// checkTrainingGate checks with backend if user is allowed to perform
+// action. Decisions must be signed with the pinned policy key. If the
+// backend cannot decide, the gate mode it last sent does, or the configured
+// one; a refusal is then errGateUnavailable.
+func (s *server) checkTrainingGate(action, resourceType string, resourceDetails map[string]interface{}) (bool, []training.Module, error) {
+	user := getCurrentUser()
+
+	// Without a pinned key there is nothing to verify decisions with
+	if _, err := s.policyKey(); errors.Is(err, errNotEnrolled) {
+		return s.unenrolledTrainingGate(user, action, resourceType, resourceDetails)
+	}
+
+	decision, err := s.requestPolicyDecision(user, action, resourceType, resourceDetails)
+	if err != nil {
+		return s.degradedTrainingGate(user, action, s.gateMode(), resourceDetails, err)
+	}
+	s.setGateMode(decision.GateMode)
+
+	key := gateCacheKey(user, action)
+	if decision.Action == "block" {
+		// An earlier allow no longer holds
+		if err := s.store.DeleteCache(key); err != nil {
+			slog.Warn("failed to clear cached training gate decision", "error", err, "action", action)
+		}
+		return false, decision.RequiredModules, nil
+	}
+
+	// Kept for no longer than the backend vouches for it
+	ttl := min(s.gateCacheTTL, time.Until(decision.ExpiresAt))
+	if err := s.store.SetCache(key, decision, ttl); err != nil {
+		slog.Warn("failed to cache training gate decision", "error", err, "action", action)
+	}
+	return true, nil, nil
+}
+
+// unenrolledTrainingGate takes the backend's decision on trust. If the
+// backend cannot decide, the configured gate mode does, which never allows
+// the operation: there is no signed decision to fall back on. Enrolling the
+// agent turns on signed decisions and the backend's gate mode.
+func (s *server) unenrolledTrainingGate(user, action, resourceType string, resourceDetails map[string]interface{}) (bool, []training.Module, error) {
+	decision, err := postPolicyCheck(user, action, resourceType, resourceDetails)
+	if err != nil {
+		return s.degradedTrainingGate(user, action, s.localGateMode, resourceDetails, fmt.Errorf("%w; %v", err, errNotEnrolled))
+	}
+	if decision.Action == "block" {
+		return false, decision.RequiredModules, nil
+	}
+	return true, nil, nil
+}
+
+// gateMode returns the gate mode the backend sent with its last decision,
+// or the configured one if it has sent none
+func (s *server) gateMode() string {
+	var mode string
+	if err := s.store.GetConfig(gateModeConfigKey, &mode); err != nil || !training.ValidGateMode(mode) {
+		return s.localGateMode
+	}
+	return mode
+}
+
+// setGateMode keeps the gate mode of a verified decision for when the
+// backend cannot decide
+func (s *server) setGateMode(mode string) {
+	if !training.ValidGateMode(mode) || mode == s.gateMode() {
+		return
+	}
+	if err := s.store.SetConfig(gateModeConfigKey, mode); err != nil {
+		slog.Warn("failed to store training gate mode", "error", err, "gate_mode", mode)
+		return
+	}
+	slog.Info("training gate mode set by the backend", "gate_mode", mode)
+}
+
+// cachedAllow returns the user's cached allow decision for action, if it
+// is still valid
+func (s *server) cachedAllow(user, action string) (*training.PolicyDecision, error) {
+	var decision training.PolicyDecision
+	if err := s.store.GetCache(gateCacheKey(user, action), &decision); err != nil {
+		return nil, err
+	}
+	pub, err := s.policyKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := decision.Verify(pub, time.Now()); err != nil {
+		return nil, err
+	}
+	if decision.UserID != user || decision.RequestedAction != action || decision.Action != "allow" {
+		return nil, errors.New("cached policy decision is for another request")
+	}
+	return &decision, nil
+}
+
+// degradedTrainingGate decides an operation the backend could not, as
+// mode says, and audits the decision. The audit event reaches the backend
+// once it can be reached again.
+func (s *server) degradedTrainingGate(user, action, mode string, resourceDetails map[string]interface{}, cause error) (bool, []training.Module, error) {
+	details := map[string]interface{}{
+		"gate_mode":        mode,
+		"error":            cause.Error(),
+		"resource_details": resourceDetails,
+	}
+
+	allowed := false
+	switch mode {
+	case training.GateFailOpen:
+		allowed = true
+	case training.GateCached:
+		if cached, err := s.cachedAllow(user, action); err == nil {
+			allowed = true
+			details["cached_decision_at"] = cached.IssuedAt
+			details["cached_decision_expires_at"] = cached.ExpiresAt
+		}
+	}
+
+	slog.Warn("backend unavailable for policy check",
+		"error", cause,
+		"gate_mode", mode,
+		"action", action,
+		"allowed", allowed,
+	)
+
+	status := "blocked"
+	if allowed {
+		status = "success"
+	}
+	// A decision that cannot be audited is not made
+	if err := s.recordAudit(map[string]interface{}{
+		"action":        "training:CheckGate",
+		"resource_type": "training:gate",
+		"resource_id":   action,
+		"status":        status,
+		"details":       details,
+	}); err != nil {
+		return false, nil, err
+	}
+
+	switch {
+	case allowed:
+		return true, nil, nil
+	case mode == training.GateCached:
+		return false, nil, fmt.Errorf("%w (%v), and the backend has not allowed %s in the last %g hours",
+			errGateUnavailable, cause, action, s.gateCacheTTL.Hours())
+	default:
+		return false, nil, fmt.Errorf("%w (%v)", errGateUnavailable, cause)
+	}
+}
+
+// requestPolicyDecision asks the backend whether user may perform action,
+// and checks that the answer is signed with the pinned key and made for
+// this request
+func (s *server) requestPolicyDecision(user, action, resourceType string, resourceDetails map[string]interface{}) (*training.PolicyDecision, error) {
+	pub, err := s.policyKey()
+	if err != nil {
+		return nil, err
+	}
+	resourceHash, err := training.HashResourceDetails(resourceDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := postPolicyCheck(user, action, resourceType, resourceDetails)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decision.Verify(pub, time.Now()); err != nil {
+		slog.Error("rejected policy decision", "error", err, "action", action)
+		return nil, err
+	}
+	if decision.UserID != user || decision.RequestedAction != action || decision.ResourceHash != resourceHash {
+		slog.Error("rejected policy decision made for another request", "action", action, "decision_action", decision.RequestedAction)
+		return nil, errors.New("policy decision is for another request")
+	}
+	if decision.Action != "allow" && decision.Action != "block" {
+		return nil, fmt.Errorf("unknown policy decision %q", decision.Action)
+	}
+	return decision, nil
+}
+
+// postPolicyCheck asks the backend whether user may perform action on a
+// resource of resourceType, and returns its answer unverified
+func postPolicyCheck(user, action, resourceType string, resourceDetails map[string]interface{}) (*training.PolicyDecision, error) {
+	reqBody := map[string]interface{}{
+		"user_id":          user,
+		"action":           action,
+		"resource_type":    resourceType,
+		"resource_details": resourceDetails,
+	}
+
+	bodyBytes, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Post(getBackendURL()+"/api/policies/check", "application/json", bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("backend unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("backend returned %d", resp.StatusCode)
+	}
+
+	var decision training.PolicyDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("decode policy decision: %w", err)
+	}
+	return &decision, nil
+}
+
+// writeGateError writes the response for a failed training gate check
+func writeGateError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errGateUnavailable) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"error": err.Error(),
+		})
+		return
+	}
+	writeJSON(w, http.StatusInternalServerError, map[string]string{
+		"error": "Failed to check training requirements",
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/outbox"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// newTestServer creates a server on a new agent store, with no backend
+func newTestServer(t *testing.T) *server {
+	t.Helper()
+	t.Setenv("ARK_BACKEND_URL", "http://127.0.0.1:1")
+	dir := t.TempDir()
+	st, err := store.New(filepath.Join(dir, "agent.db"), store.Options{KeyFile: filepath.Join(dir, "agent.key")})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return &server{
+		store:         st,
+		audit:         outbox.New(st, getBackendURL),
+		localGateMode: training.GateCached,
+		gateCacheTTL:  time.Hour,
+	}
+}
+
+// policyBackend is a backend stand-in that publishes its policy key and
+// allows every action, signing its decisions
+type policyBackend struct {
+	*httptest.Server
+	signer *training.Signer
+
+	mu            sync.Mutex
+	resourceTypes []string
+}
+
+func newPolicyBackend(t *testing.T, gateMode string) *policyBackend {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &policyBackend{signer: training.NewSigner(key, time.Hour, gateMode)}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/policies/public-key" {
+			writeJSON(w, http.StatusOK, b.signer.PublicKey())
+			return
+		}
+
+		var req checkPolicyBody
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.mu.Lock()
+		b.resourceTypes = append(b.resourceTypes, req.ResourceType)
+		b.mu.Unlock()
+
+		decision := &training.PolicyDecision{Action: "allow"}
+		if err := b.signer.Sign(decision, req.UserID, req.Action, req.ResourceDetails); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, decision)
+	}))
+	t.Cleanup(b.Close)
+	t.Setenv("ARK_BACKEND_URL", b.URL)
+	return b
+}
+
+type checkPolicyBody struct {
+	UserID          string                 `json:"user_id"`
+	Action          string                 `json:"action"`
+	ResourceType    string                 `json:"resource_type"`
+	ResourceDetails map[string]interface{} `json:"resource_details"`
+}
+
+// enroll pins the backend's key, as handleEnroll does
+func (b *policyBackend) enroll(t *testing.T, s *server) {
+	t.Helper()
+	key := b.signer.PublicKey()
+	e := enrollment{Algorithm: key.Algorithm, KeyID: key.KeyID, PublicKey: key.PublicKey, BackendURL: b.URL, EnrolledAt: time.Now()}
+	if err := s.store.SetConfig(enrollmentConfigKey, e); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Pointing ARK_BACKEND_URL at a dead host must not skip training
+func TestTrainingGateRefusesUnenrolledAgentWithoutBackend(t *testing.T) {
+	for _, mode := range []string{training.GateCached, training.GateFailClosed} {
+		t.Run(mode, func(t *testing.T) {
+			s := newTestServer(t)
+			s.localGateMode = mode
+			allowed, _, err := s.checkTrainingGate("s3:CreateBucket", "s3:bucket", map[string]interface{}{"bucket_name": "lab-data"})
+			if allowed || !errors.Is(err, errGateUnavailable) {
+				t.Fatalf("gate = %v, %v; want refused with errGateUnavailable", allowed, err)
+			}
+		})
+	}
+}
+
+func TestTrainingGateModeComesFromBackend(t *testing.T) {
+	details := map[string]interface{}{"bucket": "lab-data", "key": "results.csv"}
+	tests := []struct {
+		gateMode    string
+		wantAllowed bool
+	}{
+		{training.GateFailClosed, false},
+		{training.GateFailOpen, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.gateMode, func(t *testing.T) {
+			s := newTestServer(t)
+			backend := newPolicyBackend(t, tt.gateMode)
+			backend.enroll(t, s)
+
+			allowed, _, err := s.checkTrainingGate("s3:DeleteObject", "s3:object", details)
+			if !allowed || err != nil {
+				t.Fatalf("gate = %v, %v", allowed, err)
+			}
+			if got := backend.resourceTypes; len(got) != 1 || got[0] != "s3:object" {
+				t.Fatalf("backend was asked about resource types %v, want s3:object", got)
+			}
+
+			// The backend goes away; a new action has no cached decision
+			backend.Close()
+			allowed, _, err = s.checkTrainingGate("s3:PutObject", "s3:object", details)
+			if allowed != tt.wantAllowed {
+				t.Fatalf("gate without backend = %v, %v; want allowed %v", allowed, err, tt.wantAllowed)
+			}
+			if !allowed && !errors.Is(err, errGateUnavailable) {
+				t.Fatalf("refusal = %v, want errGateUnavailable", err)
+			}
+		})
+	}
+}
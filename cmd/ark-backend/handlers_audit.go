@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
 
@@ -22,15 +23,15 @@ func handleLogAudit(auditSvc *audit.Service) http.HandlerFunc {
 		}
 
 		// Validate required fields
-		if entry.Action == "" || entry.ResourceType == "" || entry.Status == "" {
+		if err := entry.Validate(); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{
-				"error": "action, resource_type, and status are required",
+				"error": err.Error(),
 			})
 			return
 		}
 
 		// Store audit log
-		if err := auditSvc.Log(r.Context(), entry); err != nil {
+		if err := auditSvc.Log(r.Context(), &entry); err != nil {
 			slog.Error("failed to store audit log", "error", err)
 			writeJSON(w, http.StatusInternalServerError, map[string]string{
 				"error": "Failed to store audit log",
@@ -52,6 +53,65 @@ func handleLogAudit(auditSvc *audit.Service) http.HandlerFunc {
 	}
 }
 
+// auditBatchRequest is the body of POST /api/audit/batch
+type auditBatchRequest struct {
+	Entries []audit.LogEntry `json:"entries"`
+}
+
+// auditBatchResponse reports the outcome of each entry of a batch, in order
+type auditBatchResponse struct {
+	Results []audit.BatchResult `json:"results"`
+}
+
+// handleLogAuditBatch stores several audit log entries from the agent in
+// one transaction. Entries are checked one by one, so an invalid entry is
+// reported in its result rather than failing the request.
+func handleLogAuditBatch(auditSvc *audit.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req auditBatchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			slog.Error("failed to decode audit batch", "error", err)
+			writeJSON(w, http.StatusBadRequest, map[string]string{
+				"error": "Invalid request body",
+			})
+			return
+		}
+
+		if len(req.Entries) > audit.MaxBatchSize {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{
+				"error": fmt.Sprintf("A batch holds at most %d entries", audit.MaxBatchSize),
+			})
+			return
+		}
+
+		results, err := auditSvc.LogBatch(r.Context(), req.Entries)
+		if err != nil {
+			slog.Error("failed to store audit batch", "error", err, "entries", len(req.Entries))
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "Failed to store audit logs",
+			})
+			return
+		}
+
+		counts := make(map[string]int)
+		for _, result := range results {
+			counts[result.Status]++
+		}
+		slog.Info("audit batch stored",
+			"entries", len(results),
+			"stored", counts[audit.EntryStored],
+			"duplicates", counts[audit.EntryDuplicate],
+			"rejected", counts[audit.EntryRejected],
+			"failed", counts[audit.EntryFailed],
+		)
+
+		if results == nil {
+			results = []audit.BatchResult{}
+		}
+		writeJSON(w, http.StatusOK, auditBatchResponse{Results: results})
+	}
+}
+
 // handleQueryAudit retrieves audit logs based on query parameters
 func handleQueryAudit(auditSvc *audit.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -9,17 +9,25 @@ import (
 	"github.com/scttfrdmn/ark/internal/training"
 )
 
-// handleCheckPolicy evaluates training gate policies for an action
-func handleCheckPolicy(trainingSvc *training.Service) http.HandlerFunc {
+// checkPolicyRequest is the body of POST /api/policies/check
+type checkPolicyRequest struct {
+	UserID          string                 `json:"user_id"`
+	Action          string                 `json:"action"`
+	ResourceType    string                 `json:"resource_type"`
+	ResourceDetails map[string]interface{} `json:"resource_details"`
+}
+
+// handleCheckPolicy evaluates training gate policies for an action and
+// signs the decision
+func handleCheckPolicy(trainingSvc *training.Service, signer *training.Signer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			UserID          string                 `json:"user_id"`
-			Action          string                 `json:"action"`
-			ResourceType    string                 `json:"resource_type"`
-			ResourceDetails map[string]interface{} `json:"resource_details"`
-		}
+		var req checkPolicyRequest
 
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		// Numbers in the resource details keep their form, so that their
+		// hash matches the agent's
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
 			slog.Error("failed to decode policy check request", "error", err)
 			writeJSON(w, http.StatusBadRequest, map[string]string{
 				"error": "Invalid request body",
@@ -49,6 +57,14 @@ func handleCheckPolicy(trainingSvc *training.Service) http.HandlerFunc {
 			return
 		}
 
+		if err := signer.Sign(decision, req.UserID, req.Action, req.ResourceDetails); err != nil {
+			slog.Error("failed to sign policy decision", "error", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{
+				"error": "Failed to evaluate policy",
+			})
+			return
+		}
+
 		slog.Info("policy evaluated",
 			"user_id", req.UserID,
 			"action", req.Action,
@@ -59,6 +75,13 @@ func handleCheckPolicy(trainingSvc *training.Service) http.HandlerFunc {
 	}
 }
 
+// handlePolicyPublicKey returns the key policy decisions are signed with
+func handlePolicyPublicKey(signer *training.Signer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, signer.PublicKey())
+	}
+}
+
 // handleGetUserProgress retrieves training progress for a user
 func handleGetUserProgress(trainingSvc *training.Service) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -16,6 +16,7 @@ import (
 	"github.com/go-chi/cors"
 	"github.com/scttfrdmn/ark/internal/audit"
 	"github.com/scttfrdmn/ark/internal/database"
+	"github.com/scttfrdmn/ark/internal/openapi"
 	"github.com/scttfrdmn/ark/internal/training"
 )
 
@@ -87,13 +88,41 @@ func main() {
 	auditSvc := audit.NewService(db)
 	trainingSvc := training.NewService(db)
 
+	// Policy decisions are signed, so agents can tell them from ones made
+	// up on the way. Agents pin the public key when they enroll, so the
+	// key file must be kept (and backed up) across restarts.
+	keyPath := getEnv("POLICY_SIGNING_KEY_FILE", "./policy-signing.key")
+	signingKey, created, err := training.LoadOrCreateSigningKey(keyPath)
+	if err != nil {
+		slog.Error("failed to load policy signing key", "error", err)
+		os.Exit(1)
+	}
+	decisionTTL := time.Duration(getEnvInt("POLICY_DECISION_TTL_HOURS", 24)) * time.Hour
+
+	// What agents do when they cannot reach the backend is decided here,
+	// not by their users, and sent with every decision
+	gateMode := getEnv("TRAINING_GATE_MODE", training.GateCached)
+	if !training.ValidGateMode(gateMode) {
+		slog.Error("invalid TRAINING_GATE_MODE (expected fail-open, fail-closed or cached)", "value", gateMode)
+		os.Exit(1)
+	}
+	signer := training.NewSigner(signingKey, decisionTTL, gateMode)
+	if created {
+		slog.Warn("created a new policy signing key; enrolled agents must enroll again", "path", keyPath, "key_id", signer.PublicKey().KeyID, "fingerprint", signer.Fingerprint())
+	} else {
+		slog.Info("policy signing key loaded", "path", keyPath, "key_id", signer.PublicKey().KeyID, "fingerprint", signer.Fingerprint())
+	}
+	slog.Info("training gate mode", "mode", gateMode)
+
 	slog.Info("services initialized")
 
 	// Create server
+	router := setupRouter(auditSvc, trainingSvc, signer)
+
 	addr := fmt.Sprintf("%s:%s", defaultHost, getEnv("PORT", defaultPort))
 	srv := &http.Server{
 		Addr:         addr,
-		Handler:      setupRouter(auditSvc, trainingSvc),
+		Handler:      router,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -133,7 +162,7 @@ func main() {
 	slog.Info("backend stopped")
 }
 
-func setupRouter(auditSvc *audit.Service, trainingSvc *training.Service) http.Handler {
+func setupRouter(auditSvc *audit.Service, trainingSvc *training.Service, signer *training.Signer) chi.Router {
 	r := chi.NewRouter()
 
 	// Middleware stack
@@ -160,6 +189,9 @@ func setupRouter(auditSvc *audit.Service, trainingSvc *training.Service) http.Ha
 	r.Route("/api", func(r chi.Router) {
 		r.Get("/version", handleVersion)
 
+		// OpenAPI document describing these routes
+		r.Get("/openapi.json", openapi.Handler(apiSpec()))
+
 		// System endpoints
 		r.Route("/system", func(r chi.Router) {
 			r.Get("/health", handleHealth)
@@ -169,12 +201,14 @@ func setupRouter(auditSvc *audit.Service, trainingSvc *training.Service) http.Ha
 		// Audit endpoints
 		r.Route("/audit", func(r chi.Router) {
 			r.Post("/log", handleLogAudit(auditSvc))
+			r.Post("/batch", handleLogAuditBatch(auditSvc))
 			r.Get("/logs", handleQueryAudit(auditSvc))
 		})
 
 		// Policy and training endpoints
 		r.Route("/policies", func(r chi.Router) {
-			r.Post("/check", handleCheckPolicy(trainingSvc))
+			r.Post("/check", handleCheckPolicy(trainingSvc, signer))
+			r.Get("/public-key", handlePolicyPublicKey(signer))
 		})
 
 		r.Route("/training", func(r chi.Router) {
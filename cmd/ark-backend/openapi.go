@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/scttfrdmn/ark/internal/audit"
+	"github.com/scttfrdmn/ark/internal/openapi"
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// apiSpec describes every route set up in setupRouter. The backend refuses
+// to start if the two disagree, so add an operation here with each new route.
+func apiSpec() *openapi.Document {
+	doc := openapi.New("Ark Backend API",
+		"Central service that evaluates training policy for agents and keeps the audit log.",
+		version)
+
+	errorSchema := openapi.Object(map[string]*openapi.Schema{"error": openapi.String()})
+	errorSchema.Required = []string{"error"}
+	doc.Components.Schemas["Error"] = errorSchema
+	errRef := openapi.Ref("Error")
+
+	badRequest := openapi.Reply("Invalid request", errRef)
+	internalError := openapi.Reply("Internal error", errRef)
+
+	healthOp := &openapi.Operation{
+		Summary: "Check that the backend is running",
+		Tags:    []string{"system"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Backend is healthy", openapi.Object(map[string]*openapi.Schema{
+				"status":  openapi.Enum("healthy"),
+				"version": openapi.String(),
+				"time":    openapi.DateTime(),
+			})),
+		},
+	}
+	versionOp := &openapi.Operation{
+		Summary: "Backend build information",
+		Tags:    []string{"system"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Build information", openapi.Object(map[string]*openapi.Schema{
+				"version":   openapi.String(),
+				"commit":    openapi.String(),
+				"buildDate": openapi.String(),
+			})),
+		},
+	}
+
+	// Health and system endpoints
+	doc.Add(http.MethodGet, "/health", healthOp)
+	doc.Add(http.MethodGet, "/api/version", versionOp)
+	doc.Add(http.MethodGet, "/api/system/health", healthOp)
+	doc.Add(http.MethodGet, "/api/system/version", versionOp)
+	doc.Add(http.MethodGet, "/api/openapi.json", &openapi.Operation{
+		Summary:   "This OpenAPI document",
+		Tags:      []string{"system"},
+		Responses: map[string]*openapi.Response{"200": openapi.Reply("OpenAPI 3 document", &openapi.Schema{Type: "object"})},
+	})
+
+	// Audit endpoints
+	logEntry := doc.Component("LogEntry", audit.LogEntry{})
+	doc.Add(http.MethodPost, "/api/audit/log", &openapi.Operation{
+		Summary:     "Record an audit log entry",
+		Description: "action, resource_type and status are required. id and created_at are assigned by the backend; occurred_at, when the client says the event happened, is kept as given. An entry whose event_id is already stored is not stored again; log_id is then the stored entry's. A user_id that is not a known user's is moved to details as unknown_user_id and the entry stored without a user.",
+		Tags:        []string{"audit"},
+		RequestBody: openapi.JSON(logEntry),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Entry stored", openapi.Object(map[string]*openapi.Schema{
+				"status": openapi.Enum("success"),
+				"log_id": openapi.String(),
+			})),
+			"400": badRequest,
+			"500": internalError,
+		},
+	})
+	doc.Add(http.MethodPost, "/api/audit/batch", &openapi.Operation{
+		Summary:     "Record several audit log entries in one transaction",
+		Description: "Entries are validated as for /api/audit/log, but one by one: the outcome of each is reported in results, in request order, and an invalid entry does not keep the others from being stored. Entries whose event_id is already stored are reported as duplicate. A rejected entry will not be stored if sent again; a failed one may be.",
+		Tags:        []string{"audit"},
+		RequestBody: openapi.JSON(doc.Component("AuditBatch", auditBatchRequest{})),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Outcome of each entry", doc.Component("AuditBatchResults", auditBatchResponse{})),
+			"400": badRequest,
+			"413": openapi.Reply("Too many entries", errRef),
+			"500": internalError,
+		},
+	})
+	doc.Add(http.MethodGet, "/api/audit/logs", &openapi.Operation{
+		Summary: "Query the 100 most recent matching audit log entries",
+		Tags:    []string{"audit"},
+		Parameters: []openapi.Parameter{
+			openapi.QueryParam("user_id", "Filter by user", openapi.String()),
+			openapi.QueryParam("action", "Filter by action, e.g. s3:CreateBucket", openapi.String()),
+			openapi.QueryParam("resource_type", "Filter by resource type", openapi.String()),
+			openapi.QueryParam("status", "Filter by outcome", openapi.Enum("success", "failure", "blocked")),
+		},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Matching entries, newest first", openapi.ArrayOf(logEntry)),
+			"500": internalError,
+		},
+	})
+
+	// Policy and training endpoints
+	doc.Add(http.MethodPost, "/api/policies/check", &openapi.Operation{
+		Summary: "Evaluate the training gate for an action",
+		Description: "user_id and action are required. A block lists the modules the user must complete first. " +
+			"Every decision is signed with the key from /api/policies/public-key; the signature covers key_id, user_id, requested_action, action, required_modules, " +
+			"resource_hash (the SHA-256 of the JSON encoding of resource_details, with sorted keys), issued_at, expires_at and gate_mode. " +
+			"gate_mode, set with TRAINING_GATE_MODE, is what the agent does when it cannot get a decision until it gets another: fail-open, fail-closed or cached.",
+		Tags:        []string{"policies"},
+		RequestBody: openapi.JSON(doc.Component("PolicyCheck", checkPolicyRequest{})),
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Policy decision", doc.Component("PolicyDecision", training.PolicyDecision{})),
+			"400": badRequest,
+			"500": internalError,
+		},
+	})
+	doc.Add(http.MethodGet, "/api/policies/public-key", &openapi.Operation{
+		Summary:     "The key policy decisions are signed with",
+		Description: "Agents fetch and pin this key when they enroll, and verify every decision against it.",
+		Tags:        []string{"policies"},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Ed25519 public key", doc.Component("PolicyPublicKey", training.PublicKey{})),
+		},
+	})
+	doc.Add(http.MethodGet, "/api/training/progress/{user_id}", &openapi.Operation{
+		Summary:    "A user's progress on each training module",
+		Tags:       []string{"training"},
+		Parameters: []openapi.Parameter{openapi.PathParam("user_id", "User ID")},
+		Responses: map[string]*openapi.Response{
+			"200": openapi.Reply("Training progress", openapi.Object(map[string]*openapi.Schema{
+				"user_id":  openapi.String(),
+				"progress": openapi.ArrayOf(doc.Component("Progress", training.Progress{})),
+			})),
+			"400": badRequest,
+			"500": internalError,
+		},
+	})
+
+	return doc
+}
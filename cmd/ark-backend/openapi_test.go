@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/scttfrdmn/ark/internal/openapi"
+)
+
+// Every route must be in the OpenAPI document, and every documented route
+// must exist
+func TestOpenAPIDocumentsRoutes(t *testing.T) {
+	if err := openapi.CheckRoutes(setupRouter(nil, nil, nil), apiSpec()); err != nil {
+		t.Fatal(err)
+	}
+}
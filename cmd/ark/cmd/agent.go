@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -10,6 +12,8 @@ import (
 
 	"github.com/scttfrdmn/ark/internal/agent/daemon"
 	"github.com/scttfrdmn/ark/internal/agent/lockfile"
+	"github.com/scttfrdmn/ark/internal/agentclient"
+	"github.com/scttfrdmn/ark/internal/config"
 	"github.com/spf13/cobra"
 )
 
@@ -18,14 +22,28 @@ func init() {
 	agentCmd.AddCommand(agentStartCmd)
 	agentCmd.AddCommand(agentStopCmd)
 	agentCmd.AddCommand(agentStatusCmd)
+	agentCmd.AddCommand(agentEnrollCmd)
+
+	agentEnrollCmd.Flags().StringVar(&enrollFingerprint, "fingerprint", "", "Fingerprint the backend's key must have, as published by your institution (default backend.policy_key_fingerprint)")
+	agentEnrollCmd.Flags().BoolVar(&enrollForce, "force", false, "Replace a pinned key the backend no longer uses")
 }
 
+var (
+	enrollFingerprint string
+	enrollForce       bool
+)
+
 var agentCmd = &cobra.Command{
 	Use:   "agent",
 	Short: "Manage the Ark agent",
 	Long: `Manage the Ark agent service that runs locally and brokers AWS credentials.
 
-The agent runs on localhost:8737 and must be running for Ark CLI commands to work.`,
+The agent must be running for Ark CLI commands to work. It listens on a Unix
+socket in ~/.ark that only you can use and, unless agent.listen is set to
+"socket", on agent.host:agent.port (127.0.0.1:8737 by default) for clients
+that authenticate with the agent token. On shared hosts the socket keeps each
+user's agent private, and a port already taken by another user's agent is
+skipped.`,
 }
 
 var agentStartCmd = &cobra.Command{
@@ -56,11 +74,8 @@ var agentStartCmd = &cobra.Command{
 		fmt.Println("Agent started successfully")
 
 		// Show log location
-		dataDir, _ := getDataDir()
-		if dataDir != "" {
-			logPath := filepath.Join(dataDir, "agent.log")
-			fmt.Printf("Logs: %s\n", logPath)
-		}
+		logPath := filepath.Join(newAgentClient(0).DataDir(), "agent.log")
+		fmt.Printf("Logs: %s\n", logPath)
 	},
 }
 
@@ -75,12 +90,7 @@ var agentStopCmd = &cobra.Command{
 		}
 
 		// Get lock file path
-		dataDir, err := getDataDir()
-		if err != nil {
-			ExitWithError(fmt.Errorf("get data directory: %w", err))
-		}
-
-		lockPath := filepath.Join(dataDir, "agent.lock")
+		lockPath := filepath.Join(newAgentClient(0).DataDir(), daemon.LockFileName)
 
 		// Get PID from lock file
 		pid := lockfile.GetLockedPID(lockPath)
@@ -131,55 +141,171 @@ var agentStatusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Check agent status",
 	Run: func(cmd *cobra.Command, args []string) {
-		if isAgentRunning() {
-			fmt.Println("✓ Agent is running")
+		client := newAgentClient(1 * time.Second)
+		status := struct {
+			Running  bool   `json:"running"`
+			Version  string `json:"version,omitempty"`
+			Socket   string `json:"socket,omitempty"`
+			Address  string `json:"address"`
+			Rejected bool   `json:"token_rejected,omitempty"`
+
+			AuditQueued   int   `json:"audit_queued,omitempty"`
+			AuditRejected int   `json:"audit_rejected,omitempty"`
+			Enrolled      *bool `json:"enrolled,omitempty"`
+		}{
+			Running: client.Healthy(context.Background()),
+			Address: client.Addr(),
+		}
+		if status.Running {
+			status.Version, _ = getAgentVersion()
+			status.Socket = client.SocketPath()
+
+			var health struct {
+				AuditQueued   int   `json:"audit_queued"`
+				AuditRejected int   `json:"audit_rejected"`
+				Enrolled      *bool `json:"enrolled"`
+			}
+			if err := client.Get(context.Background(), "/api/system/health", &health); err == nil {
+				status.AuditQueued, status.AuditRejected = health.AuditQueued, health.AuditRejected
+				status.Enrolled = health.Enrolled
+			}
+		} else {
+			status.Rejected = client.TokenRejected(context.Background())
+		}
 
-			// Try to get version
-			version, err := getAgentVersion()
-			if err == nil {
-				fmt.Printf("  Version: %s\n", version)
+		if jsonOutput {
+			printJSON(status)
+		} else if status.Running {
+			fmt.Println("✓ Agent is running")
+			if status.Version != "" {
+				fmt.Printf("  Version: %s\n", status.Version)
+			}
+			if status.Socket != "" {
+				fmt.Printf("  Socket: %s\n", status.Socket)
+			}
+			if status.AuditQueued > 0 {
+				fmt.Printf("⚠ %d audit events are waiting to be delivered to the backend\n", status.AuditQueued)
 			}
-			os.Exit(0)
+			if status.AuditRejected > 0 {
+				fmt.Printf("⚠ The backend rejected %d audit events; they are kept in the agent's database\n", status.AuditRejected)
+			}
+			if status.Enrolled != nil && !*status.Enrolled {
+				fmt.Println("⚠ Not enrolled with the backend, so training requirements cannot be checked")
+				fmt.Println("  Enroll with: ark agent enroll")
+			}
+		} else if status.Rejected {
+			fmt.Printf("✗ An agent is running on %s but rejected this user's token\n", status.Address)
+			fmt.Println("")
+			fmt.Println("It may belong to another user on this host.")
 		} else {
 			fmt.Println("✗ Agent is not running")
 			fmt.Println("")
 			fmt.Println("Start the agent with: ark agent start")
+		}
+
+		if !status.Running {
+			os.Exit(1)
+		}
+	},
+}
+
+var agentEnrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Pin the backend's policy signing key",
+	Long: `Fetch the backend's policy signing key and pin it in the agent. The agent
+only accepts training gate decisions signed with the pinned key, so a
+spoofed backend or a proxy cannot grant access, and does what the backend
+says when it cannot be reached. Until the agent is enrolled, the backend's
+answers are taken on trust, and operations are refused when it cannot be
+reached.
+
+The key is pinned only if its fingerprint, the SHA-256 of the key, is the one
+your institution publishes. Give it with --fingerprint, or set it once with
+"ark config set backend.policy_key_fingerprint". If the backend's key has
+been replaced, enroll again with the new fingerprint and --force.
+
+Examples:
+  ark agent enroll --fingerprint 3f2a9c0d1e2b4a5c...
+  ark agent enroll
+  ark agent enroll --fingerprint 7c1d0e5f9a2b3c4d... --force`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		fingerprint := enrollFingerprint
+		if fingerprint == "" {
+			if path, err := config.GetConfigPath(); err == nil {
+				if cfg, err := config.Load(path); err == nil {
+					fingerprint = cfg.Backend.PolicyKeyFingerprint
+				}
+			}
+		}
+		if fingerprint == "" {
+			ExitWithError(errors.New("the backend's key fingerprint, as published by your institution, is required: give it with --fingerprint or set backend.policy_key_fingerprint"))
+		}
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		req := map[string]interface{}{"fingerprint": fingerprint, "force": enrollForce}
+		var raw json.RawMessage
+		err := newAgentClient(15*time.Second).Post(context.Background(), "/api/enrollment", req, &raw)
+		var apiErr *agentclient.Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict && !jsonOutput {
+			var conflict struct {
+				KeyID       string `json:"key_id"`
+				Fingerprint string `json:"fingerprint"`
+				PinnedKeyID string `json:"pinned_key_id"`
+			}
+			apiErr.Decode(&conflict)
+			if conflict.PinnedKeyID != "" {
+				fmt.Printf("✗ The backend's policy key (%s) is not the pinned key (%s)\n", conflict.KeyID, conflict.PinnedKeyID)
+				fmt.Println("  If your institution has replaced its key, enroll again with: ark agent enroll --force")
+			} else {
+				fmt.Printf("✗ The backend's policy key has fingerprint %s, not %s\n", conflict.Fingerprint, fingerprint)
+				fmt.Println("  Check that ARK_BACKEND_URL points at your institution's backend.")
+			}
 			os.Exit(1)
 		}
+		if err != nil {
+			ExitWithError(fmt.Errorf("failed to enroll: %w", err))
+		}
+
+		var enrolled struct {
+			KeyID       string `json:"key_id"`
+			Algorithm   string `json:"algorithm"`
+			Fingerprint string `json:"fingerprint"`
+			BackendURL  string `json:"backend_url"`
+		}
+		if !decodeResult(raw, &enrolled) {
+			return
+		}
+		fmt.Printf("✓ Enrolled with %s\n", enrolled.BackendURL)
+		fmt.Printf("  Policy key: %s (%s)\n", enrolled.KeyID, enrolled.Algorithm)
+		fmt.Printf("  Fingerprint: %s\n", enrolled.Fingerprint)
 	},
 }
 
 // isAgentRunning checks if the agent is responding to health checks
 func isAgentRunning() bool {
-	client := &http.Client{
-		Timeout: 1 * time.Second,
-	}
-	resp, err := client.Get("http://127.0.0.1:8737/api/system/health")
-	if err != nil {
-		return false
-	}
-	defer resp.Body.Close()
-	return resp.StatusCode == http.StatusOK
+	return newAgentClient(1 * time.Second).Healthy(context.Background())
+}
+
+// agentRejectsToken reports whether something is answering on the agent
+// port but refusing this user's token, e.g. another user's agent on a
+// shared host
+func agentRejectsToken() bool {
+	return newAgentClient(1 * time.Second).TokenRejected(context.Background())
 }
 
 // getAgentVersion gets the agent version
 func getAgentVersion() (string, error) {
-	client := &http.Client{
-		Timeout: 1 * time.Second,
-	}
-	resp, err := client.Get("http://127.0.0.1:8737/api/system/version")
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
 	var versionResp struct {
 		Version string `json:"version"`
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&versionResp); err != nil {
+	if err := newAgentClient(1*time.Second).Get(context.Background(), "/api/system/version", &versionResp); err != nil {
 		return "", err
 	}
-
 	return versionResp.Version, nil
 }
 
@@ -197,25 +323,25 @@ func waitForAgent(timeout time.Duration) error {
 			if isAgentRunning() {
 				return nil
 			}
-			fmt.Print(".")
+			if !jsonOutput {
+				fmt.Print(".")
+			}
 		}
 	}
 }
 
-// getDataDir returns the agent data directory
-func getDataDir() (string, error) {
-	// Check environment variable first
-	if dir := os.Getenv("ARK_AGENT_DATA"); dir != "" {
-		return dir, nil
+// agentSocketEnabled reports whether the agent is configured to serve on its
+// Unix socket
+func agentSocketEnabled() bool {
+	path, err := config.GetConfigPath()
+	if err != nil {
+		return false
 	}
-
-	// Use ~/.ark for data storage
-	home, err := os.UserHomeDir()
+	cfg, err := config.Load(path)
 	if err != nil {
-		return "", fmt.Errorf("get home directory: %w", err)
+		return false
 	}
-
-	return filepath.Join(home, ".ark"), nil
+	return cfg.Agent.ListenSocket()
 }
 
 // EnsureAgentRunning ensures the agent is running, starting it if necessary
@@ -224,10 +350,13 @@ func getDataDir() (string, error) {
 func EnsureAgentRunning() error {
 	// Check if auto-start is disabled
 	if os.Getenv("ARK_NO_AUTO_START") != "" {
-		if !isAgentRunning() {
-			return fmt.Errorf("agent is not running (auto-start disabled)")
+		if isAgentRunning() {
+			return nil
 		}
-		return nil
+		if agentRejectsToken() {
+			return fmt.Errorf("an agent on %s rejected this user's token", newAgentClient(0).Addr())
+		}
+		return fmt.Errorf("agent is not running (auto-start disabled)")
 	}
 
 	// Agent already running - nothing to do
@@ -235,6 +364,12 @@ func EnsureAgentRunning() error {
 		return nil
 	}
 
+	// Starting another agent would only fail on the busy port, unless it
+	// can serve on its own socket
+	if !agentSocketEnabled() && agentRejectsToken() {
+		return fmt.Errorf("an agent on %s rejected this user's token; it may belong to another user, or was started with a different data directory", newAgentClient(0).Addr())
+	}
+
 	// Start agent in background
 	if err := daemon.Start(""); err != nil {
 		return fmt.Errorf("auto-start agent: %w", err)
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+)
+
+// newAgentClient returns an agent client set up from the global flags. A
+// zero timeout means agentclient.DefaultTimeout.
+func newAgentClient(timeout time.Duration) *agentclient.Client {
+	opts := agentclient.Options{
+		Timeout: timeout,
+		PromptMFA: func(challenge *agentclient.Error) (string, error) {
+			if challenge.Message != "" {
+				fmt.Fprintf(os.Stderr, "✗ %s\n", challenge.Message)
+			}
+			return promptMFACode(challenge.Profile, challenge.MFASerial)
+		},
+	}
+	if verbose {
+		opts.Verbose = os.Stderr
+	}
+
+	client, err := agentclient.New(opts)
+	if err != nil {
+		ExitWithError(err)
+	}
+	return client
+}
+
+// callAgent sends a request to the agent and decodes the response into
+// dest, exiting on failure. With --json the response is printed as-is and
+// callAgent returns false, so the caller skips its own output.
+func callAgent(client *agentclient.Client, method, path string, body, dest interface{}) bool {
+	var raw json.RawMessage
+	if err := client.Do(context.Background(), method, path, body, &raw); err != nil {
+		ExitWithError(err)
+	}
+	return decodeResult(raw, dest)
+}
+
+// decodeResult decodes an agent response into dest, or prints it with
+// --json and returns false
+func decodeResult(raw json.RawMessage, dest interface{}) bool {
+	if jsonOutput {
+		printJSON(raw)
+		return false
+	}
+	if dest != nil && len(raw) > 0 {
+		if err := json.Unmarshal(raw, dest); err != nil {
+			ExitWithError(fmt.Errorf("decode agent response: %w", err))
+		}
+	}
+	return true
+}
+
+// printJSON writes a value to stdout as indented JSON
+func printJSON(v interface{}) {
+	writeJSON(os.Stdout, v)
+}
+
+func writeJSON(w io.Writer, v interface{}) {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: encode output: %v\n", err)
+	}
+}
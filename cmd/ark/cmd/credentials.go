@@ -1,14 +1,18 @@
 package cmd
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"syscall"
 	"time"
 
+	"github.com/scttfrdmn/ark/internal/agentclient"
+	"github.com/scttfrdmn/ark/internal/config"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
@@ -18,12 +22,38 @@ func init() {
 	credentialsCmd.AddCommand(credentialsSetCmd)
 	credentialsCmd.AddCommand(credentialsListCmd)
 	credentialsCmd.AddCommand(credentialsDeleteCmd)
+	credentialsCmd.AddCommand(credentialsRekeyCmd)
+	credentialsCmd.AddCommand(credentialsMigrateCmd)
+	credentialsCmd.AddCommand(credentialsImportCmd)
+	credentialsCmd.AddCommand(credentialsStatusCmd)
+	credentialsCmd.AddCommand(credentialsRotateCmd)
 
 	// Flags for set command
 	credentialsSetCmd.Flags().String("access-key-id", "", "AWS access key ID")
 	credentialsSetCmd.Flags().String("secret-access-key", "", "AWS secret access key")
 	credentialsSetCmd.Flags().String("session-token", "", "AWS session token (for temporary credentials)")
+	credentialsSetCmd.Flags().String("expiration", "", "Expiry of temporary credentials (RFC 3339, e.g. 2026-01-02T15:04:05Z)")
 	credentialsSetCmd.Flags().String("region", "us-east-1", "Default AWS region")
+	credentialsSetCmd.Flags().String("role-arn", "", "IAM role to assume (makes this a role profile)")
+	credentialsSetCmd.Flags().String("source-profile", "", "Profile whose credentials are used to assume the role")
+	credentialsSetCmd.Flags().String("external-id", "", "External ID required by the role's trust policy")
+	credentialsSetCmd.Flags().String("credential-process", "", "Command that prints credentials in the AWS credential_process JSON format")
+	credentialsSetCmd.Flags().Bool("validate", true, "Check the credentials with AWS STS before storing them")
+	credentialsSetCmd.Flags().String("mfa-serial", "", "ARN of the MFA device; AWS commands prompt for a code when no session is cached")
+
+	// Flags for rekey command
+	credentialsRekeyCmd.Flags().Bool("passphrase", false, "Derive the new key from a passphrase instead of a key file")
+
+	// Flags for migrate command
+	credentialsMigrateCmd.Flags().String("to", "", "Destination backend: bolt, keyring or file")
+	credentialsMigrateCmd.MarkFlagRequired("to")
+
+	// Flags for import command
+	credentialsImportCmd.Flags().Bool("all", false, "Import every profile found in the AWS config files")
+	credentialsImportCmd.Flags().Bool("overwrite", false, "Replace Ark profiles that already exist")
+
+	// Flags for status command
+	credentialsStatusCmd.Flags().Bool("validate", false, "Check the credentials with AWS STS before reporting")
 }
 
 var credentialsCmd = &cobra.Command{
@@ -49,8 +79,20 @@ Examples:
   # Provide credentials via flags
   ark credentials set prod --access-key-id AKIA... --secret-access-key ...
 
-Note: Credentials are stored locally and not encrypted. In production
-environments, consider using IAM roles or AWS SSO instead.`,
+  # Assume a role using the keys stored in another profile
+  ark credentials set lab --role-arn arn:aws:iam::123456789012:role/Researcher \
+    --source-profile default
+
+  # Obtain credentials from an external helper (e.g. Vault)
+  ark credentials set vault --credential-process "vault-aws-creds --role researcher"
+
+  # Require an MFA code (prompted once per session) for a profile
+  ark credentials set prod --mfa-serial arn:aws:iam::123456789012:mfa/alice
+
+Credentials are encrypted at rest with a per-install key kept in
+~/.ark/agent.key, or derived from ARK_AGENT_PASSPHRASE after
+'ark credentials rekey --passphrase'. Where possible, prefer IAM roles
+or AWS SSO over long-lived access keys.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		profile := args[0]
@@ -64,68 +106,102 @@ environments, consider using IAM roles or AWS SSO instead.`,
 		accessKeyID, _ := cmd.Flags().GetString("access-key-id")
 		secretAccessKey, _ := cmd.Flags().GetString("secret-access-key")
 		sessionToken, _ := cmd.Flags().GetString("session-token")
+		expiration, _ := cmd.Flags().GetString("expiration")
 		region, _ := cmd.Flags().GetString("region")
+		roleARN, _ := cmd.Flags().GetString("role-arn")
+		sourceProfile, _ := cmd.Flags().GetString("source-profile")
+		externalID, _ := cmd.Flags().GetString("external-id")
+		mfaSerial, _ := cmd.Flags().GetString("mfa-serial")
+		validate, _ := cmd.Flags().GetBool("validate")
+		credentialProcess, _ := cmd.Flags().GetString("credential-process")
 
-		// Prompt for missing credentials
-		if accessKeyID == "" {
-			fmt.Print("AWS Access Key ID: ")
-			fmt.Scanln(&accessKeyID)
+		// Create request payload
+		payload := map[string]interface{}{
+			"profile":  profile,
+			"region":   region,
+			"validate": validate,
 		}
 
-		if secretAccessKey == "" {
-			fmt.Print("AWS Secret Access Key: ")
-			secretBytes, err := term.ReadPassword(int(syscall.Stdin))
-			if err != nil {
-				ExitWithError(fmt.Errorf("failed to read password: %w", err))
+		if credentialProcess != "" {
+			// The agent runs the command whenever it needs fresh credentials
+			payload["credential_process"] = credentialProcess
+		} else if roleARN != "" {
+			// Role profiles borrow keys from their source profile
+			if sourceProfile == "" {
+				ExitWithError(fmt.Errorf("--source-profile is required with --role-arn"))
+			}
+			payload["role_arn"] = roleARN
+			payload["source_profile"] = sourceProfile
+			if externalID != "" {
+				payload["external_id"] = externalID
+			}
+			if mfaSerial != "" {
+				payload["mfa_serial"] = mfaSerial
+			}
+		} else {
+			// Prompt for missing credentials
+			if accessKeyID == "" {
+				fmt.Print("AWS Access Key ID: ")
+				fmt.Scanln(&accessKeyID)
 			}
-			fmt.Println() // New line after password input
-			secretAccessKey = string(secretBytes)
-		}
 
-		// Validate required fields
-		if accessKeyID == "" || secretAccessKey == "" {
-			ExitWithError(fmt.Errorf("access-key-id and secret-access-key are required"))
-		}
+			if secretAccessKey == "" {
+				fmt.Print("AWS Secret Access Key: ")
+				secretBytes, err := term.ReadPassword(int(syscall.Stdin))
+				if err != nil {
+					ExitWithError(fmt.Errorf("failed to read password: %w", err))
+				}
+				fmt.Println() // New line after password input
+				secretAccessKey = string(secretBytes)
+			}
 
-		// Create request payload
-		payload := map[string]interface{}{
-			"profile":           profile,
-			"access_key_id":     accessKeyID,
-			"secret_access_key": secretAccessKey,
-			"region":            region,
-		}
-		if sessionToken != "" {
-			payload["session_token"] = sessionToken
-		}
+			// Validate required fields
+			if accessKeyID == "" || secretAccessKey == "" {
+				ExitWithError(fmt.Errorf("access-key-id and secret-access-key are required"))
+			}
 
-		// Send to agent
-		jsonData, err := json.Marshal(payload)
-		if err != nil {
-			ExitWithError(fmt.Errorf("marshal credentials: %w", err))
+			payload["access_key_id"] = accessKeyID
+			payload["secret_access_key"] = secretAccessKey
+			if mfaSerial != "" {
+				payload["mfa_serial"] = mfaSerial
+			}
+			if sessionToken != "" {
+				payload["session_token"] = sessionToken
+			}
+			if expiration != "" {
+				expiresAt, err := time.Parse(time.RFC3339, expiration)
+				if err != nil {
+					ExitWithError(fmt.Errorf("invalid --expiration: %w", err))
+				}
+				payload["expiration"] = expiresAt
+			}
 		}
 
-		resp, err := http.Post(
-			"http://127.0.0.1:8737/api/credentials",
-			"application/json",
-			bytes.NewBuffer(jsonData),
-		)
-		if err != nil {
-			ExitWithError(fmt.Errorf("send to agent: %w", err))
+		// Send to agent. Validation may run a credential process or wait on
+		// STS, so allow longer than usual.
+		client := newAgentClient(2 * time.Minute)
+		var raw json.RawMessage
+		if err := client.Post(context.Background(), "/api/credentials", payload, &raw); err != nil {
+			status := agentclient.StatusCode(err)
+			if validate && (status == http.StatusBadRequest || status == http.StatusBadGateway) {
+				ExitWithError(fmt.Errorf("credentials were not stored: %w\n(use --validate=false to store them without checking)", err))
+			}
+			ExitWithError(err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
-			var errResp struct {
-				Error string `json:"error"`
-			}
-			json.NewDecoder(resp.Body).Decode(&errResp)
-			ExitWithError(fmt.Errorf("agent error: %s", errResp.Error))
+		var result struct {
+			AccountID string `json:"account_id"`
+			ARN       string `json:"arn"`
+		}
+		if !decodeResult(raw, &result) {
+			return
 		}
 
 		fmt.Printf("✓ Credentials stored for profile '%s'\n", profile)
-		fmt.Println()
-		fmt.Println("Note: Credentials are stored locally and not encrypted.")
-		fmt.Println("In production, consider using IAM roles or AWS SSO.")
+		if result.AccountID != "" {
+			fmt.Printf("  Account:   %s\n", result.AccountID)
+			fmt.Printf("  Identity:  %s\n", result.ARN)
+		}
 	},
 }
 
@@ -140,23 +216,15 @@ var credentialsListCmd = &cobra.Command{
 		}
 
 		// Query agent
-		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Get("http://127.0.0.1:8737/api/credentials")
-		if err != nil {
-			ExitWithError(fmt.Errorf("query agent: %w", err))
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			ExitWithError(fmt.Errorf("agent returned status %d", resp.StatusCode))
-		}
-
 		var profiles []struct {
-			Profile string `json:"profile"`
-			Region  string `json:"region"`
+			Profile   string `json:"profile"`
+			Type      string `json:"type"`
+			Region    string `json:"region"`
+			AccountID string `json:"account_id"`
+			ARN       string `json:"arn"`
 		}
-		if err := json.NewDecoder(resp.Body).Decode(&profiles); err != nil {
-			ExitWithError(fmt.Errorf("decode response: %w", err))
+		if !callAgent(newAgentClient(5*time.Second), http.MethodGet, "/api/credentials", nil, &profiles) {
+			return
 		}
 
 		if len(profiles) == 0 {
@@ -169,7 +237,221 @@ var credentialsListCmd = &cobra.Command{
 		fmt.Println("Stored credential profiles:")
 		fmt.Println()
 		for _, p := range profiles {
-			fmt.Printf("  %s  (region: %s)\n", p.Profile, p.Region)
+			account := p.AccountID
+			if account == "" {
+				account = "unvalidated"
+			}
+			fmt.Printf("  %s  (type: %s, region: %s, account: %s)\n", p.Profile, p.Type, p.Region, account)
+			if p.ARN != "" {
+				fmt.Printf("      %s\n", p.ARN)
+			}
+		}
+	},
+}
+
+var credentialsStatusCmd = &cobra.Command{
+	Use:   "status [profile-name]",
+	Short: "Show credential age, expiry and identity",
+	Long: `Show when credentials were stored and last validated, which AWS account
+and identity they belong to, and when they expire.
+
+Long-term access keys older than agent.credential_max_age_days (90 days by
+default) are flagged for rotation, as are expired temporary credentials.
+
+Examples:
+  # Status of every profile
+  ark credentials status
+
+  # Check one profile against AWS STS first
+  ark credentials status prod --validate`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		validate, _ := cmd.Flags().GetBool("validate")
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		query := url.Values{}
+		if len(args) == 1 {
+			query.Set("profile", args[0])
+		}
+		if validate {
+			query.Set("validate", "true")
+		}
+
+		// Validation may run a credential process or wait on STS
+		var raw json.RawMessage
+		err := newAgentClient(2*time.Minute).Get(context.Background(), "/api/credentials/status?"+query.Encode(), &raw)
+		if agentclient.StatusCode(err) == http.StatusNotFound {
+			ExitWithError(fmt.Errorf("profile not found: %s", args[0]))
+		}
+		if err != nil {
+			ExitWithError(err)
+		}
+
+		var statuses []struct {
+			Profile     string     `json:"profile"`
+			Type        string     `json:"type"`
+			AccountID   string     `json:"account_id"`
+			ARN         string     `json:"arn"`
+			StoredAt    *time.Time `json:"stored_at"`
+			ValidatedAt *time.Time `json:"validated_at"`
+			Expiration  *time.Time `json:"expiration"`
+			AgeDays     *int       `json:"age_days"`
+			State       string     `json:"state"`
+			Warnings    []string   `json:"warnings"`
+		}
+		if err := json.Unmarshal(raw, &statuses); err != nil {
+			ExitWithError(fmt.Errorf("decode agent response: %w", err))
+		}
+
+		needsAttention := false
+		for _, st := range statuses {
+			if st.State != "ok" {
+				needsAttention = true
+			}
+		}
+
+		if jsonOutput {
+			printJSON(raw)
+			if needsAttention {
+				os.Exit(1)
+			}
+			return
+		}
+
+		if len(statuses) == 0 {
+			fmt.Println("No credentials stored.")
+			return
+		}
+
+		formatTime := func(t *time.Time) string {
+			if t == nil {
+				return "never"
+			}
+			return t.Local().Format("2006-01-02 15:04:05")
+		}
+
+		for i, st := range statuses {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			mark := "✓"
+			if st.State != "ok" {
+				mark = "✗"
+			}
+			fmt.Printf("%s %s  (%s, %s)\n", mark, st.Profile, st.Type, st.State)
+
+			if st.AccountID != "" {
+				fmt.Printf("  Account:    %s\n", st.AccountID)
+			}
+			if st.ARN != "" {
+				fmt.Printf("  Identity:   %s\n", st.ARN)
+			}
+			fmt.Printf("  Stored:     %s\n", formatTime(st.StoredAt))
+			fmt.Printf("  Validated:  %s\n", formatTime(st.ValidatedAt))
+			if st.AgeDays != nil {
+				fmt.Printf("  Key age:    %d days\n", *st.AgeDays)
+			}
+			if st.Expiration != nil {
+				fmt.Printf("  Expires:    %s\n", formatTime(st.Expiration))
+			}
+			for _, warning := range st.Warnings {
+				fmt.Printf("  ⚠ %s\n", warning)
+			}
+		}
+
+		if needsAttention {
+			os.Exit(1)
+		}
+	},
+}
+
+var credentialsRotateCmd = &cobra.Command{
+	Use:   "rotate <profile-name>",
+	Short: "Rotate the IAM access key for a profile",
+	Long: `Replace a profile's IAM access key with a new one.
+
+The agent creates a new access key, checks that it works, stores it, and
+then deactivates and deletes the old key. If a step fails before the old
+key is deactivated, the agent restores the old credentials and deletes the
+new key. Every step is recorded in the audit log.
+
+The IAM user may have at most one access key before rotating, and the
+credentials must allow iam:ListAccessKeys, iam:CreateAccessKey,
+iam:UpdateAccessKey and iam:DeleteAccessKey on the user itself.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile := args[0]
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		// New keys can take a while to propagate through IAM. A failed
+		// rotation still reports its steps.
+		client := newAgentClient(2 * time.Minute)
+		var raw json.RawMessage
+		err := client.Post(context.Background(), "/api/credentials/"+url.PathEscape(profile)+"/rotate", nil, &raw)
+		var apiErr *agentclient.Error
+		if errors.As(err, &apiErr) && apiErr.Status == "failure" {
+			raw = apiErr.Body
+		} else if err != nil {
+			ExitWithError(err)
+		}
+
+		var result struct {
+			Status         string `json:"status"`
+			Error          string `json:"error"`
+			OldAccessKeyID string `json:"old_access_key_id"`
+			NewAccessKeyID string `json:"new_access_key_id"`
+			Steps          []struct {
+				Name        string `json:"name"`
+				AccessKeyID string `json:"access_key_id"`
+				Status      string `json:"status"`
+				Error       string `json:"error"`
+			} `json:"steps"`
+		}
+		if err := json.Unmarshal(raw, &result); err != nil {
+			ExitWithError(fmt.Errorf("decode agent response: %w", err))
+		}
+
+		if jsonOutput {
+			printJSON(raw)
+			if result.Status != "success" {
+				os.Exit(1)
+			}
+			return
+		}
+
+		for _, step := range result.Steps {
+			mark := "✓"
+			if step.Status != "success" {
+				mark = "✗"
+			}
+			fmt.Printf("  %s %-24s %s\n", mark, step.Name, step.AccessKeyID)
+			if step.Error != "" {
+				fmt.Printf("      %s\n", step.Error)
+			}
+		}
+		if len(result.Steps) > 0 {
+			fmt.Println()
+		}
+
+		switch result.Status {
+		case "success":
+			fmt.Printf("✓ Rotated access key for profile '%s'\n", profile)
+			fmt.Printf("  Old key %s was deleted; new key is %s\n", result.OldAccessKeyID, result.NewAccessKeyID)
+		case "partial":
+			fmt.Printf("⚠ New access key stored for profile '%s', but cleanup failed:\n", profile)
+			fmt.Printf("  %s\n", result.Error)
+			os.Exit(1)
+		default:
+			ExitWithError(fmt.Errorf("rotation failed: %s", result.Error))
 		}
 	},
 }
@@ -188,28 +470,253 @@ var credentialsDeleteCmd = &cobra.Command{
 		}
 
 		// Send delete request to agent
-		url := fmt.Sprintf("http://127.0.0.1:8737/api/credentials/%s", profile)
-		req, err := http.NewRequest(http.MethodDelete, url, nil)
+		var raw json.RawMessage
+		err := newAgentClient(5*time.Second).Delete(context.Background(), "/api/credentials/"+url.PathEscape(profile), &raw)
+		if agentclient.StatusCode(err) == http.StatusNotFound && !jsonOutput {
+			fmt.Printf("Profile '%s' not found\n", profile)
+			os.Exit(1)
+		}
+		if err != nil {
+			ExitWithError(err)
+		}
+		if !decodeResult(raw, nil) {
+			return
+		}
+
+		fmt.Printf("✓ Deleted credentials for profile '%s'\n", profile)
+	},
+}
+
+var credentialsRekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt stored credentials with a new key",
+	Long: `Replace the key that protects stored credentials.
+
+By default a new random key is written to ~/.ark/agent.key (mode 0600).
+With --passphrase the new key is derived from a passphrase instead, and the
+agent must be started with ARK_AGENT_PASSPHRASE set from then on.
+
+Examples:
+  # Rotate the key file
+  ark credentials rekey
+
+  # Switch to a passphrase-derived key
+  ark credentials rekey --passphrase`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		usePassphrase, _ := cmd.Flags().GetBool("passphrase")
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		payload := map[string]string{}
+		if usePassphrase {
+			fmt.Print("New passphrase: ")
+			first, err := term.ReadPassword(int(syscall.Stdin))
+			if err != nil {
+				ExitWithError(fmt.Errorf("failed to read passphrase: %w", err))
+			}
+			fmt.Println()
+
+			fmt.Print("Confirm passphrase: ")
+			second, err := term.ReadPassword(int(syscall.Stdin))
+			if err != nil {
+				ExitWithError(fmt.Errorf("failed to read passphrase: %w", err))
+			}
+			fmt.Println()
+
+			if len(first) == 0 {
+				ExitWithError(fmt.Errorf("passphrase cannot be empty"))
+			}
+			if string(first) != string(second) {
+				ExitWithError(fmt.Errorf("passphrases do not match"))
+			}
+			payload["passphrase"] = string(first)
+		}
+
+		if !callAgent(newAgentClient(0), http.MethodPost, "/api/credentials/rekey", payload, nil) {
+			return
+		}
+
+		fmt.Println("✓ Credentials re-encrypted with a new key")
+		if usePassphrase {
+			fmt.Println()
+			fmt.Println("Set ARK_AGENT_PASSPHRASE before the agent is next started.")
+		}
+	},
+}
+
+var credentialsMigrateCmd = &cobra.Command{
+	Use:   "migrate --to <backend>",
+	Short: "Move stored credentials to another storage backend",
+	Long: `Move every stored profile to a different credential storage backend.
+
+Backends:
+  bolt     Encrypted records in the agent database (~/.ark/agent.db)
+  keyring  Linux kernel keyring. Its entries do not survive a reboot, so
+           an encrypted copy is kept in the agent database and restored
+           from there
+  file     Keyring stand-in using encrypted 0600 files in
+           ~/.ark/credentials, for headless hosts and testing
+
+After a successful migration the new backend is saved as
+agent.credential_backend in your configuration.
+
+Examples:
+  ark credentials migrate --to keyring
+  ark credentials migrate --to bolt`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		to, _ := cmd.Flags().GetString("to")
+
+		// Validate and load config before touching the agent
+		path, err := config.GetConfigPath()
+		if err != nil {
+			ExitWithError(fmt.Errorf("get config path: %w", err))
+		}
+		cfg, err := config.Load(path)
 		if err != nil {
-			ExitWithError(fmt.Errorf("create request: %w", err))
+			ExitWithError(fmt.Errorf("load config: %w", err))
+		}
+		if err := cfg.Set("agent.credential_backend", to); err != nil {
+			ExitWithError(err)
+		}
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		var raw json.RawMessage
+		if err := newAgentClient(0).Post(context.Background(), "/api/credentials/migrate", map[string]string{"to": to}, &raw); err != nil {
+			ExitWithError(err)
+		}
+
+		// Persist the choice so the agent uses it after a restart
+		if err := cfg.Save(path); err != nil {
+			ExitWithError(fmt.Errorf("save config: %w", err))
+		}
+
+		var result struct {
+			From     string `json:"from"`
+			To       string `json:"to"`
+			Migrated int    `json:"migrated"`
+			Warning  string `json:"warning"`
+		}
+		if !decodeResult(raw, &result) {
+			return
 		}
 
-		client := &http.Client{Timeout: 5 * time.Second}
-		resp, err := client.Do(req)
+		fmt.Printf("✓ Migrated %d profile(s) from %s to %s\n", result.Migrated, result.From, result.To)
+		if result.Warning != "" {
+			fmt.Printf("⚠ %s\n", result.Warning)
+		}
+	},
+}
+
+var credentialsImportCmd = &cobra.Command{
+	Use:   "import [aws-profile...]",
+	Short: "Import profiles from the AWS CLI configuration",
+	Long: `Create Ark profiles from ~/.aws/config and ~/.aws/credentials.
+
+Access keys, region, role_arn, source_profile and sso_* settings are kept.
+Each imported profile keeps its AWS CLI name. AWS_CONFIG_FILE and
+AWS_SHARED_CREDENTIALS_FILE are honored if set.
+
+Examples:
+  # Import a single profile
+  ark credentials import research
+
+  # Import every profile
+  ark credentials import --all`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		overwrite, _ := cmd.Flags().GetBool("overwrite")
+
+		if !all && len(args) == 0 {
+			ExitWithError(fmt.Errorf("specify one or more AWS profiles, or use --all"))
+		}
+		if all && len(args) > 0 {
+			ExitWithError(fmt.Errorf("--all cannot be combined with profile names"))
+		}
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		var raw json.RawMessage
+		err := newAgentClient(10*time.Second).Post(context.Background(), "/api/credentials/import", map[string]interface{}{
+			"aws_profiles": args,
+			"all":          all,
+			"overwrite":    overwrite,
+		}, &raw)
 		if err != nil {
-			ExitWithError(fmt.Errorf("send to agent: %w", err))
+			ExitWithError(err)
 		}
-		defer resp.Body.Close()
 
-		if resp.StatusCode == http.StatusNotFound {
-			fmt.Printf("Profile '%s' not found\n", profile)
-			os.Exit(1)
+		var result struct {
+			Imported []struct {
+				Profile string `json:"profile"`
+				Type    string `json:"type"`
+				Region  string `json:"region"`
+			} `json:"imported"`
+			Skipped []struct {
+				Profile string `json:"profile"`
+				Reason  string `json:"reason"`
+			} `json:"skipped"`
+		}
+		if err := json.Unmarshal(raw, &result); err != nil {
+			ExitWithError(fmt.Errorf("decode agent response: %w", err))
 		}
 
-		if resp.StatusCode != http.StatusOK {
-			ExitWithError(fmt.Errorf("agent returned status %d", resp.StatusCode))
+		// Record the AWS CLI profile each Ark profile came from
+		path, err := config.GetConfigPath()
+		if err != nil {
+			ExitWithError(fmt.Errorf("get config path: %w", err))
+		}
+		cfg, err := config.Load(path)
+		if err != nil {
+			ExitWithError(fmt.Errorf("load config: %w", err))
+		}
+		if cfg.Profiles == nil {
+			cfg.Profiles = make(map[string]config.Profile)
 		}
 
-		fmt.Printf("✓ Deleted credentials for profile '%s'\n", profile)
+		for _, p := range result.Imported {
+			if !jsonOutput {
+				fmt.Printf("✓ Imported '%s' (type: %s", p.Profile, p.Type)
+				if p.Region != "" {
+					fmt.Printf(", region: %s", p.Region)
+				}
+				fmt.Println(")")
+			}
+
+			cfg.Profiles[p.Profile] = config.Profile{
+				Name:        p.Profile,
+				Region:      p.Region,
+				AWSProfile:  p.Profile,
+				Description: "Imported from AWS CLI configuration",
+			}
+		}
+		if jsonOutput {
+			printJSON(raw)
+		} else {
+			for _, p := range result.Skipped {
+				fmt.Printf("- Skipped '%s': %s\n", p.Profile, p.Reason)
+			}
+		}
+
+		if len(result.Imported) > 0 {
+			if err := cfg.Save(path); err != nil {
+				ExitWithError(fmt.Errorf("save config: %w", err))
+			}
+		}
+
+		if len(result.Imported) == 0 {
+			os.Exit(1)
+		}
 	},
 }
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+	"golang.org/x/term"
+)
+
+// agentJob is a long-running operation in the agent
+type agentJob struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Progress    struct {
+		BytesDone  int64  `json:"bytes_done"`
+		BytesTotal int64  `json:"bytes_total"`
+		Message    string `json:"message"`
+	} `json:"progress"`
+	Error      string          `json:"error"`
+	Result     json.RawMessage `json:"result"`
+	CreatedAt  time.Time       `json:"created_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+	Resumed    int             `json:"resumed"`
+}
+
+// done reports whether the job has finished
+func (j *agentJob) done() bool {
+	return j.Status != "running" && j.Status != "queued"
+}
+
+// jobPath is the agent API path of a job
+func jobPath(id string) string {
+	return "/api/jobs/" + url.PathEscape(id)
+}
+
+// watchJob follows a job until it finishes, drawing a progress bar on a
+// terminal. If ctx is cancelled first (e.g. on Ctrl-C), the job is
+// cancelled in the agent and watchJob waits for it to stop.
+func watchJob(ctx context.Context, id string) (*agentJob, error) {
+	client := newAgentClient(10 * time.Second)
+	bar := newProgressBar()
+	defer bar.finish()
+
+	follow, stop := context.WithCancel(context.Background())
+	defer stop()
+	cancelErr := make(chan error, 1)
+	defer context.AfterFunc(ctx, func() {
+		bar.finish()
+		fmt.Fprintln(os.Stderr, "Stopping...")
+		// A 409 means the job finished in the meantime
+		err := client.Delete(context.Background(), jobPath(id), nil)
+		if err != nil && agentclient.StatusCode(err) != http.StatusConflict {
+			cancelErr <- err
+			stop()
+		}
+	})()
+
+	job, err := followJob(follow, client, id, bar)
+	select {
+	case err := <-cancelErr:
+		return nil, err
+	default:
+	}
+	return job, err
+}
+
+// followJob streams a job's progress from the agent until it finishes or
+// ctx is cancelled, returning the last state seen
+func followJob(ctx context.Context, client *agentclient.Client, id string, bar *progressBar) (*agentJob, error) {
+	var job agentJob
+	err := client.Stream(ctx, jobPath(id)+"/events", func(data []byte) error {
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("decode agent event: %w", err)
+		}
+		bar.update(job.Progress.BytesDone, job.Progress.BytesTotal)
+		return nil
+	})
+	if err != nil {
+		return &job, err
+	}
+	if !job.done() {
+		return &job, fmt.Errorf("the agent stopped before job %s finished", id)
+	}
+	return &job, nil
+}
+
+// progressBar draws transfer progress on stderr, if it is a terminal
+type progressBar struct {
+	mu      sync.Mutex
+	enabled bool
+	drawn   bool
+	start   time.Time
+}
+
+func newProgressBar() *progressBar {
+	return &progressBar{
+		enabled: !jsonOutput && term.IsTerminal(int(os.Stderr.Fd())),
+		start:   time.Now(),
+	}
+}
+
+const progressBarWidth = 30
+
+func (p *progressBar) update(done, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.enabled || total <= 0 {
+		return
+	}
+
+	filled := int(done * progressBarWidth / total)
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	line := fmt.Sprintf("[%s] %3d%%  %s / %s", bar, done*100/total, formatBytes(done), formatBytes(total))
+	if elapsed := time.Since(p.start).Seconds(); elapsed >= 1 {
+		line += fmt.Sprintf("  %s/s", formatBytes(int64(float64(done)/elapsed)))
+	}
+
+	fmt.Fprintf(os.Stderr, "\r%-80s", line)
+	p.drawn = true
+}
+
+// finish ends the progress bar's line
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drawn {
+		fmt.Fprintln(os.Stderr)
+		p.drawn = false
+	}
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
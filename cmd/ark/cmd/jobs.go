@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(jobsCmd)
+	jobsCmd.AddCommand(jobsListCmd)
+	jobsCmd.AddCommand(jobsWatchCmd)
+	jobsCmd.AddCommand(jobsCancelCmd)
+}
+
+var jobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "Follow and cancel background jobs in the agent",
+	Long: `Long-running operations, such as S3 transfers and syncs, run as jobs in the
+agent. A job carries on if the command that started it goes away without
+Ctrl-C (e.g. its terminal is closed), and a transfer or sync that was
+running when the agent stopped resumes when it starts again.
+
+The agent runs up to agent.max_jobs jobs at once (4 by default); the rest
+are queued. Finished jobs are kept for a week.`,
+}
+
+var jobsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List jobs",
+	Long: `List the agent's jobs, newest first.
+
+Examples:
+  ark jobs list
+  ark jobs list --json`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		var resp struct {
+			Jobs []agentJob `json:"jobs"`
+		}
+		if !callAgent(newAgentClient(0), http.MethodGet, "/api/jobs", nil, &resp) {
+			return
+		}
+
+		if len(resp.Jobs) == 0 {
+			fmt.Println("No jobs found.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tPROGRESS\tSTARTED\tDESCRIPTION")
+		for _, job := range resp.Jobs {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", job.ID, job.Status, jobProgress(&job),
+				job.CreatedAt.Local().Format("2006-01-02 15:04:05"), job.Description)
+		}
+		w.Flush()
+	},
+}
+
+var jobsWatchCmd = &cobra.Command{
+	Use:   "watch <job-id>",
+	Short: "Follow a job until it finishes",
+	Long: `Show a job's progress until it finishes. Ctrl-C stops watching; the job
+carries on in the agent. Use 'ark jobs cancel' to stop it.
+
+The command exits with status 1 if the job fails or is canceled.
+
+Examples:
+  ark jobs watch 3f2a9c...
+  ark jobs watch 3f2a9c... --json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		client := newAgentClient(10 * time.Second)
+		if !jsonOutput {
+			var job agentJob
+			if err := client.Get(ctx, jobPath(id), &job); err != nil {
+				exitIfJobNotFound(err, id)
+				ExitWithError(fmt.Errorf("failed to get job: %w", err))
+			}
+			fmt.Printf("%s (%s)\n", job.Description, job.Status)
+		}
+
+		bar := newProgressBar()
+		job, err := followJob(ctx, client, id, bar)
+		bar.finish()
+		if errors.Is(err, context.Canceled) {
+			fmt.Println()
+			fmt.Printf("Stopped watching; the job carries on in the agent. Follow it again with: ark jobs watch %s\n", id)
+			return
+		}
+		if err != nil {
+			exitIfJobNotFound(err, id)
+			ExitWithError(fmt.Errorf("failed to follow job: %w", err))
+		}
+
+		if jsonOutput {
+			printJSON(job)
+		} else {
+			printJobOutcome(job)
+		}
+		exitUnlessSucceeded(job)
+	},
+}
+
+var jobsCancelCmd = &cobra.Command{
+	Use:   "cancel <job-id>",
+	Short: "Cancel a job",
+	Long: `Cancel a queued or running job. A running job stops once its work is at a
+safe point. A canceled transfer discards what it has transferred; a canceled
+sync keeps the files it has finished copying.
+
+Examples:
+  ark jobs cancel 3f2a9c...`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		id := args[0]
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		var raw json.RawMessage
+		err := newAgentClient(0).Delete(context.Background(), jobPath(id), &raw)
+		exitIfJobNotFound(err, id)
+		if agentclient.StatusCode(err) == http.StatusConflict && !jsonOutput {
+			fmt.Printf("✗ Job %s has already finished\n", id)
+			os.Exit(1)
+		}
+		if err != nil {
+			ExitWithError(fmt.Errorf("failed to cancel job: %w", err))
+		}
+
+		var job agentJob
+		if !decodeResult(raw, &job) {
+			return
+		}
+		fmt.Printf("✓ Cancelling %s\n", job.Description)
+		fmt.Printf("  Follow it with: ark jobs watch %s\n", id)
+	},
+}
+
+// exitIfJobNotFound exits with a message if err is the agent's 404 for a job
+func exitIfJobNotFound(err error, id string) {
+	if agentclient.StatusCode(err) != http.StatusNotFound || jsonOutput {
+		return
+	}
+	fmt.Printf("✗ Job %s not found\n", id)
+	fmt.Println("  Finished jobs are kept for a week; list them with: ark jobs list")
+	os.Exit(1)
+}
+
+// jobProgress summarizes how far a job has got
+func jobProgress(job *agentJob) string {
+	p := job.Progress
+	switch {
+	case p.BytesTotal > 0:
+		return fmt.Sprintf("%d%% of %s", p.BytesDone*100/p.BytesTotal, formatBytes(p.BytesTotal))
+	case p.Message != "":
+		return p.Message
+	default:
+		return "-"
+	}
+}
+
+// printJobOutcome reports how a job finished
+func printJobOutcome(job *agentJob) {
+	switch job.Status {
+	case "succeeded":
+		fmt.Printf("✓ Job finished: %s\n", job.Description)
+	case "canceled":
+		fmt.Printf("✗ Job canceled: %s\n", job.Description)
+	default:
+		fmt.Printf("✗ Job failed: %s\n", job.Error)
+	}
+}
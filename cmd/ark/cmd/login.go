@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+
+	loginCmd.Flags().Bool("sso", false, "Log in with AWS IAM Identity Center (SSO)")
+	loginCmd.Flags().String("start-url", "", "Identity Center start URL (e.g. https://my-school.awsapps.com/start)")
+	loginCmd.Flags().String("sso-region", "", "Region of the Identity Center instance")
+	loginCmd.Flags().String("profile", "default", "Ark profile to store the role credentials under")
+	loginCmd.Flags().String("account-id", "", "AWS account to use (prompted if more than one is available)")
+	loginCmd.Flags().String("role-name", "", "Role to use in the account (prompted if more than one is available)")
+	loginCmd.Flags().String("region", "", "Default AWS region for the profile (default: the SSO region)")
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login --sso",
+	Short: "Log in to AWS through IAM Identity Center",
+	Long: `Log in to AWS with IAM Identity Center (AWS SSO) using the device code flow.
+
+Ark shows a verification URL and code to approve in your browser, then lists
+the accounts and roles you can use and stores the chosen role as an agent
+profile. The agent fetches and refreshes role credentials automatically until
+the Identity Center session expires; then run 'ark login --sso' again.
+
+The start URL and SSO region can be omitted for profiles imported from
+~/.aws/config with 'ark credentials import'.
+
+Examples:
+  # First login
+  ark login --sso --start-url https://my-school.awsapps.com/start --sso-region us-east-1
+
+  # Pick the account and role up front
+  ark login --sso --start-url https://my-school.awsapps.com/start --sso-region us-east-1 \
+    --profile lab --account-id 123456789012 --role-name ResearcherAccess
+
+  # Log in again for an existing SSO profile
+  ark login --sso --profile lab`,
+	Run: func(cmd *cobra.Command, args []string) {
+		useSSO, _ := cmd.Flags().GetBool("sso")
+		startURL, _ := cmd.Flags().GetString("start-url")
+		ssoRegion, _ := cmd.Flags().GetString("sso-region")
+		profile, _ := cmd.Flags().GetString("profile")
+		accountID, _ := cmd.Flags().GetString("account-id")
+		roleName, _ := cmd.Flags().GetString("role-name")
+		region, _ := cmd.Flags().GetString("region")
+
+		if !useSSO {
+			ExitWithError(fmt.Errorf("only --sso login is supported; use 'ark credentials set' for other credentials"))
+		}
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		// Instructions stay readable when stdout is JSON
+		out := os.Stdout
+		if jsonOutput {
+			out = os.Stderr
+		}
+
+		// Start the device authorization
+		var login struct {
+			LoginID                 string    `json:"login_id"`
+			StartURL                string    `json:"start_url"`
+			SSORegion               string    `json:"sso_region"`
+			UserCode                string    `json:"user_code"`
+			VerificationURI         string    `json:"verification_uri"`
+			VerificationURIComplete string    `json:"verification_uri_complete"`
+			Interval                int       `json:"interval"`
+			ExpiresAt               time.Time `json:"expires_at"`
+			AccountID               string    `json:"account_id"`
+			RoleName                string    `json:"role_name"`
+		}
+		postAgentJSON("/api/sso/login", map[string]string{
+			"start_url":  startURL,
+			"sso_region": ssoRegion,
+			"profile":    profile,
+		}, &login)
+
+		fmt.Fprintln(out, "To sign in, open this URL in a browser:")
+		fmt.Fprintln(out)
+		if login.VerificationURIComplete != "" {
+			fmt.Fprintf(out, "  %s\n", login.VerificationURIComplete)
+		} else {
+			fmt.Fprintf(out, "  %s\n", login.VerificationURI)
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "and confirm the code: %s\n", login.UserCode)
+		fmt.Fprintln(out)
+		fmt.Fprintln(out, "Waiting for approval...")
+
+		// Poll until the user approves the login
+		var result struct {
+			Status    string    `json:"status"`
+			ExpiresAt time.Time `json:"expires_at"`
+			Roles     []ssoRole `json:"roles"`
+		}
+		interval := time.Duration(login.Interval) * time.Second
+		for {
+			if time.Now().After(login.ExpiresAt) {
+				ExitWithError(fmt.Errorf("login expired before it was approved; run 'ark login --sso' again"))
+			}
+			time.Sleep(interval)
+
+			postAgentJSON("/api/sso/login/"+login.LoginID+"/poll", nil, &result)
+			if result.Status == "complete" {
+				break
+			}
+			if result.Status == "slow_down" {
+				interval += 5 * time.Second
+			}
+		}
+
+		fmt.Fprintln(out, "✓ Signed in to IAM Identity Center")
+		fmt.Fprintln(out)
+
+		// Stored profiles suggest the account and role to use
+		if accountID == "" && roleName == "" {
+			accountID, roleName = login.AccountID, login.RoleName
+		}
+		role, err := chooseSSORole(result.Roles, accountID, roleName)
+		if err != nil {
+			ExitWithError(err)
+		}
+
+		var stored struct {
+			Expiration time.Time `json:"expiration"`
+		}
+		postAgentJSON("/api/sso/profiles", map[string]string{
+			"profile":    profile,
+			"start_url":  login.StartURL,
+			"sso_region": login.SSORegion,
+			"account_id": role.AccountID,
+			"role_name":  role.RoleName,
+			"region":     region,
+		}, &stored)
+
+		if jsonOutput {
+			printJSON(map[string]interface{}{
+				"profile":         profile,
+				"account_id":      role.AccountID,
+				"account_name":    role.AccountName,
+				"role_name":       role.RoleName,
+				"expiration":      stored.Expiration,
+				"session_expires": result.ExpiresAt,
+			})
+			return
+		}
+		fmt.Printf("✓ Profile '%s' uses %s in %s (%s)\n", profile, role.RoleName, role.AccountName, role.AccountID)
+		fmt.Printf("  Session valid until %s\n", result.ExpiresAt.Local().Format("2006-01-02 15:04:05"))
+	},
+}
+
+// ssoRole is an account and role reachable through Identity Center
+type ssoRole struct {
+	AccountID   string `json:"account_id"`
+	AccountName string `json:"account_name"`
+	RoleName    string `json:"role_name"`
+}
+
+// chooseSSORole picks the role matching the given account and role name,
+// prompting when more than one remains
+func chooseSSORole(roles []ssoRole, accountID, roleName string) (ssoRole, error) {
+	var matches []ssoRole
+	for _, role := range roles {
+		if (accountID == "" || role.AccountID == accountID) && (roleName == "" || role.RoleName == roleName) {
+			matches = append(matches, role)
+		}
+	}
+
+	switch {
+	case len(matches) == 0:
+		return ssoRole{}, fmt.Errorf("no matching account and role is available to you")
+	case len(matches) == 1:
+		return matches[0], nil
+	case !term.IsTerminal(int(syscall.Stdin)):
+		return ssoRole{}, fmt.Errorf("%d roles are available; choose one with --account-id and --role-name", len(matches))
+	}
+
+	fmt.Println("Available roles:")
+	fmt.Println()
+	for i, role := range matches {
+		fmt.Printf("  %d. %s in %s (%s)\n", i+1, role.RoleName, role.AccountName, role.AccountID)
+	}
+	fmt.Println()
+	fmt.Print("Choose a role: ")
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return ssoRole{}, fmt.Errorf("failed to read choice: %w", err)
+	}
+	choice, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || choice < 1 || choice > len(matches) {
+		return ssoRole{}, fmt.Errorf("invalid choice: %s", strings.TrimSpace(line))
+	}
+	return matches[choice-1], nil
+}
+
+// postAgentJSON POSTs a JSON body to the agent and decodes a successful
+// response into dest, exiting with the agent's error message otherwise
+func postAgentJSON(path string, body interface{}, dest interface{}) {
+	if err := newAgentClient(30*time.Second).Post(context.Background(), path, body, dest); err != nil {
+		ExitWithError(err)
+	}
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// promptMFACode reads a one-time code from the terminal
+func promptMFACode(profile, serial string) (string, error) {
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("profile %s requires an MFA code, but stdin is not a terminal", profile)
+	}
+
+	fmt.Fprintf(os.Stderr, "MFA code for %s (%s): ", profile, serial)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read MFA code: %w", err)
+	}
+
+	code := strings.TrimSpace(line)
+	if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+		return "", fmt.Errorf("MFA code must be 6 digits")
+	}
+	return code, nil
+}
@@ -1,10 +1,14 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"runtime"
 
+	"github.com/scttfrdmn/ark/internal/agentclient"
 	"github.com/spf13/cobra"
 )
 
@@ -24,7 +28,7 @@ ensuring researchers can use cloud resources safely and compliantly from day one
 
 Ark consists of three components:
   • CLI      - Command-line interface for scripting and automation
-  • Agent    - Local service (localhost:8737) that brokers AWS credentials
+  • Agent    - Local service that brokers AWS credentials
   • Backend  - Institutional backend for training, policies, and audit
 
 Getting Started:
@@ -34,6 +38,18 @@ Getting Started:
 
 For more information: https://github.com/scttfrdmn/ark`,
 	Version: fmt.Sprintf("%s (commit: %s, built: %s)", Version, CommitSHA, BuildDate),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// --config is passed on through ARK_CONFIG, so config lookups and an
+		// auto-started agent use the same file
+		if configFile != "" {
+			path, err := filepath.Abs(configFile)
+			if err != nil {
+				return fmt.Errorf("resolve --config: %w", err)
+			}
+			os.Setenv("ARK_CONFIG", path)
+		}
+		return nil
+	},
 }
 
 func init() {
@@ -61,8 +77,18 @@ func Platform() string {
 	return fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH)
 }
 
-// ExitWithError prints an error and exits
+// ExitWithError prints an error and exits. With --json the error is
+// printed as a JSON object on stdout instead.
 func ExitWithError(err error) {
+	if jsonOutput {
+		var apiErr *agentclient.Error
+		if errors.As(err, &apiErr) && json.Valid(apiErr.Body) {
+			printJSON(json.RawMessage(apiErr.Body))
+		} else {
+			printJSON(map[string]string{"error": err.Error()})
+		}
+		os.Exit(1)
+	}
 	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 	os.Exit(1)
 }
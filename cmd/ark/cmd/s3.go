@@ -1,15 +1,25 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/scttfrdmn/ark/internal/agentclient"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 func init() {
@@ -21,7 +31,18 @@ func init() {
 	s3CreateBucketCmd.Flags().String("encryption", "AES256", "Encryption type: AES256 or aws:kms")
 	s3CreateBucketCmd.Flags().String("kms-key-id", "", "KMS key ID (required if encryption is aws:kms)")
 	s3CreateBucketCmd.Flags().Bool("versioning", false, "Enable bucket versioning")
+	s3CreateBucketCmd.Flags().StringArray("tag", nil, "Tag the bucket, as KEY=VALUE (repeatable)")
 	s3CreateBucketCmd.Flags().String("profile", "default", "AWS credential profile to use")
+
+	s3Cmd.AddCommand(s3ListBucketsCmd)
+	s3Cmd.AddCommand(s3DescribeBucketCmd)
+	s3Cmd.AddCommand(s3DeleteBucketCmd)
+
+	s3ListBucketsCmd.Flags().String("profile", "default", "AWS credential profile to use")
+	s3DescribeBucketCmd.Flags().String("profile", "default", "AWS credential profile to use")
+	s3DeleteBucketCmd.Flags().String("profile", "default", "AWS credential profile to use")
+	s3DeleteBucketCmd.Flags().Bool("force", false, "Delete all objects, versions and delete markers first")
+	s3DeleteBucketCmd.Flags().Bool("yes", false, "Do not ask for confirmation before deleting objects with --force")
 }
 
 var s3Cmd = &cobra.Command{
@@ -45,6 +66,13 @@ AWS naming rules:
   - Start and end with a letter or number
   - Not formatted as an IP address (e.g., 192.168.1.1)
 
+Every new bucket gets the institution's baseline: Block Public Access,
+BucketOwnerEnforced object ownership and a policy denying requests not made
+over TLS, plus server access logging and required tags where configured
+(s3.bucket_baseline). If
+any step fails, the bucket is deleted again, or kept and tagged
+ark:baseline=incomplete when the baseline is set to flag failures.
+
 Examples:
   # Create bucket with default settings (AES256 encryption, us-east-1)
   ark s3 create-bucket my-research-data
@@ -55,6 +83,9 @@ Examples:
   # Create bucket with KMS encryption
   ark s3 create-bucket my-secure-bucket --encryption aws:kms --kms-key-id <key-id>
 
+  # Create bucket with the tags the baseline requires
+  ark s3 create-bucket my-lab-data --tag PI=jsmith --tag grant=R01-123456 --tag classification=internal
+
   # Use non-default credential profile
   ark s3 create-bucket my-bucket --profile production
 
@@ -75,6 +106,16 @@ the required training modules and try again.`,
 		kmsKeyID, _ := cmd.Flags().GetString("kms-key-id")
 		versioning, _ := cmd.Flags().GetBool("versioning")
 		profile, _ := cmd.Flags().GetString("profile")
+		tagArgs, _ := cmd.Flags().GetStringArray("tag")
+
+		tags := map[string]string{}
+		for _, arg := range tagArgs {
+			key, value, ok := strings.Cut(arg, "=")
+			if !ok || key == "" {
+				ExitWithError(fmt.Errorf("invalid tag %q (expected KEY=VALUE)", arg))
+			}
+			tags[key] = value
+		}
 
 		// Validate encryption settings
 		if encryption != "AES256" && encryption != "aws:kms" {
@@ -98,84 +139,418 @@ the required training modules and try again.`,
 				"kms_key_id": kmsKeyID,
 			},
 			"versioning_enabled": versioning,
+			"tags":               tags,
 			"profile":            profile,
 		}
 
-		bodyBytes, err := json.Marshal(reqBody)
+		// Send request to agent. A bucket that failed the baseline still
+		// reports its steps.
+		var raw json.RawMessage
+		err := newAgentClient(time.Minute).Post(context.Background(), "/api/s3/buckets", reqBody, &raw)
+		exitIfTrainingRequired(err, "creating S3 buckets")
+		var apiErr *agentclient.Error
+		failed := errors.As(err, &apiErr) && apiErr.Status == "failure"
+		if failed {
+			raw = apiErr.Body
+		} else if err != nil {
+			ExitWithError(fmt.Errorf("failed to create bucket: %w", err))
+		}
+
+		var result struct {
+			BucketName string    `json:"bucket_name"`
+			Region     string    `json:"region"`
+			Location   string    `json:"location"`
+			CreatedAt  time.Time `json:"created_at"`
+			Error      string    `json:"error"`
+			Steps      []struct {
+				Name         string `json:"name"`
+				Status       string `json:"status"`
+				Error        string `json:"error"`
+				Compensation bool   `json:"compensation"`
+			} `json:"steps"`
+			RolledBack         bool `json:"rolled_back"`
+			BaselineIncomplete bool `json:"baseline_incomplete"`
+		}
+		if err := json.Unmarshal(raw, &result); err != nil {
+			ExitWithError(fmt.Errorf("decode agent response: %w", err))
+		}
+
+		if jsonOutput {
+			printJSON(raw)
+			if failed || result.BaselineIncomplete {
+				os.Exit(1)
+			}
+			return
+		}
+
+		if failed || result.BaselineIncomplete {
+			for _, step := range result.Steps {
+				mark := "✓"
+				if step.Status != "success" {
+					mark = "✗"
+				}
+				if step.Compensation {
+					fmt.Printf("  %s %s (undo)\n", mark, step.Name)
+				} else {
+					fmt.Printf("  %s %s\n", mark, step.Name)
+				}
+				if step.Error != "" {
+					fmt.Printf("      %s\n", step.Error)
+				}
+			}
+			fmt.Println()
+		}
+		switch {
+		case failed && result.RolledBack:
+			fmt.Printf("✗ Bucket %s did not meet the baseline and was deleted\n", result.BucketName)
+			fmt.Printf("  %s\n", result.Error)
+			os.Exit(1)
+		case failed:
+			fmt.Printf("✗ Bucket %s did not meet the baseline and could not be deleted\n", result.BucketName)
+			fmt.Printf("  %s\n", result.Error)
+			fmt.Println()
+			fmt.Println("Fix or delete it with 'ark s3 delete-bucket " + result.BucketName + "'.")
+			os.Exit(1)
+		case result.BaselineIncomplete:
+			fmt.Println("⚠ S3 bucket created, but it does not meet the baseline")
+		default:
+			fmt.Println("✓ S3 bucket created successfully")
+		}
+		fmt.Println()
+		fmt.Printf("  Name:      %s\n", result.BucketName)
+		fmt.Printf("  Region:    %s\n", result.Region)
+		if result.Location != "" {
+			fmt.Printf("  Location:  %s\n", result.Location)
+		}
+		if !result.CreatedAt.IsZero() {
+			fmt.Printf("  Created:   %s\n", result.CreatedAt.UTC().Format("2006-01-02 15:04:05 UTC"))
+		}
+		if result.BaselineIncomplete {
+			os.Exit(1)
+		}
+	},
+}
+
+var s3ListBucketsCmd = &cobra.Command{
+	Use:   "list-buckets",
+	Short: "List S3 buckets",
+	Long: `List the S3 buckets in the account of a credential profile.
+
+Examples:
+  ark s3 list-buckets
+  ark s3 list-buckets --profile production`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		profile, _ := cmd.Flags().GetString("profile")
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		var raw json.RawMessage
+		err := newAgentClient(0).Get(context.Background(), "/api/s3/buckets?profile="+url.QueryEscape(profile), &raw)
+		exitIfTrainingRequired(err, "listing S3 buckets")
 		if err != nil {
-			ExitWithError(fmt.Errorf("failed to marshal request: %w", err))
+			ExitWithError(fmt.Errorf("failed to list buckets: %w", err))
+		}
+
+		var buckets []struct {
+			Name      string    `json:"name"`
+			Region    string    `json:"region"`
+			CreatedAt time.Time `json:"created_at"`
+		}
+		if !decodeResult(raw, &buckets) {
+			return
+		}
+
+		if len(buckets) == 0 {
+			fmt.Println("No buckets found.")
+			return
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tREGION\tCREATED")
+		for _, b := range buckets {
+			region := b.Region
+			if region == "" {
+				region = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", b.Name, region, b.CreatedAt.UTC().Format("2006-01-02 15:04:05 UTC"))
 		}
+		w.Flush()
+	},
+}
+
+var s3DescribeBucketCmd = &cobra.Command{
+	Use:   "describe-bucket <bucket-name>",
+	Short: "Show an S3 bucket's configuration",
+	Long: `Show a bucket's region, default encryption, versioning, public access
+block, tags and bucket policy.
+
+Examples:
+  ark s3 describe-bucket my-research-data
+  ark s3 describe-bucket my-research-data --json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bucketName := args[0]
+		profile, _ := cmd.Flags().GetString("profile")
 
-		// Send request to agent
-		url := "http://127.0.0.1:8737/api/s3/buckets"
-		resp, err := http.Post(url, "application/json", bytes.NewReader(bodyBytes))
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		var raw json.RawMessage
+		path := "/api/s3/buckets/" + url.PathEscape(bucketName) + "?profile=" + url.QueryEscape(profile)
+		err := newAgentClient(0).Get(context.Background(), path, &raw)
+		exitIfTrainingRequired(err, "describing S3 buckets")
+		exitIfBucketNotFound(err, bucketName)
 		if err != nil {
-			ExitWithError(fmt.Errorf("failed to create bucket: %w", err))
+			ExitWithError(fmt.Errorf("failed to describe bucket: %w", err))
 		}
-		defer resp.Body.Close()
 
-		// Parse response
-		var result map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			ExitWithError(fmt.Errorf("failed to parse response: %w", err))
+		var bucket struct {
+			Name       string `json:"name"`
+			Region     string `json:"region"`
+			Encryption struct {
+				Type             string `json:"type"`
+				KMSKeyID         string `json:"kms_key_id"`
+				BucketKeyEnabled bool   `json:"bucket_key_enabled"`
+			} `json:"encryption"`
+			Versioning        string `json:"versioning"`
+			PublicAccessBlock *struct {
+				BlockPublicACLs       bool `json:"block_public_acls"`
+				IgnorePublicACLs      bool `json:"ignore_public_acls"`
+				BlockPublicPolicy     bool `json:"block_public_policy"`
+				RestrictPublicBuckets bool `json:"restrict_public_buckets"`
+			} `json:"public_access_block"`
+			Tags   map[string]string `json:"tags"`
+			Policy string            `json:"policy"`
+		}
+		if !decodeResult(raw, &bucket) {
+			return
 		}
 
-		// Handle response based on status code
-		switch resp.StatusCode {
-		case http.StatusOK, http.StatusCreated:
-			// Success
-			fmt.Println("✓ S3 bucket created successfully")
-			fmt.Println()
-			fmt.Printf("  Name:      %s\n", result["bucket_name"])
-			fmt.Printf("  Region:    %s\n", result["region"])
-			if location, ok := result["location"].(string); ok {
-				fmt.Printf("  Location:  %s\n", location)
+		fmt.Printf("Bucket: %s\n", bucket.Name)
+		fmt.Println()
+		fmt.Printf("  Region:      %s\n", bucket.Region)
+
+		encryption := bucket.Encryption.Type
+		if bucket.Encryption.KMSKeyID != "" {
+			encryption += " (" + bucket.Encryption.KMSKeyID + ")"
+		}
+		if bucket.Encryption.BucketKeyEnabled {
+			encryption += ", bucket key enabled"
+		}
+		fmt.Printf("  Encryption:  %s\n", encryption)
+		fmt.Printf("  Versioning:  %s\n", bucket.Versioning)
+
+		if pab := bucket.PublicAccessBlock; pab == nil {
+			fmt.Println("  Public access block: not configured (account settings apply)")
+		} else {
+			fmt.Println("  Public access block:")
+			fmt.Printf("    Block public ACLs:       %t\n", pab.BlockPublicACLs)
+			fmt.Printf("    Ignore public ACLs:      %t\n", pab.IgnorePublicACLs)
+			fmt.Printf("    Block public policy:     %t\n", pab.BlockPublicPolicy)
+			fmt.Printf("    Restrict public buckets: %t\n", pab.RestrictPublicBuckets)
+		}
+
+		if len(bucket.Tags) == 0 {
+			fmt.Println("  Tags:        none")
+		} else {
+			fmt.Println("  Tags:")
+			keys := make([]string, 0, len(bucket.Tags))
+			for k := range bucket.Tags {
+				keys = append(keys, k)
 			}
-			if createdAt, ok := result["created_at"].(string); ok {
-				if t, err := time.Parse(time.RFC3339, createdAt); err == nil {
-					fmt.Printf("  Created:   %s\n", t.Format("2006-01-02 15:04:05 UTC"))
-				}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Printf("    %s = %s\n", k, bucket.Tags[k])
 			}
+		}
 
-		case http.StatusForbidden:
-			// Training gate blocked
-			status, _ := result["status"].(string)
-			if status == "blocked" {
-				fmt.Println("✗ Training required before creating S3 buckets")
-				fmt.Println()
-				fmt.Println("You must complete the following training modules:")
-				fmt.Println()
-
-				if modules, ok := result["required_modules"].([]interface{}); ok && len(modules) > 0 {
-					for i, mod := range modules {
-						if m, ok := mod.(map[string]interface{}); ok {
-							title := m["title"].(string)
-							name := m["name"].(string)
-							minutes := int(m["estimated_minutes"].(float64))
-
-							fmt.Printf("  %d. %s (%d minutes)\n", i+1, title, minutes)
-							fmt.Printf("     Start training: ark training start %s\n", name)
-							fmt.Printf("     Or visit: http://localhost:8080/training/%s\n", name)
-							fmt.Println()
-						}
-					}
-				}
+		if bucket.Policy == "" {
+			fmt.Println("  Policy:      none")
+		} else {
+			fmt.Println("  Policy:")
+			var policy bytes.Buffer
+			if err := json.Indent(&policy, []byte(bucket.Policy), "    ", "  "); err != nil {
+				policy.WriteString(bucket.Policy)
+			}
+			fmt.Printf("    %s\n", policy.String())
+		}
+	},
+}
 
-				fmt.Println("After completing training, run your command again.")
-				os.Exit(1)
+var s3DeleteBucketCmd = &cobra.Command{
+	Use:   "delete-bucket <bucket-name>",
+	Short: "Delete an S3 bucket",
+	Long: `Delete an S3 bucket.
+
+The bucket must be empty unless --force is given, in which case every object,
+including all versions and delete markers in a versioned bucket, is deleted
+first. This cannot be undone, so --force asks you to confirm by typing the
+bucket name; --yes skips the question, e.g. in scripts. The agent empties the
+bucket as a job: Ctrl-C stops it, and 'ark jobs' lists it.
+
+Examples:
+  ark s3 delete-bucket my-old-bucket
+  ark s3 delete-bucket my-old-bucket --force
+  ark s3 delete-bucket my-old-bucket --force --yes`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		bucketName := args[0]
+		profile, _ := cmd.Flags().GetString("profile")
+		force, _ := cmd.Flags().GetBool("force")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		if force && !yes {
+			if err := confirmForceDelete(bucketName); err != nil {
+				ExitWithError(err)
 			}
+		}
 
-		default:
-			// Error
-			if errMsg, ok := result["error"].(string); ok {
-				ExitWithError(fmt.Errorf("failed to create bucket: %s", errMsg))
-			} else {
-				ExitWithError(fmt.Errorf("failed to create bucket: unexpected response (status %d)", resp.StatusCode))
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		query := url.Values{"profile": {profile}}
+		if force {
+			query.Set("force", "true")
+		}
+
+		var raw json.RawMessage
+		path := "/api/s3/buckets/" + url.PathEscape(bucketName) + "?" + query.Encode()
+		err := newAgentClient(time.Minute).Delete(context.Background(), path, &raw)
+		exitIfTrainingRequired(err, "deleting S3 buckets")
+		exitIfBucketNotFound(err, bucketName)
+		if agentclient.StatusCode(err) == http.StatusConflict && !jsonOutput {
+			fmt.Printf("✗ Bucket '%s' is not empty\n", bucketName)
+			fmt.Println()
+			fmt.Println("Delete its objects first, or use --force to delete them along with the bucket.")
+			os.Exit(1)
+		}
+		if err != nil {
+			ExitWithError(fmt.Errorf("failed to delete bucket: %w", err))
+		}
+
+		if force {
+			raw = followDeleteBucket(raw)
+			if raw == nil {
+				return
 			}
 		}
+
+		var result struct {
+			BucketName     string `json:"bucket_name"`
+			ObjectsDeleted int    `json:"objects_deleted"`
+		}
+		if !decodeResult(raw, &result) {
+			return
+		}
+
+		if force {
+			fmt.Printf("✓ Deleted %d object versions and delete markers\n", result.ObjectsDeleted)
+		}
+		fmt.Printf("✓ Deleted bucket '%s'\n", result.BucketName)
 	},
 }
 
+// confirmForceDelete asks the user to type the bucket name before its
+// objects are deleted
+func confirmForceDelete(bucketName string) error {
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return fmt.Errorf("--force deletes every object in %s; confirm with --yes when stdin is not a terminal", bucketName)
+	}
+
+	fmt.Fprintf(os.Stderr, "This deletes bucket %s and every object version in it, and cannot be undone.\n", bucketName)
+	fmt.Fprint(os.Stderr, "Type the bucket name to confirm: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	if strings.TrimSpace(line) != bucketName {
+		return fmt.Errorf("bucket name did not match; nothing was deleted")
+	}
+	return nil
+}
+
+// followDeleteBucket waits for a forced bucket deletion job to finish and
+// returns its result. It exits if the job did not succeed, and returns nil
+// once it has printed the job for --json.
+func followDeleteBucket(raw json.RawMessage) json.RawMessage {
+	var job agentJob
+	if err := json.Unmarshal(raw, &job); err != nil {
+		ExitWithError(fmt.Errorf("decode agent response: %w", err))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	finished, err := watchJob(ctx, job.ID)
+	if err != nil {
+		ExitWithError(fmt.Errorf("failed to follow bucket deletion: %w", err))
+	}
+
+	if jsonOutput {
+		callAgent(newAgentClient(0), http.MethodGet, jobPath(job.ID), nil, nil)
+		exitUnlessSucceeded(finished)
+		return nil
+	}
+
+	switch finished.Status {
+	case "succeeded":
+		return finished.Result
+	case "canceled":
+		fmt.Println("✗ Bucket deletion stopped; some objects may already be deleted")
+	default:
+		fmt.Printf("✗ Failed to delete bucket: %s\n", finished.Error)
+	}
+	os.Exit(1)
+	return nil
+}
+
+// exitIfTrainingRequired explains a training gate block and exits. Other
+// errors are left to the caller.
+func exitIfTrainingRequired(err error, what string) {
+	var apiErr *agentclient.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || apiErr.Status != "blocked" || jsonOutput {
+		return
+	}
+
+	var blocked struct {
+		RequiredModules []struct {
+			Name             string `json:"name"`
+			Title            string `json:"title"`
+			EstimatedMinutes int    `json:"estimated_minutes"`
+		} `json:"required_modules"`
+	}
+	apiErr.Decode(&blocked)
+
+	fmt.Printf("✗ Training required before %s\n", what)
+	fmt.Println()
+	fmt.Println("You must complete the following training modules:")
+	fmt.Println()
+	for i, m := range blocked.RequiredModules {
+		fmt.Printf("  %d. %s (%d minutes)\n", i+1, m.Title, m.EstimatedMinutes)
+		fmt.Printf("     Start training: ark training start %s\n", m.Name)
+		fmt.Printf("     Or visit: http://localhost:8080/training/%s\n", m.Name)
+		fmt.Println()
+	}
+	fmt.Println("After completing training, run your command again.")
+	os.Exit(1)
+}
+
+// exitIfBucketNotFound reports a missing bucket and exits
+func exitIfBucketNotFound(err error, bucketName string) {
+	var apiErr *agentclient.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound && apiErr.Message == "Bucket not found" && !jsonOutput {
+		fmt.Printf("✗ Bucket '%s' not found\n", bucketName)
+		os.Exit(1)
+	}
+}
+
 // validateBucketName validates an S3 bucket name according to AWS rules
 func validateBucketName(name string) error {
 	if len(name) < 3 || len(name) > 63 {
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	s3Cmd.AddCommand(s3CpCmd)
+
+	s3CpCmd.Flags().String("profile", "default", "AWS credential profile to use")
+	s3CpCmd.Flags().Int("workers", 4, "Number of parts to transfer at once")
+	s3CpCmd.Flags().Int64("part-size", 64, "Part size in MiB for multipart transfers (minimum 5)")
+}
+
+var s3CpCmd = &cobra.Command{
+	Use:   "cp <source> <destination>",
+	Short: "Copy a file to or from S3",
+	Long: `Copy a local file to S3, or an S3 object to a local file.
+
+One of source and destination is an s3://bucket/key URL. If the key ends in
+"/", the local file name is appended; if the local destination is a
+directory, the object's name is used.
+
+Files larger than one part are transferred in parts, several at once. The
+transfer runs in the agent, which records each completed part: if it is
+interrupted by a network failure or the agent stopping, run the same command
+again to resume where it left off. Stopping it with Ctrl-C, or any other
+failure, discards what was transferred.
+
+Examples:
+  # Upload a file
+  ark s3 cp ./genome.fa.gz s3://my-research-data/raw/
+
+  # Download an object into the current directory
+  ark s3 cp s3://my-research-data/raw/genome.fa.gz .
+
+  # Use more workers and larger parts for a very large file
+  ark s3 cp ./scans.tar s3://my-research-data/scans.tar --workers 16 --part-size 256`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile, _ := cmd.Flags().GetString("profile")
+		workers, _ := cmd.Flags().GetInt("workers")
+		partSize, _ := cmd.Flags().GetInt64("part-size")
+
+		source, destination := args[0], args[1]
+		upload := strings.HasPrefix(destination, "s3://")
+		if upload == strings.HasPrefix(source, "s3://") {
+			ExitWithError(fmt.Errorf("one of source and destination must be an s3://bucket/key URL"))
+		}
+		if workers < 1 {
+			ExitWithError(fmt.Errorf("--workers must be at least 1"))
+		}
+		if partSize < 5 {
+			ExitWithError(fmt.Errorf("--part-size must be at least 5 MiB"))
+		}
+
+		// The agent does not share our working directory
+		var err error
+		if upload {
+			source, err = filepath.Abs(source)
+		} else {
+			isDir := strings.HasSuffix(destination, string(filepath.Separator)) || destination == "."
+			destination, err = filepath.Abs(destination)
+			if isDir {
+				destination += string(filepath.Separator)
+			}
+		}
+		if err != nil {
+			ExitWithError(err)
+		}
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		reqBody := map[string]interface{}{
+			"profile":      profile,
+			"source":       source,
+			"destination":  destination,
+			"workers":      workers,
+			"part_size_mb": partSize,
+		}
+
+		var job agentJob
+		err = newAgentClient(time.Minute).Post(context.Background(), "/api/s3/transfers", reqBody, &job)
+		if upload {
+			exitIfTrainingRequired(err, "uploading to S3")
+		} else {
+			exitIfTrainingRequired(err, "downloading from S3")
+		}
+		var apiErr *agentclient.Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict && !jsonOutput {
+			fmt.Println("✗ This transfer is already running in the agent")
+			os.Exit(1)
+		}
+		if err != nil {
+			ExitWithError(fmt.Errorf("failed to start transfer: %w", err))
+		}
+
+		if !jsonOutput {
+			fmt.Printf("Copying %s to %s\n", source, destination)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		finished, err := watchJob(ctx, job.ID)
+		if err != nil {
+			ExitWithError(fmt.Errorf("failed to follow transfer: %w", err))
+		}
+
+		if jsonOutput {
+			callAgent(newAgentClient(0), http.MethodGet, jobPath(job.ID), nil, nil)
+			if finished.Status != "succeeded" {
+				os.Exit(1)
+			}
+			return
+		}
+
+		switch finished.Status {
+		case "succeeded":
+			var result struct {
+				Bucket       string `json:"bucket"`
+				Key          string `json:"key"`
+				LocalPath    string `json:"local_path"`
+				Size         int64  `json:"size"`
+				Parts        int    `json:"parts"`
+				PartsResumed int    `json:"parts_resumed"`
+			}
+			if err := json.Unmarshal(finished.Result, &result); err != nil {
+				ExitWithError(fmt.Errorf("decode agent response: %w", err))
+			}
+
+			remote := fmt.Sprintf("s3://%s/%s", result.Bucket, result.Key)
+			if upload {
+				fmt.Printf("✓ Uploaded %s to %s\n", formatBytes(result.Size), remote)
+			} else {
+				fmt.Printf("✓ Downloaded %s to %s\n", formatBytes(result.Size), result.LocalPath)
+			}
+			if result.PartsResumed > 0 {
+				fmt.Printf("  Resumed with %d of %d parts already transferred\n", result.PartsResumed, result.Parts)
+			}
+		case "canceled":
+			fmt.Println("✗ Transfer stopped; what was transferred has been discarded")
+			os.Exit(1)
+		default:
+			fmt.Printf("✗ Transfer failed: %s\n", finished.Error)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	s3Cmd.AddCommand(s3SyncCmd)
+
+	s3SyncCmd.Flags().String("profile", "default", "AWS credential profile to use")
+	s3SyncCmd.Flags().StringArray("include", nil, "Only sync paths matching this pattern (repeatable)")
+	s3SyncCmd.Flags().StringArray("exclude", nil, "Skip paths matching this pattern (repeatable)")
+	s3SyncCmd.Flags().Bool("delete", false, "Delete files at the destination that are not at the source")
+	s3SyncCmd.Flags().Bool("dry-run", false, "Show what would change without changing anything")
+	s3SyncCmd.Flags().Int("workers", 4, "Number of files, or parts of a large file, to transfer at once")
+	s3SyncCmd.Flags().Int64("part-size", 64, "Part size in MiB for multipart transfers (minimum 5)")
+}
+
+// syncResult is the result of a sync job
+type syncResult struct {
+	Direction string `json:"direction"`
+	LocalDir  string `json:"local_dir"`
+	Bucket    string `json:"bucket"`
+	Prefix    string `json:"prefix"`
+	Plan      []struct {
+		Action string `json:"action"`
+		Path   string `json:"path"`
+		Size   int64  `json:"size"`
+		Reason string `json:"reason"`
+	} `json:"plan"`
+	Unchanged  int   `json:"unchanged"`
+	Uploaded   int   `json:"uploaded"`
+	Downloaded int   `json:"downloaded"`
+	Deleted    int   `json:"deleted"`
+	Bytes      int64 `json:"bytes"`
+	Failures   []struct {
+		Path   string `json:"path"`
+		Action string `json:"action"`
+		Error  string `json:"error"`
+	} `json:"failures"`
+}
+
+var s3SyncCmd = &cobra.Command{
+	Use:   "sync <source> <destination>",
+	Short: "Sync a local directory with an S3 prefix",
+	Long: `Copy new and changed files from a local directory to an S3 prefix, or from
+an S3 prefix to a local directory.
+
+A file is copied when it is missing at the destination, differs in size, or
+has a newer modification time at the source and different contents (judged
+by the object's ETag). With --delete, files at the destination that are not
+at the source are deleted.
+
+The plan is shown before anything changes; with --dry-run, nothing else
+happens. The sync runs in the agent and can be stopped with Ctrl-C; running
+it again carries on with the files not yet copied. A large file interrupted
+part-way by a network failure or the agent stopping resumes where it left
+off.
+
+Patterns use shell glob syntax. A pattern without a "/" matches file and
+directory names anywhere (e.g. "*.tmp"); one with a "/" matches the whole
+path relative to the directory being synced (e.g. "raw/*.fastq"). A pattern
+ending in "/" matches everything under a directory (e.g. "scratch/"). A path
+is synced if it matches an --include pattern (or none are given) and no
+--exclude pattern.
+
+Examples:
+  # Upload new and changed results
+  ark s3 sync ./results s3://my-research-data/results
+
+  # See what a mirror of the bucket prefix would change locally
+  ark s3 sync s3://my-research-data/results ./results --delete --dry-run
+
+  # Only sequence files, skipping scratch space
+  ark s3 sync ./run42 s3://my-research-data/run42 --include "*.fastq.gz" --exclude "scratch/"`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		profile, _ := cmd.Flags().GetString("profile")
+		include, _ := cmd.Flags().GetStringArray("include")
+		exclude, _ := cmd.Flags().GetStringArray("exclude")
+		deleteExtra, _ := cmd.Flags().GetBool("delete")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		workers, _ := cmd.Flags().GetInt("workers")
+		partSize, _ := cmd.Flags().GetInt64("part-size")
+
+		source, destination := args[0], args[1]
+		upload := strings.HasPrefix(destination, "s3://")
+		if upload == strings.HasPrefix(source, "s3://") {
+			ExitWithError(fmt.Errorf("one of source and destination must be an s3://bucket/prefix URL"))
+		}
+		if workers < 1 {
+			ExitWithError(fmt.Errorf("--workers must be at least 1"))
+		}
+		if partSize < 5 {
+			ExitWithError(fmt.Errorf("--part-size must be at least 5 MiB"))
+		}
+
+		// The agent does not share our working directory
+		var err error
+		if upload {
+			source, err = filepath.Abs(source)
+		} else {
+			destination, err = filepath.Abs(destination)
+		}
+		if err != nil {
+			ExitWithError(err)
+		}
+
+		// Ensure agent is running
+		if err := EnsureAgentRunning(); err != nil {
+			ExitWithError(fmt.Errorf("agent not available: %w", err))
+		}
+
+		reqBody := map[string]interface{}{
+			"profile":      profile,
+			"source":       source,
+			"destination":  destination,
+			"include":      include,
+			"exclude":      exclude,
+			"delete":       deleteExtra,
+			"dry_run":      true,
+			"workers":      workers,
+			"part_size_mb": partSize,
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		// Work out and show the plan first
+		planJob := startSync(ctx, reqBody)
+		if jsonOutput && dryRun {
+			callAgent(newAgentClient(0), http.MethodGet, jobPath(planJob.ID), nil, nil)
+			exitUnlessSucceeded(planJob)
+			return
+		}
+		if ctx.Err() != nil {
+			fmt.Println("✗ Sync stopped before anything changed")
+			os.Exit(1)
+		}
+		if planJob.Status != "succeeded" {
+			fmt.Printf("✗ Failed to compare %s and %s: %s\n", source, destination, planJob.Error)
+			os.Exit(1)
+		}
+
+		var plan syncResult
+		if err := json.Unmarshal(planJob.Result, &plan); err != nil {
+			ExitWithError(fmt.Errorf("decode agent response: %w", err))
+		}
+		if !jsonOutput {
+			printSyncPlan(&plan, dryRun)
+		}
+		if dryRun || len(plan.Plan) == 0 {
+			return
+		}
+
+		reqBody["dry_run"] = false
+		finished := startSync(ctx, reqBody)
+		if jsonOutput {
+			callAgent(newAgentClient(0), http.MethodGet, jobPath(finished.ID), nil, nil)
+			exitUnlessSucceeded(finished)
+			return
+		}
+
+		var result syncResult
+		if len(finished.Result) > 0 {
+			if err := json.Unmarshal(finished.Result, &result); err != nil {
+				ExitWithError(fmt.Errorf("decode agent response: %w", err))
+			}
+		}
+
+		copied := result.Uploaded
+		if !upload {
+			copied = result.Downloaded
+		}
+		summary := fmt.Sprintf("%d files copied (%s), %d deleted", copied, formatBytes(result.Bytes), result.Deleted)
+
+		fmt.Println()
+		for _, f := range result.Failures {
+			fmt.Printf("✗ %s %s: %s\n", f.Action, f.Path, f.Error)
+		}
+		switch finished.Status {
+		case "succeeded":
+			fmt.Printf("✓ Sync complete: %s\n", summary)
+		case "canceled":
+			fmt.Printf("✗ Sync stopped after %s\n", summary)
+			fmt.Println()
+			fmt.Println("Run the same command again to carry on.")
+			os.Exit(1)
+		default:
+			if len(result.Failures) == 0 {
+				fmt.Printf("✗ Sync failed: %s\n", finished.Error)
+			} else {
+				fmt.Printf("✗ %s: %s\n", finished.Error, summary)
+			}
+			os.Exit(1)
+		}
+	},
+}
+
+// startSync starts a sync job and waits for it to finish
+func startSync(ctx context.Context, reqBody map[string]interface{}) *agentJob {
+	var job agentJob
+	err := newAgentClient(time.Minute).Post(context.Background(), "/api/s3/sync", reqBody, &job)
+	exitIfTrainingRequired(err, "syncing with S3")
+	var apiErr *agentclient.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusConflict && !jsonOutput {
+		fmt.Println("✗ The same sync is already running in the agent")
+		os.Exit(1)
+	}
+	if err != nil {
+		ExitWithError(fmt.Errorf("failed to start sync: %w", err))
+	}
+
+	finished, err := watchJob(ctx, job.ID)
+	if err != nil {
+		ExitWithError(fmt.Errorf("failed to follow sync: %w", err))
+	}
+	return finished
+}
+
+// printSyncPlan lists the changes a sync will make
+func printSyncPlan(plan *syncResult, dryRun bool) {
+	if len(plan.Plan) == 0 {
+		fmt.Printf("✓ Already in sync (%d files unchanged)\n", plan.Unchanged)
+		return
+	}
+
+	remote := "s3://" + plan.Bucket + "/" + plan.Prefix
+	counts := map[string]int{}
+	var bytes int64
+	for _, a := range plan.Plan {
+		counts[a.Action]++
+		local := filepath.Join(plan.LocalDir, filepath.FromSlash(a.Path))
+		switch a.Action {
+		case "upload":
+			fmt.Printf("  upload:   %s → %s%s (%s, %s)\n", local, remote, a.Path, formatBytes(a.Size), a.Reason)
+			bytes += a.Size
+		case "download":
+			fmt.Printf("  download: %s%s → %s (%s, %s)\n", remote, a.Path, local, formatBytes(a.Size), a.Reason)
+			bytes += a.Size
+		case "delete":
+			if plan.Direction == "upload" {
+				fmt.Printf("  delete:   %s%s\n", remote, a.Path)
+			} else {
+				fmt.Printf("  delete:   %s\n", local)
+			}
+		}
+	}
+
+	fmt.Println()
+	copies := counts["upload"] + counts["download"]
+	fmt.Printf("Plan: %d to copy (%s), %d to delete, %d unchanged\n", copies, formatBytes(bytes), counts["delete"], plan.Unchanged)
+	if dryRun {
+		fmt.Println("Dry run: nothing was changed.")
+	}
+}
+
+// exitUnlessSucceeded exits with status 1 if a job did not succeed
+func exitUnlessSucceeded(job *agentJob) {
+	if job.Status != "succeeded" {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,223 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Bucket creation step names, reported in CreateBucketOutput.Steps
+const (
+	CheckBucketStep  = "s3:HeadBucket"
+	CreateBucketStep = "s3:CreateBucket"
+
+	BaselineStepOwnership    = "s3:PutBucketOwnershipControls"
+	BaselineStepPublicAccess = "s3:PutBucketPublicAccessBlock"
+	BaselineStepEncryption   = "s3:PutEncryptionConfiguration"
+	BaselineStepVersioning   = "s3:PutBucketVersioning"
+	BaselineStepTLSPolicy    = "s3:PutBucketPolicy"
+	BaselineStepLogging      = "s3:PutBucketLogging"
+	BaselineStepTags         = "s3:PutBucketTagging"
+
+	// Compensating actions delete or flag a bucket that did not meet the
+	// baseline
+	BaselineStepRollback = "s3:DeleteBucket"
+	BaselineStepFlag     = "s3:PutBucketTagging:Flag"
+)
+
+// BaselineFlagTag marks a bucket that was kept although a baseline step
+// failed
+const BaselineFlagTag = "ark:baseline"
+
+// BucketBaseline is the security configuration applied to every new bucket
+type BucketBaseline struct {
+	BlockPublicAccess   bool
+	BucketOwnerEnforced bool
+	RequireTLS          bool
+
+	// LoggingBucket, if set, receives server access logs under
+	// LoggingPrefix (default "<bucket>/")
+	LoggingBucket string
+	LoggingPrefix string
+
+	// RequiredTags must be present among the bucket's tags
+	RequiredTags []string
+
+	// Tags are applied to every bucket, under the bucket's own tags
+	Tags map[string]string
+
+	// Rollback deletes a bucket whose baseline failed; otherwise it is
+	// kept and tagged ark:baseline=incomplete
+	Rollback bool
+}
+
+// MissingTags returns the required tags that tags lacks or leaves empty
+func (b BucketBaseline) MissingTags(tags map[string]string) []string {
+	var missing []string
+	for _, key := range b.RequiredTags {
+		if strings.TrimSpace(tags[key]) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// bucketTags merges the baseline's tags with a bucket's own
+func (b BucketBaseline) bucketTags(tags map[string]string) map[string]string {
+	merged := make(map[string]string, len(b.Tags)+len(tags))
+	for k, v := range b.Tags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// baselineSteps lists the steps that bring a new bucket up to the
+// baseline. They need no compensating actions of their own: deleting the
+// bucket undoes them, and flagging it marks them as incomplete.
+func baselineSteps(client *Client, input CreateBucketInput, region string) []SagaStep {
+	bucket := aws.String(input.BucketName)
+	b := input.Baseline
+	var steps []SagaStep
+	add := func(name string, apply func(ctx context.Context) error) {
+		steps = append(steps, SagaStep{
+			Name: name,
+			Do: func(ctx context.Context) error {
+				return translateS3Error(name, apply(ctx))
+			},
+		})
+	}
+
+	if b.BucketOwnerEnforced {
+		add(BaselineStepOwnership, func(ctx context.Context) error {
+			_, err := client.S3Client.PutBucketOwnershipControls(ctx, &s3.PutBucketOwnershipControlsInput{
+				Bucket: bucket,
+				OwnershipControls: &types.OwnershipControls{
+					Rules: []types.OwnershipControlsRule{{ObjectOwnership: types.ObjectOwnershipBucketOwnerEnforced}},
+				},
+			})
+			return err
+		})
+	}
+	if b.BlockPublicAccess {
+		add(BaselineStepPublicAccess, func(ctx context.Context) error {
+			_, err := client.S3Client.PutPublicAccessBlock(ctx, &s3.PutPublicAccessBlockInput{
+				Bucket: bucket,
+				PublicAccessBlockConfiguration: &types.PublicAccessBlockConfiguration{
+					BlockPublicAcls:       aws.Bool(true),
+					IgnorePublicAcls:      aws.Bool(true),
+					BlockPublicPolicy:     aws.Bool(true),
+					RestrictPublicBuckets: aws.Bool(true),
+				},
+			})
+			return err
+		})
+	}
+	if input.EncryptionType != "" && input.EncryptionType != "none" {
+		add(BaselineStepEncryption, func(ctx context.Context) error {
+			return configureBucketEncryption(ctx, client, input.BucketName, input.EncryptionType, input.KMSKeyID)
+		})
+	}
+	if input.VersioningEnabled {
+		add(BaselineStepVersioning, func(ctx context.Context) error {
+			return enableBucketVersioning(ctx, client, input.BucketName)
+		})
+	}
+	if b.RequireTLS {
+		add(BaselineStepTLSPolicy, func(ctx context.Context) error {
+			policy, err := tlsOnlyPolicy(input.BucketName, region)
+			if err != nil {
+				return err
+			}
+			_, err = client.S3Client.PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{
+				Bucket: bucket,
+				Policy: aws.String(policy),
+			})
+			return err
+		})
+	}
+	if b.LoggingBucket != "" {
+		prefix := b.LoggingPrefix
+		if prefix == "" {
+			prefix = input.BucketName + "/"
+		}
+		add(BaselineStepLogging, func(ctx context.Context) error {
+			_, err := client.S3Client.PutBucketLogging(ctx, &s3.PutBucketLoggingInput{
+				Bucket: bucket,
+				BucketLoggingStatus: &types.BucketLoggingStatus{
+					LoggingEnabled: &types.LoggingEnabled{
+						TargetBucket: aws.String(b.LoggingBucket),
+						TargetPrefix: aws.String(prefix),
+					},
+				},
+			})
+			return err
+		})
+	}
+	if tags := b.bucketTags(input.Tags); len(tags) > 0 {
+		add(BaselineStepTags, func(ctx context.Context) error {
+			return putBucketTags(ctx, client, input.BucketName, tags)
+		})
+	}
+	return steps
+}
+
+// putBucketTags replaces a bucket's tags
+func putBucketTags(ctx context.Context, client *Client, bucket string, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	tagSet := make([]types.Tag, 0, len(keys))
+	for _, k := range keys {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	_, err := client.S3Client.PutBucketTagging(ctx, &s3.PutBucketTaggingInput{
+		Bucket:  aws.String(bucket),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	return err
+}
+
+// tlsOnlyPolicy returns a bucket policy denying every request not made
+// over TLS. Block Public Access does not reject it, since it only denies.
+func tlsOnlyPolicy(bucket, region string) (string, error) {
+	arn := fmt.Sprintf("arn:%s:s3:::%s", partition(region), bucket)
+	policy := map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{{
+			"Sid":       "DenyInsecureTransport",
+			"Effect":    "Deny",
+			"Principal": "*",
+			"Action":    "s3:*",
+			"Resource":  []string{arn, arn + "/*"},
+			"Condition": map[string]interface{}{
+				"Bool": map[string]string{"aws:SecureTransport": "false"},
+			},
+		}},
+	}
+	data, err := json.Marshal(policy)
+	return string(data), err
+}
+
+// partition returns the AWS partition a region belongs to
+func partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	default:
+		return "aws"
+	}
+}
@@ -0,0 +1,163 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// bucketStandIn serves the bucket calls of creating a bucket in us-east-1,
+// where creating a bucket the account already owns succeeds
+type bucketStandIn struct {
+	mu      sync.Mutex
+	buckets map[string]bool
+	deleted []string
+
+	// fail maps a bucket subresource, e.g. publicAccessBlock, to the error
+	// code configuring it returns
+	fail map[string]string
+}
+
+func newBucketStandIn(t *testing.T, existing ...string) (*bucketStandIn, *Client) {
+	t.Helper()
+	si := &bucketStandIn{buckets: map[string]bool{}, fail: map[string]string{}}
+	for _, name := range existing {
+		si.buckets[name] = true
+	}
+	srv := httptest.NewServer(si)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	provider := credentials.NewStaticCredentialsProvider("AKIAEXAMPLE", "secret", "")
+	return si, NewClientFromProvider(provider, "us-east-1")
+}
+
+func (si *bucketStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	name := strings.Trim(r.URL.Path, "/")
+	var subresource string
+	for k := range r.URL.Query() {
+		if k != "x-id" {
+			subresource = k
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodHead:
+		if !si.buckets[name] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == http.MethodPut && subresource == "":
+		si.buckets[name] = true
+	case r.Method == http.MethodDelete && subresource == "":
+		if !si.buckets[name] {
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+			return
+		}
+		delete(si.buckets, name)
+		si.deleted = append(si.deleted, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		if code, ok := si.fail[subresource]; ok {
+			writeS3Error(w, http.StatusForbidden, code)
+			return
+		}
+		if !si.buckets[name] {
+			writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		}
+	default:
+		writeS3Error(w, http.StatusBadRequest, "NotImplemented")
+	}
+}
+
+// deletedBuckets returns the buckets deleted so far
+func (si *bucketStandIn) deletedBuckets() []string {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return append([]string(nil), si.deleted...)
+}
+
+func rollbackInput(bucket string) CreateBucketInput {
+	return CreateBucketInput{
+		BucketName: bucket,
+		Region:     "us-east-1",
+		Baseline:   BucketBaseline{BlockPublicAccess: true, Rollback: true},
+	}
+}
+
+func TestCreateBucketRefusesExistingBucket(t *testing.T) {
+	si, client := newBucketStandIn(t, "lab-data")
+	// Had the bucket been "created", this failure would have rolled it back
+	si.fail["publicAccessBlock"] = "AccessDenied"
+
+	output, err := CreateBucket(context.Background(), client, rollbackInput("lab-data"))
+	if !errors.Is(err, ErrBucketExists) || output != nil {
+		t.Fatalf("create = %+v, %v; want ErrBucketExists", output, err)
+	}
+	if got := si.deletedBuckets(); len(got) != 0 {
+		t.Fatalf("deleted the existing bucket: %v", got)
+	}
+}
+
+func TestCreateBucketRollsBackNewBucket(t *testing.T) {
+	si, client := newBucketStandIn(t)
+	si.fail["publicAccessBlock"] = "AccessDenied"
+
+	output, err := CreateBucket(context.Background(), client, rollbackInput("lab-data"))
+	if err == nil || output == nil || !output.RolledBack {
+		t.Fatalf("create = %+v, %v; want a rollback", output, err)
+	}
+	if got := si.deletedBuckets(); len(got) != 1 || got[0] != "lab-data" {
+		t.Fatalf("deleted %v, want lab-data", got)
+	}
+}
+
+func TestRecoverSagaLeavesBucketCutOffWhileChecking(t *testing.T) {
+	input, err := json.Marshal(rollbackInput("lab-data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		steps       []store.SagaStep
+		wantDeleted bool
+	}{
+		// The agent stopped while checking whether the bucket exists
+		{[]store.SagaStep{{Name: CheckBucketStep, Status: store.StepRunning}}, false},
+		// The agent stopped while creating the bucket
+		{[]store.SagaStep{
+			{Name: CheckBucketStep, Status: store.StepSucceeded},
+			{Name: CreateBucketStep, Status: store.StepRunning},
+		}, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			si, client := newBucketStandIn(t, "lab-data")
+			state := store.SagaState{
+				Operation:    CreateBucketStep,
+				ResourceType: "s3:bucket",
+				ResourceID:   "lab-data",
+				Region:       "us-east-1",
+				Input:        input,
+				Steps:        tt.steps,
+			}
+			if _, err := RecoverSaga(context.Background(), client, newTransferStore(t), "saga", state); err != nil {
+				t.Fatalf("recover: %v", err)
+			}
+			if deleted := len(si.deletedBuckets()) > 0; deleted != tt.wantDeleted {
+				t.Fatalf("bucket deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
@@ -2,12 +2,16 @@ package aws
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
 	"github.com/scttfrdmn/ark/internal/agent/store"
 )
 
@@ -15,6 +19,7 @@ import (
 type Client struct {
 	S3Client  *s3.Client
 	STSClient *sts.Client
+	IAMClient *iam.Client
 	Config    aws.Config
 	Region    string
 }
@@ -29,9 +34,6 @@ func NewClientFromCredentials(ctx context.Context, creds *store.Credentials, reg
 	if region == "" {
 		region = creds.Region
 	}
-	if region == "" {
-		region = "us-east-1" // Default region
-	}
 
 	// Create static credentials provider
 	credsProvider := credentials.NewStaticCredentialsProvider(
@@ -40,31 +42,74 @@ func NewClientFromCredentials(ctx context.Context, creds *store.Credentials, reg
 		creds.SessionToken,
 	)
 
+	return NewClientFromProvider(credsProvider, region), nil
+}
+
+// NewClientFromProvider creates an AWS client that obtains credentials from
+// provider, e.g. a Resolver's provider for a long-running job
+func NewClientFromProvider(credsProvider aws.CredentialsProvider, region string) *Client {
+	if region == "" {
+		region = "us-east-1" // Default region
+	}
+
 	// Build AWS config
 	cfg := aws.Config{
 		Region:      region,
 		Credentials: credsProvider,
 	}
 
+	// AWS_ENDPOINT_URL points every service at a local stand-in such as
+	// LocalStack or moto, which serve S3 with path-style addressing
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	if endpoint != "" {
+		cfg.BaseEndpoint = aws.String(endpoint)
+	}
+
 	// Create S3 client
-	s3Client := s3.NewFromConfig(cfg)
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = endpoint != ""
+	})
 
 	// Create STS client for credential validation
 	stsClient := sts.NewFromConfig(cfg)
 
+	// Create IAM client for access key management
+	iamClient := iam.NewFromConfig(cfg)
+
 	return &Client{
 		S3Client:  s3Client,
 		STSClient: stsClient,
+		IAMClient: iamClient,
 		Config:    cfg,
 		Region:    region,
-	}, nil
+	}
 }
 
-// ValidateCredentials checks if the credentials are valid by calling STS GetCallerIdentity
-func (c *Client) ValidateCredentials(ctx context.Context) error {
-	_, err := c.STSClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+// ErrSTSUnavailable is returned when credentials could not be checked
+// because STS could not be reached
+var ErrSTSUnavailable = errors.New("could not reach AWS STS")
+
+// CallerIdentity is the AWS identity a set of credentials belongs to
+type CallerIdentity struct {
+	AccountID string `json:"account_id"`
+	ARN       string `json:"arn"`
+	UserID    string `json:"user_id"`
+}
+
+// ValidateCredentials checks if the credentials are valid by calling STS
+// GetCallerIdentity, and returns the identity they belong to
+func (c *Client) ValidateCredentials(ctx context.Context) (*CallerIdentity, error) {
+	out, err := c.STSClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
 	if err != nil {
-		return fmt.Errorf("invalid credentials: %w", err)
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) {
+			return nil, fmt.Errorf("%w: %v", ErrSTSUnavailable, err)
+		}
+		return nil, fmt.Errorf("invalid credentials: %w", translateSTSError(err))
 	}
-	return nil
+	return &CallerIdentity{
+		AccountID: aws.ToString(out.Account),
+		ARN:       aws.ToString(out.Arn),
+		UserID:    aws.ToString(out.UserId),
+	}, nil
 }
@@ -0,0 +1,141 @@
+package aws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// DefaultProcessTimeout bounds how long a credential_process command may run
+const DefaultProcessTimeout = 1 * time.Minute
+
+// maxProcessOutput caps how much a credential_process may print
+const maxProcessOutput = 64 * 1024
+
+// processWaitDelay bounds how long output is awaited once a
+// credential_process has exited or been killed, since processes it started
+// may hold its output open
+const processWaitDelay = 2 * time.Second
+
+// processOutput is the JSON document a credential_process prints
+type processOutput struct {
+	Version         int       `json:"Version"`
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"SessionToken"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// secretPatterns match values that must not reach the logs
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(AKIA|ASIA)[A-Z0-9]{16}\b`),
+	// Secret keys, session tokens and other long base64-ish blobs
+	regexp.MustCompile(`[A-Za-z0-9/+=]{32,}`),
+}
+
+// RunCredentialProcess runs an external credential_process command through
+// the platform shell, as the AWS CLI does, and parses its output. Standard
+// error is returned only after redaction, and output is never echoed back in
+// errors since it may contain secrets.
+func RunCredentialProcess(ctx context.Context, command string, timeout time.Duration) (*store.Credentials, error) {
+	if strings.TrimSpace(command) == "" {
+		return nil, fmt.Errorf("credential_process is empty")
+	}
+	if timeout <= 0 {
+		timeout = DefaultProcessTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd.exe", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	}
+	// A timeout kills everything the command started, not just the shell
+	killProcessGroup(cmd)
+	cmd.WaitDelay = processWaitDelay
+
+	stdout := &limitedBuffer{max: maxProcessOutput}
+	stderr := &limitedBuffer{max: maxProcessOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("credential_process timed out after %s", timeout)
+	}
+	if errors.Is(err, exec.ErrWaitDelay) {
+		// The command succeeded but left something running with its output
+		// open; what it printed is complete
+		err = nil
+	}
+	if err != nil {
+		msg := RedactSecrets(strings.TrimSpace(stderr.String()))
+		if msg == "" {
+			return nil, fmt.Errorf("credential_process failed: %w", err)
+		}
+		return nil, fmt.Errorf("credential_process failed: %w: %s", err, msg)
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("credential_process output exceeds %d bytes", maxProcessOutput)
+	}
+
+	var out processOutput
+	if err := json.Unmarshal(stdout.Bytes(), &out); err != nil {
+		return nil, errors.New("credential_process output is not valid JSON")
+	}
+	if out.Version != 1 {
+		return nil, fmt.Errorf("credential_process output has unsupported Version %d (expected 1)", out.Version)
+	}
+	if out.AccessKeyID == "" || out.SecretAccessKey == "" {
+		return nil, errors.New("credential_process output is missing AccessKeyId or SecretAccessKey")
+	}
+	if !out.Expiration.IsZero() && time.Now().After(out.Expiration) {
+		return nil, fmt.Errorf("credential_process returned credentials that expired at %s", out.Expiration.Format(time.RFC3339))
+	}
+
+	return &store.Credentials{
+		AccessKeyID:     out.AccessKeyID,
+		SecretAccessKey: out.SecretAccessKey,
+		SessionToken:    out.SessionToken,
+		Expiration:      out.Expiration,
+	}, nil
+}
+
+// RedactSecrets masks access key IDs, secret keys and tokens in free text
+func RedactSecrets(s string) string {
+	for _, re := range secretPatterns {
+		s = re.ReplaceAllString(s, "[REDACTED]")
+	}
+	return s
+}
+
+// limitedBuffer collects up to max bytes and silently drops the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
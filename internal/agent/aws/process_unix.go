@@ -0,0 +1,18 @@
+//go:build !windows
+
+package aws
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// killProcessGroup runs cmd in a process group of its own, and makes
+// cancelling it kill the whole group
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		// A negative PID signals the group
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
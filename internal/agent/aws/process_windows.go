@@ -0,0 +1,9 @@
+//go:build windows
+
+package aws
+
+import "os/exec"
+
+// killProcessGroup leaves cmd as it is: cancelling it kills cmd.exe, and
+// WaitDelay stops Wait from blocking on anything it started
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,392 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+const (
+	// refreshWindow is how long before expiry cached sessions are renewed
+	// on demand
+	refreshWindow = 5 * time.Minute
+
+	// backgroundRefreshWindow is how far ahead RefreshSessions renews
+	// sessions so they are fresh before anyone asks for them
+	backgroundRefreshWindow = 10 * time.Minute
+
+	// maxSourceDepth bounds source_profile chains (and catches cycles)
+	maxSourceDepth = 5
+)
+
+// MFARequiredError is returned when a profile needs an MFA code and no
+// cached session is available. Rejected is set when a code was supplied but
+// STS did not accept it.
+type MFARequiredError struct {
+	Profile      string
+	SerialNumber string
+	Rejected     bool
+}
+
+func (e *MFARequiredError) Error() string {
+	if e.Rejected {
+		return fmt.Sprintf("MFA code for %s was rejected", e.Profile)
+	}
+	return fmt.Sprintf("profile %s requires an MFA code from %s", e.Profile, e.SerialNumber)
+}
+
+// SSOLoginRequiredError is returned when an SSO profile has no valid
+// Identity Center token and the user must log in again
+type SSOLoginRequiredError struct {
+	Profile  string
+	StartURL string
+}
+
+func (e *SSOLoginRequiredError) Error() string {
+	return fmt.Sprintf("profile %s needs an AWS SSO login for %s (run 'ark login --sso --profile %s')",
+		e.Profile, e.StartURL, e.Profile)
+}
+
+// Resolver turns stored profiles into credentials usable with
+// NewClientFromCredentials. Static profiles are returned as stored; role
+// profiles are assumed through STS, profiles with an MFA device are
+// exchanged for an MFA-authenticated session, and SSO profiles use the
+// Identity Center token from 'ark login --sso'. Temporary credentials are
+// cached in the agent store until shortly before they expire.
+type Resolver struct {
+	store *store.Store
+}
+
+// NewResolver creates a credential resolver backed by the agent store
+func NewResolver(s *store.Store) *Resolver {
+	return &Resolver{store: s}
+}
+
+// Resolve returns usable credentials for a profile. mfaCode is only used
+// when the profile (or its source profile) has an MFA device and no cached
+// session; without it a *MFARequiredError is returned.
+func (r *Resolver) Resolve(ctx context.Context, profile, mfaCode string) (*store.Credentials, error) {
+	return r.resolve(ctx, profile, mfaCode, 0)
+}
+
+func (r *Resolver) resolve(ctx context.Context, profile, mfaCode string, depth int) (*store.Credentials, error) {
+	if depth > maxSourceDepth {
+		return nil, fmt.Errorf("source_profile chain is too deep or circular at %s", profile)
+	}
+
+	creds, err := r.store.GetCredential(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	switch creds.Type() {
+	case store.CredentialTypeRole:
+		if cached, ok := r.cachedSession(profile); ok {
+			return cached, nil
+		}
+		return r.assumeRole(ctx, profile, creds, mfaCode, depth)
+	case store.CredentialTypeProcess:
+		if cached, ok := r.cachedSession(profile); ok {
+			return cached, nil
+		}
+		return r.runProcess(ctx, profile, creds)
+	case store.CredentialTypeSSO:
+		if cached, ok := r.cachedSession(profile); ok {
+			return cached, nil
+		}
+		return r.ssoSession(ctx, profile, creds)
+	default:
+		if creds.MFASerial == "" {
+			return creds, nil
+		}
+		if cached, ok := r.cachedSession(profile); ok {
+			return cached, nil
+		}
+		return r.mfaSession(ctx, profile, creds, mfaCode)
+	}
+}
+
+// cachedSession returns a cached session that is not about to expire
+func (r *Resolver) cachedSession(profile string) (*store.Credentials, bool) {
+	cached, err := r.store.GetCachedCredential(sessionCacheKey(profile))
+	if err != nil || time.Until(cached.Expiration) <= refreshWindow {
+		return nil, false
+	}
+	return cached, true
+}
+
+// mfaSession exchanges long-term keys and an MFA code for a session token
+func (r *Resolver) mfaSession(ctx context.Context, profile string, creds *store.Credentials, mfaCode string) (*store.Credentials, error) {
+	if mfaCode == "" {
+		return nil, &MFARequiredError{Profile: profile, SerialNumber: creds.MFASerial}
+	}
+
+	client, err := NewClientFromCredentials(ctx, creds, "")
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := GetSessionToken(ctx, client, creds.MFASerial, mfaCode)
+	if errors.Is(err, ErrInvalidMFACode) {
+		return nil, &MFARequiredError{Profile: profile, SerialNumber: creds.MFASerial, Rejected: true}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get MFA session: %w", err)
+	}
+	session.Region = creds.Region
+
+	if err := r.store.SetCachedCredential(sessionCacheKey(profile), *session); err != nil {
+		slog.Warn("failed to cache MFA session", "error", err, "profile", profile)
+	}
+
+	slog.Info("MFA session established", "profile", profile, "expiration", session.Expiration)
+
+	return session, nil
+}
+
+// runProcess obtains credentials from the profile's credential_process and
+// caches them until they expire. Credentials without an expiry are not
+// cached, so the command runs each time they are needed.
+func (r *Resolver) runProcess(ctx context.Context, profile string, creds *store.Credentials) (*store.Credentials, error) {
+	result, err := RunCredentialProcess(ctx, creds.CredentialProcess, DefaultProcessTimeout)
+	if err != nil {
+		// The error carries redacted stderr only
+		slog.Warn("credential_process failed", "error", err, "profile", profile)
+		return nil, fmt.Errorf("profile %s: %w", profile, err)
+	}
+	result.Region = creds.Region
+
+	if !result.Expiration.IsZero() {
+		if err := r.store.SetCachedCredential(sessionCacheKey(profile), *result); err != nil {
+			slog.Warn("failed to cache process credentials", "error", err, "profile", profile)
+		}
+	}
+
+	slog.Info("credentials obtained from credential_process",
+		"profile", profile,
+		"expiration", result.Expiration,
+	)
+
+	return result, nil
+}
+
+// ssoSession fetches role credentials for an SSO profile using the cached
+// Identity Center token for its start URL
+func (r *Resolver) ssoSession(ctx context.Context, profile string, creds *store.Credentials) (*store.Credentials, error) {
+	if creds.SSOStartURL == "" || creds.SSORegion == "" || creds.SSOAccountID == "" || creds.SSORoleName == "" {
+		return nil, fmt.Errorf("SSO profile %s needs sso_start_url, sso_region, sso_account_id and sso_role_name", profile)
+	}
+
+	token, err := r.SSOToken(creds.SSOStartURL)
+	if err != nil {
+		return nil, &SSOLoginRequiredError{Profile: profile, StartURL: creds.SSOStartURL}
+	}
+
+	session, err := NewSSOClient(creds.SSORegion).GetRoleCredentials(ctx, token, creds.SSOAccountID, creds.SSORoleName)
+	if err != nil {
+		return nil, err
+	}
+	session.Region = creds.Region
+
+	if err := r.store.SetCachedCredential(sessionCacheKey(profile), *session); err != nil {
+		slog.Warn("failed to cache SSO session", "error", err, "profile", profile)
+	}
+
+	slog.Info("SSO role credentials obtained",
+		"profile", profile,
+		"account_id", creds.SSOAccountID,
+		"role", creds.SSORoleName,
+		"expiration", session.Expiration,
+	)
+
+	return session, nil
+}
+
+// SSOToken returns the cached Identity Center token for a start URL
+func (r *Resolver) SSOToken(startURL string) (*SSOToken, error) {
+	var token SSOToken
+	if err := r.store.GetSecretCache(ssoTokenCacheKey(startURL), &token); err != nil {
+		return nil, err
+	}
+	if time.Until(token.ExpiresAt) <= refreshWindow {
+		return nil, fmt.Errorf("SSO token for %s has expired", startURL)
+	}
+	return &token, nil
+}
+
+// SaveSSOToken caches an Identity Center token until it expires
+func (r *Resolver) SaveSSOToken(startURL string, token *SSOToken) error {
+	return r.store.SetSecretCache(ssoTokenCacheKey(startURL), token, time.Until(token.ExpiresAt))
+}
+
+// SSORegistration returns the agent's OIDC client registration for the
+// client's region, registering a new client when none is cached
+func (r *Resolver) SSORegistration(ctx context.Context, client *SSOClient) (*SSORegistration, error) {
+	key := "sso-client:" + client.Region
+
+	var reg SSORegistration
+	if err := r.store.GetSecretCache(key, &reg); err == nil && time.Until(reg.ExpiresAt) > time.Hour {
+		return &reg, nil
+	}
+
+	fresh, err := client.RegisterClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.store.SetSecretCache(key, fresh, time.Until(fresh.ExpiresAt)); err != nil {
+		slog.Warn("failed to cache OIDC client registration", "error", err)
+	}
+	return fresh, nil
+}
+
+// assumeRole assumes the profile's role using its source profile and caches
+// the resulting session
+func (r *Resolver) assumeRole(ctx context.Context, profile string, creds *store.Credentials, mfaCode string, depth int) (*store.Credentials, error) {
+	if creds.SourceProfile == "" {
+		return nil, fmt.Errorf("role profile %s has no source_profile", profile)
+	}
+	if creds.MFASerial != "" && mfaCode == "" {
+		return nil, &MFARequiredError{Profile: profile, SerialNumber: creds.MFASerial}
+	}
+
+	// A code meant for this role must not be spent on the source profile
+	sourceCode := mfaCode
+	if creds.MFASerial != "" {
+		sourceCode = ""
+	}
+	source, err := r.resolve(ctx, creds.SourceProfile, sourceCode, depth+1)
+	if err != nil {
+		var mfaErr *MFARequiredError
+		if errors.As(err, &mfaErr) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("resolve source profile %s: %w", creds.SourceProfile, err)
+	}
+
+	client, err := NewClientFromCredentials(ctx, source, creds.Region)
+	if err != nil {
+		return nil, err
+	}
+
+	name := creds.RoleSessionName
+	if name == "" {
+		name = sessionName(currentUser())
+	}
+
+	session, err := AssumeRole(ctx, client, AssumeRoleInput{
+		RoleARN:         creds.RoleARN,
+		RoleSessionName: name,
+		ExternalID:      creds.ExternalID,
+		MFASerial:       creds.MFASerial,
+		MFACode:         mfaCode,
+	})
+	if errors.Is(err, ErrInvalidMFACode) {
+		return nil, &MFARequiredError{Profile: profile, SerialNumber: creds.MFASerial, Rejected: true}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("assume role %s: %w", creds.RoleARN, err)
+	}
+
+	if err := r.store.SetCachedCredential(sessionCacheKey(profile), *session); err != nil {
+		// The session is still usable; it just won't be reused
+		slog.Warn("failed to cache role session", "error", err, "profile", profile)
+	}
+
+	slog.Info("assumed role",
+		"profile", profile,
+		"role_arn", creds.RoleARN,
+		"expiration", session.Expiration,
+	)
+
+	return session, nil
+}
+
+// Provider returns an AWS credentials provider for a profile that resolves
+// it again whenever its credentials expire, for work that can outlast a
+// session such as a large transfer. Sessions that need an MFA code cannot
+// be renewed this way.
+func (r *Resolver) Provider(profile string) aws.CredentialsProvider {
+	return aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		creds, err := r.Resolve(ctx, profile, "")
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		return aws.Credentials{
+			AccessKeyID:     creds.AccessKeyID,
+			SecretAccessKey: creds.SecretAccessKey,
+			SessionToken:    creds.SessionToken,
+			Source:          "ark:" + profile,
+			CanExpire:       !creds.Expiration.IsZero(),
+			Expires:         creds.Expiration,
+		}, nil
+	}), func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = refreshWindow
+	})
+}
+
+// RefreshSessions renews cached role sessions, SSO role credentials and
+// credential_process results that are close to expiring. Only sessions
+// already in the cache are refreshed, so idle profiles do not trigger STS
+// calls or run commands. Sessions that need an MFA code are left to expire.
+func (r *Resolver) RefreshSessions(ctx context.Context) {
+	profiles, err := r.store.ListCredentials()
+	if err != nil {
+		slog.Warn("failed to list profiles for session refresh", "error", err)
+		return
+	}
+
+	for profile, creds := range profiles {
+		if creds.Type() == store.CredentialTypeStatic || creds.MFASerial != "" {
+			continue
+		}
+
+		cached, err := r.store.GetCachedCredential(sessionCacheKey(profile))
+		if err != nil || time.Until(cached.Expiration) > backgroundRefreshWindow {
+			continue
+		}
+
+		switch creds.Type() {
+		case store.CredentialTypeProcess:
+			_, err = r.runProcess(ctx, profile, creds)
+		case store.CredentialTypeSSO:
+			_, err = r.ssoSession(ctx, profile, creds)
+		default:
+			_, err = r.assumeRole(ctx, profile, creds, "", 0)
+		}
+		if err != nil {
+			slog.Warn("failed to refresh session", "error", err, "profile", profile, "type", creds.Type())
+		}
+	}
+}
+
+// InvalidateSession drops any cached session for a profile
+func (r *Resolver) InvalidateSession(profile string) error {
+	return r.store.DeleteCache(sessionCacheKey(profile))
+}
+
+// ssoTokenCacheKey is the cache key for a start URL's Identity Center token
+func ssoTokenCacheKey(startURL string) string {
+	return "sso-token:" + startURL
+}
+
+// sessionCacheKey is the cache key for a profile's temporary credentials
+func sessionCacheKey(profile string) string {
+	return "session:" + profile
+}
+
+// currentUser returns the local user name used in role session names
+func currentUser() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	if user := os.Getenv("USERNAME"); user != "" {
+		return user
+	}
+	return "unknown"
+}
@@ -0,0 +1,245 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// stsStandIn serves AssumeRole, issuing sessions that last for lifetime
+type stsStandIn struct {
+	mu       sync.Mutex
+	calls    []assumeRoleCall
+	lifetime time.Duration
+}
+
+// assumeRoleCall is what an AssumeRole request asked for, and with which
+// access key it was signed
+type assumeRoleCall struct {
+	caller, roleARN, sessionName, externalID, serial, code string
+}
+
+// validMFACode is the only code the stand-in accepts
+const validMFACode = "123456"
+
+// newSTSStandIn starts a stand-in and points the AWS clients at it
+func newSTSStandIn(t *testing.T) *stsStandIn {
+	t.Helper()
+	si := &stsStandIn{lifetime: time.Hour}
+	srv := httptest.NewServer(si)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return si
+}
+
+func (si *stsStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if action := r.Form.Get("Action"); action != "AssumeRole" {
+		writeQueryError(w, http.StatusBadRequest, "InvalidAction", "unsupported action "+action)
+		return
+	}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	call := assumeRoleCall{
+		roleARN:     r.Form.Get("RoleArn"),
+		sessionName: r.Form.Get("RoleSessionName"),
+		externalID:  r.Form.Get("ExternalId"),
+		serial:      r.Form.Get("SerialNumber"),
+		code:        r.Form.Get("TokenCode"),
+	}
+	if m := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+		call.caller = m[1]
+	}
+	si.calls = append(si.calls, call)
+
+	if call.serial != "" && call.code != validMFACode {
+		writeQueryError(w, http.StatusForbidden, "AccessDenied", "MultiFactorAuthentication failed with invalid MFA one time pass code.")
+		return
+	}
+	fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>`+
+		`<AccessKeyId>ASIASESSION%05d</AccessKeyId><SecretAccessKey>session-secret</SecretAccessKey>`+
+		`<SessionToken>session-token-%d</SessionToken><Expiration>%s</Expiration>`+
+		`</Credentials></AssumeRoleResult></AssumeRoleResponse>`,
+		len(si.calls), len(si.calls), time.Now().Add(si.lifetime).UTC().Format(time.RFC3339))
+}
+
+// setLifetime sets how long the sessions issued from now on last
+func (si *stsStandIn) setLifetime(d time.Duration) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.lifetime = d
+}
+
+func (si *stsStandIn) recorded() []assumeRoleCall {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return append([]assumeRoleCall(nil), si.calls...)
+}
+
+// newRoleResolver returns a resolver on a new store holding profiles
+func newRoleResolver(t *testing.T, profiles map[string]store.Credentials) *Resolver {
+	t.Helper()
+	st := newTransferStore(t)
+	for name, creds := range profiles {
+		if err := st.SetCredential(name, creds); err != nil {
+			t.Fatalf("set %s: %v", name, err)
+		}
+	}
+	return NewResolver(st)
+}
+
+var sourceKeys = store.Credentials{AccessKeyID: "AKIASOURCE0000", SecretAccessKey: "source-secret"}
+
+func TestResolveAssumesRoleAndCachesSession(t *testing.T) {
+	si := newSTSStandIn(t)
+	r := newRoleResolver(t, map[string]store.Credentials{
+		"default": sourceKeys,
+		"lab": {
+			RoleARN:         "arn:aws:iam::123456789012:role/lab",
+			SourceProfile:   "default",
+			ExternalID:      "lab-external-id",
+			RoleSessionName: "ark-test",
+			Region:          "us-west-2",
+		},
+	})
+	ctx := context.Background()
+
+	creds, err := r.Resolve(ctx, "lab", "")
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	if creds.AccessKeyID != "ASIASESSION00001" || creds.SessionToken != "session-token-1" || creds.Region != "us-west-2" {
+		t.Fatalf("session = %+v", creds)
+	}
+	want := assumeRoleCall{caller: "AKIASOURCE0000", roleARN: "arn:aws:iam::123456789012:role/lab", sessionName: "ark-test", externalID: "lab-external-id"}
+	if calls := si.recorded(); len(calls) != 1 || calls[0] != want {
+		t.Fatalf("AssumeRole calls = %+v, want %+v", calls, want)
+	}
+
+	// The cached session is used until it nears expiry
+	again, err := r.Resolve(ctx, "lab", "")
+	if err != nil || again.AccessKeyID != creds.AccessKeyID {
+		t.Fatalf("second resolve = %+v, %v", again, err)
+	}
+	if err := r.InvalidateSession("lab"); err != nil {
+		t.Fatal(err)
+	}
+	if again, err = r.Resolve(ctx, "lab", ""); err != nil || again.AccessKeyID != "ASIASESSION00002" {
+		t.Fatalf("resolve after invalidating = %+v, %v", again, err)
+	}
+}
+
+func TestResolveChainedRole(t *testing.T) {
+	si := newSTSStandIn(t)
+	r := newRoleResolver(t, map[string]store.Credentials{
+		"default": sourceKeys,
+		"hub":     {RoleARN: "arn:aws:iam::111111111111:role/hub", SourceProfile: "default", RoleSessionName: "ark-test"},
+		"spoke":   {RoleARN: "arn:aws:iam::222222222222:role/spoke", SourceProfile: "hub", RoleSessionName: "ark-test"},
+		"loop-a":  {RoleARN: "arn:aws:iam::123456789012:role/a", SourceProfile: "loop-b"},
+		"loop-b":  {RoleARN: "arn:aws:iam::123456789012:role/b", SourceProfile: "loop-a"},
+	})
+	ctx := context.Background()
+
+	if _, err := r.Resolve(ctx, "spoke", ""); err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	calls := si.recorded()
+	if len(calls) != 2 || calls[0].roleARN != "arn:aws:iam::111111111111:role/hub" || calls[0].caller != "AKIASOURCE0000" ||
+		calls[1].roleARN != "arn:aws:iam::222222222222:role/spoke" || calls[1].caller != "ASIASESSION00001" {
+		t.Fatalf("AssumeRole calls = %+v, want hub with the source keys, then spoke with hub's session", calls)
+	}
+
+	if _, err := r.Resolve(ctx, "loop-a", ""); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Fatalf("resolve circular chain: got %v", err)
+	}
+}
+
+func TestResolveRoleWithMFA(t *testing.T) {
+	si := newSTSStandIn(t)
+	serial := "arn:aws:iam::123456789012:mfa/researcher"
+	r := newRoleResolver(t, map[string]store.Credentials{
+		"default": sourceKeys,
+		"admin":   {RoleARN: "arn:aws:iam::123456789012:role/admin", SourceProfile: "default", MFASerial: serial},
+	})
+	ctx := context.Background()
+
+	_, err := r.Resolve(ctx, "admin", "")
+	var mfaErr *MFARequiredError
+	if !errors.As(err, &mfaErr) || mfaErr.Rejected || mfaErr.SerialNumber != serial {
+		t.Fatalf("resolve without a code: got %v, want *MFARequiredError", err)
+	}
+	if calls := si.recorded(); len(calls) != 0 {
+		t.Fatalf("AssumeRole called without a code: %+v", calls)
+	}
+
+	_, err = r.Resolve(ctx, "admin", "000000")
+	if !errors.As(err, &mfaErr) || !mfaErr.Rejected {
+		t.Fatalf("resolve with a wrong code: got %v, want a rejected *MFARequiredError", err)
+	}
+
+	if _, err := r.Resolve(ctx, "admin", validMFACode); err != nil {
+		t.Fatalf("resolve with the code: %v", err)
+	}
+	calls := si.recorded()
+	if last := calls[len(calls)-1]; last.serial != serial || last.code != validMFACode {
+		t.Fatalf("AssumeRole sent serial %q and code %q", last.serial, last.code)
+	}
+
+	// The session is reused without a code until it expires
+	if _, err := r.Resolve(ctx, "admin", ""); err != nil {
+		t.Fatalf("resolve cached MFA session: %v", err)
+	}
+}
+
+func TestRefreshSessions(t *testing.T) {
+	si := newSTSStandIn(t)
+	r := newRoleResolver(t, map[string]store.Credentials{
+		"default": sourceKeys,
+		"lab":     {RoleARN: "arn:aws:iam::123456789012:role/lab", SourceProfile: "default"},
+		"idle":    {RoleARN: "arn:aws:iam::123456789012:role/idle", SourceProfile: "default"},
+		"admin":   {RoleARN: "arn:aws:iam::123456789012:role/admin", SourceProfile: "default", MFASerial: "arn:aws:iam::123456789012:mfa/researcher"},
+	})
+	ctx := context.Background()
+
+	// Sessions close enough to expiry for the background refresh, but not
+	// yet for Resolve
+	si.setLifetime((refreshWindow + backgroundRefreshWindow) / 2)
+	if _, err := r.Resolve(ctx, "lab", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Resolve(ctx, "admin", validMFACode); err != nil {
+		t.Fatal(err)
+	}
+
+	si.setLifetime(time.Hour)
+	r.RefreshSessions(ctx)
+
+	// Only lab is renewed: idle has no session to renew, and admin's
+	// needs a code
+	calls := si.recorded()
+	if len(calls) != 3 || calls[2].roleARN != "arn:aws:iam::123456789012:role/lab" {
+		t.Fatalf("AssumeRole calls = %+v, want a third for lab", calls)
+	}
+	creds, err := r.Resolve(ctx, "lab", "")
+	if err != nil || creds.AccessKeyID != "ASIASESSION00003" {
+		t.Fatalf("lab after refresh = %+v, %v; want the renewed session", creds, err)
+	}
+
+	// A fresh session is left alone
+	r.RefreshSessions(ctx)
+	if calls := si.recorded(); len(calls) != 3 {
+		t.Fatalf("fresh session renewed: %+v", calls[3:])
+	}
+}
@@ -0,0 +1,224 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// Rotation step names, reported through RotateOptions.OnStep
+const (
+	RotationStepCreate     = "iam:CreateAccessKey"
+	RotationStepVerify     = "sts:GetCallerIdentity"
+	RotationStepStore      = "credentials:Store"
+	RotationStepDeactivate = "iam:UpdateAccessKey"
+	RotationStepDelete     = "iam:DeleteAccessKey"
+
+	// Rollback steps undo the ones above
+	RotationStepRollbackStore  = "credentials:Restore"
+	RotationStepRollbackCreate = "iam:DeleteNewAccessKey"
+)
+
+// defaultVerifyTimeout bounds how long a new key may take to become usable;
+// IAM changes are eventually consistent and usually settle within seconds
+const defaultVerifyTimeout = 30 * time.Second
+
+// RotationStep is the outcome of one rotation or rollback step
+type RotationStep struct {
+	Name        string `json:"name"`
+	AccessKeyID string `json:"access_key_id"`
+	Status      string `json:"status"` // success, failure
+	Error       string `json:"error,omitempty"`
+}
+
+// RotateOptions controls RotateAccessKey
+type RotateOptions struct {
+	// Save stores the new credentials once the new key is verified
+	Save func(store.Credentials) error
+
+	// Restore puts the previous credentials back during rollback
+	Restore func(store.Credentials) error
+
+	// OnStep is called after every step, including rollback steps
+	OnStep func(RotationStep)
+
+	// VerifyTimeout bounds how long to wait for the new key to work
+	VerifyTimeout time.Duration
+}
+
+// RotateAccessKey replaces a profile's IAM access key: it creates a new key,
+// verifies it with STS, saves it, then deactivates and deletes the old key.
+// A failure before the old key is deactivated rolls back by restoring the
+// old credentials and deleting the new key. If only the final delete fails,
+// the rotation stands and the old key is left inactive; the returned error
+// says so and the new credentials are returned alongside it.
+func RotateAccessKey(ctx context.Context, old *store.Credentials, opts RotateOptions) (*store.Credentials, error) {
+	if old.Type() != store.CredentialTypeStatic || old.SessionToken != "" {
+		return nil, fmt.Errorf("only long-term access keys can be rotated")
+	}
+	if opts.VerifyTimeout == 0 {
+		opts.VerifyTimeout = defaultVerifyTimeout
+	}
+	report := func(name, keyID string, err error) {
+		if opts.OnStep == nil {
+			return
+		}
+		step := RotationStep{Name: name, AccessKeyID: keyID, Status: "success"}
+		if err != nil {
+			step.Status = "failure"
+			step.Error = err.Error()
+		}
+		opts.OnStep(step)
+	}
+
+	oldClient, err := NewClientFromCredentials(ctx, old, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// IAM allows two keys per user; a second key leaves no room to rotate
+	keys, err := oldClient.IAMClient.ListAccessKeys(ctx, &iam.ListAccessKeysInput{})
+	if err != nil {
+		return nil, fmt.Errorf("list access keys: %w", translateIAMError(err))
+	}
+	if len(keys.AccessKeyMetadata) >= 2 {
+		return nil, fmt.Errorf("IAM user already has two access keys; delete the unused one before rotating")
+	}
+
+	created, err := oldClient.IAMClient.CreateAccessKey(ctx, &iam.CreateAccessKeyInput{})
+	if err != nil {
+		err = translateIAMError(err)
+		report(RotationStepCreate, "", err)
+		return nil, fmt.Errorf("create access key: %w", err)
+	}
+	newKeyID := aws.ToString(created.AccessKey.AccessKeyId)
+	report(RotationStepCreate, newKeyID, nil)
+
+	// deleteNewKey undoes CreateAccessKey using the still-active old key. It
+	// carries on if the caller has gone: an orphaned key would block the
+	// next rotation.
+	deleteNewKey := func() error {
+		_, err := oldClient.IAMClient.DeleteAccessKey(context.WithoutCancel(ctx), &iam.DeleteAccessKeyInput{
+			AccessKeyId: aws.String(newKeyID),
+		})
+		if err != nil {
+			err = translateIAMError(err)
+		}
+		report(RotationStepRollbackCreate, newKeyID, err)
+		return err
+	}
+	rollback := func(cause error, restore bool) error {
+		var errs []error
+		if restore && opts.Restore != nil {
+			err := opts.Restore(*old)
+			report(RotationStepRollbackStore, old.AccessKeyID, err)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("restore previous credentials: %w", err))
+			}
+		}
+		if err := deleteNewKey(); err != nil {
+			errs = append(errs, fmt.Errorf("delete new key %s: %w", newKeyID, err))
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%w (rollback incomplete: %v)", cause, errors.Join(errs...))
+		}
+		return fmt.Errorf("%w (rolled back)", cause)
+	}
+
+	rotated := *old
+	rotated.AccessKeyID = newKeyID
+	rotated.SecretAccessKey = aws.ToString(created.AccessKey.SecretAccessKey)
+	rotated.StoredAt = time.Now().UTC()
+	rotated.RotationAlertedAt = time.Time{}
+
+	identity, err := verifyNewKey(ctx, &rotated, opts.VerifyTimeout)
+	report(RotationStepVerify, newKeyID, err)
+	if err != nil {
+		return nil, rollback(fmt.Errorf("verify new key: %w", err), false)
+	}
+	rotated.AccountID = identity.AccountID
+	rotated.ARN = identity.ARN
+	rotated.ValidatedAt = time.Now().UTC()
+
+	if opts.Save != nil {
+		err := opts.Save(rotated)
+		report(RotationStepStore, newKeyID, err)
+		if err != nil {
+			return nil, rollback(fmt.Errorf("store new key: %w", err), false)
+		}
+	}
+
+	newClient, err := NewClientFromCredentials(ctx, &rotated, "")
+	if err != nil {
+		return nil, rollback(err, true)
+	}
+
+	_, err = newClient.IAMClient.UpdateAccessKey(ctx, &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(old.AccessKeyID),
+		Status:      iamtypes.StatusTypeInactive,
+	})
+	if err != nil {
+		err = translateIAMError(err)
+		report(RotationStepDeactivate, old.AccessKeyID, err)
+		return nil, rollback(fmt.Errorf("deactivate old key: %w", err), true)
+	}
+	report(RotationStepDeactivate, old.AccessKeyID, nil)
+
+	_, err = newClient.IAMClient.DeleteAccessKey(ctx, &iam.DeleteAccessKeyInput{
+		AccessKeyId: aws.String(old.AccessKeyID),
+	})
+	if err != nil {
+		err = translateIAMError(err)
+		report(RotationStepDelete, old.AccessKeyID, err)
+		return &rotated, fmt.Errorf("old key %s was deactivated but not deleted: %w", old.AccessKeyID, err)
+	}
+	report(RotationStepDelete, old.AccessKeyID, nil)
+
+	return &rotated, nil
+}
+
+// verifyNewKey waits for a freshly created key to be accepted by STS
+func verifyNewKey(ctx context.Context, creds *store.Credentials, timeout time.Duration) (*CallerIdentity, error) {
+	client, err := NewClientFromCredentials(ctx, creds, "")
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	delay := 1 * time.Second
+	for {
+		identity, err := client.ValidateCredentials(ctx)
+		if err == nil {
+			return identity, nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// translateIAMError translates IAM errors to user-friendly messages
+func translateIAMError(err error) error {
+	var limitErr *iamtypes.LimitExceededException
+	if errors.As(err, &limitErr) {
+		return fmt.Errorf("IAM user already has the maximum number of access keys")
+	}
+	var noEntity *iamtypes.NoSuchEntityException
+	if errors.As(err, &noEntity) {
+		return fmt.Errorf("access key or user not found (root and federated identities cannot rotate keys here)")
+	}
+	return translateSTSError(err)
+}
@@ -0,0 +1,283 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// iamStandIn serves the IAM and STS calls key rotation makes, for one IAM
+// user
+type iamStandIn struct {
+	mu   sync.Mutex
+	keys map[string]*standInKey
+	next int
+
+	// unverified is how many GetCallerIdentity calls a new key fails
+	// before IAM has "propagated" it; -1 fails them all
+	unverified int
+
+	// fail maps an IAM action to the error code it returns
+	fail map[string]string
+
+	// onVerify is called for every GetCallerIdentity with a new key
+	onVerify func()
+}
+
+type standInKey struct {
+	secret   string
+	active   bool
+	verified int
+	original bool
+}
+
+// newIAMStandIn starts a stand-in holding one active key, and points the
+// AWS clients at it
+func newIAMStandIn(t *testing.T) (*iamStandIn, *store.Credentials) {
+	t.Helper()
+	si := &iamStandIn{
+		keys: map[string]*standInKey{"AKIAORIGINAL0000": {secret: "original-secret", active: true, original: true}},
+		fail: map[string]string{},
+	}
+	srv := httptest.NewServer(si)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return si, &store.Credentials{AccessKeyID: "AKIAORIGINAL0000", SecretAccessKey: "original-secret"}
+}
+
+// keyIDs lists the IDs of the user's keys, sorted, with inactive ones
+// marked
+func (si *iamStandIn) keyIDs() []string {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	var ids []string
+	for id, key := range si.keys {
+		if !key.active {
+			id += " (inactive)"
+		}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+var credentialPattern = regexp.MustCompile(`Credential=([A-Z0-9]+)/`)
+
+func (si *iamStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := r.Form.Get("Action")
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	var caller *standInKey
+	if m := credentialPattern.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+		caller = si.keys[m[1]]
+	}
+	if caller == nil || !caller.active {
+		writeQueryError(w, http.StatusForbidden, "InvalidClientTokenId", "The security token included in the request is invalid.")
+		return
+	}
+
+	if code, ok := si.fail[action]; ok {
+		writeQueryError(w, http.StatusForbidden, code, "injected failure")
+		return
+	}
+
+	switch action {
+	case "GetCallerIdentity":
+		if !caller.original {
+			if si.onVerify != nil {
+				si.onVerify()
+			}
+			if si.unverified < 0 || caller.verified < si.unverified {
+				caller.verified++
+				writeQueryError(w, http.StatusForbidden, "InvalidClientTokenId", "The security token included in the request is invalid.")
+				return
+			}
+		}
+		fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult>`+
+			`<Arn>arn:aws:iam::123456789012:user/researcher</Arn><UserId>AIDAEXAMPLE</UserId><Account>123456789012</Account>`+
+			`</GetCallerIdentityResult></GetCallerIdentityResponse>`)
+	case "ListAccessKeys":
+		fmt.Fprint(w, `<ListAccessKeysResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/"><ListAccessKeysResult><AccessKeyMetadata>`)
+		for id, key := range si.keys {
+			status := "Active"
+			if !key.active {
+				status = "Inactive"
+			}
+			fmt.Fprintf(w, `<member><UserName>researcher</UserName><AccessKeyId>%s</AccessKeyId><Status>%s</Status></member>`, id, status)
+		}
+		fmt.Fprint(w, `</AccessKeyMetadata><IsTruncated>false</IsTruncated></ListAccessKeysResult></ListAccessKeysResponse>`)
+	case "CreateAccessKey":
+		if len(si.keys) >= 2 {
+			writeQueryError(w, http.StatusConflict, "LimitExceeded", "Cannot exceed quota for AccessKeysPerUser: 2")
+			return
+		}
+		si.next++
+		id := fmt.Sprintf("AKIAROTATED%05d", si.next)
+		si.keys[id] = &standInKey{secret: fmt.Sprintf("rotated-secret-%d", si.next), active: true}
+		fmt.Fprintf(w, `<CreateAccessKeyResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/"><CreateAccessKeyResult><AccessKey>`+
+			`<UserName>researcher</UserName><AccessKeyId>%s</AccessKeyId><Status>Active</Status><SecretAccessKey>%s</SecretAccessKey>`+
+			`</AccessKey></CreateAccessKeyResult></CreateAccessKeyResponse>`, id, si.keys[id].secret)
+	case "UpdateAccessKey", "DeleteAccessKey":
+		key, ok := si.keys[r.Form.Get("AccessKeyId")]
+		if !ok {
+			writeQueryError(w, http.StatusNotFound, "NoSuchEntity", "The Access Key cannot be found.")
+			return
+		}
+		if action == "DeleteAccessKey" {
+			delete(si.keys, r.Form.Get("AccessKeyId"))
+		} else {
+			key.active = r.Form.Get("Status") == "Active"
+		}
+		fmt.Fprintf(w, `<%sResponse xmlns="https://iam.amazonaws.com/doc/2010-05-08/"><ResponseMetadata><RequestId>1</RequestId></ResponseMetadata></%sResponse>`, action, action)
+	default:
+		writeQueryError(w, http.StatusBadRequest, "InvalidAction", "unsupported action "+action)
+	}
+}
+
+// writeQueryError writes an error in the AWS query protocol's format
+func writeQueryError(w http.ResponseWriter, status int, code, message string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<ErrorResponse><Error><Type>Sender</Type><Code>%s</Code><Message>%s</Message></Error><RequestId>1</RequestId></ErrorResponse>`, code, message)
+}
+
+// rotation records what a rotation saved and which steps it reported
+type rotation struct {
+	saved, restored []string
+	steps           []string
+}
+
+func (rec *rotation) options() RotateOptions {
+	return RotateOptions{
+		Save:          func(c store.Credentials) error { rec.saved = append(rec.saved, c.AccessKeyID); return nil },
+		Restore:       func(c store.Credentials) error { rec.restored = append(rec.restored, c.AccessKeyID); return nil },
+		OnStep:        func(s RotationStep) { rec.steps = append(rec.steps, s.Name+":"+s.Status) },
+		VerifyTimeout: 1500 * time.Millisecond,
+	}
+}
+
+func TestRotateAccessKey(t *testing.T) {
+	si, old := newIAMStandIn(t)
+	si.unverified = 1 // the new key takes a moment to propagate
+	var rec rotation
+
+	rotated, err := RotateAccessKey(context.Background(), old, rec.options())
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if rotated.AccessKeyID != "AKIAROTATED00001" || rotated.SecretAccessKey != "rotated-secret-1" || rotated.AccountID != "123456789012" {
+		t.Fatalf("rotated credentials = %+v", rotated)
+	}
+	if got := si.keyIDs(); len(got) != 1 || got[0] != "AKIAROTATED00001" {
+		t.Fatalf("keys after rotation = %v", got)
+	}
+	if len(rec.saved) != 1 || rec.saved[0] != "AKIAROTATED00001" || len(rec.restored) != 0 {
+		t.Fatalf("saved %v, restored %v", rec.saved, rec.restored)
+	}
+	want := []string{
+		RotationStepCreate + ":success",
+		RotationStepVerify + ":success",
+		RotationStepStore + ":success",
+		RotationStepDeactivate + ":success",
+		RotationStepDelete + ":success",
+	}
+	if fmt.Sprint(rec.steps) != fmt.Sprint(want) {
+		t.Fatalf("steps = %v, want %v", rec.steps, want)
+	}
+}
+
+func TestRotateAccessKeyRefusesSecondKey(t *testing.T) {
+	si, old := newIAMStandIn(t)
+	si.keys["AKIASPARE0000000"] = &standInKey{secret: "spare", active: false}
+
+	_, err := RotateAccessKey(context.Background(), old, RotateOptions{})
+	if err == nil {
+		t.Fatal("rotation succeeded with two keys")
+	}
+	if got := si.keyIDs(); len(got) != 2 {
+		t.Fatalf("keys = %v", got)
+	}
+}
+
+func TestRotateAccessKeyRollsBackUnverifiedKey(t *testing.T) {
+	si, old := newIAMStandIn(t)
+	si.unverified = -1
+	var rec rotation
+
+	if _, err := RotateAccessKey(context.Background(), old, rec.options()); err == nil {
+		t.Fatal("rotation succeeded with a key that never worked")
+	}
+	if got := si.keyIDs(); len(got) != 1 || got[0] != old.AccessKeyID {
+		t.Fatalf("keys after rollback = %v", got)
+	}
+	if len(rec.saved) != 0 {
+		t.Fatalf("saved %v before the key was verified", rec.saved)
+	}
+}
+
+func TestRotateAccessKeyRollsBackAfterCancel(t *testing.T) {
+	si, old := newIAMStandIn(t)
+	si.unverified = -1
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The client goes away while the new key is being verified
+	si.onVerify = cancel
+
+	_, err := RotateAccessKey(ctx, old, RotateOptions{VerifyTimeout: time.Minute})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("rotate: got %v, want context.Canceled", err)
+	}
+	if got := si.keyIDs(); len(got) != 1 || got[0] != old.AccessKeyID {
+		t.Fatalf("new key left behind after cancel: keys = %v", got)
+	}
+
+	// With the new key cleaned up, the next rotation is not refused
+	si.unverified, si.onVerify = 0, nil
+	if _, err := RotateAccessKey(context.Background(), old, RotateOptions{}); err != nil {
+		t.Fatalf("rotate again: %v", err)
+	}
+}
+
+func TestRotateAccessKeyRollsBackFailedDeactivation(t *testing.T) {
+	si, old := newIAMStandIn(t)
+	si.fail["UpdateAccessKey"] = "AccessDenied"
+	var rec rotation
+
+	if _, err := RotateAccessKey(context.Background(), old, rec.options()); err == nil {
+		t.Fatal("rotation succeeded although the old key was not deactivated")
+	}
+	if got := si.keyIDs(); len(got) != 1 || got[0] != old.AccessKeyID {
+		t.Fatalf("keys after rollback = %v", got)
+	}
+	if len(rec.restored) != 1 || rec.restored[0] != old.AccessKeyID {
+		t.Fatalf("restored %v, want the old key", rec.restored)
+	}
+}
+
+func TestRotateAccessKeyKeepsRotationWhenDeleteFails(t *testing.T) {
+	si, old := newIAMStandIn(t)
+	si.fail["DeleteAccessKey"] = "AccessDenied"
+
+	rotated, err := RotateAccessKey(context.Background(), old, RotateOptions{})
+	if err == nil || rotated == nil {
+		t.Fatalf("rotate = %v, %v; want the new key and an error", rotated, err)
+	}
+	want := fmt.Sprint([]string{old.AccessKeyID + " (inactive)", rotated.AccessKeyID})
+	if got := fmt.Sprint(si.keyIDs()); got != want {
+		t.Fatalf("keys = %s, want %s", got, want)
+	}
+}
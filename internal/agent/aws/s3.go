@@ -2,15 +2,19 @@ package aws
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
+	"github.com/scttfrdmn/ark/internal/agent/store"
 )
 
 // CreateBucketInput represents parameters for bucket creation
@@ -20,6 +24,12 @@ type CreateBucketInput struct {
 	EncryptionType    string // "AES256" or "aws:kms"
 	KMSKeyID          string // Optional, for aws:kms encryption
 	VersioningEnabled bool
+	Tags              map[string]string
+	Baseline          BucketBaseline
+
+	// Journal records the creation so that it can be rolled back if the
+	// agent stops part-way
+	Journal *SagaJournal `json:"-"`
 }
 
 // CreateBucketOutput represents the result of bucket creation
@@ -28,14 +38,33 @@ type CreateBucketOutput struct {
 	Region     string    `json:"region"`
 	Location   string    `json:"location"`
 	CreatedAt  time.Time `json:"created_at"`
+
+	// Steps lists every step of the creation, including any compensating
+	// actions
+	Steps []store.SagaStep `json:"steps"`
+
+	// RolledBack is set when a step failed and the bucket was deleted;
+	// BaselineIncomplete when it was kept and tagged instead
+	RolledBack         bool `json:"rolled_back,omitempty"`
+	BaselineIncomplete bool `json:"baseline_incomplete,omitempty"`
 }
 
-// CreateBucket creates an S3 bucket with specified configuration
+// ErrMissingTags is returned when a bucket lacks tags the baseline requires
+var ErrMissingTags = errors.New("missing required tags")
+
+// CreateBucket creates an S3 bucket and brings it up to the baseline,
+// including the requested encryption and versioning. It runs as a saga: if
+// a step fails, the bucket is deleted, or kept and tagged
+// ark:baseline=incomplete when the baseline does not roll back. The output
+// is returned alongside the error either way, listing the steps.
 func CreateBucket(ctx context.Context, client *Client, input CreateBucketInput) (*CreateBucketOutput, error) {
 	// Validate bucket name
 	if err := validateBucketName(input.BucketName); err != nil {
 		return nil, err
 	}
+	if missing := input.Baseline.MissingTags(input.Tags); len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingTags, strings.Join(missing, ", "))
+	}
 
 	// Use client's region if not specified
 	region := input.Region
@@ -43,49 +72,387 @@ func CreateBucket(ctx context.Context, client *Client, input CreateBucketInput)
 		region = client.Region
 	}
 
-	// Create bucket input
-	createInput := &s3.CreateBucketInput{
-		Bucket: aws.String(input.BucketName),
+	saga := &Saga{
+		Operation:    CreateBucketStep,
+		ResourceType: "s3:bucket",
+		ResourceID:   input.BucketName,
+		Region:       region,
+		Input:        input,
+		Steps:        createBucketSteps(client, input, region),
+		Journal:      input.Journal,
+	}
+	steps, err := saga.Run(ctx)
+
+	// Nothing to report if the bucket was never created
+	var sagaErr *SagaError
+	if !errors.As(err, &sagaErr) && err != nil {
+		return nil, err
+	}
+	if sagaErr != nil && (sagaErr.Step == CheckBucketStep || sagaErr.Step == CreateBucketStep) {
+		return nil, sagaErr.Err
+	}
+
+	// Build response
+	output := &CreateBucketOutput{
+		BucketName: input.BucketName,
+		Region:     region,
+		Location:   fmt.Sprintf("http://%s.s3.amazonaws.com/", input.BucketName),
+		CreatedAt:  time.Now().UTC(),
+		Steps:      steps,
+	}
+
+	switch {
+	case sagaErr == nil:
+		return output, nil
+	case input.Baseline.Rollback:
+		output.RolledBack = sagaErr.CompensationErr == nil
+		return output, sagaErr
+	case sagaErr.CompensationErr != nil:
+		output.BaselineIncomplete = true
+		return output, fmt.Errorf("%s: %w (bucket kept but not tagged %s=incomplete: %v)",
+			sagaErr.Step, sagaErr.Err, BaselineFlagTag, sagaErr.CompensationErr)
+	default:
+		output.BaselineIncomplete = true
+		return output, fmt.Errorf("%s: %w (bucket kept and tagged %s=incomplete)", sagaErr.Step, sagaErr.Err, BaselineFlagTag)
 	}
+}
+
+// createBucketSteps lists the steps of creating a bucket. Creating it is
+// compensated for by deleting it again, or by flagging it when the
+// baseline does not roll back.
+func createBucketSteps(client *Client, input CreateBucketInput, region string) []SagaStep {
+	bucket := aws.String(input.BucketName)
 
-	// For regions other than us-east-1, specify location constraint
-	if region != "us-east-1" {
-		createInput.CreateBucketConfiguration = &types.CreateBucketConfiguration{
-			LocationConstraint: types.BucketLocationConstraint(region),
+	// In us-east-1, creating a bucket the account already owns succeeds, and
+	// compensating for it would delete or flag someone's bucket. This step
+	// has nothing to undo, so a saga cut off while checking leaves the
+	// bucket alone too.
+	check := SagaStep{
+		Name: CheckBucketStep,
+		Do: func(ctx context.Context) error {
+			_, err := client.S3Client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: bucket})
+			switch status := httpStatus(err); {
+			case err == nil, status == http.StatusMovedPermanently:
+				return ErrBucketExists
+			case status == http.StatusNotFound, status == http.StatusForbidden:
+				// Missing, or someone else's, which creating it reports
+				return nil
+			default:
+				return translateS3Error("s3:ListBucket", err)
+			}
+		},
+	}
+
+	create := SagaStep{
+		Name: CreateBucketStep,
+		Do: func(ctx context.Context) error {
+			createInput := &s3.CreateBucketInput{Bucket: bucket}
+
+			// For regions other than us-east-1, specify location constraint
+			if region != "us-east-1" {
+				createInput.CreateBucketConfiguration = &types.CreateBucketConfiguration{
+					LocationConstraint: types.BucketLocationConstraint(region),
+				}
+			}
+			_, err := client.S3Client.CreateBucket(ctx, createInput)
+			return translateS3Error("s3:CreateBucket", err)
+		},
+	}
+
+	// A bucket that was never created needs neither
+	if input.Baseline.Rollback {
+		create.UndoName = BaselineStepRollback
+		create.Undo = func(ctx context.Context) error {
+			_, err := client.S3Client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: bucket})
+			if err = translateS3Error("s3:DeleteBucket", err); errors.Is(err, ErrNoSuchBucket) {
+				return nil
+			}
+			return err
+		}
+	} else {
+		create.UndoName = BaselineStepFlag
+		create.Undo = func(ctx context.Context) error {
+			tags := input.Baseline.bucketTags(input.Tags)
+			tags[BaselineFlagTag] = "incomplete"
+			err := translateS3Error("s3:PutBucketTagging", putBucketTags(ctx, client, input.BucketName, tags))
+			if errors.Is(err, ErrNoSuchBucket) {
+				return nil
+			}
+			return err
 		}
 	}
 
-	// Create the bucket
-	_, err := client.S3Client.CreateBucket(ctx, createInput)
+	return append([]SagaStep{check, create}, baselineSteps(client, input, region)...)
+}
+
+// createBucketSagaSteps rebuilds the steps of an interrupted bucket creation
+func createBucketSagaSteps(client *Client, data json.RawMessage, region string) ([]SagaStep, error) {
+	var input CreateBucketInput
+	if err := json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+	return createBucketSteps(client, input, region), nil
+}
+
+// BucketSummary is a bucket in a listing
+type BucketSummary struct {
+	Name      string    `json:"name"`
+	Region    string    `json:"region,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListBuckets lists the buckets owned by the client's account
+func ListBuckets(ctx context.Context, client *Client) ([]BucketSummary, error) {
+	buckets := []BucketSummary{}
+	paginator := s3.NewListBucketsPaginator(client.S3Client, &s3.ListBucketsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateS3Error("s3:ListAllMyBuckets", err)
+		}
+		for _, b := range page.Buckets {
+			buckets = append(buckets, BucketSummary{
+				Name:      aws.ToString(b.Name),
+				Region:    aws.ToString(b.BucketRegion),
+				CreatedAt: aws.ToTime(b.CreationDate),
+			})
+		}
+	}
+	return buckets, nil
+}
+
+// BucketEncryption is a bucket's default encryption
+type BucketEncryption struct {
+	Type             string `json:"type"` // AES256, aws:kms, aws:kms:dsse, or none
+	KMSKeyID         string `json:"kms_key_id,omitempty"`
+	BucketKeyEnabled bool   `json:"bucket_key_enabled,omitempty"`
+}
+
+// PublicAccessBlock is a bucket's public access block configuration
+type PublicAccessBlock struct {
+	BlockPublicACLs       bool `json:"block_public_acls"`
+	IgnorePublicACLs      bool `json:"ignore_public_acls"`
+	BlockPublicPolicy     bool `json:"block_public_policy"`
+	RestrictPublicBuckets bool `json:"restrict_public_buckets"`
+}
+
+// BucketDetails describes a bucket's configuration
+type BucketDetails struct {
+	Name       string           `json:"name"`
+	Region     string           `json:"region"`
+	Encryption BucketEncryption `json:"encryption"`
+	Versioning string           `json:"versioning"` // Enabled, Suspended, or Disabled
+
+	// PublicAccessBlock is nil when the bucket has no configuration of its
+	// own, leaving only account-level settings in force
+	PublicAccessBlock *PublicAccessBlock `json:"public_access_block"`
+
+	Tags   map[string]string `json:"tags"`
+	Policy string            `json:"policy,omitempty"`
+}
+
+// DescribeBucket reads a bucket's region, encryption, versioning, public
+// access block, tags and policy. Missing optional configuration is reported
+// as empty rather than as an error.
+func DescribeBucket(ctx context.Context, client *Client, bucket string) (*BucketDetails, error) {
+	const permission = "s3:GetBucket*"
+
+	region, err := BucketRegion(ctx, client, bucket)
 	if err != nil {
-		return nil, translateS3Error(err)
+		return nil, err
+	}
+	inRegion := func(o *s3.Options) { o.Region = region }
+
+	details := &BucketDetails{
+		Name:       bucket,
+		Region:     region,
+		Encryption: BucketEncryption{Type: "none"},
+		Versioning: "Disabled",
+		Tags:       map[string]string{},
 	}
 
-	// Configure encryption if requested
-	if input.EncryptionType != "" && input.EncryptionType != "none" {
-		if err := configureBucketEncryption(ctx, client, input.BucketName, input.EncryptionType, input.KMSKeyID); err != nil {
-			return nil, fmt.Errorf("configure encryption: %w", err)
+	enc, err := client.S3Client.GetBucketEncryption(ctx, &s3.GetBucketEncryptionInput{Bucket: aws.String(bucket)}, inRegion)
+	switch {
+	case err == nil:
+		if enc.ServerSideEncryptionConfiguration != nil && len(enc.ServerSideEncryptionConfiguration.Rules) > 0 {
+			rule := enc.ServerSideEncryptionConfiguration.Rules[0]
+			if def := rule.ApplyServerSideEncryptionByDefault; def != nil {
+				details.Encryption.Type = string(def.SSEAlgorithm)
+				details.Encryption.KMSKeyID = aws.ToString(def.KMSMasterKeyID)
+			}
+			details.Encryption.BucketKeyEnabled = aws.ToBool(rule.BucketKeyEnabled)
 		}
+	case !isS3ErrorCode(err, "ServerSideEncryptionConfigurationNotFoundError"):
+		return nil, fmt.Errorf("get encryption: %w", translateS3Error(permission, err))
 	}
 
-	// Enable versioning if requested
-	if input.VersioningEnabled {
-		if err := enableBucketVersioning(ctx, client, input.BucketName); err != nil {
-			return nil, fmt.Errorf("enable versioning: %w", err)
+	ver, err := client.S3Client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)}, inRegion)
+	if err != nil {
+		return nil, fmt.Errorf("get versioning: %w", translateS3Error(permission, err))
+	}
+	if ver.Status != "" {
+		details.Versioning = string(ver.Status)
+	}
+
+	pab, err := client.S3Client.GetPublicAccessBlock(ctx, &s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)}, inRegion)
+	switch {
+	case err == nil:
+		if c := pab.PublicAccessBlockConfiguration; c != nil {
+			details.PublicAccessBlock = &PublicAccessBlock{
+				BlockPublicACLs:       aws.ToBool(c.BlockPublicAcls),
+				IgnorePublicACLs:      aws.ToBool(c.IgnorePublicAcls),
+				BlockPublicPolicy:     aws.ToBool(c.BlockPublicPolicy),
+				RestrictPublicBuckets: aws.ToBool(c.RestrictPublicBuckets),
+			}
 		}
+	case !isS3ErrorCode(err, "NoSuchPublicAccessBlockConfiguration"):
+		return nil, fmt.Errorf("get public access block: %w", translateS3Error(permission, err))
 	}
 
-	// Build response
-	output := &CreateBucketOutput{
-		BucketName: input.BucketName,
-		Region:     region,
-		Location:   fmt.Sprintf("http://%s.s3.amazonaws.com/", input.BucketName),
-		CreatedAt:  time.Now().UTC(),
+	tags, err := client.S3Client.GetBucketTagging(ctx, &s3.GetBucketTaggingInput{Bucket: aws.String(bucket)}, inRegion)
+	switch {
+	case err == nil:
+		for _, tag := range tags.TagSet {
+			details.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	case !isS3ErrorCode(err, "NoSuchTagSet"):
+		return nil, fmt.Errorf("get tags: %w", translateS3Error(permission, err))
 	}
 
+	policy, err := client.S3Client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{Bucket: aws.String(bucket)}, inRegion)
+	switch {
+	case err == nil:
+		details.Policy = aws.ToString(policy.Policy)
+	case !isS3ErrorCode(err, "NoSuchBucketPolicy"):
+		return nil, fmt.Errorf("get policy: %w", translateS3Error(permission, err))
+	}
+
+	return details, nil
+}
+
+// DeleteBucketOutput is the result of deleting a bucket
+type DeleteBucketOutput struct {
+	BucketName string `json:"bucket_name"`
+	Region     string `json:"region"`
+
+	// ObjectsDeleted counts the object versions and delete markers removed
+	// when emptying the bucket first
+	ObjectsDeleted int `json:"objects_deleted"`
+}
+
+// DeleteBucket deletes a bucket. With force, every object version and delete
+// marker is deleted first; otherwise a bucket that is not empty fails with
+// ErrBucketNotEmpty.
+func DeleteBucket(ctx context.Context, client *Client, bucket string, force bool) (*DeleteBucketOutput, error) {
+	region, err := BucketRegion(ctx, client, bucket)
+	if err != nil {
+		return nil, err
+	}
+	inRegion := func(o *s3.Options) { o.Region = region }
+
+	output := &DeleteBucketOutput{BucketName: bucket, Region: region}
+
+	if force {
+		deleted, err := emptyBucket(ctx, client, bucket, inRegion)
+		output.ObjectsDeleted = deleted
+		if err != nil {
+			return output, fmt.Errorf("empty bucket: %w", err)
+		}
+	}
+
+	if _, err := client.S3Client.DeleteBucket(ctx, &s3.DeleteBucketInput{Bucket: aws.String(bucket)}, inRegion); err != nil {
+		return output, translateS3Error("s3:DeleteBucket", err)
+	}
 	return output, nil
 }
 
+// emptyBucket deletes every object version and delete marker in a bucket,
+// which also covers unversioned objects, and returns how many it deleted
+func emptyBucket(ctx context.Context, client *Client, bucket string, optFns ...func(*s3.Options)) (int, error) {
+	deleted := 0
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(bucket)}
+
+	for {
+		page, err := client.S3Client.ListObjectVersions(ctx, input, optFns...)
+		if err != nil {
+			return deleted, translateS3Error("s3:ListBucketVersions", err)
+		}
+
+		var objects []types.ObjectIdentifier
+		for _, v := range page.Versions {
+			objects = append(objects, types.ObjectIdentifier{Key: v.Key, VersionId: v.VersionId})
+		}
+		for _, m := range page.DeleteMarkers {
+			objects = append(objects, types.ObjectIdentifier{Key: m.Key, VersionId: m.VersionId})
+		}
+
+		// A page holds at most 1000 entries, the DeleteObjects limit
+		if len(objects) > 0 {
+			out, err := client.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+				Bucket: aws.String(bucket),
+				Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+			}, optFns...)
+			if err != nil {
+				return deleted, translateS3Error("s3:DeleteObjectVersion", err)
+			}
+			deleted += len(objects) - len(out.Errors)
+			if len(out.Errors) > 0 {
+				first := out.Errors[0]
+				return deleted, fmt.Errorf("%d objects could not be deleted, e.g. %s: %s",
+					len(out.Errors), aws.ToString(first.Key), aws.ToString(first.Message))
+			}
+		}
+
+		if !aws.ToBool(page.IsTruncated) {
+			return deleted, nil
+		}
+		input.KeyMarker = page.NextKeyMarker
+		input.VersionIdMarker = page.NextVersionIdMarker
+	}
+}
+
+// BucketRegion returns the region a bucket lives in
+func BucketRegion(ctx context.Context, client *Client, bucket string) (string, error) {
+	out, err := client.S3Client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		return "", translateS3Error("s3:GetBucketLocation", err)
+	}
+
+	// Buckets in us-east-1 have no location constraint, and the oldest
+	// buckets in eu-west-1 report the legacy "EU"
+	switch out.LocationConstraint {
+	case "":
+		return "us-east-1", nil
+	case types.BucketLocationConstraintEu:
+		return "eu-west-1", nil
+	default:
+		return string(out.LocationConstraint), nil
+	}
+}
+
+// httpStatus returns the HTTP status of a failed AWS request, or 0
+func httpStatus(err error) int {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+// isS3ErrorCode reports whether err is an S3 error with one of the codes
+func isS3ErrorCode(err error, codes ...string) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	for _, code := range codes {
+		if apiErr.ErrorCode() == code {
+			return true
+		}
+	}
+	return false
+}
+
 // configureBucketEncryption sets encryption configuration for a bucket
 func configureBucketEncryption(ctx context.Context, client *Client, bucket, encType, kmsKeyID string) error {
 	var rule types.ServerSideEncryptionRule
@@ -149,8 +516,32 @@ func validateBucketName(name string) error {
 	return nil
 }
 
-// translateS3Error translates AWS SDK errors to user-friendly messages
-func translateS3Error(err error) error {
+// ErrNoSuchBucket is returned when a bucket does not exist
+var ErrNoSuchBucket = errors.New("bucket does not exist")
+
+// ErrNoSuchKey is returned when an object does not exist
+var ErrNoSuchKey = errors.New("object does not exist")
+
+// ErrBucketNotEmpty is returned when deleting a bucket that still has objects
+var ErrBucketNotEmpty = errors.New("bucket is not empty")
+
+// ErrBucketExists is returned when creating a bucket that already exists
+// and is visible to the caller
+var ErrBucketExists = errors.New("bucket already exists")
+
+// s3Error is an S3 error without a friendlier message of its own. It keeps
+// the SDK error, so that callers can tell whether it is worth retrying.
+type s3Error struct {
+	message string
+	err     error
+}
+
+func (e *s3Error) Error() string { return e.message }
+func (e *s3Error) Unwrap() error { return e.err }
+
+// translateS3Error translates AWS SDK errors to user-friendly messages.
+// permission is the IAM action named when access is denied.
+func translateS3Error(permission string, err error) error {
 	if err == nil {
 		return nil
 	}
@@ -166,12 +557,18 @@ func translateS3Error(err error) error {
 			return fmt.Errorf("you already own a bucket with this name")
 		case "InvalidBucketName":
 			return fmt.Errorf("invalid bucket name (check naming rules: 3-63 chars, lowercase, no consecutive periods)")
+		case "NoSuchBucket":
+			return ErrNoSuchBucket
+		case "NoSuchKey":
+			return ErrNoSuchKey
+		case "BucketNotEmpty":
+			return ErrBucketNotEmpty
 		case "AccessDenied":
-			return fmt.Errorf("permission denied (check your AWS credentials have s3:CreateBucket permission)")
+			return fmt.Errorf("permission denied (check your AWS credentials have %s permission)", permission)
 		case "TooManyBuckets":
 			return fmt.Errorf("bucket limit reached (AWS allows 100 buckets per account by default)")
 		default:
-			return fmt.Errorf("AWS error (%s): %s", code, apiErr.ErrorMessage())
+			return &s3Error{message: fmt.Sprintf("AWS error (%s): %s", code, apiErr.ErrorMessage()), err: err}
 		}
 	}
 
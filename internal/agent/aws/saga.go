@@ -0,0 +1,247 @@
+package aws
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// SagaStep is one step of a saga, with the compensating action that undoes
+// it
+type SagaStep struct {
+	Name string
+	Do   func(ctx context.Context) error
+
+	// Undo compensates for Do and is recorded as UndoName. It also runs for
+	// a step interrupted by the agent stopping, so it must cope with Do not
+	// having taken effect. A step whose effects go with an earlier step's
+	// compensation (e.g. settings on a bucket that is deleted) needs none.
+	UndoName string
+	Undo     func(ctx context.Context) error
+}
+
+// SagaJournal records sagas in the agent store while they run
+type SagaJournal struct {
+	Store   *store.Store
+	Profile string
+}
+
+// Saga is an AWS operation made of several calls. Steps run in order; if
+// one fails, the compensating actions of the steps before it run in
+// reverse order. Operations that make more than one call should run as a
+// saga.
+type Saga struct {
+	Operation    string // e.g. s3:CreateBucket; must have a sagaBuilder
+	ResourceType string
+	ResourceID   string
+	Region       string
+
+	// Input is recorded with the saga's progress, so that its steps can be
+	// rebuilt to compensate for it after a restart
+	Input interface{}
+
+	Steps []SagaStep
+
+	// Journal records progress; with none, an interrupted saga is lost
+	Journal *SagaJournal
+}
+
+// SagaError is returned by a saga whose step failed
+type SagaError struct {
+	Step string
+	Err  error
+
+	// CompensationErr is set when a compensating action failed too
+	CompensationErr error
+}
+
+func (e *SagaError) Error() string {
+	if e.CompensationErr != nil {
+		return fmt.Sprintf("%s: %v (rollback incomplete: %v)", e.Step, e.Err, e.CompensationErr)
+	}
+	return fmt.Sprintf("%s: %v (rolled back)", e.Step, e.Err)
+}
+
+func (e *SagaError) Unwrap() error {
+	return e.Err
+}
+
+// sagaBuilder rebuilds a saga's steps from its recorded input
+type sagaBuilder func(client *Client, input json.RawMessage, region string) ([]SagaStep, error)
+
+// sagaBuilders maps operations to the builders used to recover them
+var sagaBuilders = map[string]sagaBuilder{
+	"s3:CreateBucket": createBucketSagaSteps,
+}
+
+// Run runs the saga and returns the outcome of every step and compensating
+// action. A failed step returns a *SagaError.
+func (s *Saga) Run(ctx context.Context) ([]store.SagaStep, error) {
+	input, err := json.Marshal(s.Input)
+	if err != nil {
+		return nil, fmt.Errorf("marshal saga input: %w", err)
+	}
+
+	r := &sagaRun{
+		id:      newSagaID(),
+		journal: s.Journal,
+		state: store.SagaState{
+			Operation:    s.Operation,
+			ResourceType: s.ResourceType,
+			ResourceID:   s.ResourceID,
+			Region:       s.Region,
+			Input:        input,
+			Steps:        []store.SagaStep{},
+			StartedAt:    time.Now(),
+		},
+	}
+	if s.Journal != nil {
+		r.state.Profile = s.Journal.Profile
+	}
+
+	// Nothing has happened yet, so a saga that cannot be recorded does not
+	// start
+	if err := r.save(); err != nil {
+		return nil, fmt.Errorf("record saga: %w", err)
+	}
+	defer r.finish()
+
+	for i, step := range s.Steps {
+		r.record(store.SagaStep{Name: step.Name, Status: store.StepRunning})
+		err := step.Do(ctx)
+		r.state.Steps[i] = outcome(step.Name, err, false)
+		r.saveOrWarn()
+
+		if err != nil {
+			return r.state.Steps, &SagaError{
+				Step:            step.Name,
+				Err:             err,
+				CompensationErr: r.compensate(ctx, s.Steps[:i]),
+			}
+		}
+	}
+	return r.state.Steps, nil
+}
+
+// RecoverSaga compensates for a saga that was interrupted by the agent
+// stopping. Steps that succeeded or were cut off part-way are compensated
+// for, except for compensating actions that already succeeded. The saga's
+// record is removed afterwards, whatever the outcome.
+func RecoverSaga(ctx context.Context, client *Client, st *store.Store, id string, state store.SagaState) ([]store.SagaStep, error) {
+	r := &sagaRun{id: id, journal: &SagaJournal{Store: st, Profile: state.Profile}, state: state}
+	defer r.finish()
+
+	build, ok := sagaBuilders[state.Operation]
+	if !ok {
+		return state.Steps, fmt.Errorf("cannot recover %s", state.Operation)
+	}
+	steps, err := build(client, state.Input, state.Region)
+	if err != nil {
+		return state.Steps, fmt.Errorf("rebuild %s: %w", state.Operation, err)
+	}
+
+	compensated := make(map[string]bool)
+	var undo []SagaStep
+	for i, recorded := range r.state.Steps {
+		if recorded.Compensation {
+			if recorded.Status == store.StepSucceeded {
+				compensated[recorded.Name] = true
+			}
+			continue
+		}
+		if recorded.Status == store.StepRunning {
+			r.state.Steps[i].Status = store.StepInterrupted
+		}
+		if i < len(steps) && recorded.Status != store.StepFailed {
+			undo = append(undo, steps[i])
+		}
+	}
+	for i := range undo {
+		if compensated[undo[i].UndoName] {
+			undo[i].Undo = nil
+		}
+	}
+
+	return r.state.Steps, r.compensate(ctx, undo)
+}
+
+// sagaRun is a saga in progress
+type sagaRun struct {
+	id      string
+	journal *SagaJournal
+	state   store.SagaState
+}
+
+// compensate runs the compensating actions of steps in reverse order. It
+// carries on if the caller has gone, and past failed actions, so as much
+// as possible is undone.
+func (r *sagaRun) compensate(ctx context.Context, steps []SagaStep) error {
+	ctx = context.WithoutCancel(ctx)
+
+	var errs []error
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		if step.Undo == nil {
+			continue
+		}
+		err := step.Undo(ctx)
+		r.record(outcome(step.UndoName, err, true))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", step.UndoName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// record adds the outcome of a step and saves the saga's progress
+func (r *sagaRun) record(step store.SagaStep) {
+	r.state.Steps = append(r.state.Steps, step)
+	r.saveOrWarn()
+}
+
+func (r *sagaRun) save() error {
+	if r.journal == nil {
+		return nil
+	}
+	return r.journal.Store.SetSaga(r.id, r.state)
+}
+
+func (r *sagaRun) saveOrWarn() {
+	if err := r.save(); err != nil {
+		slog.Warn("failed to record saga progress", "error", err, "operation", r.state.Operation, "saga", r.id)
+	}
+}
+
+// finish removes the record of a saga that has run its course
+func (r *sagaRun) finish() {
+	if r.journal == nil {
+		return
+	}
+	if err := r.journal.Store.DeleteSaga(r.id); err != nil {
+		slog.Warn("failed to remove saga record", "error", err, "operation", r.state.Operation, "saga", r.id)
+	}
+}
+
+// outcome describes the result of a step or compensating action
+func outcome(name string, err error, compensation bool) store.SagaStep {
+	step := store.SagaStep{Name: name, Status: store.StepSucceeded, Compensation: compensation}
+	if err != nil {
+		step.Status = store.StepFailed
+		step.Error = err.Error()
+	}
+	return step
+}
+
+// newSagaID returns a random saga ID
+func newSagaID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,215 @@
+package aws
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// SharedProfile is a profile read from the AWS shared config and
+// credentials files (~/.aws/config and ~/.aws/credentials)
+type SharedProfile struct {
+	Name            string `json:"name"`
+	AccessKeyID     string `json:"-"`
+	SecretAccessKey string `json:"-"`
+	SessionToken    string `json:"-"`
+	Region          string `json:"region,omitempty"`
+	RoleARN         string `json:"role_arn,omitempty"`
+	SourceProfile   string `json:"source_profile,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	MFASerial       string `json:"mfa_serial,omitempty"`
+	RoleSessionName string `json:"role_session_name,omitempty"`
+	SSOSession      string `json:"sso_session,omitempty"`
+	SSOStartURL     string `json:"sso_start_url,omitempty"`
+	SSORegion       string `json:"sso_region,omitempty"`
+	SSOAccountID    string `json:"sso_account_id,omitempty"`
+	SSORoleName     string `json:"sso_role_name,omitempty"`
+
+	CredentialProcess string `json:"credential_process,omitempty"`
+}
+
+// Usable reports whether the profile carries keys, a role, SSO settings or
+// a credential_process command
+func (p *SharedProfile) Usable() bool {
+	return (p.AccessKeyID != "" && p.SecretAccessKey != "") || p.RoleARN != "" ||
+		p.SSOStartURL != "" || p.CredentialProcess != ""
+}
+
+// Credentials converts the profile into an agent credential record
+func (p *SharedProfile) Credentials() store.Credentials {
+	return store.Credentials{
+		AccessKeyID:     p.AccessKeyID,
+		SecretAccessKey: p.SecretAccessKey,
+		SessionToken:    p.SessionToken,
+		Region:          p.Region,
+		RoleARN:         p.RoleARN,
+		SourceProfile:   p.SourceProfile,
+		ExternalID:      p.ExternalID,
+		MFASerial:       p.MFASerial,
+		RoleSessionName: p.RoleSessionName,
+		SSOSession:      p.SSOSession,
+		SSOStartURL:     p.SSOStartURL,
+		SSORegion:       p.SSORegion,
+		SSOAccountID:    p.SSOAccountID,
+		SSORoleName:     p.SSORoleName,
+
+		CredentialProcess: p.CredentialProcess,
+	}
+}
+
+// SharedConfigPaths returns the AWS shared config and credentials file
+// paths, honoring AWS_CONFIG_FILE and AWS_SHARED_CREDENTIALS_FILE
+func SharedConfigPaths() (configPath, credentialsPath string, err error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("get home directory: %w", err)
+	}
+
+	configPath = os.Getenv("AWS_CONFIG_FILE")
+	if configPath == "" {
+		configPath = filepath.Join(home, ".aws", "config")
+	}
+	credentialsPath = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsPath == "" {
+		credentialsPath = filepath.Join(home, ".aws", "credentials")
+	}
+	return configPath, credentialsPath, nil
+}
+
+// LoadSharedProfiles parses the AWS shared config and credentials files.
+// Missing files are treated as empty. Keys from the credentials file take
+// precedence over the same keys in the config file, as in the AWS CLI.
+func LoadSharedProfiles(configPath, credentialsPath string) (map[string]*SharedProfile, error) {
+	configSections, err := parseINIFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", configPath, err)
+	}
+	credentialSections, err := parseINIFile(credentialsPath)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", credentialsPath, err)
+	}
+
+	profiles := make(map[string]map[string]string)
+	ssoSessions := make(map[string]map[string]string)
+
+	for section, values := range configSections {
+		switch {
+		case section == "default":
+			profiles["default"] = values
+		case strings.HasPrefix(section, "profile "):
+			profiles[strings.TrimSpace(strings.TrimPrefix(section, "profile "))] = values
+		case strings.HasPrefix(section, "sso-session "):
+			ssoSessions[strings.TrimSpace(strings.TrimPrefix(section, "sso-session "))] = values
+		}
+	}
+
+	// The credentials file uses bare profile names
+	for name, values := range credentialSections {
+		merged, ok := profiles[name]
+		if !ok {
+			merged = make(map[string]string)
+			profiles[name] = merged
+		}
+		for k, v := range values {
+			merged[k] = v
+		}
+	}
+
+	result := make(map[string]*SharedProfile, len(profiles))
+	for name, values := range profiles {
+		p := &SharedProfile{
+			Name:            name,
+			AccessKeyID:     values["aws_access_key_id"],
+			SecretAccessKey: values["aws_secret_access_key"],
+			SessionToken:    values["aws_session_token"],
+			Region:          values["region"],
+			RoleARN:         values["role_arn"],
+			SourceProfile:   values["source_profile"],
+			ExternalID:      values["external_id"],
+			MFASerial:       values["mfa_serial"],
+			RoleSessionName: values["role_session_name"],
+			SSOSession:      values["sso_session"],
+			SSOStartURL:     values["sso_start_url"],
+			SSORegion:       values["sso_region"],
+			SSOAccountID:    values["sso_account_id"],
+			SSORoleName:     values["sso_role_name"],
+
+			CredentialProcess: values["credential_process"],
+		}
+
+		// Newer configs keep the start URL and region in an sso-session section
+		if session, ok := ssoSessions[p.SSOSession]; ok {
+			if p.SSOStartURL == "" {
+				p.SSOStartURL = session["sso_start_url"]
+			}
+			if p.SSORegion == "" {
+				p.SSORegion = session["sso_region"]
+			}
+		}
+
+		result[name] = p
+	}
+
+	return result, nil
+}
+
+// parseINIFile reads an AWS-style INI file into section -> key -> value.
+// Indented lines (nested sub-settings such as "s3 =") are skipped.
+func parseINIFile(path string) (map[string]map[string]string, error) {
+	sections := make(map[string]map[string]string)
+
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sections, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var current map[string]string
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		raw := scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: malformed section header", lineNum)
+			}
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			current = make(map[string]string)
+			sections[name] = current
+			continue
+		}
+
+		// Sub-settings are indented under a parent key
+		if raw[0] == ' ' || raw[0] == '\t' {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNum)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: setting outside of a section", lineNum)
+		}
+		current[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
@@ -0,0 +1,143 @@
+package aws
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSharedConfig = `# AWS CLI config
+[default]
+region = us-east-1
+s3 =
+    max_concurrent_requests = 20
+    region = ignored
+
+[profile lab]
+Role_ARN = arn:aws:iam::123456789012:role/lab
+source_profile = default
+mfa_serial = arn:aws:iam::123456789012:mfa/researcher
+region = us-west-2
+; overridden by the credentials file
+external_id = from-config
+
+[profile sso]
+sso_session = corp
+sso_account_id = 123456789012
+sso_role_name = Researcher
+
+[profile sso-legacy]
+sso_start_url = https://legacy.awsapps.com/start
+sso_region = eu-west-1
+sso_session = corp
+
+[sso-session corp]
+sso_start_url = https://corp.awsapps.com/start
+sso_region = us-east-2
+
+[profile tool]
+credential_process = /usr/local/bin/creds --profile "tool = x"
+
+[services local]
+region = us-east-1
+`
+
+const testSharedCredentials = `[default]
+aws_access_key_id = AKIADEFAULT
+aws_secret_access_key = secret/with=equals
+
+[lab]
+external_id = from-credentials
+
+[keys-only]
+aws_access_key_id = AKIAKEYSONLY
+aws_secret_access_key = secret
+aws_session_token = token
+`
+
+// writeSharedFiles writes the AWS config and credentials files to a
+// temporary directory
+func writeSharedFiles(t *testing.T, config, credentials string) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath, credentialsPath := filepath.Join(dir, "config"), filepath.Join(dir, "credentials")
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(credentialsPath, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return configPath, credentialsPath
+}
+
+func TestLoadSharedProfiles(t *testing.T) {
+	profiles, err := LoadSharedProfiles(writeSharedFiles(t, testSharedConfig, testSharedCredentials))
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	want := map[string]SharedProfile{
+		// Indented sub-settings do not override the profile's own keys
+		"default": {Name: "default", Region: "us-east-1", AccessKeyID: "AKIADEFAULT", SecretAccessKey: "secret/with=equals"},
+		// Keys are case-insensitive; the credentials file wins
+		"lab": {Name: "lab", Region: "us-west-2", RoleARN: "arn:aws:iam::123456789012:role/lab", SourceProfile: "default",
+			MFASerial: "arn:aws:iam::123456789012:mfa/researcher", ExternalID: "from-credentials"},
+		// The start URL and region come from the sso-session section...
+		"sso": {Name: "sso", SSOSession: "corp", SSOStartURL: "https://corp.awsapps.com/start", SSORegion: "us-east-2",
+			SSOAccountID: "123456789012", SSORoleName: "Researcher"},
+		// ...unless the profile has its own
+		"sso-legacy": {Name: "sso-legacy", SSOSession: "corp", SSOStartURL: "https://legacy.awsapps.com/start", SSORegion: "eu-west-1"},
+		"tool":       {Name: "tool", CredentialProcess: `/usr/local/bin/creds --profile "tool = x"`},
+		"keys-only":  {Name: "keys-only", AccessKeyID: "AKIAKEYSONLY", SecretAccessKey: "secret", SessionToken: "token"},
+	}
+	if len(profiles) != len(want) {
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		t.Fatalf("profiles %v, want %d", names, len(want))
+	}
+	for name, w := range want {
+		got, ok := profiles[name]
+		if !ok {
+			t.Errorf("profile %s missing", name)
+			continue
+		}
+		if *got != w {
+			t.Errorf("%s = %+v\nwant %+v", name, *got, w)
+		}
+		if !got.Usable() {
+			t.Errorf("%s is not usable", name)
+		}
+	}
+}
+
+func TestLoadSharedProfilesWithoutFiles(t *testing.T) {
+	dir := t.TempDir()
+	profiles, err := LoadSharedProfiles(filepath.Join(dir, "config"), filepath.Join(dir, "credentials"))
+	if err != nil || len(profiles) != 0 {
+		t.Fatalf("load missing files = %v, %v", profiles, err)
+	}
+}
+
+func TestParseINIFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unclosed section", "[default\nregion = us-east-1\n", "line 1: malformed section header"},
+		{"no value", "[default]\nregion\n", "line 2: expected key = value"},
+		{"outside a section", "# comment\nregion = us-east-1\n", "line 2: setting outside of a section"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath, credentialsPath := writeSharedFiles(t, tt.content, "")
+			_, err := LoadSharedProfiles(configPath, credentialsPath)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), configPath) {
+				t.Fatalf("load: got %v, want %q in %s", err, tt.wantErr, configPath)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sso"
+	"github.com/aws/aws-sdk-go-v2/service/ssooidc"
+	oidctypes "github.com/aws/aws-sdk-go-v2/service/ssooidc/types"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// deviceCodeGrant is the OAuth grant type for the device authorization flow
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Device authorization poll outcomes
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too fast")
+	ErrDeviceCodeExpired    = errors.New("device code expired; start the login again")
+	ErrAuthorizationDenied  = errors.New("authorization was denied")
+)
+
+// SSOClient talks to the IAM Identity Center OIDC and portal services. The
+// calls are unsigned, so no AWS credentials are needed.
+type SSOClient struct {
+	OIDC   *ssooidc.Client
+	Portal *sso.Client
+	Region string
+}
+
+// NewSSOClient creates an Identity Center client for the SSO region.
+// AWS_ENDPOINT_URL redirects both services to a local stand-in.
+func NewSSOClient(region string) *SSOClient {
+	cfg := aws.Config{Region: region}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		cfg.BaseEndpoint = aws.String(endpoint)
+	}
+
+	return &SSOClient{
+		OIDC:   ssooidc.NewFromConfig(cfg),
+		Portal: sso.NewFromConfig(cfg),
+		Region: region,
+	}
+}
+
+// SSORegistration is a registered OIDC client, reusable until it expires
+type SSORegistration struct {
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// DeviceAuthorization is a pending device-code login
+type DeviceAuthorization struct {
+	DeviceCode              string    `json:"-"`
+	UserCode                string    `json:"user_code"`
+	VerificationURI         string    `json:"verification_uri"`
+	VerificationURIComplete string    `json:"verification_uri_complete"`
+	Interval                int       `json:"interval"`
+	ExpiresAt               time.Time `json:"expires_at"`
+}
+
+// SSOToken is an Identity Center access token
+type SSOToken struct {
+	AccessToken string    `json:"access_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// SSORole is an account and role the signed-in user can reach
+type SSORole struct {
+	AccountID    string `json:"account_id"`
+	AccountName  string `json:"account_name"`
+	EmailAddress string `json:"email_address,omitempty"`
+	RoleName     string `json:"role_name"`
+}
+
+// RegisterClient registers Ark as a public OIDC client
+func (c *SSOClient) RegisterClient(ctx context.Context) (*SSORegistration, error) {
+	out, err := c.OIDC.RegisterClient(ctx, &ssooidc.RegisterClientInput{
+		ClientName: aws.String("ark"),
+		ClientType: aws.String("public"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("register OIDC client: %w", err)
+	}
+
+	return &SSORegistration{
+		ClientID:     aws.ToString(out.ClientId),
+		ClientSecret: aws.ToString(out.ClientSecret),
+		ExpiresAt:    time.Unix(out.ClientSecretExpiresAt, 0),
+	}, nil
+}
+
+// StartDeviceAuthorization begins a device-code login for a start URL
+func (c *SSOClient) StartDeviceAuthorization(ctx context.Context, reg *SSORegistration, startURL string) (*DeviceAuthorization, error) {
+	out, err := c.OIDC.StartDeviceAuthorization(ctx, &ssooidc.StartDeviceAuthorizationInput{
+		ClientId:     aws.String(reg.ClientID),
+		ClientSecret: aws.String(reg.ClientSecret),
+		StartUrl:     aws.String(startURL),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start device authorization: %w", err)
+	}
+
+	interval := int(out.Interval)
+	if interval <= 0 {
+		interval = 5
+	}
+
+	return &DeviceAuthorization{
+		DeviceCode:              aws.ToString(out.DeviceCode),
+		UserCode:                aws.ToString(out.UserCode),
+		VerificationURI:         aws.ToString(out.VerificationUri),
+		VerificationURIComplete: aws.ToString(out.VerificationUriComplete),
+		Interval:                interval,
+		ExpiresAt:               time.Now().Add(time.Duration(out.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// CreateToken makes one attempt to exchange an approved device code for an
+// access token. Until the user approves the login it returns
+// ErrAuthorizationPending (or ErrSlowDown if polled too often).
+func (c *SSOClient) CreateToken(ctx context.Context, reg *SSORegistration, auth *DeviceAuthorization) (*SSOToken, error) {
+	out, err := c.OIDC.CreateToken(ctx, &ssooidc.CreateTokenInput{
+		ClientId:     aws.String(reg.ClientID),
+		ClientSecret: aws.String(reg.ClientSecret),
+		GrantType:    aws.String(deviceCodeGrant),
+		DeviceCode:   aws.String(auth.DeviceCode),
+	})
+	if err != nil {
+		var pending *oidctypes.AuthorizationPendingException
+		var slowDown *oidctypes.SlowDownException
+		var expired *oidctypes.ExpiredTokenException
+		var denied *oidctypes.AccessDeniedException
+		switch {
+		case errors.As(err, &pending):
+			return nil, ErrAuthorizationPending
+		case errors.As(err, &slowDown):
+			return nil, ErrSlowDown
+		case errors.As(err, &expired):
+			return nil, ErrDeviceCodeExpired
+		case errors.As(err, &denied):
+			return nil, ErrAuthorizationDenied
+		}
+		return nil, fmt.Errorf("create token: %w", err)
+	}
+
+	return &SSOToken{
+		AccessToken: aws.ToString(out.AccessToken),
+		ExpiresAt:   time.Now().Add(time.Duration(out.ExpiresIn) * time.Second),
+	}, nil
+}
+
+// ListRoles lists every account and role the token can reach
+func (c *SSOClient) ListRoles(ctx context.Context, token *SSOToken) ([]SSORole, error) {
+	var roles []SSORole
+
+	accounts := sso.NewListAccountsPaginator(c.Portal, &sso.ListAccountsInput{
+		AccessToken: aws.String(token.AccessToken),
+	})
+	for accounts.HasMorePages() {
+		page, err := accounts.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list accounts: %w", err)
+		}
+
+		for _, account := range page.AccountList {
+			accountRoles := sso.NewListAccountRolesPaginator(c.Portal, &sso.ListAccountRolesInput{
+				AccessToken: aws.String(token.AccessToken),
+				AccountId:   account.AccountId,
+			})
+			for accountRoles.HasMorePages() {
+				rolePage, err := accountRoles.NextPage(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("list roles for account %s: %w", aws.ToString(account.AccountId), err)
+				}
+				for _, role := range rolePage.RoleList {
+					roles = append(roles, SSORole{
+						AccountID:    aws.ToString(account.AccountId),
+						AccountName:  aws.ToString(account.AccountName),
+						EmailAddress: aws.ToString(account.EmailAddress),
+						RoleName:     aws.ToString(role.RoleName),
+					})
+				}
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// GetRoleCredentials exchanges the token for temporary role credentials
+func (c *SSOClient) GetRoleCredentials(ctx context.Context, token *SSOToken, accountID, roleName string) (*store.Credentials, error) {
+	out, err := c.Portal.GetRoleCredentials(ctx, &sso.GetRoleCredentialsInput{
+		AccessToken: aws.String(token.AccessToken),
+		AccountId:   aws.String(accountID),
+		RoleName:    aws.String(roleName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get role credentials for %s/%s: %w", accountID, roleName, err)
+	}
+
+	rc := out.RoleCredentials
+	return &store.Credentials{
+		AccessKeyID:     aws.ToString(rc.AccessKeyId),
+		SecretAccessKey: aws.ToString(rc.SecretAccessKey),
+		SessionToken:    aws.ToString(rc.SessionToken),
+		Expiration:      time.UnixMilli(rc.Expiration),
+	}, nil
+}
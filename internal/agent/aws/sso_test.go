@@ -0,0 +1,234 @@
+package aws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ssoStandIn serves the Identity Center OIDC and portal calls of a
+// device-code login
+type ssoStandIn struct {
+	mu sync.Mutex
+
+	// outcomes are the errors successive token requests return before the
+	// login is approved, e.g. AuthorizationPendingException
+	outcomes []string
+	polls    int
+}
+
+const ssoAccessToken = "sso-access-token"
+
+func newSSOStandIn(t *testing.T, outcomes ...string) *SSOClient {
+	t.Helper()
+	si := &ssoStandIn{outcomes: outcomes}
+	srv := httptest.NewServer(si)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	return NewSSOClient("us-west-2")
+}
+
+func (si *ssoStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	// Portal calls authenticate with the access token
+	if r.Method == http.MethodGet && r.Header.Get("x-amz-sso_bearer_token") != ssoAccessToken {
+		writeRestJSONError(w, http.StatusUnauthorized, "UnauthorizedException", "invalid token")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/client/register":
+		writeJSONBody(w, map[string]interface{}{
+			"clientId":              "client-id",
+			"clientSecret":          "client-secret",
+			"clientSecretExpiresAt": time.Now().Add(90 * 24 * time.Hour).Unix(),
+		})
+	case "/device_authorization":
+		if body["clientId"] != "client-id" || body["startUrl"] != "https://example.awsapps.com/start" {
+			writeRestJSONError(w, http.StatusBadRequest, "InvalidRequestException", "bad request")
+			return
+		}
+		writeJSONBody(w, map[string]interface{}{
+			"deviceCode":              "device-code",
+			"userCode":                "ABCD-EFGH",
+			"verificationUri":         "https://device.sso.us-west-2.amazonaws.com/",
+			"verificationUriComplete": "https://device.sso.us-west-2.amazonaws.com/?user_code=ABCD-EFGH",
+			"expiresIn":               600,
+			"interval":                1,
+		})
+	case "/token":
+		if body["deviceCode"] != "device-code" || body["grantType"] != deviceCodeGrant {
+			writeRestJSONError(w, http.StatusBadRequest, "InvalidGrantException", "unknown device code")
+			return
+		}
+		if si.polls < len(si.outcomes) {
+			outcome := si.outcomes[si.polls]
+			si.polls++
+			writeRestJSONError(w, http.StatusBadRequest, outcome, outcome)
+			return
+		}
+		writeJSONBody(w, map[string]interface{}{
+			"accessToken": ssoAccessToken,
+			"tokenType":   "Bearer",
+			"expiresIn":   28800,
+		})
+	case "/assignment/accounts":
+		// Two pages, to exercise pagination
+		if r.URL.Query().Get("next_token") == "" {
+			writeJSONBody(w, map[string]interface{}{
+				"accountList": []map[string]string{{"accountId": "111111111111", "accountName": "research", "emailAddress": "research@example.org"}},
+				"nextToken":   "page-2",
+			})
+			return
+		}
+		writeJSONBody(w, map[string]interface{}{
+			"accountList": []map[string]string{{"accountId": "222222222222", "accountName": "teaching"}},
+		})
+	case "/assignment/roles":
+		roles := map[string][]map[string]string{
+			"111111111111": {{"roleName": "ResearcherAccess", "accountId": "111111111111"}, {"roleName": "ReadOnly", "accountId": "111111111111"}},
+			"222222222222": {{"roleName": "ReadOnly", "accountId": "222222222222"}},
+		}
+		writeJSONBody(w, map[string]interface{}{"roleList": roles[r.URL.Query().Get("account_id")]})
+	case "/federation/credentials":
+		q := r.URL.Query()
+		if q.Get("account_id") != "111111111111" || q.Get("role_name") != "ResearcherAccess" {
+			writeRestJSONError(w, http.StatusForbidden, "ForbiddenException", "no access")
+			return
+		}
+		writeJSONBody(w, map[string]interface{}{
+			"roleCredentials": map[string]interface{}{
+				"accessKeyId":     "ASIASSOEXAMPLE",
+				"secretAccessKey": "sso-secret",
+				"sessionToken":    "sso-session",
+				"expiration":      time.Now().Add(time.Hour).UnixMilli(),
+			},
+		})
+	default:
+		writeRestJSONError(w, http.StatusNotFound, "ResourceNotFoundException", r.URL.Path)
+	}
+}
+
+func writeJSONBody(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeRestJSONError writes an error in the AWS REST-JSON protocol's format
+func writeRestJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Amzn-ErrorType", code)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code, "error_description": message})
+}
+
+// startLogin registers a client and starts a device authorization
+func startLogin(t *testing.T, c *SSOClient) (*SSORegistration, *DeviceAuthorization) {
+	t.Helper()
+	ctx := context.Background()
+	reg, err := c.RegisterClient(ctx)
+	if err != nil {
+		t.Fatalf("register client: %v", err)
+	}
+	if reg.ClientID != "client-id" || reg.ExpiresAt.Before(time.Now()) {
+		t.Fatalf("registration = %+v", reg)
+	}
+	auth, err := c.StartDeviceAuthorization(ctx, reg, "https://example.awsapps.com/start")
+	if err != nil {
+		t.Fatalf("start device authorization: %v", err)
+	}
+	if auth.UserCode != "ABCD-EFGH" || auth.Interval != 1 || time.Until(auth.ExpiresAt) < 9*time.Minute {
+		t.Fatalf("device authorization = %+v", auth)
+	}
+	return reg, auth
+}
+
+func TestSSODeviceFlowOutcomes(t *testing.T) {
+	tests := []struct {
+		outcome string
+		want    error
+	}{
+		{"AuthorizationPendingException", ErrAuthorizationPending},
+		{"SlowDownException", ErrSlowDown},
+		{"ExpiredTokenException", ErrDeviceCodeExpired},
+		{"AccessDeniedException", ErrAuthorizationDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.outcome, func(t *testing.T) {
+			c := newSSOStandIn(t, tt.outcome)
+			reg, auth := startLogin(t, c)
+
+			token, err := c.CreateToken(context.Background(), reg, auth)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("create token = %v, %v; want %v", token, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSODeviceFlowLogin(t *testing.T) {
+	c := newSSOStandIn(t, "AuthorizationPendingException", "SlowDownException", "AuthorizationPendingException")
+	reg, auth := startLogin(t, c)
+	ctx := context.Background()
+
+	// Poll as the agent does until the user approves
+	var token *SSOToken
+	for attempt := 0; token == nil; attempt++ {
+		if attempt > 5 {
+			t.Fatal("login never completed")
+		}
+		var err error
+		token, err = c.CreateToken(ctx, reg, auth)
+		if err != nil && !errors.Is(err, ErrAuthorizationPending) && !errors.Is(err, ErrSlowDown) {
+			t.Fatalf("create token: %v", err)
+		}
+	}
+	if token.AccessToken != ssoAccessToken || time.Until(token.ExpiresAt) < 7*time.Hour {
+		t.Fatalf("token = %+v", token)
+	}
+
+	roles, err := c.ListRoles(ctx, token)
+	if err != nil {
+		t.Fatalf("list roles: %v", err)
+	}
+	want := []SSORole{
+		{AccountID: "111111111111", AccountName: "research", EmailAddress: "research@example.org", RoleName: "ResearcherAccess"},
+		{AccountID: "111111111111", AccountName: "research", EmailAddress: "research@example.org", RoleName: "ReadOnly"},
+		{AccountID: "222222222222", AccountName: "teaching", RoleName: "ReadOnly"},
+	}
+	if len(roles) != len(want) {
+		t.Fatalf("roles = %+v, want %+v", roles, want)
+	}
+	for i := range want {
+		if roles[i] != want[i] {
+			t.Errorf("role %d = %+v, want %+v", i, roles[i], want[i])
+		}
+	}
+
+	creds, err := c.GetRoleCredentials(ctx, token, "111111111111", "ResearcherAccess")
+	if err != nil {
+		t.Fatalf("get role credentials: %v", err)
+	}
+	if creds.AccessKeyID != "ASIASSOEXAMPLE" || creds.SessionToken != "sso-session" || time.Until(creds.Expiration) < 50*time.Minute {
+		t.Fatalf("credentials = %+v", creds)
+	}
+
+	if _, err := c.GetRoleCredentials(ctx, token, "222222222222", "ResearcherAccess"); err == nil {
+		t.Fatal("got credentials for a role the user cannot reach")
+	}
+	if _, err := c.ListRoles(ctx, &SSOToken{AccessToken: "stale"}); err == nil {
+		t.Fatal("listed roles with an invalid token")
+	}
+}
@@ -0,0 +1,116 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/smithy-go"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// AssumeRoleInput represents parameters for an STS AssumeRole call
+type AssumeRoleInput struct {
+	RoleARN         string
+	RoleSessionName string
+	ExternalID      string
+	MFASerial       string
+	MFACode         string
+}
+
+// AssumeRole obtains temporary credentials for a role
+func AssumeRole(ctx context.Context, client *Client, input AssumeRoleInput) (*store.Credentials, error) {
+	stsInput := &sts.AssumeRoleInput{
+		RoleArn:         aws.String(input.RoleARN),
+		RoleSessionName: aws.String(input.RoleSessionName),
+	}
+	if input.ExternalID != "" {
+		stsInput.ExternalId = aws.String(input.ExternalID)
+	}
+	if input.MFASerial != "" {
+		stsInput.SerialNumber = aws.String(input.MFASerial)
+		stsInput.TokenCode = aws.String(input.MFACode)
+	}
+
+	out, err := client.STSClient.AssumeRole(ctx, stsInput)
+	if err != nil {
+		return nil, translateSTSError(err)
+	}
+
+	return &store.Credentials{
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Expiration:      aws.ToTime(out.Credentials.Expiration),
+		Region:          client.Region,
+	}, nil
+}
+
+// GetSessionToken obtains MFA-authenticated temporary credentials for the
+// client's long-term keys
+func GetSessionToken(ctx context.Context, client *Client, mfaSerial, mfaCode string) (*store.Credentials, error) {
+	out, err := client.STSClient.GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		SerialNumber: aws.String(mfaSerial),
+		TokenCode:    aws.String(mfaCode),
+	})
+	if err != nil {
+		return nil, translateSTSError(err)
+	}
+
+	return &store.Credentials{
+		AccessKeyID:     aws.ToString(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(out.Credentials.SessionToken),
+		Expiration:      aws.ToTime(out.Credentials.Expiration),
+		Region:          client.Region,
+	}, nil
+}
+
+// sessionName returns a default role session name for the current user
+func sessionName(user string) string {
+	name := fmt.Sprintf("ark-%s-%d", user, time.Now().Unix())
+	// Role session names are limited to 64 characters
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// ErrInvalidMFACode is returned when STS rejects an MFA one-time code
+var ErrInvalidMFACode = errors.New("MFA code was rejected (codes can only be used once and expire after 30 seconds)")
+
+// translateSTSError translates STS errors to user-friendly messages
+func translateSTSError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+
+		switch code {
+		case "InvalidClientTokenId":
+			return fmt.Errorf("access key ID is not valid (check for typos or a deleted key)")
+		case "SignatureDoesNotMatch":
+			return fmt.Errorf("secret access key does not match the access key ID")
+		case "ExpiredToken", "ExpiredTokenException":
+			return fmt.Errorf("session token has expired (obtain new temporary credentials)")
+		case "AccessDenied":
+			if strings.Contains(apiErr.ErrorMessage(), "MultiFactorAuthentication") {
+				return ErrInvalidMFACode
+			}
+			return fmt.Errorf("permission denied: %s", apiErr.ErrorMessage())
+		case "RegionDisabledException":
+			return fmt.Errorf("STS is not enabled in this region for your account")
+		default:
+			return fmt.Errorf("AWS error (%s): %s", code, apiErr.ErrorMessage())
+		}
+	}
+
+	return fmt.Errorf("STS operation failed: %w", err)
+}
@@ -0,0 +1,546 @@
+package aws
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// Sync actions
+const (
+	SyncUpload   = "upload"
+	SyncDownload = "download"
+	SyncDelete   = "delete"
+)
+
+// SyncInput describes a sync between a local directory and an S3 prefix
+type SyncInput struct {
+	Direction string // store.TransferUpload (local to S3) or store.TransferDownload
+	LocalDir  string
+	Bucket    string
+	Prefix    string
+
+	// Include and Exclude filter the paths synced, relative to LocalDir
+	// and Prefix. See SyncFilter.
+	Include []string
+	Exclude []string
+
+	// Delete removes files at the destination that are not at the source
+	Delete bool
+
+	// DryRun only works out the plan
+	DryRun bool
+
+	PartSize int64
+	Workers  int
+
+	// Store, if set, keeps the state of large files' transfers under
+	// Profile so they resume if the sync is interrupted
+	Store   *store.Store
+	Profile string
+
+	// Progress is called with the bytes copied so far and the number of
+	// actions finished
+	Progress func(done, total int64, actionsDone, actions int)
+}
+
+// SyncAction is one change a sync makes
+type SyncAction struct {
+	Action string `json:"action"` // upload, download, or delete
+	Path   string `json:"path"`   // relative to the local directory and prefix
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"` // new, size, modified, or extraneous
+}
+
+// SyncFailure is an action that failed
+type SyncFailure struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Error  string `json:"error"`
+}
+
+// SyncOutput is the plan and outcome of a sync
+type SyncOutput struct {
+	Direction  string        `json:"direction"`
+	LocalDir   string        `json:"local_dir"`
+	Bucket     string        `json:"bucket"`
+	Prefix     string        `json:"prefix"`
+	DryRun     bool          `json:"dry_run"`
+	Plan       []SyncAction  `json:"plan"`
+	Unchanged  int           `json:"unchanged"`
+	Uploaded   int           `json:"uploaded"`
+	Downloaded int           `json:"downloaded"`
+	Deleted    int           `json:"deleted"`
+	Bytes      int64         `json:"bytes"`
+	Failures   []SyncFailure `json:"failures,omitempty"`
+}
+
+// syncEntry is a file on either side of a sync
+type syncEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string // objects only
+}
+
+// Sync makes the destination of a sync match its source. Files are copied
+// when they are missing at the destination, differ in size, or have a newer
+// modification time at the source and a different ETag. Failed actions are
+// collected in the output and reported together in the error, rather than
+// stopping the sync.
+func Sync(ctx context.Context, client *Client, input SyncInput) (*SyncOutput, error) {
+	filter, err := NewSyncFilter(input.Include, input.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := input.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	output := &SyncOutput{
+		Direction: input.Direction,
+		LocalDir:  input.LocalDir,
+		Bucket:    input.Bucket,
+		Prefix:    prefix,
+		DryRun:    input.DryRun,
+		Plan:      []SyncAction{},
+	}
+
+	local, err := listLocalFiles(input.LocalDir, filter, input.Direction == store.TransferUpload)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := listObjects(ctx, client, input.Bucket, prefix, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	source, dest, copyAction := local, remote, SyncUpload
+	if input.Direction == store.TransferDownload {
+		source, dest, copyAction = remote, local, SyncDownload
+	}
+
+	for _, rel := range sortedKeys(source) {
+		src := source[rel]
+		reason, err := syncReason(input, rel, src, dest[rel])
+		if err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			output.Unchanged++
+			continue
+		}
+		output.Plan = append(output.Plan, SyncAction{Action: copyAction, Path: rel, Size: src.size, Reason: reason})
+	}
+	if input.Delete {
+		for _, rel := range sortedKeys(dest) {
+			if _, ok := source[rel]; !ok {
+				output.Plan = append(output.Plan, SyncAction{Action: SyncDelete, Path: rel, Size: dest[rel].size, Reason: "extraneous"})
+			}
+		}
+	}
+
+	if input.DryRun || len(output.Plan) == 0 {
+		return output, nil
+	}
+
+	if err := runSync(ctx, client, input, prefix, output); err != nil {
+		return output, err
+	}
+	if len(output.Failures) > 0 {
+		return output, fmt.Errorf("%d of %d changes failed", len(output.Failures), len(output.Plan))
+	}
+	return output, nil
+}
+
+// syncReason says why src should be copied over dst, or returns "" if dst is
+// up to date
+func syncReason(input SyncInput, rel string, src, dst *syncEntry) (string, error) {
+	switch {
+	case dst == nil:
+		return "new", nil
+	case src.size != dst.size:
+		return "size", nil
+	}
+
+	// S3 keeps modification times to the second
+	srcTime, dstTime := src.modTime.Truncate(time.Second), dst.modTime.Truncate(time.Second)
+	if !srcTime.After(dstTime) {
+		return "", nil
+	}
+
+	// The source looks newer; compare contents where the ETag allows it
+	localPath, etag := filepath.Join(input.LocalDir, filepath.FromSlash(rel)), dst.etag
+	if input.Direction == store.TransferDownload {
+		etag = src.etag
+	}
+	local, ok, err := fileETag(localPath, etag, src.size, input.PartSize)
+	if err != nil {
+		return "", err
+	}
+	if ok && local == strings.Trim(etag, `"`) {
+		return "", nil
+	}
+	return "modified", nil
+}
+
+// runSync carries out a sync's plan. Large files are copied one at a time
+// with their parts in parallel; everything else runs input.Workers at a time.
+func runSync(ctx context.Context, client *Client, input SyncInput, prefix string, output *SyncOutput) error {
+	workers := input.Workers
+	if workers <= 0 {
+		workers = DefaultTransferWorkers
+	}
+	partSize := input.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+
+	var small, large, deletes []SyncAction
+	var totalBytes int64
+	for _, action := range output.Plan {
+		switch {
+		case action.Action == SyncDelete:
+			deletes = append(deletes, action)
+		case action.Size > partSize:
+			large = append(large, action)
+			totalBytes += action.Size
+		default:
+			small = append(small, action)
+			totalBytes += action.Size
+		}
+	}
+
+	var mu sync.Mutex
+	var bytesDone int64
+	actionsDone := 0
+	report := func() {
+		if input.Progress != nil {
+			input.Progress(bytesDone, totalBytes, actionsDone, len(output.Plan))
+		}
+	}
+	finish := func(action SyncAction, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		actionsDone++
+		switch {
+		case err != nil:
+			output.Failures = append(output.Failures, SyncFailure{Path: action.Path, Action: action.Action, Error: err.Error()})
+		case action.Action == SyncUpload:
+			output.Uploaded++
+			output.Bytes += action.Size
+		case action.Action == SyncDownload:
+			output.Downloaded++
+			output.Bytes += action.Size
+		default:
+			output.Deleted++
+		}
+		report()
+	}
+
+	copyFile := func(ctx context.Context, action SyncAction, partWorkers int) error {
+		localPath := filepath.Join(input.LocalDir, filepath.FromSlash(action.Path))
+		key := prefix + action.Path
+		opts := TransferOptions{PartSize: input.PartSize, Workers: partWorkers}
+
+		var id string
+		if input.Store != nil && action.Size > partSize {
+			id = store.TransferID(input.Direction, input.Profile, localPath, input.Bucket, key)
+			opts = Resumable(input.Store, id, input.Profile, opts)
+		}
+
+		// Count this file's bytes towards the sync's progress as they
+		// arrive
+		var counted int64
+		opts.Progress = func(done, total int64) {
+			mu.Lock()
+			defer mu.Unlock()
+			bytesDone += done - counted
+			counted = done
+			report()
+		}
+
+		var err error
+		if action.Action == SyncUpload {
+			_, err = Upload(ctx, client, localPath, input.Bucket, key, opts)
+		} else {
+			if err = os.MkdirAll(filepath.Dir(localPath), 0755); err == nil {
+				_, err = Download(ctx, client, input.Bucket, key, localPath, opts)
+			}
+		}
+		if id != "" {
+			EndTransfer(ctx, client, input.Store, id, err)
+		}
+		return err
+	}
+
+	// Small files, several at once
+	pending := make(chan SyncAction)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for action := range pending {
+				finish(action, copyFile(ctx, action, 1))
+			}
+		}()
+	}
+	for _, action := range small {
+		if ctx.Err() != nil {
+			break
+		}
+		pending <- action
+	}
+	close(pending)
+	wg.Wait()
+
+	// Large files, one at a time
+	for _, action := range large {
+		if ctx.Err() != nil {
+			break
+		}
+		finish(action, copyFile(ctx, action, workers))
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if input.Direction == store.TransferUpload {
+		deleteObjects(ctx, client, input.Bucket, prefix, deletes, finish)
+	} else {
+		for _, action := range deletes {
+			finish(action, os.Remove(filepath.Join(input.LocalDir, filepath.FromSlash(action.Path))))
+		}
+	}
+	return ctx.Err()
+}
+
+// deleteObjects deletes the objects named by actions, up to 1000 per request
+func deleteObjects(ctx context.Context, client *Client, bucket, prefix string, actions []SyncAction, finish func(SyncAction, error)) {
+	for len(actions) > 0 {
+		batch := actions
+		if len(batch) > 1000 {
+			batch = batch[:1000]
+		}
+		actions = actions[len(batch):]
+
+		objects := make([]types.ObjectIdentifier, 0, len(batch))
+		for _, action := range batch {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(prefix + action.Path)})
+		}
+		out, err := client.S3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			err = translateS3Error("s3:DeleteObject", err)
+			for _, action := range batch {
+				finish(action, err)
+			}
+			continue
+		}
+
+		// Quiet mode only lists the keys that could not be deleted
+		failed := make(map[string]error, len(out.Errors))
+		for _, e := range out.Errors {
+			failed[aws.ToString(e.Key)] = fmt.Errorf("%s: %s", aws.ToString(e.Code), aws.ToString(e.Message))
+		}
+		for _, action := range batch {
+			finish(action, failed[prefix+action.Path])
+		}
+	}
+}
+
+// listLocalFiles returns the regular files under dir that pass the filter,
+// by slash-separated path relative to dir. A missing directory is empty
+// unless it is the source.
+func listLocalFiles(dir string, filter *SyncFilter, source bool) (map[string]*syncEntry, error) {
+	files := make(map[string]*syncEntry)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) && !source {
+		return files, nil
+	}
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, PartialSuffix) {
+			return nil
+		}
+
+		// Follow links to files, but not to directories
+		info, err := os.Stat(p)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if filter.Match(rel) {
+			files[rel] = &syncEntry{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// listObjects returns the objects under prefix that pass the filter, by key
+// relative to prefix
+func listObjects(ctx context.Context, client *Client, bucket, prefix string, filter *SyncFilter) (map[string]*syncEntry, error) {
+	objects := make(map[string]*syncEntry)
+	paginator := s3.NewListObjectsV2Paginator(client.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, translateS3Error("s3:ListBucket", err)
+		}
+		for _, obj := range page.Contents {
+			rel := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			// Skip "folder" placeholders
+			if rel == "" || strings.HasSuffix(rel, "/") || !filter.Match(rel) {
+				continue
+			}
+			objects[rel] = &syncEntry{
+				size:    aws.ToInt64(obj.Size),
+				modTime: aws.ToTime(obj.LastModified),
+				etag:    aws.ToString(obj.ETag),
+			}
+		}
+	}
+	return objects, nil
+}
+
+// fileETag computes the ETag S3 would give a local file uploaded the way
+// etag's object was: the MD5 of the content, or for a multipart upload the
+// MD5 of the parts' MD5s. It reports false when that cannot be known, e.g.
+// for an object uploaded with different part sizes than Upload uses.
+func fileETag(localPath, etag string, size, partSize int64) (string, bool, error) {
+	etag = strings.Trim(etag, `"`)
+	parts := 0
+	if _, count, multipart := strings.Cut(etag, "-"); multipart {
+		if _, err := fmt.Sscanf(count, "%d", &parts); err != nil {
+			return "", false, nil
+		}
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	if parts == 0 {
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", false, err
+		}
+		return hex.EncodeToString(h.Sum(nil)), true, nil
+	}
+
+	partSize = partSizeFor(size, partSize)
+	if partCount(size, partSize) != parts {
+		return "", false, nil
+	}
+	sums := md5.New()
+	for part := int32(1); part <= int32(parts); part++ {
+		offset, length := partRange(part, size, partSize)
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(f, offset, length)); err != nil {
+			return "", false, err
+		}
+		sums.Write(h.Sum(nil))
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sums.Sum(nil)), parts), true, nil
+}
+
+// SyncFilter selects the paths a sync covers. A path is included if it
+// matches an include pattern (or there are none) and no exclude pattern.
+// Patterns use path.Match syntax; a pattern without a "/" matches the last
+// element of a path, and one with a "/" matches the whole relative path.
+// A pattern ending in "/" matches everything under the directories it
+// matches, by the same rule.
+type SyncFilter struct {
+	include []string
+	exclude []string
+}
+
+// NewSyncFilter checks the patterns and returns a filter
+func NewSyncFilter(include, exclude []string) (*SyncFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return &SyncFilter{include: include, exclude: exclude}, nil
+}
+
+// Match reports whether a slash-separated relative path is included
+func (f *SyncFilter) Match(rel string) bool {
+	if len(f.include) > 0 && !matchAny(f.include, rel) {
+		return false
+	}
+	return !matchAny(f.exclude, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			// Everything under a matching directory
+			elems := strings.Split(rel, "/")
+			for i := 1; i < len(elems); i++ {
+				name := elems[i-1]
+				if strings.Contains(dir, "/") {
+					name = strings.Join(elems[:i], "/")
+				}
+				if matched, _ := path.Match(dir, name); matched {
+					return true
+				}
+			}
+			continue
+		}
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]*syncEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
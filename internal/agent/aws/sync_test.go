@@ -0,0 +1,169 @@
+package aws
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// writeSyncFile writes size bytes of a repeating pattern to dir/name
+func writeSyncFile(t *testing.T, dir, name string, size int) []byte {
+	t.Helper()
+	data := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFileETag(t *testing.T) {
+	dir := t.TempDir()
+	size := 2*MinPartSize + 1
+	data := writeSyncFile(t, dir, "data.bin", size)
+
+	// S3's multipart ETag is the MD5 of the parts' MD5s, and the part count
+	var sums []byte
+	for offset := 0; offset < size; offset += MinPartSize {
+		end := min(offset+MinPartSize, size)
+		sum := md5.Sum(data[offset:end])
+		sums = append(sums, sum[:]...)
+	}
+	multipart := md5Hex(sums) + "-3"
+
+	tests := []struct {
+		name     string
+		etag     string
+		partSize int64
+		want     string
+		wantOK   bool
+	}{
+		{"single part", `"` + md5Hex(data) + `"`, MinPartSize, md5Hex(data), true},
+		{"multipart", `"` + multipart + `"`, MinPartSize, multipart, true},
+		{"multipart with the default part size", multipart, 0, "", false},
+		{"different part count", "0123456789abcdef0123456789abcdef-2", MinPartSize, "", false},
+		{"unreadable part count", "0123456789abcdef0123456789abcdef-x", MinPartSize, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok, err := fileETag(filepath.Join(dir, "data.bin"), tt.etag, int64(size), tt.partSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || ok != tt.wantOK {
+				t.Fatalf("fileETag = %q, %v; want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestSyncReason(t *testing.T) {
+	dir := t.TempDir()
+	data := writeSyncFile(t, dir, "results.csv", 100)
+	etag := `"` + md5Hex(data) + `"`
+	other := `"0123456789abcdef0123456789abcdef"`
+
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name      string
+		direction string
+		src, dst  *syncEntry
+		want      string
+	}{
+		{"missing", store.TransferUpload, &syncEntry{size: 100, modTime: now}, nil, "new"},
+		{"different size", store.TransferUpload, &syncEntry{size: 100, modTime: now}, &syncEntry{size: 99, modTime: now, etag: etag}, "size"},
+		{"older source", store.TransferUpload, &syncEntry{size: 100, modTime: now.Add(-time.Hour)}, &syncEntry{size: 100, modTime: now, etag: other}, ""},
+		// S3 drops the fraction of a second
+		{"newer within the second", store.TransferUpload, &syncEntry{size: 100, modTime: now.Add(500 * time.Millisecond)}, &syncEntry{size: 100, modTime: now, etag: other}, ""},
+		{"newer with the same content", store.TransferUpload, &syncEntry{size: 100, modTime: now.Add(time.Hour)}, &syncEntry{size: 100, modTime: now, etag: etag}, ""},
+		{"newer with other content", store.TransferUpload, &syncEntry{size: 100, modTime: now.Add(time.Hour)}, &syncEntry{size: 100, modTime: now, etag: other}, "modified"},
+		{"newer with an unknown part size", store.TransferUpload, &syncEntry{size: 100, modTime: now.Add(time.Hour)}, &syncEntry{size: 100, modTime: now, etag: `"` + md5Hex(data) + `-2"`}, "modified"},
+		// Downloading, the ETag is the source's
+		{"newer object with the same content", store.TransferDownload, &syncEntry{size: 100, modTime: now.Add(time.Hour), etag: etag}, &syncEntry{size: 100, modTime: now}, ""},
+		{"newer object with other content", store.TransferDownload, &syncEntry{size: 100, modTime: now.Add(time.Hour), etag: other}, &syncEntry{size: 100, modTime: now}, "modified"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := SyncInput{Direction: tt.direction, LocalDir: dir}
+			got, err := syncReason(input, "results.csv", tt.src, tt.dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("syncReason = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncFilter(t *testing.T) {
+	tests := []struct {
+		include, exclude []string
+		path             string
+		want             bool
+	}{
+		{nil, nil, "a/b/c.txt", true},
+
+		// Without a "/", a pattern matches a path's last element
+		{nil, []string{"*.tmp"}, "scratch.tmp", false},
+		{nil, []string{"*.tmp"}, "a/b/scratch.tmp", false},
+		{nil, []string{"*.tmp"}, "a/b/c.txt", true},
+
+		// With one, the whole path
+		{nil, []string{"data/*.csv"}, "data/results.csv", false},
+		{nil, []string{"data/*.csv"}, "lab/data/results.csv", true},
+		{nil, []string{"data/*.csv"}, "results.csv", true},
+
+		// Ending in "/", everything under a directory matching by the
+		// same rules: any directory with that name...
+		{nil, []string{"build/"}, "build/out.o", false},
+		{nil, []string{"build/"}, "src/build/out.o", false},
+		{nil, []string{"build/"}, "build", true},
+		{nil, []string{"build/"}, "builds/out.o", true},
+		{nil, []string{"b*/"}, "src/bin/tool", false},
+
+		// ...or, with another "/", the directory at that path
+		{nil, []string{"src/build/"}, "src/build/out.o", false},
+		{nil, []string{"src/build/"}, "src/build/deep/out.o", false},
+		{nil, []string{"src/build/"}, "lib/src/build/out.o", true},
+		{nil, []string{"src/build/"}, "build/out.o", true},
+
+		// Includes narrow the paths before excludes take some away
+		{[]string{"*.csv"}, nil, "a/results.csv", true},
+		{[]string{"*.csv"}, nil, "a/notes.txt", false},
+		{[]string{"*.csv"}, []string{"tmp/"}, "tmp/results.csv", false},
+		{[]string{"raw/"}, []string{"*.tmp"}, "raw/a/scan.dat", true},
+		{[]string{"raw/"}, []string{"*.tmp"}, "raw/a/scan.tmp", false},
+		{[]string{"raw/"}, []string{"*.tmp"}, "cooked/scan.dat", false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%v-%v-%s", tt.include, tt.exclude, tt.path), func(t *testing.T) {
+			filter, err := NewSyncFilter(tt.include, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := filter.Match(tt.path); got != tt.want {
+				t.Fatalf("Match(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncFilterRejectsBadPattern(t *testing.T) {
+	for _, pattern := range []string{"[", "data/[/"} {
+		if _, err := NewSyncFilter(nil, []string{pattern}); err == nil {
+			t.Errorf("pattern %q accepted", pattern)
+		}
+	}
+}
@@ -0,0 +1,592 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// Transfer tuning defaults and limits
+const (
+	DefaultPartSize        = 64 << 20
+	MinPartSize            = 5 << 20 // smallest part S3 accepts, except the last
+	DefaultTransferWorkers = 4
+	MaxTransferWorkers     = 64
+
+	// maxParts is the most parts a multipart upload may have
+	maxParts = 10000
+)
+
+// PartialSuffix is appended to a download's destination while it is in
+// progress
+const PartialSuffix = ".arkpart"
+
+// ErrObjectChanged is returned when an object is replaced while it is being
+// downloaded
+var ErrObjectChanged = errors.New("object changed during download")
+
+// TransferOptions controls an upload or download
+type TransferOptions struct {
+	PartSize int64 // bytes per part; DefaultPartSize if zero
+	Workers  int   // parts transferred at once; DefaultTransferWorkers if zero
+
+	// State is what an earlier, interrupted attempt saved. It is used if it
+	// still matches the source and destination, and ignored otherwise.
+	State *store.TransferState
+
+	// Save is called with the transfer's state whenever a part completes,
+	// so the transfer can be resumed if it is interrupted
+	Save func(store.TransferState) error
+
+	// Progress is called with the bytes transferred so far
+	Progress func(done, total int64)
+}
+
+// TransferOutput is the result of a completed transfer
+type TransferOutput struct {
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	LocalPath    string `json:"local_path"`
+	Size         int64  `json:"size"`
+	Parts        int    `json:"parts"`
+	PartsResumed int    `json:"parts_resumed"`
+	ETag         string `json:"etag,omitempty"`
+}
+
+// Resumable returns opts set up to resume the transfer whose state st keeps
+// under id, and to keep its state there as parts complete. The caller
+// passes the outcome to EndTransfer once the transfer is done.
+func Resumable(st *store.Store, id, profile string, opts TransferOptions) TransferOptions {
+	saved, err := st.GetTransfer(id)
+	if err == nil {
+		opts.State = saved
+	}
+	opts.Save = func(state store.TransferState) error {
+		state.Profile = profile
+		return st.SetTransfer(id, state)
+	}
+	return opts
+}
+
+// EndTransfer deals with the state st keeps under id for a transfer that
+// ended with err. The state is kept only if the transfer can resume: the
+// agent stopped it, or it was interrupted (see Interrupted). Otherwise the
+// state is deleted, and if the transfer did not complete, its multipart
+// upload is aborted or its partial download removed. It reports whether
+// the state was kept.
+func EndTransfer(ctx context.Context, client *Client, st *store.Store, id string, err error) bool {
+	if err != nil && !jobs.Canceled(ctx) && (ctx.Err() != nil || Interrupted(err)) {
+		return true
+	}
+
+	if err != nil {
+		if state, getErr := st.GetTransfer(id); getErr == nil {
+			// Clean up even though the transfer's context is cancelled
+			if discardErr := discardTransfer(context.WithoutCancel(ctx), client, state); discardErr != nil {
+				slog.Warn("failed to discard transfer", "error", discardErr, "transfer", id)
+			}
+		}
+	}
+	if err := st.DeleteTransfer(id); err != nil {
+		slog.Warn("failed to remove transfer state", "error", err, "transfer", id)
+	}
+	return false
+}
+
+// Interrupted reports whether a transfer that failed with err can be
+// resumed: it lost its connection to S3, S3 was unavailable or throttled
+// it, or its credentials expired. Other failures do not go away by trying
+// again.
+func Interrupted(err error) bool {
+	if errors.Is(err, io.ErrUnexpectedEOF) || isS3ErrorCode(err, "ExpiredToken", "RequestTimeout") {
+		return true
+	}
+	return retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary
+}
+
+// discardTransfer aborts the multipart upload of an unfinished upload, or
+// removes the partial file of an unfinished download
+func discardTransfer(ctx context.Context, client *Client, state *store.TransferState) error {
+	if state.Direction == store.TransferDownload {
+		if err := os.Remove(state.LocalPath + PartialSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if state.UploadID == "" {
+		return nil
+	}
+	_, err := client.S3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	})
+	if err != nil && !isS3ErrorCode(err, "NoSuchUpload") {
+		return translateS3Error("s3:AbortMultipartUpload", err)
+	}
+	return nil
+}
+
+// ObjectInfo describes an S3 object
+type ObjectInfo struct {
+	Size         int64
+	ETag         string
+	LastModified time.Time
+}
+
+// StatObject returns an object's size, ETag and modification time
+func StatObject(ctx context.Context, client *Client, bucket, key string) (*ObjectInfo, error) {
+	out, err := client.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// HEAD responses have no body, so a missing bucket and a missing
+		// key both come back as NotFound
+		if isS3ErrorCode(err, "NotFound") {
+			return nil, ErrNoSuchKey
+		}
+		return nil, translateS3Error("s3:GetObject", err)
+	}
+	return &ObjectInfo{
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// Upload copies a local file to S3. Files larger than one part are sent as
+// a multipart upload with parts uploaded concurrently; an interrupted
+// multipart upload is resumed from opts.State.
+func Upload(ctx context.Context, client *Client, localPath, bucket, key string, opts TransferOptions) (*TransferOutput, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", localPath)
+	}
+
+	size := info.Size()
+	partSize := partSizeFor(size, opts.PartSize)
+	output := &TransferOutput{Bucket: bucket, Key: key, LocalPath: localPath, Size: size, Parts: 1}
+
+	// Single request for anything that fits in one part
+	if size <= partSize {
+		out, err := client.S3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:        aws.String(bucket),
+			Key:           aws.String(key),
+			Body:          f,
+			ContentLength: aws.Int64(size),
+		})
+		if err != nil {
+			return nil, translateS3Error("s3:PutObject", err)
+		}
+		report(opts, size, size)
+		output.ETag = aws.ToString(out.ETag)
+		return output, nil
+	}
+
+	state, err := resumeUpload(ctx, client, opts.State, store.TransferState{
+		Direction: store.TransferUpload,
+		LocalPath: localPath,
+		Bucket:    bucket,
+		Key:       key,
+		Size:      size,
+		ModTime:   info.ModTime(),
+		PartSize:  partSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := save(opts, *state); err != nil {
+		return nil, err
+	}
+
+	output.Parts = partCount(size, partSize)
+	output.PartsResumed = len(state.Parts)
+
+	err = transferParts(ctx, state, opts, func(ctx context.Context, part int32, offset, length int64) (store.TransferPart, error) {
+		out, err := client.S3Client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String(key),
+			UploadId:          aws.String(state.UploadID),
+			PartNumber:        aws.Int32(part),
+			Body:              io.NewSectionReader(f, offset, length),
+			ContentLength:     aws.Int64(length),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			return store.TransferPart{}, translateS3Error("s3:PutObject", err)
+		}
+		return store.TransferPart{
+			Number:        part,
+			ETag:          aws.ToString(out.ETag),
+			ChecksumCRC32: aws.ToString(out.ChecksumCRC32),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	completed := make([]types.CompletedPart, 0, len(state.Parts))
+	for _, p := range state.Parts {
+		part := types.CompletedPart{
+			PartNumber: aws.Int32(p.Number),
+			ETag:       aws.String(p.ETag),
+		}
+		if p.ChecksumCRC32 != "" {
+			part.ChecksumCRC32 = aws.String(p.ChecksumCRC32)
+		}
+		completed = append(completed, part)
+	}
+
+	out, err := client.S3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("complete upload: %w", translateS3Error("s3:PutObject", err))
+	}
+
+	output.ETag = aws.ToString(out.ETag)
+	return output, nil
+}
+
+// resumeUpload continues the multipart upload in saved if it is for the same
+// file, checking with S3 which parts it holds. Otherwise it abandons any
+// saved upload and starts a new one.
+func resumeUpload(ctx context.Context, client *Client, saved *store.TransferState, want store.TransferState) (*store.TransferState, error) {
+	if saved != nil && saved.UploadID != "" {
+		if saved.Bucket == want.Bucket && saved.Key == want.Key && saved.Size == want.Size &&
+			saved.ModTime.Equal(want.ModTime) && saved.PartSize == want.PartSize {
+			parts, err := listUploadedParts(ctx, client, saved)
+			if err == nil {
+				state := *saved
+				state.Parts = parts
+				return &state, nil
+			}
+			if !isS3ErrorCode(err, "NoSuchUpload") {
+				return nil, translateS3Error("s3:ListMultipartUploadParts", err)
+			}
+		} else {
+			// The file changed; the old parts are of no use
+			client.S3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+				Bucket:   aws.String(saved.Bucket),
+				Key:      aws.String(saved.Key),
+				UploadId: aws.String(saved.UploadID),
+			})
+		}
+	}
+
+	out, err := client.S3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(want.Bucket),
+		Key:               aws.String(want.Key),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	if err != nil {
+		return nil, translateS3Error("s3:PutObject", err)
+	}
+
+	state := want
+	state.UploadID = aws.ToString(out.UploadId)
+	state.StartedAt = time.Now()
+	return &state, nil
+}
+
+// listUploadedParts returns the parts S3 holds for a multipart upload
+func listUploadedParts(ctx context.Context, client *Client, state *store.TransferState) ([]store.TransferPart, error) {
+	var parts []store.TransferPart
+	paginator := s3.NewListPartsPaginator(client.S3Client, &s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.Parts {
+			number := aws.ToInt32(p.PartNumber)
+			// Only parts of the expected size can be reused
+			_, length := partRange(number, state.Size, state.PartSize)
+			if aws.ToInt64(p.Size) != length {
+				continue
+			}
+			parts = append(parts, store.TransferPart{
+				Number:        number,
+				ETag:          aws.ToString(p.ETag),
+				ChecksumCRC32: aws.ToString(p.ChecksumCRC32),
+			})
+		}
+	}
+	return parts, nil
+}
+
+// Download copies an S3 object to a local file. Parts are fetched
+// concurrently with ranged GETs into a partial file next to the destination,
+// which is renamed into place once complete. An interrupted download is
+// resumed from opts.State if the object has not changed.
+func Download(ctx context.Context, client *Client, bucket, key, localPath string, opts TransferOptions) (*TransferOutput, error) {
+	object, err := StatObject(ctx, client, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := partSizeFor(object.Size, opts.PartSize)
+	partial := localPath + PartialSuffix
+	output := &TransferOutput{
+		Bucket:    bucket,
+		Key:       key,
+		LocalPath: localPath,
+		Size:      object.Size,
+		Parts:     partCount(object.Size, partSize),
+		ETag:      object.ETag,
+	}
+
+	want := store.TransferState{
+		Direction: store.TransferDownload,
+		LocalPath: localPath,
+		Bucket:    bucket,
+		Key:       key,
+		Size:      object.Size,
+		ModTime:   object.LastModified,
+		ETag:      object.ETag,
+		PartSize:  partSize,
+		StartedAt: time.Now(),
+	}
+	state := &want
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if saved := opts.State; saved != nil && saved.ETag == want.ETag && saved.Size == want.Size && saved.PartSize == want.PartSize {
+		if info, err := os.Stat(partial); err == nil && info.Size() == want.Size {
+			state = saved
+			flags = os.O_RDWR
+		}
+	}
+
+	f, err := os.OpenFile(partial, flags, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := f.Truncate(object.Size); err != nil {
+		return nil, err
+	}
+	if err := save(opts, *state); err != nil {
+		return nil, err
+	}
+	output.PartsResumed = len(state.Parts)
+
+	err = transferParts(ctx, state, opts, func(ctx context.Context, part int32, offset, length int64) (store.TransferPart, error) {
+		out, err := client.S3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(bucket),
+			Key:     aws.String(key),
+			Range:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+			IfMatch: aws.String(object.ETag),
+		})
+		if err != nil {
+			if isS3ErrorCode(err, "PreconditionFailed") {
+				return store.TransferPart{}, ErrObjectChanged
+			}
+			return store.TransferPart{}, translateS3Error("s3:GetObject", err)
+		}
+		defer out.Body.Close()
+
+		n, err := io.Copy(io.NewOffsetWriter(f, offset), io.LimitReader(out.Body, length))
+		if err != nil {
+			return store.TransferPart{}, fmt.Errorf("download part %d: %w", part, err)
+		}
+		if n != length {
+			return store.TransferPart{}, fmt.Errorf("download part %d: got %d of %d bytes: %w", part, n, length, io.ErrUnexpectedEOF)
+		}
+		return store.TransferPart{Number: part}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	// Keep the object's modification time, so later comparisons with the
+	// object can tell whether the copy is current
+	if err := os.Chtimes(partial, object.LastModified, object.LastModified); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partial, localPath); err != nil {
+		return nil, err
+	}
+
+	report(opts, object.Size, object.Size)
+	return output, nil
+}
+
+// transferParts runs fn for every part not yet in state.Parts, on up to
+// opts.Workers goroutines, recording each completed part in state and
+// saving it. It stops at the first failure.
+func transferParts(ctx context.Context, state *store.TransferState, opts TransferOptions,
+	fn func(ctx context.Context, part int32, offset, length int64) (store.TransferPart, error)) error {
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	total := partCount(state.Size, state.PartSize)
+	done := make(map[int32]bool, len(state.Parts))
+	var bytesDone int64
+	for _, p := range state.Parts {
+		done[p.Number] = true
+		_, length := partRange(p.Number, state.Size, state.PartSize)
+		bytesDone += length
+	}
+	report(opts, bytesDone, state.Size)
+
+	pending := make(chan int32)
+	go func() {
+		defer close(pending)
+		for part := int32(1); part <= int32(total); part++ {
+			if done[part] {
+				continue
+			}
+			select {
+			case pending <- part:
+			case <-workCtx.Done():
+				return
+			}
+		}
+	}()
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultTransferWorkers
+	}
+	if workers > MaxTransferWorkers {
+		workers = MaxTransferWorkers
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range pending {
+				offset, length := partRange(part, state.Size, state.PartSize)
+				completed, err := fn(workCtx, part, offset, length)
+				if err != nil {
+					fail(err)
+					return
+				}
+
+				mu.Lock()
+				state.Parts = append(state.Parts, completed)
+				bytesDone += length
+				err = save(opts, *state)
+				report(opts, bytesDone, state.Size)
+				mu.Unlock()
+				if err != nil {
+					fail(fmt.Errorf("save transfer state: %w", err))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		// Cancellation is reported as such, rather than as whichever
+		// request it interrupted
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return firstErr
+	}
+	if len(state.Parts) < total {
+		return ctx.Err()
+	}
+
+	sort.Slice(state.Parts, func(i, j int) bool {
+		return state.Parts[i].Number < state.Parts[j].Number
+	})
+	return nil
+}
+
+// partSizeFor returns the part size for an object of size bytes: the
+// requested size, raised if needed to stay within S3's limits
+func partSizeFor(size, requested int64) int64 {
+	partSize := requested
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize < MinPartSize {
+		partSize = MinPartSize
+	}
+	if least := (size + maxParts - 1) / maxParts; partSize < least {
+		// Round up to a whole MiB
+		partSize = (least + 1<<20 - 1) &^ (1<<20 - 1)
+	}
+	return partSize
+}
+
+// partCount returns the number of parts in size bytes
+func partCount(size, partSize int64) int {
+	return int((size + partSize - 1) / partSize)
+}
+
+// partRange returns the offset and length of a part, numbered from 1
+func partRange(part int32, size, partSize int64) (int64, int64) {
+	offset := int64(part-1) * partSize
+	length := partSize
+	if offset+length > size {
+		length = size - offset
+	}
+	return offset, length
+}
+
+func save(opts TransferOptions, state store.TransferState) error {
+	if opts.Save == nil {
+		return nil
+	}
+	return opts.Save(state)
+}
+
+func report(opts TransferOptions, done, total int64) {
+	if opts.Progress != nil {
+		opts.Progress(done, total)
+	}
+}
@@ -0,0 +1,270 @@
+package aws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/smithy-go"
+	"github.com/scttfrdmn/ark/internal/agent/jobs"
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// s3StandIn serves the S3 calls of multipart uploads and ranged downloads
+// for one bucket
+type s3StandIn struct {
+	mu      sync.Mutex
+	object  []byte // what GETs of any key return
+	uploads map[string]bool
+	aborted []string
+
+	// onPart is called for every part uploaded or downloaded; an error
+	// code it returns fails the request
+	onPart func() string
+}
+
+const standInETag = `"0123456789abcdef"`
+
+var standInModTime = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newS3StandIn(t *testing.T) (*s3StandIn, *Client) {
+	t.Helper()
+	si := &s3StandIn{uploads: map[string]bool{}}
+	srv := httptest.NewServer(si)
+	t.Cleanup(srv.Close)
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+	provider := credentials.NewStaticCredentialsProvider("AKIAEXAMPLE", "secret", "")
+	return si, NewClientFromProvider(provider, "us-east-1")
+}
+
+func (si *s3StandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	q := r.URL.Query()
+
+	si.mu.Lock()
+	onPart := si.onPart
+	si.mu.Unlock()
+	isPart := (r.Method == http.MethodPut && q.Has("partNumber")) || (r.Method == http.MethodGet && r.Header.Get("Range") != "")
+	if isPart && onPart != nil {
+		if code := onPart(); code != "" {
+			writeS3Error(w, http.StatusForbidden, code)
+			return
+		}
+	}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := fmt.Sprintf("upload-%d", len(si.uploads)+1)
+		si.uploads[id] = true
+		fmt.Fprintf(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>`, id)
+	case r.Method == http.MethodPut && q.Has("partNumber"):
+		if !si.uploads[q.Get("uploadId")] {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		w.Header().Set("ETag", `"part-`+q.Get("partNumber")+`"`)
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		if !si.uploads[q.Get("uploadId")] {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		delete(si.uploads, q.Get("uploadId"))
+		si.aborted = append(si.aborted, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead:
+		w.Header().Set("Content-Length", strconv.Itoa(len(si.object)))
+		w.Header().Set("ETag", standInETag)
+		w.Header().Set("Last-Modified", standInModTime.Format(http.TimeFormat))
+	case r.Method == http.MethodGet:
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			writeS3Error(w, http.StatusBadRequest, "InvalidRange")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(end-start+1))
+		w.Header().Set("ETag", standInETag)
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(si.object[start : end+1])
+	default:
+		writeS3Error(w, http.StatusBadRequest, "NotImplemented")
+	}
+}
+
+// writeS3Error writes an error in the S3 REST protocol's format
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `<Error><Code>%s</Code><Message>%s</Message><RequestId>1</RequestId></Error>`, code, code)
+}
+
+// abortedUploads returns the IDs of the multipart uploads aborted so far
+func (si *s3StandIn) abortedUploads() []string {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return append([]string(nil), si.aborted...)
+}
+
+func newTransferStore(t *testing.T) *store.Store {
+	t.Helper()
+	dir := t.TempDir()
+	st, err := store.New(filepath.Join(dir, "agent.db"), store.Options{KeyFile: filepath.Join(dir, "agent.key")})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+// interruptedUpload uploads a three-part file, calling onPart for each
+// part, and returns the transfer's ID and the upload's error
+func interruptedUpload(t *testing.T, si *s3StandIn, client *Client, st *store.Store, ctx context.Context, onPart func() string) (string, error) {
+	t.Helper()
+	localPath := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(localPath, make([]byte, 3*MinPartSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	si.onPart = onPart
+	id := store.TransferID(store.TransferUpload, "default", localPath, "bucket", "key")
+	opts := Resumable(st, id, "default", TransferOptions{PartSize: MinPartSize, Workers: 1})
+	_, err := Upload(ctx, client, localPath, "bucket", "key", opts)
+	if err == nil {
+		t.Fatal("upload completed despite being stopped")
+	}
+	return id, err
+}
+
+// stopAfterFirstPart returns an onPart that calls stop once
+func stopAfterFirstPart(stop func()) func() string {
+	var once sync.Once
+	return func() string {
+		once.Do(stop)
+		return ""
+	}
+}
+
+func TestEndTransferKeepsUploadWhenAgentStops(t *testing.T) {
+	si, client := newS3StandIn(t)
+	st := newTransferStore(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	id, err := interruptedUpload(t, si, client, st, ctx, stopAfterFirstPart(cancel))
+	if !EndTransfer(ctx, client, st, id, err) {
+		t.Fatal("state of an upload interrupted by the agent stopping was discarded")
+	}
+	if state, err := st.GetTransfer(id); err != nil || state.UploadID != "upload-1" {
+		t.Fatalf("transfer state = %+v, %v", state, err)
+	}
+	if got := si.abortedUploads(); len(got) != 0 {
+		t.Fatalf("aborted %v", got)
+	}
+}
+
+func TestEndTransferAbortsCanceledUpload(t *testing.T) {
+	si, client := newS3StandIn(t)
+	st := newTransferStore(t)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	id, err := interruptedUpload(t, si, client, st, ctx, stopAfterFirstPart(func() { cancel(jobs.ErrCanceled) }))
+	if EndTransfer(ctx, client, st, id, err) {
+		t.Fatal("state of a canceled upload was kept")
+	}
+	if _, err := st.GetTransfer(id); err == nil {
+		t.Fatal("transfer state left behind")
+	}
+	if got := si.abortedUploads(); len(got) != 1 || got[0] != "upload-1" {
+		t.Fatalf("aborted %v, want upload-1", got)
+	}
+}
+
+func TestEndTransferAbortsFailedUpload(t *testing.T) {
+	si, client := newS3StandIn(t)
+	st := newTransferStore(t)
+
+	// The second part is refused; trying again will not help
+	parts := 0
+	id, err := interruptedUpload(t, si, client, st, context.Background(), func() string {
+		if parts++; parts > 1 {
+			return "AccessDenied"
+		}
+		return ""
+	})
+	if EndTransfer(context.Background(), client, st, id, err) {
+		t.Fatalf("state of an upload that failed with %v was kept", err)
+	}
+	if got := si.abortedUploads(); len(got) != 1 || got[0] != "upload-1" {
+		t.Fatalf("aborted %v, want upload-1", got)
+	}
+}
+
+func TestEndTransferRemovesCanceledDownload(t *testing.T) {
+	si, client := newS3StandIn(t)
+	si.object = make([]byte, 3*MinPartSize)
+	st := newTransferStore(t)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	localPath := filepath.Join(t.TempDir(), "data.bin")
+	var once sync.Once
+	si.onPart = func() string {
+		once.Do(func() { cancel(jobs.ErrCanceled) })
+		return ""
+	}
+	id := store.TransferID(store.TransferDownload, "default", localPath, "bucket", "key")
+	opts := Resumable(st, id, "default", TransferOptions{PartSize: MinPartSize, Workers: 1})
+	_, err := Download(ctx, client, "bucket", "key", localPath, opts)
+	if err == nil {
+		t.Fatal("download completed despite being canceled")
+	}
+	if _, statErr := os.Stat(localPath + PartialSuffix); statErr != nil {
+		t.Fatalf("no partial file before EndTransfer: %v", statErr)
+	}
+
+	if EndTransfer(ctx, client, st, id, err) {
+		t.Fatal("state of a canceled download was kept")
+	}
+	if _, err := os.Stat(localPath + PartialSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("partial file left behind: %v", err)
+	}
+	if _, err := st.GetTransfer(id); err == nil {
+		t.Fatal("transfer state left behind")
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{translateS3Error("s3:PutObject", &smithy.GenericAPIError{Code: "SlowDown"}), true},
+		{translateS3Error("s3:PutObject", &smithy.GenericAPIError{Code: "ExpiredToken"}), true},
+		{fmt.Errorf("download part 2: %w", io.ErrUnexpectedEOF), true},
+		{translateS3Error("s3:PutObject", &smithy.GenericAPIError{Code: "AccessDenied"}), false},
+		{translateS3Error("s3:PutObject", &smithy.GenericAPIError{Code: "NoSuchBucket"}), false},
+		{ErrObjectChanged, false},
+		{errors.New("open data.bin: permission denied"), false},
+	}
+	for _, tt := range tests {
+		if got := Interrupted(tt.err); got != tt.want {
+			t.Errorf("Interrupted(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if !strings.Contains(translateS3Error("s3:PutObject", &smithy.GenericAPIError{Code: "SlowDown", Message: "reduce your request rate"}).Error(), "reduce your request rate") {
+		t.Error("translated error lost its message")
+	}
+}
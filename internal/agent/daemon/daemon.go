@@ -8,11 +8,19 @@ import (
 	"runtime"
 )
 
+// Files the agent keeps in its data directory. The token is the API token
+// for TCP clients; like the socket, only the owning user can use it.
+const (
+	LockFileName   = "agent.lock"
+	SocketFileName = "agent.sock"
+	TokenFileName  = "agent.token"
+)
+
 // Start launches the agent as a background daemon process
 // Returns nil if the agent was started successfully
 func Start(agentBinaryPath string) error {
 	// Get data directory for log file
-	dataDir, err := getDataDir()
+	dataDir, err := DataDir()
 	if err != nil {
 		return fmt.Errorf("get data directory: %w", err)
 	}
@@ -92,8 +100,8 @@ func findAgentBinary() (string, error) {
 	return "", fmt.Errorf("ark-agent binary not found in PATH or %s", exeDir)
 }
 
-// getDataDir returns the agent data directory
-func getDataDir() (string, error) {
+// DataDir returns the agent data directory
+func DataDir() (string, error) {
 	// Check environment variable first
 	if dir := os.Getenv("ARK_AGENT_DATA"); dir != "" {
 		return dir, nil
@@ -0,0 +1,484 @@
+// Package jobs runs long operations in the agent, such as large S3
+// transfers, outside of the HTTP request that started them. Clients follow
+// a job's progress and can cancel it.
+//
+// Jobs are kept in the agent store, so they outlive the agent. A job that
+// was queued or running when the agent stopped is resumed when it starts
+// again if its type registered a Resumer, and failed otherwise.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// ErrNotFound is returned for an unknown job ID
+var ErrNotFound = errors.New("job not found")
+
+// ErrFinished is returned when cancelling a job that has already finished
+var ErrFinished = errors.New("job has already finished")
+
+// ErrCanceled is the cause of a job's context when a client cancels the
+// job, as opposed to the agent stopping
+var ErrCanceled = errors.New("job canceled")
+
+// Canceled reports whether the job running with ctx was cancelled by a
+// client. Work it has done can be discarded; a job stopped by the agent
+// stopping keeps it, so that it can resume.
+func Canceled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrCanceled)
+}
+
+// Status is the state of a job
+type Status string
+
+// Job states
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Done reports whether a job in this state has finished
+func (s Status) Done() bool {
+	return s != StatusQueued && s != StatusRunning
+}
+
+// Progress is how far a job has got. Jobs that move data count bytes;
+// Message describes the current step.
+type Progress struct {
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Job is a snapshot of a job
+type Job struct {
+	ID          string      `json:"id"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Status      Status      `json:"status"`
+	Progress    Progress    `json:"progress"`
+	Error       string      `json:"error,omitempty"`
+	Result      interface{} `json:"result,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	FinishedAt  *time.Time  `json:"finished_at,omitempty"`
+
+	// Resumed counts the times the job was picked up again after the
+	// agent restarted
+	Resumed int `json:"resumed,omitempty"`
+}
+
+// Func is the work of a job. It reports progress through update and
+// returns the job's result. It must return once ctx is cancelled.
+type Func func(ctx context.Context, update func(Progress)) (interface{}, error)
+
+// Resumer rebuilds the work of a job interrupted by the agent stopping from
+// the parameters it was started with
+type Resumer func(id string, params json.RawMessage) (Func, error)
+
+// record is a job as kept in the store
+type record struct {
+	Job
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Defaults for Options
+const (
+	DefaultMaxConcurrent = 4
+	DefaultRetention     = 7 * 24 * time.Hour
+)
+
+// saveInterval bounds how often progress alone is written to the store
+const saveInterval = 2 * time.Second
+
+// Options configures a Manager
+type Options struct {
+	// Store keeps jobs across restarts; without one they are kept in
+	// memory only
+	Store *store.Store
+
+	// MaxConcurrent bounds how many jobs run at once; the rest wait in
+	// the queue
+	MaxConcurrent int
+
+	// Retention is how long finished jobs are kept
+	Retention time.Duration
+}
+
+// Manager runs jobs and keeps track of them
+type Manager struct {
+	ctx       context.Context
+	store     *store.Store
+	slots     chan struct{}
+	retention time.Duration
+
+	mu       sync.Mutex
+	jobs     map[string]*entry
+	resumers map[string]Resumer
+}
+
+// entry is a job and what the manager needs to run and follow it
+type entry struct {
+	job     Job
+	params  json.RawMessage
+	cancel  context.CancelCauseFunc
+	changed chan struct{} // closed and replaced on every change
+	saved   time.Time
+}
+
+// NewManager creates a manager. Jobs are stopped when ctx is cancelled,
+// without being marked as finished, so that they resume on the next start.
+func NewManager(ctx context.Context, opts Options) *Manager {
+	if opts.MaxConcurrent <= 0 {
+		opts.MaxConcurrent = DefaultMaxConcurrent
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultRetention
+	}
+	return &Manager{
+		ctx:       ctx,
+		store:     opts.Store,
+		slots:     make(chan struct{}, opts.MaxConcurrent),
+		retention: opts.Retention,
+		jobs:      make(map[string]*entry),
+		resumers:  make(map[string]Resumer),
+	}
+}
+
+// Register lets interrupted jobs of a type resume. Register every type
+// before calling Recover.
+func (m *Manager) Register(jobType string, resume Resumer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resumers[jobType] = resume
+}
+
+// Recover loads the jobs kept from before the agent last started, resuming
+// or failing the ones that had not finished and dropping finished ones
+// past the retention period
+func (m *Manager) Recover() error {
+	if m.store == nil {
+		return nil
+	}
+	stored, err := m.store.ListJobs()
+	if err != nil {
+		return fmt.Errorf("list jobs: %w", err)
+	}
+
+	// Resume jobs in the order they were started
+	records := make([]record, 0, len(stored))
+	for id, data := range stored {
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			slog.Warn("dropping unreadable job", "error", err, "job", id)
+			m.delete(id)
+			continue
+		}
+		records = append(records, rec)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	for _, rec := range records {
+		id := rec.ID
+		e := &entry{job: rec.Job, params: rec.Params, changed: make(chan struct{})}
+		if rec.Status.Done() {
+			if rec.FinishedAt != nil && time.Since(*rec.FinishedAt) > m.retention {
+				m.delete(id)
+				continue
+			}
+			m.mu.Lock()
+			m.jobs[id] = e
+			m.mu.Unlock()
+			continue
+		}
+
+		m.mu.Lock()
+		resume := m.resumers[rec.Type]
+		m.mu.Unlock()
+
+		var fn Func
+		err = fmt.Errorf("interrupted when the agent stopped")
+		if resume != nil && rec.Params != nil {
+			if fn, err = resume(id, rec.Params); err != nil {
+				err = fmt.Errorf("interrupted when the agent stopped, and could not be resumed: %w", err)
+			}
+		}
+
+		if fn == nil {
+			now := time.Now()
+			e.job.Status = StatusFailed
+			e.job.Error = err.Error()
+			e.job.UpdatedAt = now
+			e.job.FinishedAt = &now
+			m.mu.Lock()
+			m.jobs[id] = e
+			m.save(e, true)
+			m.mu.Unlock()
+			slog.Warn("job interrupted", "job", id, "type", rec.Type, "error", err)
+			continue
+		}
+
+		e.job.Status = StatusQueued
+		e.job.Resumed++
+		e.job.UpdatedAt = time.Now()
+		m.launch(e, fn)
+		slog.Info("job resumed", "job", id, "type", rec.Type, "description", rec.Description)
+	}
+	return nil
+}
+
+// Start queues fn to run in the background and returns the new job.
+// params, if not nil, is kept with the job so that its type's Resumer can
+// pick it up after a restart.
+func (m *Manager) Start(jobType, description string, params interface{}, fn Func) (Job, error) {
+	id, err := newID()
+	if err != nil {
+		return Job{}, err
+	}
+
+	var raw json.RawMessage
+	if params != nil {
+		if raw, err = json.Marshal(params); err != nil {
+			return Job{}, fmt.Errorf("marshal job parameters: %w", err)
+		}
+	}
+
+	now := time.Now()
+	e := &entry{
+		job: Job{
+			ID:          id,
+			Type:        jobType,
+			Description: description,
+			Status:      StatusQueued,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		params:  raw,
+		changed: make(chan struct{}),
+	}
+	m.prune()
+	return m.launch(e, fn), nil
+}
+
+// launch adds a job and runs it once a slot is free
+func (m *Manager) launch(e *entry, fn Func) Job {
+	ctx, cancel := context.WithCancelCause(m.ctx)
+
+	m.mu.Lock()
+	e.cancel = cancel
+	m.jobs[e.job.ID] = e
+	m.save(e, true)
+	snapshot := e.job
+	m.mu.Unlock()
+
+	go m.run(ctx, e, fn)
+	return snapshot
+}
+
+func (m *Manager) run(ctx context.Context, e *entry, fn Func) {
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		m.finish(ctx, e, nil, ctx.Err())
+		return
+	}
+
+	m.mu.Lock()
+	e.job.Status = StatusRunning
+	e.job.UpdatedAt = time.Now()
+	m.changedLocked(e, true)
+	m.mu.Unlock()
+
+	result, err := fn(ctx, func(p Progress) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		e.job.Progress = p
+		e.job.UpdatedAt = time.Now()
+		m.changedLocked(e, false)
+	})
+	m.finish(ctx, e, result, err)
+}
+
+// finish records the outcome of a job. A job stopped by the agent shutting
+// down is left as it was in the store, so that it is recovered next time.
+func (m *Manager) finish(ctx context.Context, e *entry, result interface{}, err error) {
+	// Asked before the job's context is released, which cancels it too
+	canceled := Canceled(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.cancel(nil)
+	if m.ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	e.job.UpdatedAt = now
+	e.job.FinishedAt = &now
+	e.job.Result = result
+	switch {
+	case err == nil:
+		e.job.Status = StatusSucceeded
+	case canceled:
+		e.job.Status = StatusCanceled
+		e.job.Error = err.Error()
+	default:
+		e.job.Status = StatusFailed
+		e.job.Error = err.Error()
+	}
+	m.changedLocked(e, true)
+}
+
+// changedLocked wakes watchers of a job and saves it: always if force is
+// set, otherwise at most every saveInterval
+func (m *Manager) changedLocked(e *entry, force bool) {
+	close(e.changed)
+	e.changed = make(chan struct{})
+	m.save(e, force)
+}
+
+// save writes a job to the store. It is called with m.mu held.
+func (m *Manager) save(e *entry, force bool) {
+	if m.store == nil || (!force && time.Since(e.saved) < saveInterval) {
+		return
+	}
+	if err := m.store.SetJob(e.job.ID, record{Job: e.job, Params: e.params}); err != nil {
+		slog.Warn("failed to save job", "error", err, "job", e.job.ID)
+		return
+	}
+	e.saved = time.Now()
+}
+
+// delete removes a job from the store
+func (m *Manager) delete(id string) {
+	if err := m.store.DeleteJob(id); err != nil {
+		slog.Warn("failed to delete job", "error", err, "job", id)
+	}
+}
+
+// prune forgets finished jobs past the retention period
+func (m *Manager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, e := range m.jobs {
+		if e.job.FinishedAt != nil && time.Since(*e.job.FinishedAt) > m.retention {
+			delete(m.jobs, id)
+			if m.store != nil {
+				m.delete(id)
+			}
+		}
+	}
+}
+
+// Get returns a snapshot of a job
+func (m *Manager) Get(id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job, nil
+}
+
+// List returns snapshots of every job, newest first
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]Job, 0, len(m.jobs))
+	for _, e := range m.jobs {
+		list = append(list, e.job)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Watch sends a snapshot of a job now and after every change, until the
+// job finishes or ctx is cancelled. Changes that arrive faster than the
+// receiver takes them are merged.
+func (m *Manager) Watch(ctx context.Context, id string) (<-chan Job, error) {
+	m.mu.Lock()
+	_, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	updates := make(chan Job)
+	go func() {
+		defer close(updates)
+		for {
+			m.mu.Lock()
+			e, ok := m.jobs[id]
+			if !ok {
+				m.mu.Unlock()
+				return
+			}
+			job, changed := e.job, e.changed
+			m.mu.Unlock()
+
+			select {
+			case updates <- job:
+			case <-ctx.Done():
+				return
+			}
+			if job.Status.Done() {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return updates, nil
+}
+
+// Cancel stops a queued or running job. The job's function decides what
+// is kept, e.g. the state of a transfer so it can be resumed.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if e.job.Status.Done() {
+		return ErrFinished
+	}
+	e.cancel(ErrCanceled)
+	return nil
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
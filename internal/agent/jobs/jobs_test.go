@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// stopJob starts a job that waits to be stopped, stops it with stop, and
+// returns whether the job saw a client cancel it
+func stopJob(t *testing.T, m *Manager, stop func(id string)) bool {
+	t.Helper()
+	started := make(chan struct{})
+	canceled := make(chan bool, 1)
+	job, err := m.Start("test", "wait", nil, func(ctx context.Context, update func(Progress)) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		canceled <- Canceled(ctx)
+		return nil, ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+
+	<-started
+	stop(job.ID)
+	select {
+	case c := <-canceled:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not stop")
+		return false
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	m := NewManager(context.Background(), Options{})
+	if !stopJob(t, m, func(id string) { m.Cancel(id) }) {
+		t.Error("a job cancelled by a client did not see it")
+	}
+
+	ctx, shutdown := context.WithCancel(context.Background())
+	m = NewManager(ctx, Options{})
+	if stopJob(t, m, func(string) { shutdown() }) {
+		t.Error("a job stopped by the agent stopping saw a client cancel")
+	}
+}
+
+// finalJob waits for a job to finish and returns it
+func finalJob(t *testing.T, m *Manager, id string) Job {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	updates, err := m.Watch(ctx, id)
+	if err != nil {
+		t.Fatalf("watch: %v", err)
+	}
+	var job Job
+	for job = range updates {
+	}
+	if !job.Status.Done() {
+		t.Fatalf("job did not finish: %+v", job)
+	}
+	return job
+}
+
+func TestFailedJobIsNotCanceled(t *testing.T) {
+	m := NewManager(context.Background(), Options{})
+	job, err := m.Start("test", "fail", nil, func(ctx context.Context, update func(Progress)) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	if job = finalJob(t, m, job.ID); job.Status != StatusFailed || job.Error != "boom" {
+		t.Fatalf("job ended %s (%q), want failed (boom)", job.Status, job.Error)
+	}
+
+	started := make(chan struct{})
+	job, err = m.Start("test", "wait", nil, func(ctx context.Context, update func(Progress)) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	<-started
+	m.Cancel(job.ID)
+	if job = finalJob(t, m, job.ID); job.Status != StatusCanceled {
+		t.Fatalf("canceled job ended %s", job.Status)
+	}
+}
@@ -9,10 +9,13 @@ import (
 	"syscall"
 )
 
-// LockFile represents a PID-based lock file
+// LockFile represents a PID-based lock file. The first line holds the PID;
+// later lines hold key=value details about the running agent, such as the
+// Unix socket it listens on.
 type LockFile struct {
-	path string
-	pid  int
+	path   string
+	pid    int
+	socket string
 }
 
 // New creates a new lock file at the specified path
@@ -23,6 +26,12 @@ func New(path string) *LockFile {
 	}
 }
 
+// SetSocket records the agent's Unix socket path in the lock file. Call it
+// before Acquire.
+func (l *LockFile) SetSocket(path string) {
+	l.socket = path
+}
+
 // Acquire attempts to acquire the lock
 // Returns an error if the lock is already held by another running process
 func (l *LockFile) Acquire() error {
@@ -34,8 +43,7 @@ func (l *LockFile) Acquire() error {
 			return fmt.Errorf("read existing lock file: %w", err)
 		}
 
-		pidStr := strings.TrimSpace(string(data))
-		existingPID, err := strconv.Atoi(pidStr)
+		existingPID, _, err := parseLock(data)
 		if err != nil {
 			// Invalid PID in lock file - consider it stale
 			if err := os.Remove(l.path); err != nil {
@@ -59,9 +67,12 @@ func (l *LockFile) Acquire() error {
 		return fmt.Errorf("create lock directory: %w", err)
 	}
 
-	// Write our PID to the lock file
-	pidStr := fmt.Sprintf("%d\n", l.pid)
-	if err := os.WriteFile(l.path, []byte(pidStr), 0600); err != nil {
+	// Write our PID (and socket, if any) to the lock file
+	contents := fmt.Sprintf("%d\n", l.pid)
+	if l.socket != "" {
+		contents += fmt.Sprintf("socket=%s\n", l.socket)
+	}
+	if err := os.WriteFile(l.path, []byte(contents), 0600); err != nil {
 		return fmt.Errorf("write lock file: %w", err)
 	}
 
@@ -80,8 +91,7 @@ func (l *LockFile) Release() error {
 		return fmt.Errorf("read lock file: %w", err)
 	}
 
-	pidStr := strings.TrimSpace(string(data))
-	lockPID, err := strconv.Atoi(pidStr)
+	lockPID, _, err := parseLock(data)
 	if err != nil {
 		// Invalid PID - remove the file anyway
 		return os.Remove(l.path)
@@ -97,18 +107,7 @@ func (l *LockFile) Release() error {
 
 // IsLocked checks if a valid lock is currently held
 func IsLocked(path string) bool {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return false
-	}
-
-	pidStr := strings.TrimSpace(string(data))
-	pid, err := strconv.Atoi(pidStr)
-	if err != nil {
-		return false
-	}
-
-	return processExists(pid)
+	return GetLockedPID(path) != 0
 }
 
 // GetLockedPID returns the PID that holds the lock, or 0 if not locked
@@ -118,8 +117,7 @@ func GetLockedPID(path string) int {
 		return 0
 	}
 
-	pidStr := strings.TrimSpace(string(data))
-	pid, err := strconv.Atoi(pidStr)
+	pid, _, err := parseLock(data)
 	if err != nil {
 		return 0
 	}
@@ -131,6 +129,38 @@ func GetLockedPID(path string) int {
 	return 0
 }
 
+// GetSocketPath returns the Unix socket recorded by the lock holder, or ""
+// if the lock is not held or the agent has no socket
+func GetSocketPath(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+
+	pid, socket, err := parseLock(data)
+	if err != nil || !processExists(pid) {
+		return ""
+	}
+	return socket
+}
+
+// parseLock parses lock file contents into the PID and socket path
+func parseLock(data []byte) (pid int, socket string, err error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	pid, err = strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid PID in lock file: %w", err)
+	}
+
+	for _, line := range lines[1:] {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "socket="); ok {
+			socket = value
+		}
+	}
+	return pid, socket, nil
+}
+
 // processExists checks if a process with the given PID exists
 func processExists(pid int) bool {
 	// Send signal 0 to check if process exists
@@ -0,0 +1,343 @@
+// Package outbox delivers the agent's audit events to the backend without
+// losing any. An event is written to the agent store before the operation
+// it describes is reported, and a background worker sends stored events
+// to the backend in order, retrying with backoff while it is unreachable.
+//
+// Events are sent in batches. Delivery is at least once: an event can be
+// sent again if the agent stops between the backend storing it and the
+// event leaving the outbox. Each event carries an event_id the backend uses
+// to drop such duplicates.
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/store"
+)
+
+// Delivery tuning
+const (
+	// BatchSize bounds the events read from the store per delivery round
+	BatchSize = 100
+
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+
+	// pollInterval is how often the worker looks at the outbox when no new
+	// event wakes it
+	pollInterval = time.Minute
+)
+
+// Outbox records audit events and delivers them to the backend
+type Outbox struct {
+	store      *store.Store
+	backendURL func() string
+	client     *http.Client
+	wake       chan struct{}
+}
+
+// New creates an outbox on st. backendURL is consulted on every delivery
+// round, so a changed backend takes effect without a restart.
+func New(st *store.Store, backendURL func() string) *Outbox {
+	return &Outbox{
+		store:      st,
+		backendURL: backendURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Record stores an audit event for delivery, assigning its event_id and,
+// unless set, its occurred_at. The event is on disk when Record returns.
+func (o *Outbox) Record(event map[string]interface{}) error {
+	id, err := newEventID()
+	if err != nil {
+		return err
+	}
+	event["event_id"] = id
+	if _, ok := event["occurred_at"]; !ok {
+		event["occurred_at"] = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal audit event: %w", err)
+	}
+	if err := o.store.AppendAuditEvent(data); err != nil {
+		return fmt.Errorf("store audit event: %w", err)
+	}
+
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers events until ctx is cancelled. Events left from before the
+// agent started are delivered first.
+func (o *Outbox) Run(ctx context.Context) {
+	backoff := time.Duration(0)
+	for {
+		handled, err := o.flush(ctx)
+		switch {
+		case err != nil:
+			if backoff == 0 {
+				backoff = minBackoff
+			} else {
+				backoff = min(backoff*2, maxBackoff)
+			}
+			queued, _, _ := o.store.AuditBacklog()
+			slog.Warn("audit events not delivered, will retry", "error", err, "queued", queued, "retry_in", backoff.String())
+		case handled > 0:
+			backoff = 0
+			// There may be more
+			continue
+		default:
+			backoff = 0
+		}
+
+		wait := pollInterval
+		if backoff > 0 {
+			wait = backoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-o.wake:
+			// A new event does not cut a backoff short
+			if backoff > 0 {
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// flush sends one batch of events and removes the ones the backend stored
+// or rejected, returning how many. It stops at the first event that could
+// not be delivered, so events are not reordered; any after it that were
+// stored come back as duplicates when sent again.
+func (o *Outbox) flush(ctx context.Context) (int, error) {
+	events, err := o.store.QueuedAuditEvents(BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("read outbox: %w", err)
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	results, err := o.sendBatch(ctx, events)
+	if errors.Is(err, errBatchUnsupported) {
+		return o.flushEach(ctx, events)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var delivered []uint64
+	var rejectedCount int
+	var sendErr error
+	for i, event := range events {
+		result := results[i]
+		if result.Status == statusStored || result.Status == statusDuplicate {
+			delivered = append(delivered, event.Seq)
+			continue
+		}
+		if result.Status == statusRejected {
+			if err := o.reject(event, fmt.Errorf("backend rejected event: %s", result.Error)); err != nil {
+				sendErr = err
+				break
+			}
+			rejectedCount++
+			continue
+		}
+		sendErr = fmt.Errorf("backend did not store event: %s", result.Error)
+		break
+	}
+
+	if err := o.remove(delivered); err != nil {
+		return rejectedCount, err
+	}
+	return len(delivered) + rejectedCount, sendErr
+}
+
+// flushEach sends events one at a time, for a backend without batch
+// ingestion or a batch it refused as a whole. It stops at the first event
+// that could not be delivered.
+func (o *Outbox) flushEach(ctx context.Context, events []store.QueuedAuditEvent) (int, error) {
+	var delivered []uint64
+	var rejectedCount int
+	var sendErr error
+	for _, event := range events {
+		err := o.send(ctx, event.Event)
+		var rejected *rejectedError
+		if errors.As(err, &rejected) {
+			if err := o.reject(event, err); err != nil {
+				sendErr = err
+				break
+			}
+			rejectedCount++
+			continue
+		}
+		if err != nil {
+			sendErr = err
+			break
+		}
+		delivered = append(delivered, event.Seq)
+	}
+
+	if err := o.remove(delivered); err != nil {
+		return rejectedCount, err
+	}
+	return len(delivered) + rejectedCount, sendErr
+}
+
+// reject sets aside an event the backend will not take. Retrying would not
+// help, and it would hold up the rest.
+func (o *Outbox) reject(event store.QueuedAuditEvent, reason error) error {
+	slog.Error("backend rejected audit event", "error", reason, "event", string(event.Event))
+	if err := o.store.RejectAuditEvent(event.Seq, reason.Error()); err != nil {
+		return fmt.Errorf("set aside rejected event: %w", err)
+	}
+	return nil
+}
+
+// remove deletes delivered events from the outbox
+func (o *Outbox) remove(delivered []uint64) error {
+	if len(delivered) == 0 {
+		return nil
+	}
+	if err := o.store.DeleteAuditEvents(delivered...); err != nil {
+		return fmt.Errorf("remove delivered events: %w", err)
+	}
+	slog.Debug("audit events delivered", "count", len(delivered))
+	return nil
+}
+
+// Outcomes the backend reports for each event of a batch
+const (
+	statusStored    = "stored"
+	statusDuplicate = "duplicate"
+	statusRejected  = "rejected"
+)
+
+// batchResult is the backend's outcome for one event of a batch
+type batchResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+// errBatchUnsupported means the events must be sent one at a time
+var errBatchUnsupported = errors.New("batch not accepted")
+
+// sendBatch delivers events in one request and returns the outcome of each
+func (o *Outbox) sendBatch(ctx context.Context, events []store.QueuedAuditEvent) ([]batchResult, error) {
+	entries := make([]json.RawMessage, len(events))
+	for i, event := range events {
+		entries[i] = event.Event
+	}
+	data, err := json.Marshal(map[string]interface{}{"entries": entries})
+	if err != nil {
+		return nil, fmt.Errorf("marshal audit batch: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.backendURL()+"/api/audit/batch", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send audit events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		// A backend from before batch ingestion
+		return nil, errBatchUnsupported
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		// Sent one at a time, the events at fault are set aside on their
+		// own
+		return nil, errBatchUnsupported
+	default:
+		return nil, fmt.Errorf("backend returned %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Results []batchResult `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode audit batch response: %w", err)
+	}
+	if len(body.Results) != len(events) {
+		return nil, fmt.Errorf("backend returned %d results for %d events", len(body.Results), len(events))
+	}
+	return body.Results, nil
+}
+
+// rejectedError is a response saying the event itself is at fault
+type rejectedError struct {
+	status int
+	body   string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("backend returned %d: %s", e.status, e.body)
+}
+
+// send delivers one event
+func (o *Outbox) send(ctx context.Context, event json.RawMessage) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.backendURL()+"/api/audit/log", bytes.NewReader(event))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send audit event: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	// Only responses about the event itself set it aside; anything else,
+	// e.g. a proxy's 403 or 404, may clear up
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return &rejectedError{status: resp.StatusCode, body: string(bytes.TrimSpace(body))}
+	default:
+		return fmt.Errorf("backend returned %d", resp.StatusCode)
+	}
+}
+
+// newEventID returns a random event ID
+func newEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate event ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
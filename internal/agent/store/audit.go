@@ -0,0 +1,112 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// QueuedAuditEvent is an audit event in the outbox
+type QueuedAuditEvent struct {
+	Seq   uint64
+	Event json.RawMessage
+}
+
+// rejectedAuditEvent is an audit event the backend refused, kept for
+// inspection
+type rejectedAuditEvent struct {
+	Event      json.RawMessage `json:"event"`
+	Error      string          `json:"error"`
+	RejectedAt time.Time       `json:"rejected_at"`
+}
+
+// AppendAuditEvent adds an audit event to the end of the outbox. The event
+// is on disk when it returns.
+func (s *Store) AppendAuditEvent(event json.RawMessage) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(AuditOutboxBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return fmt.Errorf("next audit sequence: %w", err)
+		}
+		return b.Put(seqKey(seq), event)
+	})
+}
+
+// QueuedAuditEvents returns up to limit events from the front of the
+// outbox, oldest first
+func (s *Store) QueuedAuditEvents(limit int) ([]QueuedAuditEvent, error) {
+	var events []QueuedAuditEvent
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(AuditOutboxBucket).Cursor()
+		for k, v := c.First(); k != nil && len(events) < limit; k, v = c.Next() {
+			events = append(events, QueuedAuditEvent{
+				Seq:   binary.BigEndian.Uint64(k),
+				Event: append(json.RawMessage(nil), v...),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// DeleteAuditEvents removes delivered events from the outbox
+func (s *Store) DeleteAuditEvents(seqs ...uint64) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(AuditOutboxBucket)
+		for _, seq := range seqs {
+			if err := b.Delete(seqKey(seq)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RejectAuditEvent moves an event the backend refused out of the outbox,
+// keeping it with the reason so it is not lost
+func (s *Store) RejectAuditEvent(seq uint64, reason string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		outbox := tx.Bucket(AuditOutboxBucket)
+		event := outbox.Get(seqKey(seq))
+		if event == nil {
+			return nil
+		}
+		data, err := json.Marshal(rejectedAuditEvent{
+			Event:      append(json.RawMessage(nil), event...),
+			Error:      reason,
+			RejectedAt: time.Now().UTC(),
+		})
+		if err != nil {
+			return fmt.Errorf("marshal rejected audit event: %w", err)
+		}
+		if err := tx.Bucket(AuditRejectedBucket).Put(seqKey(seq), data); err != nil {
+			return err
+		}
+		return outbox.Delete(seqKey(seq))
+	})
+}
+
+// AuditBacklog returns the number of events waiting in the outbox and the
+// number the backend rejected
+func (s *Store) AuditBacklog() (queued, rejected int, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		queued = tx.Bucket(AuditOutboxBucket).Stats().KeyN
+		rejected = tx.Bucket(AuditRejectedBucket).Stats().KeyN
+		return nil
+	})
+	return queued, rejected, err
+}
+
+// seqKey encodes a sequence number so that keys sort in order
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
@@ -0,0 +1,509 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.etcd.io/bbolt"
+)
+
+// Credential backend names
+const (
+	BackendBolt    = "bolt"    // encrypted records in agent.db (default)
+	BackendKeyring = "keyring" // OS keyring (Linux kernel keyring), backed up in agent.db
+	BackendFile    = "file"    // encrypted files, a keyring stand-in for headless systems
+)
+
+// CredentialBackend persists credential profiles
+type CredentialBackend interface {
+	Name() string
+	Set(profile string, creds Credentials) error
+	Get(profile string) (*Credentials, error)
+	List() (map[string]*Credentials, error)
+	Delete(profile string) error
+}
+
+// Keyring is a minimal secret store keyed by name. Get returns an error
+// wrapping errKeyNotFound for a key it does not hold.
+type Keyring interface {
+	Set(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	Keys() ([]string, error)
+}
+
+// errKeyNotFound is returned by a Keyring for a key it does not hold
+var errKeyNotFound = errors.New("key not found")
+
+// keyringPrefix namespaces Ark entries in shared keyrings
+const keyringPrefix = "ark:"
+
+// OpenBackend returns the named credential backend
+func (s *Store) OpenBackend(name string) (CredentialBackend, error) {
+	switch name {
+	case "", BackendBolt:
+		return &boltBackend{s: s, bucket: CredentialsBucket}, nil
+	case BackendKeyring:
+		kr, err := openSystemKeyring()
+		if err != nil {
+			return nil, fmt.Errorf("open system keyring: %w", err)
+		}
+		return s.newKeyringBackend(kr), nil
+	case BackendFile:
+		dir := filepath.Join(s.dataDir, "credentials")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("create credentials directory: %w", err)
+		}
+		return s.newFileBackend(&fileKeyring{dir: dir})
+	default:
+		return nil, fmt.Errorf("unknown credential backend: %s", name)
+	}
+}
+
+// newKeyringBackend returns the keyring backend on kr. The kernel keyring
+// is emptied on reboot, so every profile is also kept, encrypted, in the
+// keyring backup bucket and restored from there when the keyring has lost
+// it.
+func (s *Store) newKeyringBackend(kr Keyring) *keyringBackend {
+	return &keyringBackend{
+		name:   BackendKeyring,
+		kr:     kr,
+		backup: &boltBackend{s: s, bucket: KeyringBackupBucket},
+	}
+}
+
+// newFileBackend returns the file backend on kr. Entries are sealed with a
+// data key wrapped by the store key, and entries written in plaintext by
+// older versions are sealed in place.
+func (s *Store) newFileBackend(kr Keyring) (*keyringBackend, error) {
+	key, err := s.fileBackendKey()
+	if err != nil {
+		return nil, err
+	}
+	sealed := &sealedKeyring{kr: kr, key: key}
+	if err := sealed.sealPlaintext(); err != nil {
+		return nil, fmt.Errorf("encrypt credential files: %w", err)
+	}
+	return &keyringBackend{name: BackendFile, kr: sealed}, nil
+}
+
+// Backend returns the active credential backend
+func (s *Store) Backend() CredentialBackend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.creds
+}
+
+// SetBackend switches the active credential backend
+func (s *Store) SetBackend(b CredentialBackend) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = b
+}
+
+// CleanupError is returned by MigrateCredentials when every profile reached
+// the destination but some could not be removed from the source. The
+// migration itself succeeded.
+type CleanupError struct {
+	Backend  string
+	Profiles []string
+	Err      error
+}
+
+func (e *CleanupError) Error() string {
+	return fmt.Sprintf("%d profile(s) left in %s: %v", len(e.Profiles), e.Backend, e.Err)
+}
+
+func (e *CleanupError) Unwrap() error {
+	return e.Err
+}
+
+// MigrateCredentials copies every profile from one backend to another and
+// then removes it from the source. Profiles are removed from the source only
+// after all of them have been written to the destination; if a write fails,
+// the copies already written are removed and the source is left as it was.
+func MigrateCredentials(from, to CredentialBackend) (int, error) {
+	if from.Name() == to.Name() {
+		return 0, fmt.Errorf("source and destination backends are both %s", from.Name())
+	}
+
+	profiles, err := from.List()
+	if err != nil {
+		return 0, fmt.Errorf("list %s credentials: %w", from.Name(), err)
+	}
+
+	names := make([]string, 0, len(profiles))
+	for profile := range profiles {
+		names = append(names, profile)
+	}
+	sort.Strings(names)
+
+	for i, profile := range names {
+		if err := to.Set(profile, *profiles[profile]); err != nil {
+			for _, written := range names[:i] {
+				to.Delete(written)
+			}
+			return 0, fmt.Errorf("write %s to %s: %w", profile, to.Name(), err)
+		}
+	}
+
+	var left []string
+	var errs []error
+	for _, profile := range names {
+		if err := from.Delete(profile); err != nil {
+			left = append(left, profile)
+			errs = append(errs, fmt.Errorf("%s: %w", profile, err))
+		}
+	}
+	if len(left) > 0 {
+		return len(names), &CleanupError{Backend: from.Name(), Profiles: left, Err: errors.Join(errs...)}
+	}
+
+	return len(names), nil
+}
+
+// boltBackend stores envelope-encrypted records in a bucket: the
+// credentials bucket, or the keyring backup bucket
+type boltBackend struct {
+	s      *Store
+	bucket []byte
+}
+
+func (b *boltBackend) Name() string { return BackendBolt }
+
+func (b *boltBackend) Set(profile string, creds Credentials) error {
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("marshal credentials: %w", err)
+	}
+
+	b.s.keyMu.RLock()
+	defer b.s.keyMu.RUnlock()
+	data, err := b.s.encryptRecord([]byte(profile), plaintext)
+	if err != nil {
+		return fmt.Errorf("encrypt credentials: %w", err)
+	}
+
+	return b.s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(b.bucket).Put([]byte(profile), data)
+	})
+}
+
+func (b *boltBackend) Get(profile string) (*Credentials, error) {
+	b.s.keyMu.RLock()
+	defer b.s.keyMu.RUnlock()
+	var creds Credentials
+	err := b.s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(b.bucket).Get([]byte(profile))
+		if data == nil {
+			return fmt.Errorf("%w: %s", ErrProfileNotFound, profile)
+		}
+		plaintext, err := b.s.decryptRecord([]byte(profile), data)
+		if err != nil {
+			return fmt.Errorf("decrypt credentials for %s: %w", profile, err)
+		}
+		return json.Unmarshal(plaintext, &creds)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+func (b *boltBackend) List() (map[string]*Credentials, error) {
+	profiles := make(map[string]*Credentials)
+
+	b.s.keyMu.RLock()
+	defer b.s.keyMu.RUnlock()
+	err := b.s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(b.bucket).Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			plaintext, err := b.s.decryptRecord(k, v)
+			if err != nil {
+				return fmt.Errorf("decrypt credentials for %s: %w", k, err)
+			}
+			var creds Credentials
+			if err := json.Unmarshal(plaintext, &creds); err != nil {
+				return fmt.Errorf("unmarshal credentials for %s: %w", k, err)
+			}
+			profiles[string(k)] = &creds
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+func (b *boltBackend) Delete(profile string) error {
+	return b.s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(b.bucket).Delete([]byte(profile))
+	})
+}
+
+// keyringBackend stores JSON records in a Keyring, relying on the keyring
+// itself for protection. With a backup, every record is also written there
+// and the backup stands in for the keyring when an entry has gone missing.
+type keyringBackend struct {
+	name   string
+	kr     Keyring
+	backup CredentialBackend
+}
+
+func (b *keyringBackend) Name() string { return b.name }
+
+func (b *keyringBackend) Set(profile string, creds Credentials) error {
+	data, err := json.Marshal(creds)
+	if err != nil {
+		return fmt.Errorf("marshal credentials: %w", err)
+	}
+	// The backup goes first: a profile must never exist only in the keyring
+	if b.backup != nil {
+		if err := b.backup.Set(profile, creds); err != nil {
+			return fmt.Errorf("back up credentials: %w", err)
+		}
+	}
+	return b.kr.Set(keyringPrefix+profile, data)
+}
+
+func (b *keyringBackend) Get(profile string) (*Credentials, error) {
+	// Only a missing entry is restored; one that cannot be read or
+	// decrypted is an error, not a profile to report as not found
+	data, err := b.kr.Get(keyringPrefix + profile)
+	if errors.Is(err, errKeyNotFound) {
+		return b.restore(profile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read credentials for %s: %w", profile, err)
+	}
+
+	var creds Credentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("unmarshal credentials for %s: %w", profile, err)
+	}
+	return &creds, nil
+}
+
+func (b *keyringBackend) List() (map[string]*Credentials, error) {
+	keys, err := b.kr.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("list keyring: %w", err)
+	}
+
+	profiles := make(map[string]*Credentials)
+	if b.backup != nil {
+		if profiles, err = b.backup.List(); err != nil {
+			return nil, fmt.Errorf("list credential backup: %w", err)
+		}
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, keyringPrefix) {
+			continue
+		}
+		profile := strings.TrimPrefix(key, keyringPrefix)
+		creds, err := b.Get(profile)
+		if err != nil {
+			return nil, err
+		}
+		profiles[profile] = creds
+	}
+	return profiles, nil
+}
+
+func (b *keyringBackend) Delete(profile string) error {
+	if err := b.kr.Delete(keyringPrefix + profile); err != nil {
+		return err
+	}
+	if b.backup != nil {
+		return b.backup.Delete(profile)
+	}
+	return nil
+}
+
+// restore returns a profile the keyring does not hold from the backup, and
+// puts it back in the keyring
+func (b *keyringBackend) restore(profile string) (*Credentials, error) {
+	if b.backup == nil {
+		return nil, fmt.Errorf("%w: %s", ErrProfileNotFound, profile)
+	}
+	creds, err := b.backup.Get(profile)
+	if err != nil {
+		return nil, err
+	}
+	if data, err := json.Marshal(creds); err == nil {
+		// Best effort: the backup answers until the keyring takes it
+		b.kr.Set(keyringPrefix+profile, data)
+	}
+	return creds, nil
+}
+
+// fileKeyName is the ConfigBucket key holding the file backend's data key,
+// wrapped by the store key
+var fileKeyName = []byte("file_backend_key")
+
+// fileBackendKey returns the data key of the file backend, creating it on
+// first use. It is wrapped like a credential record, so a rekey re-wraps it
+// along with them.
+func (s *Store) fileBackendKey() ([]byte, error) {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	var key []byte
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(ConfigBucket)
+		if data := b.Get(fileKeyName); data != nil {
+			var err error
+			key, err = s.decryptRecord(fileKeyName, data)
+			return err
+		}
+
+		key = make([]byte, keySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return fmt.Errorf("generate key: %w", err)
+		}
+		data, err := s.encryptRecord(fileKeyName, key)
+		if err != nil {
+			return err
+		}
+		return b.Put(fileKeyName, data)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("file backend key: %w", err)
+	}
+	return key, nil
+}
+
+// sealedEntry is the stored form of a sealedKeyring entry
+type sealedEntry struct {
+	Version    int    `json:"v"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// sealedKeyring encrypts the entries of a Keyring that offers no protection
+// of its own. Each entry is bound to its key.
+type sealedKeyring struct {
+	kr  Keyring
+	key []byte
+}
+
+func (k *sealedKeyring) Set(key string, data []byte) error {
+	ciphertext, err := seal(k.key, data, []byte(key))
+	if err != nil {
+		return fmt.Errorf("encrypt %s: %w", key, err)
+	}
+	sealed, err := json.Marshal(sealedEntry{Version: envelopeVersion, Ciphertext: ciphertext})
+	if err != nil {
+		return err
+	}
+	return k.kr.Set(key, sealed)
+}
+
+func (k *sealedKeyring) Get(key string) ([]byte, error) {
+	data, err := k.kr.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var entry sealedEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Version == 0 {
+		return nil, fmt.Errorf("%s is not encrypted", key)
+	}
+	plaintext, err := open(k.key, entry.Ciphertext, []byte(key))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return plaintext, nil
+}
+
+func (k *sealedKeyring) Delete(key string) error {
+	return k.kr.Delete(key)
+}
+
+func (k *sealedKeyring) Keys() ([]string, error) {
+	return k.kr.Keys()
+}
+
+// sealPlaintext encrypts entries written before entries were sealed
+func (k *sealedKeyring) sealPlaintext() error {
+	keys, err := k.kr.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		data, err := k.kr.Get(key)
+		if err != nil {
+			return err
+		}
+		var entry sealedEntry
+		if json.Unmarshal(data, &entry) == nil && entry.Version > 0 {
+			continue
+		}
+		if err := k.Set(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileKeyring keeps one 0600 file per entry in a private directory, so the
+// keyring backend can be used on hosts without an OS keyring. It offers no
+// protection beyond file permissions; the file backend seals its entries
+// before they get here.
+type fileKeyring struct {
+	dir string
+}
+
+func (f *fileKeyring) path(key string) string {
+	// Keys are profile names; escape path separators defensively
+	return filepath.Join(f.dir, strings.NewReplacer("/", "%2F", "\\", "%5C").Replace(key)+".json")
+}
+
+func (f *fileKeyring) Set(key string, data []byte) error {
+	tmp := f.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write %s: %w", key, err)
+	}
+	return os.Rename(tmp, f.path(key))
+}
+
+func (f *fileKeyring) Get(key string) ([]byte, error) {
+	data, err := os.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", errKeyNotFound, key)
+	}
+	return data, err
+}
+
+func (f *fileKeyring) Delete(key string) error {
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *fileKeyring) Keys() ([]string, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	unescape := strings.NewReplacer("%2F", "/", "%5C", "\\")
+	var keys []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		keys = append(keys, unescape.Replace(strings.TrimSuffix(name, ".json")))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
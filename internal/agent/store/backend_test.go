@@ -0,0 +1,337 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// memKeyring is an in-memory Keyring; clearing it stands in for a reboot
+type memKeyring struct {
+	entries map[string][]byte
+}
+
+func newMemKeyring() *memKeyring {
+	return &memKeyring{entries: make(map[string][]byte)}
+}
+
+func (m *memKeyring) Set(key string, data []byte) error {
+	m.entries[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memKeyring) Get(key string) ([]byte, error) {
+	data, ok := m.entries[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errKeyNotFound, key)
+	}
+	return data, nil
+}
+
+func (m *memKeyring) Delete(key string) error {
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *memKeyring) Keys() ([]string, error) {
+	var keys []string
+	for k := range m.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// failingBackend wraps a backend, failing Set or Delete for one profile
+type failingBackend struct {
+	CredentialBackend
+	failSet    string
+	failDelete string
+}
+
+func (f *failingBackend) Set(profile string, creds Credentials) error {
+	if profile == f.failSet {
+		return errors.New("injected write failure")
+	}
+	return f.CredentialBackend.Set(profile, creds)
+}
+
+func (f *failingBackend) Delete(profile string) error {
+	if profile == f.failDelete {
+		return errors.New("injected delete failure")
+	}
+	return f.CredentialBackend.Delete(profile)
+}
+
+func testProfiles() map[string]Credentials {
+	return map[string]Credentials{
+		"default":  {AccessKeyID: "AKIADEFAULT", SecretAccessKey: "secret-default", Region: "us-east-1"},
+		"research": {AccessKeyID: "AKIARESEARCH", SecretAccessKey: "secret-research"},
+		"team/lab": {RoleARN: "arn:aws:iam::123456789012:role/lab", SourceProfile: "default"},
+	}
+}
+
+func setProfiles(t *testing.T, b CredentialBackend) {
+	t.Helper()
+	for name, creds := range testProfiles() {
+		if err := b.Set(name, creds); err != nil {
+			t.Fatalf("%s: set %s: %v", b.Name(), name, err)
+		}
+	}
+}
+
+// checkProfiles fails unless b holds exactly the test profiles
+func checkProfiles(t *testing.T, b CredentialBackend) {
+	t.Helper()
+	want := testProfiles()
+	got, err := b.List()
+	if err != nil {
+		t.Fatalf("%s: list: %v", b.Name(), err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("%s: list returned %d profiles, want %d", b.Name(), len(got), len(want))
+	}
+	for name, creds := range want {
+		stored, err := b.Get(name)
+		if err != nil {
+			t.Fatalf("%s: get %s: %v", b.Name(), name, err)
+		}
+		if *stored != creds {
+			t.Errorf("%s: %s = %+v, want %+v", b.Name(), name, *stored, creds)
+		}
+	}
+}
+
+func TestBackendRoundTrip(t *testing.T) {
+	s := newTestStore(t, Options{})
+	file, err := s.OpenBackend(BackendFile)
+	if err != nil {
+		t.Fatalf("open file backend: %v", err)
+	}
+	backends := []CredentialBackend{
+		s.Backend(),
+		s.newKeyringBackend(newMemKeyring()),
+		file,
+	}
+
+	for _, b := range backends {
+		t.Run(b.Name(), func(t *testing.T) {
+			setProfiles(t, b)
+			checkProfiles(t, b)
+
+			if err := b.Delete("research"); err != nil {
+				t.Fatalf("delete: %v", err)
+			}
+			if _, err := b.Get("research"); !errors.Is(err, ErrProfileNotFound) {
+				t.Fatalf("get deleted profile: got %v, want ErrProfileNotFound", err)
+			}
+			profiles, err := b.List()
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			if _, ok := profiles["research"]; ok || len(profiles) != 2 {
+				t.Fatalf("list after delete = %v", profiles)
+			}
+		})
+	}
+}
+
+func TestSystemKeyring(t *testing.T) {
+	kr, err := openSystemKeyring()
+	if err != nil {
+		t.Skipf("no system keyring: %v", err)
+	}
+
+	key := fmt.Sprintf("ark-test:%d", os.Getpid())
+	t.Cleanup(func() { kr.Delete(key) })
+	if err := kr.Set(key, []byte("payload")); err != nil {
+		t.Skipf("system keyring not writable: %v", err)
+	}
+
+	data, err := kr.Get(key)
+	if err != nil || string(data) != "payload" {
+		t.Fatalf("get = %q, %v", data, err)
+	}
+	keys, err := kr.Keys()
+	if err != nil {
+		t.Fatalf("keys: %v", err)
+	}
+	found := false
+	for _, k := range keys {
+		found = found || k == key
+	}
+	if !found {
+		t.Fatalf("keys %v do not include %s", keys, key)
+	}
+	if err := kr.Delete(key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := kr.Get(key); err == nil {
+		t.Fatal("key still present after delete")
+	}
+}
+
+func TestKeyringBackendSurvivesReboot(t *testing.T) {
+	s := newTestStore(t, Options{})
+	kr := newMemKeyring()
+	b := s.newKeyringBackend(kr)
+	setProfiles(t, b)
+
+	// A reboot empties the kernel keyring
+	kr.entries = make(map[string][]byte)
+
+	checkProfiles(t, b)
+	if _, err := kr.Get(keyringPrefix + "default"); err != nil {
+		t.Fatalf("profile not restored to the keyring: %v", err)
+	}
+
+	// The backup goes through a rekey with the rest of the store
+	kr.entries = make(map[string][]byte)
+	if err := s.Rekey(""); err != nil {
+		t.Fatalf("rekey: %v", err)
+	}
+	checkProfiles(t, b)
+}
+
+func TestFileBackendEncryptsEntries(t *testing.T) {
+	s := newTestStore(t, Options{})
+	dir := filepath.Join(s.dataDir, "credentials")
+
+	// A profile written in plaintext by an older version
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	legacy := `{"access_key_id":"AKIALEGACY","secret_access_key":"secret-legacy"}`
+	if err := os.WriteFile(filepath.Join(dir, keyringPrefix+"legacy.json"), []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := s.OpenBackend(BackendFile)
+	if err != nil {
+		t.Fatalf("open file backend: %v", err)
+	}
+	setProfiles(t, b)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "secret-") {
+			t.Errorf("%s holds a plaintext secret: %s", e.Name(), data)
+		}
+	}
+
+	if err := s.Rekey("new passphrase"); err != nil {
+		t.Fatalf("rekey: %v", err)
+	}
+	creds, err := b.Get("legacy")
+	if err != nil || creds.SecretAccessKey != "secret-legacy" {
+		t.Fatalf("legacy profile after rekey = %+v, %v", creds, err)
+	}
+	reopened, err := s.OpenBackend(BackendFile)
+	if err != nil {
+		t.Fatalf("reopen file backend: %v", err)
+	}
+	if _, err := reopened.Get("default"); err != nil {
+		t.Fatalf("get after rekey: %v", err)
+	}
+}
+
+func TestMigrateCredentials(t *testing.T) {
+	s := newTestStore(t, Options{})
+	bolt := s.Backend()
+	file, err := s.OpenBackend(BackendFile)
+	if err != nil {
+		t.Fatalf("open file backend: %v", err)
+	}
+	keyring := s.newKeyringBackend(newMemKeyring())
+	setProfiles(t, bolt)
+
+	for _, hop := range [][2]CredentialBackend{{bolt, file}, {file, keyring}, {keyring, bolt}} {
+		from, to := hop[0], hop[1]
+		n, err := MigrateCredentials(from, to)
+		if err != nil || n != len(testProfiles()) {
+			t.Fatalf("migrate %s to %s = %d, %v", from.Name(), to.Name(), n, err)
+		}
+		checkProfiles(t, to)
+		if left, _ := from.List(); len(left) != 0 {
+			t.Fatalf("%s still holds %d profiles", from.Name(), len(left))
+		}
+	}
+
+	if _, err := MigrateCredentials(bolt, bolt); err == nil {
+		t.Fatal("migrating a backend to itself succeeded")
+	}
+}
+
+func TestMigrateCredentialsWriteFailure(t *testing.T) {
+	s := newTestStore(t, Options{})
+	from := s.Backend()
+	setProfiles(t, from)
+	kr := newMemKeyring()
+	to := &failingBackend{CredentialBackend: s.newKeyringBackend(kr), failSet: "research"}
+
+	if _, err := MigrateCredentials(from, to); err == nil {
+		t.Fatal("migration succeeded despite a failed write")
+	}
+	checkProfiles(t, from)
+	if left, _ := to.List(); len(left) != 0 {
+		t.Fatalf("destination kept %d partial copies", len(left))
+	}
+}
+
+func TestMigrateCredentialsCleanupFailure(t *testing.T) {
+	s := newTestStore(t, Options{})
+	from := &failingBackend{CredentialBackend: s.Backend(), failDelete: "default"}
+	setProfiles(t, from)
+	to, err := s.OpenBackend(BackendFile)
+	if err != nil {
+		t.Fatalf("open file backend: %v", err)
+	}
+
+	n, err := MigrateCredentials(from, to)
+	var cleanup *CleanupError
+	if !errors.As(err, &cleanup) {
+		t.Fatalf("migrate: got %v, want a CleanupError", err)
+	}
+	if n != len(testProfiles()) || len(cleanup.Profiles) != 1 || cleanup.Profiles[0] != "default" {
+		t.Fatalf("migrate = %d, %+v", n, cleanup)
+	}
+	checkProfiles(t, to)
+}
+
+// Only a missing entry reads as a missing profile; one that cannot be
+// decrypted must not
+func TestKeyringBackendReportsUnreadableEntry(t *testing.T) {
+	s := newTestStore(t, Options{})
+	b, err := s.OpenBackend(BackendFile)
+	if err != nil {
+		t.Fatalf("open file backend: %v", err)
+	}
+	setProfiles(t, b)
+
+	path := filepath.Join(s.dataDir, "credentials", keyringPrefix+"default.json")
+	tampered := `{"v":1,"ciphertext":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}`
+	if err := os.WriteFile(path, []byte(tampered), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get("default"); err == nil || errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("get tampered profile: got %v, want a decryption error", err)
+	}
+	if _, err := b.List(); err == nil {
+		t.Fatal("list with a tampered profile succeeded")
+	}
+
+	if _, err := b.Get("missing"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("get missing profile: got %v, want ErrProfileNotFound", err)
+	}
+}
@@ -0,0 +1,515 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Key sources for the key-encryption key
+const (
+	KeySourceFile       = "keyfile"
+	KeySourcePassphrase = "passphrase"
+)
+
+const (
+	keySize          = 32 // AES-256
+	saltSize         = 16
+	pbkdf2Iterations = 600000
+	envelopeVersion  = 1
+)
+
+// encryptionMetaKey is the ConfigBucket key holding key metadata
+var encryptionMetaKey = []byte("encryption")
+
+// ErrWrongKey is returned when the configured key does not match the key
+// the credential records were encrypted with
+var ErrWrongKey = errors.New("encryption key does not match the credential store (wrong passphrase or key file)")
+
+// Options configures how the store protects credential records
+type Options struct {
+	// KeyFile is the path of the key-encryption key file. It is created
+	// with 0600 permissions when the store is first initialized.
+	KeyFile string
+
+	// Passphrase derives the key-encryption key with PBKDF2 instead of
+	// KeyFile. It is only consulted on first initialization and for
+	// stores that were set up (or rekeyed) with a passphrase.
+	Passphrase string
+
+	// CredentialBackend selects where credential profiles are kept
+	// (BackendBolt, BackendKeyring or BackendFile). Defaults to BackendBolt.
+	CredentialBackend string
+}
+
+// keyMetadata describes the key-encryption key without revealing it
+type keyMetadata struct {
+	Source     string `json:"source"`
+	KeyID      string `json:"key_id"`
+	Salt       []byte `json:"salt,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+}
+
+// envelope is the stored form of an encrypted credential record. The record
+// is sealed with a random per-record data key, and the data key is sealed
+// with the per-install key-encryption key.
+type envelope struct {
+	Version    int    `json:"v"`
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// loadKey resolves the key-encryption key, initializing it on first use
+func (s *Store) loadKey(opts Options) error {
+	var meta *keyMetadata
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(ConfigBucket).Get(encryptionMetaKey)
+		if data == nil {
+			return nil
+		}
+		meta = &keyMetadata{}
+		return json.Unmarshal(data, meta)
+	})
+	if err != nil {
+		return fmt.Errorf("read key metadata: %w", err)
+	}
+
+	s.keyFile = opts.KeyFile
+
+	// First run: create a key from the requested source
+	if meta == nil {
+		kek, newMeta, err := newKey(opts)
+		if err != nil {
+			return err
+		}
+		if newMeta.Source == KeySourceFile {
+			if err := writeKeyFile(opts.KeyFile, kek); err != nil {
+				return err
+			}
+		}
+		if err := s.db.Update(func(tx *bbolt.Tx) error {
+			return putKeyMetadata(tx, newMeta)
+		}); err != nil {
+			return fmt.Errorf("store key metadata: %w", err)
+		}
+		s.kek, s.keyMeta = kek, *newMeta
+		return nil
+	}
+
+	var kek []byte
+	switch meta.Source {
+	case KeySourcePassphrase:
+		if opts.Passphrase == "" {
+			return fmt.Errorf("credential store is protected by a passphrase (set ARK_AGENT_PASSPHRASE)")
+		}
+		kek, err = deriveKey(opts.Passphrase, meta.Salt, meta.Iterations)
+		if err != nil {
+			return err
+		}
+	case KeySourceFile:
+		kek, err = readKeyFile(opts.KeyFile)
+		if err != nil {
+			return err
+		}
+		// A rekey may have been interrupted after the database commit but
+		// before the new key file was moved into place
+		if keyID(kek) != meta.KeyID {
+			if pending, perr := readKeyFile(opts.KeyFile + ".new"); perr == nil && keyID(pending) == meta.KeyID {
+				if err := os.Rename(opts.KeyFile+".new", opts.KeyFile); err != nil {
+					return fmt.Errorf("install pending key file: %w", err)
+				}
+				kek = pending
+			}
+		}
+	default:
+		return fmt.Errorf("unknown key source: %s", meta.Source)
+	}
+
+	if keyID(kek) != meta.KeyID {
+		return ErrWrongKey
+	}
+
+	s.kek, s.keyMeta = kek, *meta
+	return nil
+}
+
+// KeySource reports whether the store key comes from a key file or a passphrase
+func (s *Store) KeySource() string {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	return s.keyMeta.Source
+}
+
+// Rekey re-wraps every data key with a new key-encryption key: those of
+// credential records, keyring backups, secret cache entries and the file
+// backend. An empty passphrase generates a fresh random key file; otherwise
+// the new key is derived from the passphrase. Record contents are not
+// re-encrypted.
+func (s *Store) Rekey(passphrase string) error {
+	s.keyMu.Lock()
+	defer s.keyMu.Unlock()
+
+	kek, meta, err := newKey(Options{KeyFile: s.keyFile, Passphrase: passphrase})
+	if err != nil {
+		return err
+	}
+
+	// Stage the new key file so a crash after commit can be recovered
+	pendingPath := s.keyFile + ".new"
+	if meta.Source == KeySourceFile {
+		if err := writeKeyFile(pendingPath, kek); err != nil {
+			return err
+		}
+	}
+
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		if err := s.rewrapBucket(tx.Bucket(CredentialsBucket), kek, meta.KeyID); err != nil {
+			return err
+		}
+		if err := s.rewrapBucket(tx.Bucket(KeyringBackupBucket), kek, meta.KeyID); err != nil {
+			return err
+		}
+		if err := s.rewrapSecretCache(tx.Bucket(CacheBucket), kek, meta.KeyID); err != nil {
+			return err
+		}
+		if data := tx.Bucket(ConfigBucket).Get(fileKeyName); data != nil {
+			rewrapped, err := s.rewrap(fileKeyName, data, kek, meta.KeyID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Bucket(ConfigBucket).Put(fileKeyName, rewrapped); err != nil {
+				return err
+			}
+		}
+		return putKeyMetadata(tx, meta)
+	})
+	if err != nil {
+		if meta.Source == KeySourceFile {
+			os.Remove(pendingPath)
+		}
+		return fmt.Errorf("rekey credentials: %w", err)
+	}
+
+	if meta.Source == KeySourceFile {
+		if err := os.Rename(pendingPath, s.keyFile); err != nil {
+			return fmt.Errorf("install new key file: %w", err)
+		}
+	} else if s.keyFile != "" {
+		// The old key file can no longer decrypt anything
+		if err := os.Remove(s.keyFile); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove old key file: %w", err)
+		}
+	}
+
+	s.kek, s.keyMeta = kek, *meta
+	return nil
+}
+
+// rewrapBucket re-seals the data key of every envelope in b under kek
+func (s *Store) rewrapBucket(b *bbolt.Bucket, kek []byte, kid string) error {
+	updates := make(map[string][]byte)
+	err := b.ForEach(func(k, v []byte) error {
+		data, err := s.rewrap(k, v, kek, kid)
+		if err != nil {
+			return err
+		}
+		updates[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return putAll(b, updates)
+}
+
+// rewrapSecretCache re-seals the data keys of the encrypted entries in the
+// cache bucket under kek. Plain cache entries are left as they are.
+func (s *Store) rewrapSecretCache(b *bbolt.Bucket, kek []byte, kid string) error {
+	updates := make(map[string][]byte)
+	err := b.ForEach(func(k, v []byte) error {
+		var entry struct {
+			Value     json.RawMessage `json:"value"`
+			ExpiresAt time.Time       `json:"expires_at"`
+		}
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return nil // not ours to fix; GetCache reports it
+		}
+		var sealed []byte
+		if err := json.Unmarshal(entry.Value, &sealed); err != nil || !isEnvelope(sealed) {
+			return nil
+		}
+
+		rewrapped, err := s.rewrap(k, sealed, kek, kid)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(CacheEntry{Value: rewrapped, ExpiresAt: entry.ExpiresAt})
+		if err != nil {
+			return fmt.Errorf("encode cache entry %s: %w", k, err)
+		}
+		updates[string(k)] = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return putAll(b, updates)
+}
+
+// rewrap re-seals the data key of the envelope stored under name
+func (s *Store) rewrap(name, data, kek []byte, kid string) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("decode record %s: %w", name, err)
+	}
+	dek, err := open(s.kek, env.WrappedKey, name)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key for %s: %w", name, err)
+	}
+	if env.WrappedKey, err = seal(kek, dek, name); err != nil {
+		return nil, fmt.Errorf("wrap key for %s: %w", name, err)
+	}
+	env.KeyID = kid
+	data, err = json.Marshal(env)
+	if err != nil {
+		return nil, fmt.Errorf("encode record %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// putAll writes updates to b
+func putAll(b *bbolt.Bucket, updates map[string][]byte) error {
+	for k, v := range updates {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePlaintext encrypts credential records written before encryption
+// was introduced. It runs once per open and is a no-op afterwards.
+func (s *Store) migratePlaintext() error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(CredentialsBucket)
+		updates := make(map[string][]byte)
+		err := b.ForEach(func(k, v []byte) error {
+			if isEnvelope(v) {
+				return nil
+			}
+			sealed, err := s.encryptRecord(k, v)
+			if err != nil {
+				return fmt.Errorf("encrypt record %s: %w", k, err)
+			}
+			updates[string(k)] = sealed
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for k, v := range updates {
+			if err := b.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// encryptRecord seals plaintext under a fresh data key bound to name. The
+// caller holds keyMu until the record is written.
+func (s *Store) encryptRecord(name, plaintext []byte) ([]byte, error) {
+	dek := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, fmt.Errorf("generate data key: %w", err)
+	}
+
+	ciphertext, err := seal(dek, plaintext, name)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := seal(s.kek, dek, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		Version:    envelopeVersion,
+		KeyID:      s.keyMeta.KeyID,
+		WrappedKey: wrapped,
+		Ciphertext: ciphertext,
+	})
+}
+
+// decryptRecord opens a record sealed by encryptRecord. The caller holds
+// keyMu.
+func (s *Store) decryptRecord(name, data []byte) ([]byte, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("decode envelope: %w", err)
+	}
+	if env.Version != envelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version: %d", env.Version)
+	}
+	if env.KeyID != s.keyMeta.KeyID {
+		return nil, ErrWrongKey
+	}
+
+	dek, err := open(s.kek, env.WrappedKey, name)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return open(dek, env.Ciphertext, name)
+}
+
+// isEnvelope reports whether data is an encrypted record
+func isEnvelope(data []byte) bool {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return false
+	}
+	return env.Version > 0 && len(env.Ciphertext) > 0
+}
+
+// newKey creates a key-encryption key from opts
+func newKey(opts Options) ([]byte, *keyMetadata, error) {
+	if opts.Passphrase != "" {
+		salt := make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, nil, fmt.Errorf("generate salt: %w", err)
+		}
+		kek, err := deriveKey(opts.Passphrase, salt, pbkdf2Iterations)
+		if err != nil {
+			return nil, nil, err
+		}
+		return kek, &keyMetadata{
+			Source:     KeySourcePassphrase,
+			KeyID:      keyID(kek),
+			Salt:       salt,
+			Iterations: pbkdf2Iterations,
+		}, nil
+	}
+
+	if opts.KeyFile == "" {
+		return nil, nil, fmt.Errorf("a key file or passphrase is required")
+	}
+	kek := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, kek); err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+	return kek, &keyMetadata{Source: KeySourceFile, KeyID: keyID(kek)}, nil
+}
+
+// deriveKey derives a key-encryption key from a passphrase
+func deriveKey(passphrase string, salt []byte, iterations int) ([]byte, error) {
+	kek, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("derive key: %w", err)
+	}
+	return kek, nil
+}
+
+// keyID returns a stable identifier for a key that does not reveal it
+func keyID(kek []byte) string {
+	sum := sha256.Sum256(append([]byte("ark-kek-id:"), kek...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// readKeyFile reads a key file, refusing files other users can read
+func readKeyFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat key file: %w", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("key file %s has insecure permissions %04o (expected 0600)", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	kek, err := hex.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(kek) != keySize {
+		return nil, fmt.Errorf("key file %s is malformed", path)
+	}
+	return kek, nil
+}
+
+// writeKeyFile writes a hex-encoded key with owner-only permissions
+func writeKeyFile(path string, kek []byte) error {
+	if path == "" {
+		return fmt.Errorf("key file path is required")
+	}
+	data := []byte(hex.EncodeToString(kek) + "\n")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("write key file: %w", err)
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, 0600); err != nil {
+		return fmt.Errorf("chmod key file: %w", err)
+	}
+	return nil
+}
+
+// putKeyMetadata stores key metadata in the config bucket
+func putKeyMetadata(tx *bbolt.Tx, meta *keyMetadata) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(ConfigBucket).Put(encryptionMetaKey, data)
+}
+
+// seal encrypts plaintext with AES-256-GCM, prefixing the nonce
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// open decrypts data produced by seal
+func open(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// newGCM returns an AES-GCM AEAD for key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestStore opens a store with a key file in a temporary directory
+func newTestStore(t *testing.T, opts Options) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	if opts.KeyFile == "" && opts.Passphrase == "" {
+		opts.KeyFile = filepath.Join(dir, "agent.key")
+	}
+	s, err := New(filepath.Join(dir, "agent.db"), opts)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestRekeyRewrapsSecretCache(t *testing.T) {
+	s := newTestStore(t, Options{})
+
+	creds := Credentials{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: "secret"}
+	if err := s.SetCredential("default", creds); err != nil {
+		t.Fatalf("set credential: %v", err)
+	}
+	session := Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "s", SessionToken: "t", Expiration: time.Now().Add(time.Hour)}
+	if err := s.SetCachedCredential("session:default", session); err != nil {
+		t.Fatalf("cache session: %v", err)
+	}
+	if err := s.SetSecretCache("sso:token", map[string]string{"access_token": "tok"}, time.Hour); err != nil {
+		t.Fatalf("cache token: %v", err)
+	}
+	if err := s.SetCache("plain", "value", time.Hour); err != nil {
+		t.Fatalf("cache plain value: %v", err)
+	}
+
+	if err := s.Rekey(""); err != nil {
+		t.Fatalf("rekey: %v", err)
+	}
+
+	got, err := s.GetCredential("default")
+	if err != nil || got.SecretAccessKey != "secret" {
+		t.Fatalf("credential after rekey = %+v, %v", got, err)
+	}
+	cached, err := s.GetCachedCredential("session:default")
+	if err != nil || cached.SessionToken != "t" {
+		t.Fatalf("cached session after rekey = %+v, %v", cached, err)
+	}
+	var token map[string]string
+	if err := s.GetSecretCache("sso:token", &token); err != nil || token["access_token"] != "tok" {
+		t.Fatalf("cached token after rekey = %v, %v", token, err)
+	}
+	var plain string
+	if err := s.GetCache("plain", &plain); err != nil || plain != "value" {
+		t.Fatalf("plain cache entry after rekey = %q, %v", plain, err)
+	}
+}
+
+func TestRecordsWrittenDuringRekeyOpenWithNewKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.db")
+	opts := Options{KeyFile: filepath.Join(dir, "agent.key")}
+	s, err := New(path, opts)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+
+	const writes = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writes)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			name := fmt.Sprintf("profile-%d", i)
+			if err := s.SetCredential(name, Credentials{AccessKeyID: "AKIAEXAMPLE", SecretAccessKey: name}); err != nil {
+				errs <- err
+			}
+			if _, err := s.GetCredential(name); err != nil {
+				errs <- err
+			}
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < writes; i++ {
+			if err := s.SetSecretCache(fmt.Sprintf("token-%d", i), i, time.Hour); err != nil {
+				errs <- err
+			}
+		}
+	}()
+	if err := s.Rekey(""); err != nil {
+		t.Fatalf("rekey: %v", err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("during rekey: %v", err)
+	}
+	s.Close()
+
+	s, err = New(path, opts)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	defer s.Close()
+	for i := 0; i < writes; i++ {
+		name := fmt.Sprintf("profile-%d", i)
+		if got, err := s.GetCredential(name); err != nil || got.SecretAccessKey != name {
+			t.Errorf("%s after reopen = %+v, %v", name, got, err)
+		}
+		var n int
+		if err := s.GetSecretCache(fmt.Sprintf("token-%d", i), &n); err != nil || n != i {
+			t.Errorf("token-%d after reopen = %d, %v", i, n, err)
+		}
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.db")
+	s, err := New(path, Options{Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	s.Close()
+
+	if _, err := New(path, Options{Passphrase: "battery staple"}); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("open with wrong passphrase: got %v, want ErrWrongKey", err)
+	}
+}
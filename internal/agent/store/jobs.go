@@ -0,0 +1,44 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// SetJob stores a background job. The jobs package owns the record's
+// format.
+func (s *Store) SetJob(id string, job interface{}) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("marshal job: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(JobsBucket).Put([]byte(id), data)
+	})
+}
+
+// ListJobs returns every stored job, by ID
+func (s *Store) ListJobs() (map[string]json.RawMessage, error) {
+	jobs := make(map[string]json.RawMessage)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(JobsBucket).ForEach(func(k, v []byte) error {
+			// v is only valid during the transaction
+			jobs[string(k)] = append(json.RawMessage(nil), v...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// DeleteJob removes a job
+func (s *Store) DeleteJob(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(JobsBucket).Delete([]byte(id))
+	})
+}
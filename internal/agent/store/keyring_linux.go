@@ -0,0 +1,114 @@
+//go:build linux
+
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// keyPerm grants the possessor and the owning user full access to a key
+const keyPerm = 0x3f3f0000
+
+// kernelKeyring stores entries as "user" keys in the Linux kernel keyring.
+// Entries live in the per-user persistent keyring when the kernel supports
+// it, otherwise in the user keyring; neither survives a reboot.
+type kernelKeyring struct {
+	ringID int
+}
+
+// openSystemKeyring returns the kernel keyring for the current user
+func openSystemKeyring() (Keyring, error) {
+	ringID, err := unix.KeyctlInt(unix.KEYCTL_GET_PERSISTENT, -1, unix.KEY_SPEC_USER_KEYRING, 0, 0)
+	if err != nil {
+		// Persistent keyrings are optional; fall back to the user keyring
+		ringID, err = unix.KeyctlGetKeyringID(unix.KEY_SPEC_USER_KEYRING, true)
+		if err != nil {
+			return nil, fmt.Errorf("get user keyring: %w", err)
+		}
+	}
+	return &kernelKeyring{ringID: ringID}, nil
+}
+
+func (k *kernelKeyring) Set(key string, data []byte) error {
+	id, err := unix.AddKey("user", key, data, k.ringID)
+	if err != nil {
+		return fmt.Errorf("add key %s: %w", key, err)
+	}
+	if err := unix.KeyctlSetperm(id, keyPerm); err != nil {
+		return fmt.Errorf("set permissions on %s: %w", key, err)
+	}
+	return nil
+}
+
+func (k *kernelKeyring) Get(key string) ([]byte, error) {
+	id, err := unix.KeyctlSearch(k.ringID, "user", key, 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return nil, fmt.Errorf("%w: %s", errKeyNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find key %s: %w", key, err)
+	}
+	return readKey(id)
+}
+
+func (k *kernelKeyring) Delete(key string) error {
+	id, err := unix.KeyctlSearch(k.ringID, "user", key, 0)
+	if errors.Is(err, unix.ENOKEY) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("find key %s: %w", key, err)
+	}
+	if _, err := unix.KeyctlInt(unix.KEYCTL_UNLINK, id, k.ringID, 0, 0); err != nil {
+		return fmt.Errorf("unlink key %s: %w", key, err)
+	}
+	return nil
+}
+
+func (k *kernelKeyring) Keys() ([]string, error) {
+	// Reading a keyring yields the serial numbers of its keys
+	data, err := readKey(k.ringID)
+	if err != nil {
+		return nil, fmt.Errorf("read keyring: %w", err)
+	}
+
+	var keys []string
+	for i := 0; i+4 <= len(data); i += 4 {
+		id := int(int32(binary.NativeEndian.Uint32(data[i : i+4])))
+		desc, err := unix.KeyctlString(unix.KEYCTL_DESCRIBE, id)
+		if err != nil {
+			continue // key may have been removed concurrently
+		}
+		// Format: type;uid;gid;perm;description
+		parts := strings.SplitN(desc, ";", 5)
+		if len(parts) == 5 && parts[0] == "user" {
+			keys = append(keys, parts[4])
+		}
+	}
+	return keys, nil
+}
+
+// readKey returns the payload of a key or keyring
+func readKey(id int) ([]byte, error) {
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		buf := make([]byte, size)
+		n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0)
+		if err != nil {
+			return nil, err
+		}
+		// The payload may have grown between calls
+		if n <= size {
+			return buf[:n], nil
+		}
+		size = n
+	}
+}
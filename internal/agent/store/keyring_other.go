@@ -0,0 +1,13 @@
+//go:build !linux
+
+package store
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// openSystemKeyring reports that no OS keyring integration exists yet
+func openSystemKeyring() (Keyring, error) {
+	return nil, fmt.Errorf("keyring backend is not supported on %s (use %q)", runtime.GOOS, BackendFile)
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Saga step states
+const (
+	StepRunning     = "running"
+	StepSucceeded   = "success"
+	StepFailed      = "failure"
+	StepInterrupted = "interrupted"
+)
+
+// SagaState is the progress of a multi-step AWS operation, kept until it
+// finishes so that one interrupted by the agent stopping can be
+// compensated for when the agent starts again
+type SagaState struct {
+	Operation    string `json:"operation"` // e.g. s3:CreateBucket
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	Profile      string `json:"profile"`
+	Region       string `json:"region"`
+
+	// Input is the operation's input, from which its steps are rebuilt
+	Input json.RawMessage `json:"input"`
+
+	// Steps lists the steps started so far, then any compensating actions
+	Steps []SagaStep `json:"steps"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SagaStep is the outcome of one step of a saga, or of a compensating
+// action
+type SagaStep struct {
+	Name         string `json:"name"`
+	Status       string `json:"status"` // success, failure, running or interrupted
+	Error        string `json:"error,omitempty"`
+	Compensation bool   `json:"compensation,omitempty"`
+}
+
+// SetSaga stores the progress of a saga
+func (s *Store) SetSaga(id string, state SagaState) error {
+	state.UpdatedAt = time.Now()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal saga: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(SagasBucket).Put([]byte(id), data)
+	})
+}
+
+// ListSagas returns the sagas that have not finished, by ID
+func (s *Store) ListSagas() (map[string]SagaState, error) {
+	sagas := make(map[string]SagaState)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(SagasBucket).ForEach(func(k, v []byte) error {
+			var state SagaState
+			if err := json.Unmarshal(v, &state); err != nil {
+				return fmt.Errorf("unmarshal saga %s: %w", k, err)
+			}
+			sagas[string(k)] = state
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sagas, nil
+}
+
+// DeleteSaga removes a finished saga
+func (s *Store) DeleteSaga(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(SagasBucket).Delete([]byte(id))
+	})
+}
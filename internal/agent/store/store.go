@@ -2,7 +2,10 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"go.etcd.io/bbolt"
@@ -10,18 +13,51 @@ import (
 
 // Store provides persistent storage for the agent using BoltDB
 type Store struct {
-	db *bbolt.DB
+	db      *bbolt.DB
+	keyFile string
+	dataDir string
+
+	// keyMu guards the store key. Rekey holds it exclusively; code that
+	// encrypts or decrypts records holds it shared from reading the key
+	// until the record is written, so no record is sealed under a key
+	// being replaced.
+	keyMu   sync.RWMutex
+	kek     []byte
+	keyMeta keyMetadata
+
+	mu    sync.RWMutex
+	creds CredentialBackend
+
+	// credMu orders writes of credential profiles, so that UpdateCredential
+	// does not write back a profile replaced meanwhile
+	credMu sync.Mutex
 }
 
+// ErrProfileNotFound is returned when no credentials exist for a profile
+var ErrProfileNotFound = errors.New("profile not found")
+
 // Bucket names
 var (
 	ConfigBucket      = []byte("config")
 	CredentialsBucket = []byte("credentials")
 	CacheBucket       = []byte("cache")
+	TransfersBucket   = []byte("transfers")
+	SagasBucket       = []byte("sagas")
+	JobsBucket        = []byte("jobs")
+
+	// Audit events waiting for the backend, and events it refused
+	AuditOutboxBucket   = []byte("audit_outbox")
+	AuditRejectedBucket = []byte("audit_rejected")
+
+	// Encrypted copies of the profiles held in the kernel keyring, which
+	// does not survive a reboot
+	KeyringBackupBucket = []byte("keyring_backup")
 )
 
-// New creates a new agent store
-func New(path string) (*Store, error) {
+// New creates a new agent store. Credential records are encrypted with a
+// key obtained according to opts, and any plaintext records left by older
+// versions are encrypted in place.
+func New(path string, opts Options) (*Store, error) {
 	db, err := bbolt.Open(path, 0600, &bbolt.Options{
 		Timeout: 1 * time.Second,
 	})
@@ -31,7 +67,7 @@ func New(path string) (*Store, error) {
 
 	// Create buckets
 	err = db.Update(func(tx *bbolt.Tx) error {
-		for _, bucket := range [][]byte{ConfigBucket, CredentialsBucket, CacheBucket} {
+		for _, bucket := range [][]byte{ConfigBucket, CredentialsBucket, CacheBucket, TransfersBucket, SagasBucket, JobsBucket, AuditOutboxBucket, AuditRejectedBucket, KeyringBackupBucket} {
 			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
 				return fmt.Errorf("create bucket %s: %w", bucket, err)
 			}
@@ -43,7 +79,25 @@ func New(path string) (*Store, error) {
 		return nil, err
 	}
 
-	return &Store{db: db}, nil
+	s := &Store{db: db, dataDir: filepath.Dir(path)}
+	if err := s.loadKey(opts); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("load encryption key: %w", err)
+	}
+
+	if err := s.migratePlaintext(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("encrypt existing credentials: %w", err)
+	}
+
+	backend, err := s.OpenBackend(opts.CredentialBackend)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.creds = backend
+
+	return s, nil
 }
 
 // Close closes the database
@@ -76,67 +130,45 @@ func (s *Store) GetConfig(key string, dest interface{}) error {
 	})
 }
 
-// SetCredential stores AWS credentials (encrypted in production)
-// Note: This is a simple implementation. Production should encrypt credentials.
+// SetCredential stores AWS credentials in the active credential backend
 func (s *Store) SetCredential(profile string, creds Credentials) error {
-	data, err := json.Marshal(creds)
+	s.credMu.Lock()
+	defer s.credMu.Unlock()
+	return s.Backend().Set(profile, creds)
+}
+
+// UpdateCredential reads a profile's credentials, applies update to them
+// and stores the result, with no other write in between, so that a rotation
+// finishing meanwhile is not undone. It returns the stored credentials.
+func (s *Store) UpdateCredential(profile string, update func(*Credentials)) (*Credentials, error) {
+	s.credMu.Lock()
+	defer s.credMu.Unlock()
+	creds, err := s.Backend().Get(profile)
 	if err != nil {
-		return fmt.Errorf("marshal credentials: %w", err)
+		return nil, err
 	}
-
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(CredentialsBucket)
-		return b.Put([]byte(profile), data)
-	})
+	update(creds)
+	if err := s.Backend().Set(profile, *creds); err != nil {
+		return nil, err
+	}
+	return creds, nil
 }
 
 // GetCredential retrieves AWS credentials for a profile
 func (s *Store) GetCredential(profile string) (*Credentials, error) {
-	var creds Credentials
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(CredentialsBucket)
-		data := b.Get([]byte(profile))
-		if data == nil {
-			return fmt.Errorf("profile not found: %s", profile)
-		}
-		return json.Unmarshal(data, &creds)
-	})
-	if err != nil {
-		return nil, err
-	}
-	return &creds, nil
+	return s.Backend().Get(profile)
 }
 
 // ListCredentials retrieves all stored credential profiles
 func (s *Store) ListCredentials() (map[string]*Credentials, error) {
-	profiles := make(map[string]*Credentials)
-
-	err := s.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(CredentialsBucket)
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			var creds Credentials
-			if err := json.Unmarshal(v, &creds); err != nil {
-				return fmt.Errorf("unmarshal credentials for %s: %w", k, err)
-			}
-			profiles[string(k)] = &creds
-		}
-		return nil
-	})
-
-	if err != nil {
-		return nil, err
-	}
-	return profiles, nil
+	return s.Backend().List()
 }
 
 // DeleteCredential removes AWS credentials for a profile
 func (s *Store) DeleteCredential(profile string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket(CredentialsBucket)
-		return b.Delete([]byte(profile))
-	})
+	s.credMu.Lock()
+	defer s.credMu.Unlock()
+	return s.Backend().Delete(profile)
 }
 
 // SetCache stores a cache entry with optional TTL
@@ -193,6 +225,123 @@ type Credentials struct {
 	SessionToken    string    `json:"session_token,omitempty"`
 	Expiration      time.Time `json:"expiration,omitempty"`
 	Region          string    `json:"region,omitempty"`
+
+	// Role and SSO settings, as found in the AWS shared config file
+	RoleARN         string `json:"role_arn,omitempty"`
+	SourceProfile   string `json:"source_profile,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+	MFASerial       string `json:"mfa_serial,omitempty"`
+	RoleSessionName string `json:"role_session_name,omitempty"`
+	SSOSession      string `json:"sso_session,omitempty"`
+	SSOStartURL     string `json:"sso_start_url,omitempty"`
+	SSORegion       string `json:"sso_region,omitempty"`
+	SSOAccountID    string `json:"sso_account_id,omitempty"`
+	SSORoleName     string `json:"sso_role_name,omitempty"`
+
+	// CredentialProcess is an external command that prints credentials in
+	// the AWS credential_process JSON format
+	CredentialProcess string `json:"credential_process,omitempty"`
+
+	// Lifecycle tracking, maintained by the agent
+	StoredAt          time.Time `json:"stored_at,omitempty"`
+	ValidatedAt       time.Time `json:"validated_at,omitempty"`
+	AccountID         string    `json:"account_id,omitempty"`
+	ARN               string    `json:"arn,omitempty"`
+	RotationAlertedAt time.Time `json:"rotation_alerted_at,omitempty"`
+}
+
+// LongTerm reports whether the profile holds long-term access keys (as
+// opposed to a role, SSO settings or temporary session credentials)
+func (c *Credentials) LongTerm() bool {
+	return c.Type() == CredentialTypeStatic && c.SessionToken == ""
+}
+
+// Expired reports whether the credentials have a known expiry in the past
+func (c *Credentials) Expired() bool {
+	return !c.Expiration.IsZero() && time.Now().After(c.Expiration)
+}
+
+// Credential profile types
+const (
+	CredentialTypeStatic  = "static"
+	CredentialTypeRole    = "role"
+	CredentialTypeSSO     = "sso"
+	CredentialTypeProcess = "process"
+)
+
+// Type reports how credentials for the profile are obtained
+func (c *Credentials) Type() string {
+	switch {
+	case c.SSOStartURL != "" || c.SSOSession != "":
+		return CredentialTypeSSO
+	case c.RoleARN != "":
+		return CredentialTypeRole
+	case c.CredentialProcess != "":
+		return CredentialTypeProcess
+	default:
+		return CredentialTypeStatic
+	}
+}
+
+// SetCachedCredential caches temporary credentials until they expire. The
+// entry is encrypted like a credential record.
+func (s *Store) SetCachedCredential(key string, creds Credentials) error {
+	return s.SetSecretCache(key, creds, time.Until(creds.Expiration))
+}
+
+// GetCachedCredential retrieves temporary credentials if not expired
+func (s *Store) GetCachedCredential(key string) (*Credentials, error) {
+	var creds Credentials
+	if err := s.GetSecretCache(key, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// SetSecretCache stores an encrypted cache entry with a TTL, for secrets
+// such as session credentials and SSO tokens
+func (s *Store) SetSecretCache(key string, value interface{}, ttl time.Duration) error {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal value: %w", err)
+	}
+
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	sealed, err := s.encryptRecord([]byte(key), plaintext)
+	if err != nil {
+		return fmt.Errorf("encrypt cache entry: %w", err)
+	}
+
+	return s.SetCache(key, sealed, ttl)
+}
+
+// GetSecretCache retrieves and decrypts a cache entry if not expired
+func (s *Store) GetSecretCache(key string, dest interface{}) error {
+	s.keyMu.RLock()
+	defer s.keyMu.RUnlock()
+	var sealed []byte
+	if err := s.GetCache(key, &sealed); err != nil {
+		return err
+	}
+
+	plaintext, err := s.decryptRecord([]byte(key), sealed)
+	if err != nil {
+		return fmt.Errorf("decrypt cache entry: %w", err)
+	}
+
+	if err := json.Unmarshal(plaintext, dest); err != nil {
+		return fmt.Errorf("unmarshal cache entry: %w", err)
+	}
+	return nil
+}
+
+// DeleteCache removes a cache entry
+func (s *Store) DeleteCache(key string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(CacheBucket)
+		return b.Delete([]byte(key))
+	})
 }
 
 // CacheEntry represents a cached value with expiration
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateCredentialKeepsConcurrentRotation(t *testing.T) {
+	s := newTestStore(t, Options{})
+	if err := s.SetCredential("default", Credentials{AccessKeyID: "AKIA0", SecretAccessKey: "secret"}); err != nil {
+		t.Fatalf("set credential: %v", err)
+	}
+
+	// Rotations and validations of the same profile at once
+	const rotations = 50
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 1; i <= rotations; i++ {
+			key := fmt.Sprintf("AKIA%d", i)
+			if err := s.SetCredential("default", Credentials{AccessKeyID: key, SecretAccessKey: "secret"}); err != nil {
+				t.Errorf("rotate: %v", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rotations; i++ {
+			if _, err := s.UpdateCredential("default", func(c *Credentials) { c.ValidatedAt = time.Now() }); err != nil {
+				t.Errorf("update: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	creds, err := s.GetCredential("default")
+	if err != nil {
+		t.Fatalf("get credential: %v", err)
+	}
+	if want := fmt.Sprintf("AKIA%d", rotations); creds.AccessKeyID != want {
+		t.Fatalf("access key = %s after the last rotation to %s", creds.AccessKeyID, want)
+	}
+}
+
+func TestUpdateCredentialOfMissingProfile(t *testing.T) {
+	s := newTestStore(t, Options{})
+	_, err := s.UpdateCredential("gone", func(c *Credentials) { c.ValidatedAt = time.Now() })
+	if !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("update = %v, want ErrProfileNotFound", err)
+	}
+	if _, err := s.GetCredential("gone"); !errors.Is(err, ErrProfileNotFound) {
+		t.Fatalf("update created the profile: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrTransferNotFound is returned when no state is kept for a transfer
+var ErrTransferNotFound = errors.New("transfer not found")
+
+// Transfer directions
+const (
+	TransferUpload   = "upload"
+	TransferDownload = "download"
+)
+
+// TransferState is the progress of an S3 transfer, kept so that an
+// interrupted transfer can pick up where it stopped
+type TransferState struct {
+	Direction string `json:"direction"`
+	Profile   string `json:"profile"`
+	LocalPath string `json:"local_path"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+
+	// Size and ModTime identify the version of the source being copied:
+	// the local file for uploads, the object for downloads. A change means
+	// the transfer starts over.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	ETag    string    `json:"etag,omitempty"`
+
+	PartSize int64 `json:"part_size"`
+
+	// UploadID is the multipart upload being filled, for uploads
+	UploadID string `json:"upload_id,omitempty"`
+
+	// Parts lists the parts transferred so far
+	Parts []TransferPart `json:"parts"`
+
+	StartedAt time.Time `json:"started_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TransferPart is a part that has been transferred
+type TransferPart struct {
+	Number        int32  `json:"number"`
+	ETag          string `json:"etag,omitempty"`
+	ChecksumCRC32 string `json:"checksum_crc32,omitempty"`
+}
+
+// TransferID identifies the state of a transfer, so that repeating the same
+// transfer finds it
+func TransferID(direction, profile, localPath, bucket, key string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{direction, profile, localPath, bucket, key}, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// SetTransfer stores the state of a transfer
+func (s *Store) SetTransfer(id string, state TransferState) error {
+	state.UpdatedAt = time.Now()
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("marshal transfer: %w", err)
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(TransfersBucket).Put([]byte(id), data)
+	})
+}
+
+// GetTransfer retrieves the state of a transfer
+func (s *Store) GetTransfer(id string) (*TransferState, error) {
+	var state TransferState
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(TransfersBucket).Get([]byte(id))
+		if data == nil {
+			return ErrTransferNotFound
+		}
+		return json.Unmarshal(data, &state)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// DeleteTransfer removes the state of a finished or abandoned transfer
+func (s *Store) DeleteTransfer(id string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(TransfersBucket).Delete([]byte(id))
+	})
+}
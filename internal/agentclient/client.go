@@ -0,0 +1,402 @@
+package agentclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/daemon"
+	"github.com/scttfrdmn/ark/internal/agent/lockfile"
+	"github.com/scttfrdmn/ark/internal/config"
+)
+
+// DefaultTimeout bounds a request when Options.Timeout is not set
+const DefaultTimeout = 30 * time.Second
+
+// MFACodeHeader carries a one-time MFA code to the agent
+const MFACodeHeader = "X-Ark-MFA-Code"
+
+// maxMFAAttempts bounds how many codes the user is asked for per request
+const maxMFAAttempts = 3
+
+// Options configures a Client
+type Options struct {
+	// ConfigPath is the Ark config file; the default honors ARK_CONFIG
+	ConfigPath string
+
+	// Timeout bounds each request (DefaultTimeout if zero)
+	Timeout time.Duration
+
+	// Verbose, if set, receives a line per request and response
+	Verbose io.Writer
+
+	// PromptMFA asks the user for a one-time code when the agent needs one;
+	// the challenge's Message says why a previous code was rejected. Without
+	// it, MFA challenges are returned as errors.
+	PromptMFA func(challenge *Error) (string, error)
+}
+
+// Client talks to the local Ark agent. When the agent is configured to
+// serve on its Unix socket, requests only ever go over the socket, which
+// other users cannot reach. Otherwise they go to its TCP address with the
+// agent token.
+type Client struct {
+	addr      string
+	socket    bool // the agent serves on its socket; never fall back to TCP
+	dataDir   string
+	timeout   time.Duration
+	verbose   io.Writer
+	promptMFA func(challenge *Error) (string, error)
+	http      *http.Client
+}
+
+// New creates a client for the agent described by the Ark configuration.
+// A missing config file means the defaults.
+func New(opts Options) (*Client, error) {
+	path := opts.ConfigPath
+	if path == "" {
+		var err error
+		if path, err = config.GetConfigPath(); err != nil {
+			return nil, fmt.Errorf("get config path: %w", err)
+		}
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
+	}
+
+	dataDir, err := daemon.DataDir()
+	if err != nil {
+		return nil, fmt.Errorf("get data directory: %w", err)
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	c := &Client{
+		addr:      cfg.Agent.Address(),
+		socket:    cfg.Agent.ListenSocket(),
+		dataDir:   dataDir,
+		timeout:   opts.Timeout,
+		verbose:   opts.Verbose,
+		promptMFA: opts.PromptMFA,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = c.dial
+	// A pooled TCP connection would outlive a socket that appears later,
+	// e.g. once an auto-started agent is up
+	transport.DisableKeepAlives = true
+	c.http = &http.Client{Transport: transport}
+
+	return c, nil
+}
+
+// Addr returns the agent's TCP address
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// endpoint describes where requests go, for errors and logs
+func (c *Client) endpoint() string {
+	if c.socket {
+		return c.socketPath()
+	}
+	return c.addr
+}
+
+// DataDir returns the agent data directory
+func (c *Client) DataDir() string {
+	return c.dataDir
+}
+
+// WithTimeout returns a copy of the client with a different request timeout
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	clone := *c
+	clone.timeout = timeout
+	return &clone
+}
+
+// socketPath returns the socket of the running agent, or where the agent
+// creates its socket if none is running
+func (c *Client) socketPath() string {
+	if path := lockfile.GetSocketPath(filepath.Join(c.dataDir, daemon.LockFileName)); path != "" {
+		return path
+	}
+	return filepath.Join(c.dataDir, daemon.SocketFileName)
+}
+
+// SocketPath returns the Unix socket of the running agent, or "" if no
+// agent is running or it only listens on TCP
+func (c *Client) SocketPath() string {
+	path := lockfile.GetSocketPath(filepath.Join(c.dataDir, daemon.LockFileName))
+	if path == "" {
+		return ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// Get sends a GET request and decodes the response into dest
+func (c *Client) Get(ctx context.Context, path string, dest interface{}) error {
+	return c.Do(ctx, http.MethodGet, path, nil, dest)
+}
+
+// Post sends body as JSON and decodes the response into dest
+func (c *Client) Post(ctx context.Context, path string, body, dest interface{}) error {
+	return c.Do(ctx, http.MethodPost, path, body, dest)
+}
+
+// Delete sends a DELETE request and decodes the response into dest
+func (c *Client) Delete(ctx context.Context, path string, dest interface{}) error {
+	return c.Do(ctx, http.MethodDelete, path, nil, dest)
+}
+
+// Do sends a request to the agent. A non-nil body is sent as JSON, and a
+// successful response is decoded into dest unless dest is nil. Responses
+// outside 2xx are returned as *Error. When the agent asks for an MFA code,
+// the user is prompted and the request retried; the agent caches the
+// resulting session, so later requests for the profile do not prompt again.
+func (c *Client) Do(ctx context.Context, method, path string, body, dest interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+	}
+
+	code := ""
+	for attempt := 0; ; attempt++ {
+		respBody, err := c.send(ctx, method, path, data, code)
+		if err == nil {
+			if dest == nil || len(bytes.TrimSpace(respBody)) == 0 {
+				return nil
+			}
+			if err := json.Unmarshal(respBody, dest); err != nil {
+				return fmt.Errorf("decode agent response: %w", err)
+			}
+			return nil
+		}
+
+		var challenge *Error
+		if !errors.As(err, &challenge) || !challenge.MFARequired() || c.promptMFA == nil {
+			return err
+		}
+		if attempt >= maxMFAAttempts {
+			return fmt.Errorf("too many rejected MFA codes for profile %s", challenge.Profile)
+		}
+
+		if code, err = c.promptMFA(challenge); err != nil {
+			return err
+		}
+	}
+}
+
+// send makes one request and returns the response body for 2xx responses
+func (c *Client) send(ctx context.Context, method, path string, data []byte, mfaCode string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, method, path, data, mfaCode)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	c.logf("→ %s %s", method, path)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.logf("✗ %s %s: %v", method, path, err)
+		return nil, &UnavailableError{Addr: c.endpoint(), Err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read agent response: %w", err)
+	}
+	c.logf("← %d %s %s (%dms)", resp.StatusCode, method, path, time.Since(start).Milliseconds())
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newError(resp.StatusCode, respBody)
+	}
+	return respBody, nil
+}
+
+// Stream follows a server-sent event stream, calling fn with the data of
+// each event until the stream ends, fn returns an error or ctx is
+// cancelled. The client's timeout only bounds the wait for the response
+// to start.
+func (c *Client) Stream(parent context.Context, path string, fn func(data []byte) error) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	req, err := c.newRequest(ctx, http.MethodGet, path, nil, "")
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	c.logf("→ %s %s (stream)", http.MethodGet, path)
+	timer := time.AfterFunc(c.timeout, cancel)
+	resp, err := c.http.Do(req)
+	timer.Stop()
+	if err != nil {
+		if parent.Err() != nil {
+			return parent.Err()
+		}
+		c.logf("✗ %s %s: %v", http.MethodGet, path, err)
+		return &UnavailableError{Addr: c.endpoint(), Err: err}
+	}
+	defer resp.Body.Close()
+	c.logf("← %d %s %s (stream)", resp.StatusCode, http.MethodGet, path)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return newError(resp.StatusCode, respBody)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	var event []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if event != nil {
+				if err := fn(event); err != nil {
+					return err
+				}
+			}
+			event = nil
+			continue
+		}
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			if event != nil {
+				event = append(event, '\n')
+			}
+			event = append(event, bytes.TrimPrefix(data, []byte(" "))...)
+		}
+	}
+	if parent.Err() != nil {
+		return parent.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("read agent events: %w", err)
+	}
+	return nil
+}
+
+// maxEventSize bounds one line of an event stream; a job's result can be
+// a long list
+const maxEventSize = 64 << 20
+
+// newRequest builds a request to the agent
+func (c *Client) newRequest(ctx context.Context, method, path string, data []byte, mfaCode string) (*http.Request, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+c.addr+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if mfaCode != "" {
+		req.Header.Set(MFACodeHeader, mfaCode)
+	}
+	if c.socket {
+		// The socket needs no token
+		return req, nil
+	}
+	if token, err := c.token(); err == nil {
+		// Without one the agent's 401 explains what is wrong
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// dial connects to the agent's Unix socket, or to its TCP address if the
+// agent does not serve on a socket. There is no fallback from the socket to
+// TCP: while this user's agent is down, another user could be listening on
+// the port.
+func (c *Client) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	if !c.socket {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	path := c.socketPath()
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c.logf("  via %s", path)
+	return conn, nil
+}
+
+// token reads the agent's API token. It is read per request, since the
+// agent creates it on first start, which may happen during this command.
+func (c *Client) token() (string, error) {
+	data, err := os.ReadFile(filepath.Join(c.dataDir, daemon.TokenFileName))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Healthy reports whether the agent answers health checks
+func (c *Client) Healthy(ctx context.Context) bool {
+	return c.Get(ctx, "/api/system/health", nil) == nil
+}
+
+// TokenRejected reports whether something answers on the agent's TCP
+// address but refuses this user's token, e.g. another user's agent on a
+// shared host. The socket is not consulted. It is always false when the
+// agent serves on its socket, since the token is not sent over TCP then.
+func (c *Client) TokenRejected(ctx context.Context) bool {
+	if c.socket {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+c.addr+"/api/system/health", nil)
+	if err != nil {
+		return false
+	}
+	if token, err := c.token(); err == nil {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusUnauthorized
+}
+
+func (c *Client) logf(format string, args ...interface{}) {
+	if c.verbose != nil {
+		fmt.Fprintf(c.verbose, format+"\n", args...)
+	}
+}
@@ -0,0 +1,133 @@
+//go:build !windows
+
+package agentclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/daemon"
+)
+
+// recorder is an agent stand-in that records the Authorization header of
+// every request
+type recorder struct {
+	mu    sync.Mutex
+	auths []string
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	rec.auths = append(rec.auths, r.Header.Get("Authorization"))
+	rec.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"status":"healthy"}`)
+}
+
+func (rec *recorder) requests() []string {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]string(nil), rec.auths...)
+}
+
+// setup creates a data directory holding an agent token, and a client
+// configured with listen mode and the TCP address of tcp
+func setup(t *testing.T, listen string, tcp *httptest.Server) (*Client, string) {
+	t.Helper()
+	dataDir := t.TempDir()
+	t.Setenv("ARK_AGENT_DATA", dataDir)
+	if err := os.WriteFile(filepath.Join(dataDir, daemon.TokenFileName), []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(tcp.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := fmt.Sprintf("agent:\n  host: %s\n  port: %s\n  listen: %s\n", host, port, listen)
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(Options{ConfigPath: configPath, Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	return c, dataDir
+}
+
+// serveSocket serves h on the agent socket in dataDir
+func serveSocket(t *testing.T, dataDir string, h http.Handler) {
+	t.Helper()
+	ln, err := net.Listen("unix", filepath.Join(dataDir, daemon.SocketFileName))
+	if err != nil {
+		t.Fatalf("listen on socket: %v", err)
+	}
+	srv := &http.Server{Handler: h}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+}
+
+func TestSocketClientNeverFallsBackToTCP(t *testing.T) {
+	// Something else is listening on the agent's port while the agent is down
+	squatter := &recorder{}
+	tcp := httptest.NewServer(squatter)
+	defer tcp.Close()
+
+	c, _ := setup(t, "both", tcp)
+	err := c.Get(context.Background(), "/api/system/health", nil)
+	var unavailable *UnavailableError
+	if !errors.As(err, &unavailable) {
+		t.Fatalf("get: got %v, want UnavailableError", err)
+	}
+	if got := squatter.requests(); len(got) != 0 {
+		t.Fatalf("client connected to the TCP port: %v", got)
+	}
+	if c.TokenRejected(context.Background()) || len(squatter.requests()) != 0 {
+		t.Fatal("TokenRejected sent the token over TCP")
+	}
+}
+
+func TestSocketClientUsesSocketWithoutToken(t *testing.T) {
+	squatter := &recorder{}
+	tcp := httptest.NewServer(squatter)
+	defer tcp.Close()
+
+	c, dataDir := setup(t, "socket", tcp)
+	agent := &recorder{}
+	serveSocket(t, dataDir, agent)
+
+	if !c.Healthy(context.Background()) {
+		t.Fatal("agent on the socket is not healthy")
+	}
+	if got := agent.requests(); len(got) != 1 || got[0] != "" {
+		t.Fatalf("socket requests carried Authorization %q", got)
+	}
+	if got := squatter.requests(); len(got) != 0 {
+		t.Fatalf("client connected to the TCP port: %v", got)
+	}
+}
+
+func TestTCPClientSendsToken(t *testing.T) {
+	agent := &recorder{}
+	tcp := httptest.NewServer(agent)
+	defer tcp.Close()
+
+	c, _ := setup(t, "tcp", tcp)
+	if !c.Healthy(context.Background()) {
+		t.Fatal("agent on TCP is not healthy")
+	}
+	if got := agent.requests(); len(got) != 1 || got[0] != "Bearer secret-token" {
+		t.Fatalf("TCP requests carried Authorization %q", got)
+	}
+}
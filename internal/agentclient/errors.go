@@ -0,0 +1,87 @@
+package agentclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error is a non-2xx response from the agent
+type Error struct {
+	StatusCode int
+	Message    string // the agent's "error" field
+	Status     string // the agent's "status" field, e.g. blocked
+	Reason     string // the agent's "reason" field, e.g. mfa_required
+	Profile    string
+	MFASerial  string
+
+	// Body is the raw response, for callers that need more of it
+	Body []byte
+}
+
+func newError(statusCode int, body []byte) *Error {
+	var fields struct {
+		Error     string `json:"error"`
+		Status    string `json:"status"`
+		Reason    string `json:"reason"`
+		Profile   string `json:"profile"`
+		MFASerial string `json:"mfa_serial"`
+	}
+	json.Unmarshal(body, &fields)
+
+	return &Error{
+		StatusCode: statusCode,
+		Message:    fields.Error,
+		Status:     fields.Status,
+		Reason:     fields.Reason,
+		Profile:    fields.Profile,
+		MFASerial:  fields.MFASerial,
+		Body:       body,
+	}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Message != "":
+		return e.Message
+	case e.Reason != "":
+		return fmt.Sprintf("%s (status %d)", e.Reason, e.StatusCode)
+	default:
+		return fmt.Sprintf("agent returned status %d", e.StatusCode)
+	}
+}
+
+// Decode decodes the response body into dest
+func (e *Error) Decode(dest interface{}) error {
+	return json.Unmarshal(e.Body, dest)
+}
+
+// MFARequired reports whether the agent needs an MFA code to continue
+func (e *Error) MFARequired() bool {
+	return e.StatusCode == http.StatusUnauthorized && e.Reason == "mfa_required"
+}
+
+// UnavailableError means the agent could not be reached
+type UnavailableError struct {
+	Addr string
+	Err  error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("agent unavailable at %s: %v", e.Addr, e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the HTTP status of an agent error, or 0 if err is not
+// a response from the agent
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
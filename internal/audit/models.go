@@ -1,9 +1,13 @@
 package audit
 
 import (
+	"errors"
 	"time"
 )
 
+// MaxEventIDLength bounds a client-supplied event ID
+const MaxEventIDLength = 64
+
 // LogEntry represents an audit log entry
 type LogEntry struct {
 	ID           string                 `json:"id"`
@@ -15,7 +19,28 @@ type LogEntry struct {
 	Details      map[string]interface{} `json:"details"`
 	IPAddress    string                 `json:"ip_address,omitempty"`
 	UserAgent    string                 `json:"user_agent,omitempty"`
-	CreatedAt    time.Time              `json:"created_at"`
+	CreatedAt    time.Time              `json:"created_at"` // set by the backend when it stores the entry
+
+	// OccurredAt is when the client says the event happened, which for an
+	// agent delivering events it queued offline is well before created_at.
+	// It is the client's word, so created_at is what entries are ordered
+	// and filtered by.
+	OccurredAt *time.Time `json:"occurred_at,omitempty"`
+
+	// EventID is assigned by the client. An entry whose event ID is already
+	// stored is not stored again, so a client can retry delivery safely.
+	EventID string `json:"event_id,omitempty"`
+}
+
+// Validate checks the fields a client must supply
+func (e *LogEntry) Validate() error {
+	if e.Action == "" || e.ResourceType == "" || e.Status == "" {
+		return errors.New("action, resource_type, and status are required")
+	}
+	if len(e.EventID) > MaxEventIDLength {
+		return errors.New("event_id is longer than 64 characters")
+	}
+	return nil
 }
 
 // QueryFilters represents filters for querying audit logs
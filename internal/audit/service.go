@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/scttfrdmn/ark/internal/database"
 )
 
@@ -19,26 +23,121 @@ func NewService(db *database.DB) *Service {
 	return &Service{db: db}
 }
 
-// Log stores an audit log entry in the database
-func (s *Service) Log(ctx context.Context, entry LogEntry) error {
+// Outcomes of storing an entry of a batch
+const (
+	EntryStored    = "stored"
+	EntryDuplicate = "duplicate" // the event ID was already stored
+	EntryRejected  = "rejected"  // the entry is invalid; sending it again will not help
+	EntryFailed    = "failed"    // the entry may be stored if sent again
+)
+
+// MaxBatchSize bounds the entries accepted by LogBatch
+const MaxBatchSize = 500
+
+// BatchResult is the outcome of storing one entry of a batch
+type BatchResult struct {
+	EventID string `json:"event_id,omitempty"`
+	Status  string `json:"status"` // stored, duplicate, rejected, failed
+	LogID   string `json:"log_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// queryRower is a database or a transaction
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// Log stores an audit log entry in the database and sets its ID and
+// created_at, which is always the time it is stored. An entry whose event ID is already stored is not stored
+// again; it gets the stored entry's ID.
+func (s *Service) Log(ctx context.Context, entry *LogEntry) error {
+	_, err := insertEntry(ctx, s.db, entry)
+	return err
+}
+
+// LogBatch stores up to MaxBatchSize entries in one transaction and reports
+// the outcome of each, in order. An entry that cannot be stored does not
+// keep the others from being stored; an error is returned only if none
+// were.
+func (s *Service) LogBatch(ctx context.Context, entries []LogEntry) ([]BatchResult, error) {
+	if len(entries) > MaxBatchSize {
+		return nil, fmt.Errorf("batch of %d entries exceeds the limit of %d", len(entries), MaxBatchSize)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	results := make([]BatchResult, len(entries))
+	for i := range entries {
+		entry := &entries[i]
+		result := &results[i]
+		result.EventID = entry.EventID
+
+		if err := entry.Validate(); err != nil {
+			result.Status = EntryRejected
+			result.Error = err.Error()
+			continue
+		}
+
+		// A failed statement aborts the whole transaction, unless it is
+		// rolled back to a savepoint
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT audit_entry"); err != nil {
+			return nil, fmt.Errorf("create savepoint: %w", err)
+		}
+		duplicate, err := insertEntry(ctx, tx, entry)
+		if err != nil {
+			if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT audit_entry"); rbErr != nil {
+				return nil, fmt.Errorf("roll back to savepoint: %w", rbErr)
+			}
+			result.Status = EntryFailed
+			if invalidEntry(err) {
+				result.Status = EntryRejected
+			}
+			result.Error = err.Error()
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT audit_entry"); err != nil {
+			return nil, fmt.Errorf("release savepoint: %w", err)
+		}
+
+		result.Status = EntryStored
+		if duplicate {
+			result.Status = EntryDuplicate
+		}
+		result.LogID = entry.ID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit transaction: %w", err)
+	}
+	return results, nil
+}
+
+// insertEntry stores entry and sets its ID and created_at, reporting
+// whether its event ID was already stored
+func insertEntry(ctx context.Context, db queryRower, entry *LogEntry) (bool, error) {
+	userID, err := resolveUserID(ctx, db, entry)
+	if err != nil {
+		return false, err
+	}
+
 	// Marshal details to JSON
 	detailsJSON, err := json.Marshal(entry.Details)
 	if err != nil {
-		return fmt.Errorf("marshal details: %w", err)
+		return false, fmt.Errorf("marshal details: %w", err)
 	}
 
-	// Insert into database
+	// Insert into database. Entries without an event ID never conflict.
 	query := `
-		INSERT INTO audit_logs (user_id, action, resource_type, resource_id, status, details, ip_address, user_agent)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		INSERT INTO audit_logs (user_id, action, resource_type, resource_id, status, details, ip_address, user_agent, occurred_at, event_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (event_id) DO NOTHING
 		RETURNING id, created_at
 	`
 
-	var userID *string
-	if entry.UserID != "" {
-		userID = &entry.UserID
-	}
-
 	var ipAddress *string
 	if entry.IPAddress != "" {
 		ipAddress = &entry.IPAddress
@@ -49,7 +148,20 @@ func (s *Service) Log(ctx context.Context, entry LogEntry) error {
 		userAgent = &entry.UserAgent
 	}
 
-	err = s.db.QueryRowContext(ctx, query,
+	// created_at is the backend's; the time an agent gives is kept as
+	// occurred_at. Agents from before occurred_at sent it as created_at.
+	occurredAt := entry.OccurredAt
+	if occurredAt == nil && !entry.CreatedAt.IsZero() {
+		t := entry.CreatedAt
+		occurredAt = &t
+	}
+
+	var eventID *string
+	if entry.EventID != "" {
+		eventID = &entry.EventID
+	}
+
+	err = db.QueryRowContext(ctx, query,
 		userID,
 		entry.Action,
 		entry.ResourceType,
@@ -58,20 +170,106 @@ func (s *Service) Log(ctx context.Context, entry LogEntry) error {
 		detailsJSON,
 		ipAddress,
 		userAgent,
+		occurredAt,
+		eventID,
 	).Scan(&entry.ID, &entry.CreatedAt)
 
+	entry.OccurredAt = occurredAt
+	if errors.Is(err, sql.ErrNoRows) {
+		// Already stored, e.g. by a delivery the client did not hear back
+		// from
+		err = db.QueryRowContext(ctx,
+			`SELECT id, created_at FROM audit_logs WHERE event_id = $1`,
+			entry.EventID,
+		).Scan(&entry.ID, &entry.CreatedAt)
+		if err != nil {
+			return false, fmt.Errorf("look up stored audit log: %w", err)
+		}
+		return true, nil
+	}
 	if err != nil {
-		return fmt.Errorf("insert audit log: %w", err)
+		return false, fmt.Errorf("insert audit log: %w", err)
+	}
+
+	return false, nil
+}
+
+// resolveUserID returns the user ID to store for entry. A user ID that is
+// not a known user's, e.g. the OS user name older agents send, is no reason
+// to lose the event: the entry is stored without a user, with the ID moved
+// to its details.
+func resolveUserID(ctx context.Context, db queryRower, entry *LogEntry) (*string, error) {
+	if entry.UserID == "" {
+		return nil, nil
+	}
+	if isUUID(entry.UserID) {
+		var exists bool
+		err := db.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`,
+			entry.UserID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("look up user: %w", err)
+		}
+		if exists {
+			return &entry.UserID, nil
+		}
 	}
 
-	return nil
+	slog.Warn("audit log names an unknown user, storing it without one",
+		"user_id", entry.UserID, "action", entry.Action, "event_id", entry.EventID)
+	detachUserID(entry)
+	return nil, nil
+}
+
+// detachUserID moves entry's user ID to its details as unknown_user_id
+func detachUserID(entry *LogEntry) {
+	details := make(map[string]interface{}, len(entry.Details)+1)
+	for k, v := range entry.Details {
+		details[k] = v
+	}
+	details["unknown_user_id"] = entry.UserID
+	entry.Details = details
+	entry.UserID = ""
+}
+
+// isUUID reports whether s is a UUID in its canonical textual form
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i, c := range s {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// invalidEntry reports whether err is the database refusing an entry's
+// data, e.g. a value too long for its column, rather than a transient failure
+func invalidEntry(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	// Class 22 is data exceptions, 23 integrity constraint violations
+	class := pqErr.Code.Class()
+	return class == "22" || class == "23"
 }
 
 // Query retrieves audit logs based on filters
 func (s *Service) Query(ctx context.Context, filters QueryFilters) ([]LogEntry, error) {
 	// Build query with filters
 	query := `
-		SELECT id, user_id, action, resource_type, resource_id, status, details, ip_address, user_agent, created_at
+		SELECT id, user_id, action, resource_type, resource_id, status, details, ip_address, user_agent, created_at, occurred_at, event_id
 		FROM audit_logs
 		WHERE 1=1
 	`
@@ -145,6 +343,8 @@ func (s *Service) Query(ctx context.Context, filters QueryFilters) ([]LogEntry,
 		var userID sql.NullString
 		var ipAddress sql.NullString
 		var userAgent sql.NullString
+		var occurredAt sql.NullTime
+		var eventID sql.NullString
 		var detailsJSON []byte
 
 		err := rows.Scan(
@@ -158,6 +358,8 @@ func (s *Service) Query(ctx context.Context, filters QueryFilters) ([]LogEntry,
 			&ipAddress,
 			&userAgent,
 			&entry.CreatedAt,
+			&occurredAt,
+			&eventID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
@@ -169,9 +371,15 @@ func (s *Service) Query(ctx context.Context, filters QueryFilters) ([]LogEntry,
 		if ipAddress.Valid {
 			entry.IPAddress = ipAddress.String
 		}
+		if occurredAt.Valid {
+			entry.OccurredAt = &occurredAt.Time
+		}
 		if userAgent.Valid {
 			entry.UserAgent = userAgent.String
 		}
+		if eventID.Valid {
+			entry.EventID = eventID.String
+		}
 
 		// Unmarshal details
 		if len(detailsJSON) > 0 {
@@ -0,0 +1,41 @@
+package audit
+
+import "testing"
+
+func TestIsUUID(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c8", true},
+		{"6BA7B810-9DAD-11D1-80B4-00C04FD430C8", true},
+		{"researcher", false},
+		{"", false},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430c", false},
+		{"6ba7b8109dad-11d1-80b4-00c04fd430c8a", false},
+		{"6ba7b810-9dad-11d1-80b4-00c04fd430cg", false},
+	}
+	for _, tt := range tests {
+		if got := isUUID(tt.s); got != tt.want {
+			t.Errorf("isUUID(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestDetachUserID(t *testing.T) {
+	agentDetails := map[string]interface{}{"profile": "default"}
+	entry := LogEntry{UserID: "researcher", Details: agentDetails}
+	detachUserID(&entry)
+	if entry.UserID != "" || entry.Details["unknown_user_id"] != "researcher" || entry.Details["profile"] != "default" {
+		t.Fatalf("entry = %+v", entry)
+	}
+	if _, ok := agentDetails["unknown_user_id"]; ok {
+		t.Fatal("detachUserID changed the caller's details")
+	}
+
+	entry = LogEntry{UserID: "researcher"}
+	detachUserID(&entry)
+	if entry.Details["unknown_user_id"] != "researcher" {
+		t.Fatalf("entry without details = %+v", entry)
+	}
+}
@@ -2,8 +2,13 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -24,17 +29,131 @@ type Config struct {
 
 	// Training preferences
 	Training TrainingConfig `yaml:"training"`
+
+	// S3 settings
+	S3 S3Config `yaml:"s3"`
 }
 
 // AgentConfig holds agent-specific settings
 type AgentConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
+
+	// CredentialBackend selects where the agent keeps credentials:
+	// "bolt" (encrypted agent database), "keyring" (OS keyring, with an
+	// encrypted copy in the agent database) or "file" (encrypted files, a
+	// keyring stand-in for headless hosts)
+	CredentialBackend string `yaml:"credential_backend"`
+
+	// CredentialMaxAgeDays is how old long-term access keys may get before
+	// they are reported as due for rotation
+	CredentialMaxAgeDays int `yaml:"credential_max_age_days"`
+
+	// MaxJobs is how many background jobs (e.g. S3 transfers) the agent
+	// runs at once; the rest wait their turn
+	MaxJobs int `yaml:"max_jobs"`
+
+	// Listen selects how the agent accepts connections: "socket" (a Unix
+	// socket in the data directory, private to the user), "tcp" (host and
+	// port, authenticated with the agent token) or "both"
+	Listen string `yaml:"listen"`
+}
+
+// DefaultCredentialMaxAgeDays is the rotation age used when none is configured
+const DefaultCredentialMaxAgeDays = 90
+
+// CredentialMaxAge returns the configured rotation age for access keys
+func (a AgentConfig) CredentialMaxAge() time.Duration {
+	days := a.CredentialMaxAgeDays
+	if days <= 0 {
+		days = DefaultCredentialMaxAgeDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// DefaultMaxJobs is the number of jobs run at once when none is configured
+const DefaultMaxJobs = 4
+
+// JobLimit returns the configured number of jobs run at once
+func (a AgentConfig) JobLimit() int {
+	if a.MaxJobs <= 0 {
+		return DefaultMaxJobs
+	}
+	return a.MaxJobs
+}
+
+// Address returns the agent's TCP address. AGENT_PORT overrides the
+// configured port, as it does for the agent itself.
+func (a AgentConfig) Address() string {
+	host := a.Host
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	port := strconv.Itoa(a.Port)
+	if a.Port <= 0 {
+		port = "8737"
+	}
+	if env := os.Getenv("AGENT_PORT"); env != "" {
+		port = env
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// ListenSocket reports whether the agent should serve on its Unix socket
+func (a AgentConfig) ListenSocket() bool {
+	return runtime.GOOS != "windows" && a.Listen != "tcp"
+}
+
+// ListenTCP reports whether the agent should serve on its TCP port
+func (a AgentConfig) ListenTCP() bool {
+	return a.Listen != "socket" || !a.ListenSocket()
 }
 
 // BackendConfig holds backend-specific settings
 type BackendConfig struct {
 	URL string `yaml:"url"`
+
+	// PolicyKeyFingerprint is the SHA-256 of the backend's policy signing
+	// key, as published by the institution. "ark agent enroll" pins the
+	// key only if it matches.
+	PolicyKeyFingerprint string `yaml:"policy_key_fingerprint"`
+}
+
+// S3Config holds S3 settings
+type S3Config struct {
+	// BucketBaseline is applied to every bucket the agent creates
+	BucketBaseline BucketBaselineConfig `yaml:"bucket_baseline"`
+}
+
+// BucketBaselineConfig adds to the baseline for new buckets. Block Public
+// Access, BucketOwnerEnforced object ownership and a policy denying requests
+// not made over TLS are always applied: this file is the user's to edit, so
+// it cannot turn them off.
+type BucketBaselineConfig struct {
+	// LoggingBucket receives server access logs for new buckets, under
+	// LoggingPrefix (default "<bucket>/"). It must be in the same account
+	// and region as the buckets it logs, and allow the S3 logging service
+	// to write to it.
+	LoggingBucket string `yaml:"logging_bucket"`
+	LoggingPrefix string `yaml:"logging_prefix"`
+
+	// RequiredTags must be given when creating a bucket, e.g. PI, grant
+	// and data classification
+	RequiredTags []string `yaml:"required_tags"`
+
+	// Tags are applied to every new bucket; tags given at creation take
+	// precedence
+	Tags map[string]string `yaml:"tags"`
+
+	// OnFailure is what happens to a new bucket when a baseline step
+	// fails: "rollback" (delete it, the default) or "flag" (keep it,
+	// tagged as not meeting the baseline)
+	OnFailure string `yaml:"on_failure"`
+}
+
+// Rollback reports whether a bucket that fails the baseline is deleted
+func (b BucketBaselineConfig) Rollback() bool {
+	return b.OnFailure != "flag"
 }
 
 // Profile represents an AWS profile configuration
@@ -50,6 +169,46 @@ type TrainingConfig struct {
 	Enabled      bool     `yaml:"enabled"`
 	SkipModules  []string `yaml:"skip_modules"`
 	AutoComplete bool     `yaml:"auto_complete"`
+
+	// GateMode is what the training gate does when the backend cannot be
+	// reached or its answer cannot be used, until an enrolled agent gets a
+	// signed decision that carries the backend's mode: "fail-closed"
+	// (refuse) or "cached" (allow if the backend allowed the user the same
+	// action within GateCacheHours, and its signed decision has not
+	// expired; the default). Only the backend can make the gate fail open.
+	GateMode string `yaml:"gate_mode"`
+
+	// GateCacheHours is how long the agent reuses an allow decision when
+	// the backend cannot be reached and the gate mode is cached. The
+	// backend decides how long a decision is valid for at most.
+	GateCacheHours int `yaml:"gate_cache_hours"`
+}
+
+// Training gate modes a user can configure
+const (
+	GateFailClosed = "fail-closed"
+	GateCached     = "cached"
+)
+
+// Gate returns the configured training gate mode. Any other value, e.g.
+// fail-open written into the file by hand, is taken as cached.
+func (t TrainingConfig) Gate() string {
+	if t.GateMode == GateFailClosed {
+		return GateFailClosed
+	}
+	return GateCached
+}
+
+// DefaultGateCacheHours is the cached mode's TTL when none is configured
+const DefaultGateCacheHours = 24
+
+// GateCacheTTL returns how long an allow decision is reused in cached mode
+func (t TrainingConfig) GateCacheTTL() time.Duration {
+	hours := t.GateCacheHours
+	if hours <= 0 {
+		hours = DefaultGateCacheHours
+	}
+	return time.Duration(hours) * time.Hour
 }
 
 // DefaultConfig returns a new config with default values
@@ -57,8 +216,12 @@ func DefaultConfig() *Config {
 	return &Config{
 		CurrentProfile: "default",
 		Agent: AgentConfig{
-			Host: "127.0.0.1",
-			Port: 8737,
+			Host:                 "127.0.0.1",
+			Port:                 8737,
+			CredentialBackend:    "bolt",
+			CredentialMaxAgeDays: DefaultCredentialMaxAgeDays,
+			MaxJobs:              DefaultMaxJobs,
+			Listen:               "both",
 		},
 		Backend: BackendConfig{
 			URL: "http://localhost:8080",
@@ -72,9 +235,11 @@ func DefaultConfig() *Config {
 			},
 		},
 		Training: TrainingConfig{
-			Enabled:      true,
-			SkipModules:  []string{},
-			AutoComplete: false,
+			Enabled:        true,
+			SkipModules:    []string{},
+			AutoComplete:   false,
+			GateMode:       GateCached,
+			GateCacheHours: DefaultGateCacheHours,
 		},
 	}
 }
@@ -149,12 +314,73 @@ func (c *Config) Set(key, value string) error {
 			return fmt.Errorf("invalid port number: %s", value)
 		}
 		c.Agent.Port = port
+	case "agent.credential_backend":
+		switch value {
+		case "bolt", "keyring", "file":
+			c.Agent.CredentialBackend = value
+		default:
+			return fmt.Errorf("invalid credential backend: %s (expected bolt, keyring or file)", value)
+		}
+	case "agent.credential_max_age_days":
+		var days int
+		if _, err := fmt.Sscanf(value, "%d", &days); err != nil || days <= 0 {
+			return fmt.Errorf("invalid credential max age: %s (expected a positive number of days)", value)
+		}
+		c.Agent.CredentialMaxAgeDays = days
+	case "agent.max_jobs":
+		var n int
+		if _, err := fmt.Sscanf(value, "%d", &n); err != nil || n <= 0 {
+			return fmt.Errorf("invalid max jobs: %s (expected a positive number)", value)
+		}
+		c.Agent.MaxJobs = n
+	case "agent.listen":
+		switch value {
+		case "both", "socket", "tcp":
+			c.Agent.Listen = value
+		default:
+			return fmt.Errorf("invalid listen mode: %s (expected both, socket or tcp)", value)
+		}
 	case "backend.url":
 		c.Backend.URL = value
+	case "backend.policy_key_fingerprint":
+		c.Backend.PolicyKeyFingerprint = value
 	case "training.enabled":
 		c.Training.Enabled = value == "true"
 	case "training.auto_complete":
 		c.Training.AutoComplete = value == "true"
+	case "training.gate_mode":
+		switch value {
+		case GateFailClosed, GateCached:
+			c.Training.GateMode = value
+		case "fail-open":
+			return fmt.Errorf("invalid training gate mode: %s (only the backend can make the gate fail open; expected fail-closed or cached)", value)
+		default:
+			return fmt.Errorf("invalid training gate mode: %s (expected fail-closed or cached)", value)
+		}
+	case "training.gate_cache_hours":
+		var hours int
+		if _, err := fmt.Sscanf(value, "%d", &hours); err != nil || hours <= 0 {
+			return fmt.Errorf("invalid gate cache hours: %s (expected a positive number of hours)", value)
+		}
+		c.Training.GateCacheHours = hours
+	case "s3.bucket_baseline.logging_bucket":
+		c.S3.BucketBaseline.LoggingBucket = value
+	case "s3.bucket_baseline.logging_prefix":
+		c.S3.BucketBaseline.LoggingPrefix = value
+	case "s3.bucket_baseline.required_tags":
+		c.S3.BucketBaseline.RequiredTags = nil
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				c.S3.BucketBaseline.RequiredTags = append(c.S3.BucketBaseline.RequiredTags, tag)
+			}
+		}
+	case "s3.bucket_baseline.on_failure":
+		switch value {
+		case "rollback", "flag":
+			c.S3.BucketBaseline.OnFailure = value
+		default:
+			return fmt.Errorf("invalid baseline failure mode: %s (expected rollback or flag)", value)
+		}
 	default:
 		return fmt.Errorf("unknown config key: %s", key)
 	}
@@ -170,12 +396,37 @@ func (c *Config) Get(key string) (string, error) {
 		return c.Agent.Host, nil
 	case "agent.port":
 		return fmt.Sprintf("%d", c.Agent.Port), nil
+	case "agent.credential_backend":
+		return c.Agent.CredentialBackend, nil
+	case "agent.credential_max_age_days":
+		return fmt.Sprintf("%d", c.Agent.CredentialMaxAgeDays), nil
+	case "agent.max_jobs":
+		return fmt.Sprintf("%d", c.Agent.JobLimit()), nil
+	case "agent.listen":
+		return c.Agent.Listen, nil
 	case "backend.url":
 		return c.Backend.URL, nil
+	case "backend.policy_key_fingerprint":
+		return c.Backend.PolicyKeyFingerprint, nil
 	case "training.enabled":
 		return fmt.Sprintf("%t", c.Training.Enabled), nil
 	case "training.auto_complete":
 		return fmt.Sprintf("%t", c.Training.AutoComplete), nil
+	case "training.gate_mode":
+		return c.Training.Gate(), nil
+	case "training.gate_cache_hours":
+		return fmt.Sprintf("%d", int(c.Training.GateCacheTTL().Hours())), nil
+	case "s3.bucket_baseline.logging_bucket":
+		return c.S3.BucketBaseline.LoggingBucket, nil
+	case "s3.bucket_baseline.logging_prefix":
+		return c.S3.BucketBaseline.LoggingPrefix, nil
+	case "s3.bucket_baseline.required_tags":
+		return strings.Join(c.S3.BucketBaseline.RequiredTags, ","), nil
+	case "s3.bucket_baseline.on_failure":
+		if c.S3.BucketBaseline.OnFailure == "" {
+			return "rollback", nil
+		}
+		return c.S3.BucketBaseline.OnFailure, nil
 	default:
 		return "", fmt.Errorf("unknown config key: %s", key)
 	}
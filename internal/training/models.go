@@ -1,5 +1,7 @@
 package training
 
+import "time"
+
 // Module represents a training module
 type Module struct {
 	ID               string `json:"id"`
@@ -12,8 +14,41 @@ type Module struct {
 type PolicyDecision struct {
 	Action          string   `json:"action"` // "allow" or "block"
 	Reason          string   `json:"reason,omitempty"`
-	RequiredModules []Module `json:"required_modules,omitempty"`
+	RequiredModules []Module `json:"required_modules,omitempty"` // covered by Signature
 	Message         string   `json:"message"`
+
+	// What was decided, and for how long, covered by Signature (see
+	// Signer)
+	UserID          string    `json:"user_id"`
+	RequestedAction string    `json:"requested_action"` // e.g. s3:CreateBucket
+	ResourceHash    string    `json:"resource_hash"`    // see HashResourceDetails
+	IssuedAt        time.Time `json:"issued_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	KeyID           string    `json:"key_id"`
+	Signature       string    `json:"signature"` // base64 Ed25519 signature
+
+	// GateMode is what the agent does while it cannot get a decision,
+	// until it gets another; also covered by Signature
+	GateMode string `json:"gate_mode"`
+}
+
+// Training gate modes: when an enrolled agent cannot reach the backend, or
+// cannot use its answer, it allows the operation (fail-open), refuses it
+// (fail-closed), or allows it only if the backend allowed the user the same
+// action recently and that signed decision has not expired (cached)
+const (
+	GateFailOpen   = "fail-open"
+	GateFailClosed = "fail-closed"
+	GateCached     = "cached"
+)
+
+// ValidGateMode reports whether mode is a training gate mode
+func ValidGateMode(mode string) bool {
+	switch mode {
+	case GateFailOpen, GateFailClosed, GateCached:
+		return true
+	}
+	return false
 }
 
 // Progress represents user training progress
@@ -0,0 +1,253 @@
+package training
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// SigningAlgorithm is the algorithm policy decisions are signed with
+const SigningAlgorithm = "Ed25519"
+
+// signingContext starts every signed payload, so a signature over a policy
+// decision cannot be passed off as one over anything else
+const signingContext = "ark-policy-decision/v1"
+
+// PublicKey is a policy signing key as published by the backend
+type PublicKey struct {
+	Algorithm string `json:"algorithm"`  // Ed25519
+	KeyID     string `json:"key_id"`     // see KeyID
+	PublicKey string `json:"public_key"` // base64
+}
+
+// Key decodes the public key, checking that it matches its key ID
+func (k PublicKey) Key() (ed25519.PublicKey, error) {
+	if k.Algorithm != SigningAlgorithm {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", k.Algorithm)
+	}
+	raw, err := base64.StdEncoding.DecodeString(k.PublicKey)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed public key")
+	}
+	pub := ed25519.PublicKey(raw)
+	if KeyID(pub) != k.KeyID {
+		return nil, fmt.Errorf("public key does not match key ID %s", k.KeyID)
+	}
+	return pub, nil
+}
+
+// KeyID identifies a public key: the first 8 bytes of its SHA-256, in hex
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Fingerprint identifies a public key out of band, e.g. on an institution's
+// web page: its SHA-256, in hex. Unlike a key ID, it is too long for a
+// made-up key to match.
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeFingerprint puts a fingerprint as a person may give it, in upper
+// case or with colons or spaces between bytes, in the form Fingerprint
+// returns
+func NormalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fp))
+}
+
+// HashResourceDetails returns the SHA-256, in hex, of the JSON encoding of a
+// policy check's resource details. Object keys are sorted, so the agent and
+// the backend arrive at the same hash as long as numbers keep the form they
+// were sent in (see json.Decoder.UseNumber).
+func HashResourceDetails(details map[string]interface{}) (string, error) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	data, err := json.Marshal(details)
+	if err != nil {
+		return "", fmt.Errorf("marshal resource details: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Signer signs policy decisions
+type Signer struct {
+	key      ed25519.PrivateKey
+	keyID    string
+	ttl      time.Duration
+	gateMode string
+}
+
+// NewSigner creates a signer whose decisions are valid for ttl and tell
+// agents to fall back on gateMode
+func NewSigner(key ed25519.PrivateKey, ttl time.Duration, gateMode string) *Signer {
+	return &Signer{
+		key:      key,
+		keyID:    KeyID(key.Public().(ed25519.PublicKey)),
+		ttl:      ttl,
+		gateMode: gateMode,
+	}
+}
+
+// PublicKey returns the key agents verify decisions with
+func (s *Signer) PublicKey() PublicKey {
+	return PublicKey{
+		Algorithm: SigningAlgorithm,
+		KeyID:     s.keyID,
+		PublicKey: base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey)),
+	}
+}
+
+// Fingerprint returns the fingerprint of the key agents verify decisions
+// with, for the institution to publish
+func (s *Signer) Fingerprint() string {
+	return Fingerprint(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign binds a decision to the user, action and resource details it was
+// made for, and signs it
+func (s *Signer) Sign(d *PolicyDecision, userID, action string, resourceDetails map[string]interface{}) error {
+	hash, err := HashResourceDetails(resourceDetails)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	d.UserID = userID
+	d.RequestedAction = action
+	d.ResourceHash = hash
+	d.IssuedAt = now
+	d.ExpiresAt = now.Add(s.ttl)
+	d.KeyID = s.keyID
+	d.GateMode = s.gateMode
+
+	payload, err := d.signingPayload()
+	if err != nil {
+		return err
+	}
+	d.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload))
+	return nil
+}
+
+// Verify checks that the decision was signed with pub and has not expired.
+// The caller checks that it is for the user, action and resource in
+// question.
+func (d *PolicyDecision) Verify(pub ed25519.PublicKey, now time.Time) error {
+	if d.Signature == "" {
+		return errors.New("policy decision is not signed")
+	}
+	if d.KeyID != KeyID(pub) {
+		return fmt.Errorf("policy decision is signed with key %s, not the pinned key %s", d.KeyID, KeyID(pub))
+	}
+	sig, err := base64.StdEncoding.DecodeString(d.Signature)
+	if err != nil {
+		return errors.New("malformed policy decision signature")
+	}
+	payload, err := d.signingPayload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return errors.New("policy decision signature is invalid")
+	}
+	if !now.Before(d.ExpiresAt) {
+		return fmt.Errorf("policy decision expired at %s", d.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// signingPayload is what a decision's signature covers
+func (d *PolicyDecision) signingPayload() ([]byte, error) {
+	// A left-out list, as it decodes, signs the same as an empty one
+	modules := d.RequiredModules
+	if modules == nil {
+		modules = []Module{}
+	}
+	payload, err := json.Marshal([]interface{}{
+		signingContext,
+		d.KeyID,
+		d.UserID,
+		d.RequestedAction,
+		d.Action,
+		modules,
+		d.ResourceHash,
+		d.IssuedAt.Unix(),
+		d.ExpiresAt.Unix(),
+		d.GateMode,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal signing payload: %w", err)
+	}
+	return payload, nil
+}
+
+// LoadOrCreateSigningKey reads an Ed25519 private key from a PKCS #8 PEM
+// file, e.g. one made with "openssl genpkey -algorithm ed25519". If the
+// file does not exist, it is created with a new key, and the bool result
+// is true.
+func LoadOrCreateSigningKey(path string) (ed25519.PrivateKey, bool, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := parseSigningKey(data)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %w", path, err)
+		}
+		return key, false, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("read signing key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("generate signing key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("marshal signing key: %w", err)
+	}
+	block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	// O_EXCL so that a key written meanwhile is not overwritten
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, false, fmt.Errorf("create signing key: %w", err)
+	}
+	if _, err := f.Write(block); err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("write signing key: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, false, fmt.Errorf("write signing key: %w", err)
+	}
+	return key, true, nil
+}
+
+// parseSigningKey decodes a PEM-encoded PKCS #8 Ed25519 private key
+func parseSigningKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("not a PEM-encoded PKCS #8 private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is %T, not Ed25519", parsed)
+	}
+	return key, nil
+}
@@ -0,0 +1,83 @@
+package training
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// signedDecision signs decision and returns it as the agent decodes it
+func signedDecision(t *testing.T, s *Signer, decision PolicyDecision) PolicyDecision {
+	t.Helper()
+	if err := s.Sign(&decision, "researcher", "s3:CreateBucket", map[string]interface{}{"bucket_name": "lab-data"}); err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	data, err := json.Marshal(decision)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received PolicyDecision
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+func TestPolicyDecisionSignatureCoversDecision(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSigner(key, time.Hour, GateFailClosed)
+	pub := key.Public().(ed25519.PublicKey)
+
+	allow := signedDecision(t, s, PolicyDecision{Action: "allow", RequiredModules: []Module{}})
+	if err := allow.Verify(pub, time.Now()); err != nil {
+		t.Fatalf("verify allow: %v", err)
+	}
+	block := signedDecision(t, s, PolicyDecision{
+		Action:          "block",
+		RequiredModules: []Module{{ID: "m1", Name: "s3-basics", Title: "S3 basics", EstimatedMinutes: 15}},
+	})
+	if err := block.Verify(pub, time.Now()); err != nil {
+		t.Fatalf("verify block: %v", err)
+	}
+
+	tampered := map[string]func(d *PolicyDecision){
+		"required_modules": func(d *PolicyDecision) { d.RequiredModules[0].Name = "nothing" },
+		"no modules":       func(d *PolicyDecision) { d.RequiredModules = nil },
+		"action":           func(d *PolicyDecision) { d.Action = "allow" },
+		"gate_mode":        func(d *PolicyDecision) { d.GateMode = GateFailOpen },
+		"expires_at":       func(d *PolicyDecision) { d.ExpiresAt = d.ExpiresAt.Add(time.Hour) },
+	}
+	for field, tamper := range tampered {
+		d := block
+		d.RequiredModules = append([]Module(nil), block.RequiredModules...)
+		tamper(&d)
+		if err := d.Verify(pub, time.Now()); err == nil {
+			t.Errorf("decision with changed %s verified", field)
+		}
+	}
+}
+
+func TestNormalizeFingerprint(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := Fingerprint(pub)
+	var colons string
+	for i := 0; i < len(fp); i += 2 {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += fp[i : i+2]
+	}
+	for _, given := range []string{fp, colons} {
+		if NormalizeFingerprint(given) != fp {
+			t.Errorf("NormalizeFingerprint(%q) = %q, want %q", given, NormalizeFingerprint(given), fp)
+		}
+	}
+}
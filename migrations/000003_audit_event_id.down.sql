@@ -0,0 +1,7 @@
+-- Rollback audit event IDs and occurrence times
+
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS occurred_at;
+
+DROP INDEX IF EXISTS idx_audit_logs_event_id;
+
+ALTER TABLE audit_logs DROP COLUMN IF EXISTS event_id;
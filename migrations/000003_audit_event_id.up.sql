@@ -0,0 +1,11 @@
+-- Client-supplied audit event IDs, so that an agent can retry delivering
+-- an event without it being recorded twice. Entries without one (NULL) are
+-- not deduplicated.
+ALTER TABLE audit_logs ADD COLUMN event_id VARCHAR(64);
+
+CREATE UNIQUE INDEX idx_audit_logs_event_id ON audit_logs(event_id);
+
+-- When the client says the event happened, e.g. an agent delivering events
+-- it queued while the backend was unreachable. created_at is always when
+-- the backend stored the entry.
+ALTER TABLE audit_logs ADD COLUMN occurred_at TIMESTAMP;
@@ -0,0 +1,380 @@
+// Package arkclient is a Go client for the Ark agent and backend APIs.
+//
+// Agent calls go through the local agent, which holds the AWS credentials
+// and enforces the training gate, exactly as the ark CLI does:
+//
+//	agent, err := arkclient.NewAgent(arkclient.AgentOptions{})
+//	if err != nil {
+//		return err
+//	}
+//	bucket, err := agent.CreateBucket(ctx, arkclient.CreateBucketInput{BucketName: "my-data"})
+//	var training *arkclient.TrainingRequiredError
+//	if errors.As(err, &training) {
+//		for _, m := range training.Modules {
+//			fmt.Println("complete:", m.Title)
+//		}
+//	}
+package arkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+)
+
+// AgentOptions configures an Agent client
+type AgentOptions struct {
+	// ConfigPath is the Ark config file naming the agent's address. The
+	// default is ARK_CONFIG or ~/.ark/config.yml.
+	ConfigPath string
+
+	// Timeout bounds each request (30 seconds if zero)
+	Timeout time.Duration
+
+	// PromptMFA is called for a one-time code when a profile needs one; the
+	// request is then retried. Without it, such requests fail with
+	// *MFARequiredError.
+	PromptMFA func(profile, serialNumber string) (string, error)
+}
+
+// Agent is a client for the local Ark agent. It connects over the agent's
+// Unix socket unless the agent is configured to listen on TCP only, in
+// which case it sends the agent token, so it must run as the user who owns
+// the agent.
+type Agent struct {
+	client *agentclient.Client
+}
+
+// NewAgent creates an agent client
+func NewAgent(opts AgentOptions) (*Agent, error) {
+	clientOpts := agentclient.Options{
+		ConfigPath: opts.ConfigPath,
+		Timeout:    opts.Timeout,
+	}
+	if opts.PromptMFA != nil {
+		clientOpts.PromptMFA = func(challenge *agentclient.Error) (string, error) {
+			return opts.PromptMFA(challenge.Profile, challenge.MFASerial)
+		}
+	}
+
+	client, err := agentclient.New(clientOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &Agent{client: client}, nil
+}
+
+// do sends a request, converting failures to typed errors
+func (a *Agent) do(ctx context.Context, action, method, path string, body, dest interface{}) error {
+	if err := a.client.Do(ctx, method, path, body, dest); err != nil {
+		return fromAgent(action, err)
+	}
+	return nil
+}
+
+// Health checks that the agent is running
+func (a *Agent) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := a.do(ctx, "", http.MethodGet, "/api/system/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Version returns the agent's build information
+func (a *Agent) Version(ctx context.Context) (*Version, error) {
+	var version Version
+	if err := a.do(ctx, "", http.MethodGet, "/api/system/version", nil, &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// Enrollment returns the backend policy key the agent has pinned. It fails
+// with an *APIError with status 404 if the agent is not enrolled.
+func (a *Agent) Enrollment(ctx context.Context) (*Enrollment, error) {
+	var e Enrollment
+	if err := a.do(ctx, "", http.MethodGet, "/api/enrollment", nil, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Enroll has the agent fetch and pin the backend's policy key. A non-empty
+// keyID is the key ID the backend's key must have. A different pinned key
+// is only replaced with force; otherwise, as for a keyID mismatch, Enroll
+// fails with an *APIError with status 409.
+func (a *Agent) Enroll(ctx context.Context, keyID string, force bool) (*Enrollment, error) {
+	body := map[string]interface{}{"key_id": keyID, "force": force}
+	var e Enrollment
+	if err := a.do(ctx, "", http.MethodPost, "/api/enrollment", body, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// SetCredentials stores a credential profile
+func (a *Agent) SetCredentials(ctx context.Context, input CredentialsInput) (*StoredCredentials, error) {
+	var stored StoredCredentials
+	if err := a.do(ctx, "", http.MethodPost, "/api/credentials", input, &stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// ListCredentials lists the stored profiles
+func (a *Agent) ListCredentials(ctx context.Context) ([]CredentialProfile, error) {
+	var profiles []CredentialProfile
+	if err := a.do(ctx, "", http.MethodGet, "/api/credentials", nil, &profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// CredentialStatus reports the lifecycle state of one profile, or of all
+// profiles if profile is empty. With validate, the credentials are checked
+// with AWS STS first.
+func (a *Agent) CredentialStatus(ctx context.Context, profile string, validate bool) ([]CredentialStatus, error) {
+	query := url.Values{}
+	if profile != "" {
+		query.Set("profile", profile)
+	}
+	if validate {
+		query.Set("validate", "true")
+	}
+
+	var statuses []CredentialStatus
+	if err := a.do(ctx, "", http.MethodGet, "/api/credentials/status?"+query.Encode(), nil, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// DeleteCredentials removes a stored profile
+func (a *Agent) DeleteCredentials(ctx context.Context, profile string) error {
+	return a.do(ctx, "", http.MethodDelete, "/api/credentials/"+url.PathEscape(profile), nil, nil)
+}
+
+// RotateCredentials replaces a profile's IAM access key. A rotation that
+// succeeded but could not delete the old key has Status "partial". A failed
+// rotation returns its steps along with the error. Waiting for a new key can
+// take up to a minute, so allow for that in ctx and AgentOptions.Timeout.
+func (a *Agent) RotateCredentials(ctx context.Context, profile string) (*Rotation, error) {
+	var rotation Rotation
+	err := a.client.Do(ctx, http.MethodPost, "/api/credentials/"+url.PathEscape(profile)+"/rotate", nil, &rotation)
+	if err == nil {
+		return &rotation, nil
+	}
+
+	var apiErr *agentclient.Error
+	if errors.As(err, &apiErr) && apiErr.Decode(&rotation) == nil && rotation.Status == "failure" {
+		return &rotation, &APIError{StatusCode: apiErr.StatusCode, Message: rotation.Error}
+	}
+	return nil, fromAgent("", err)
+}
+
+// ImportCredentials creates profiles from the AWS CLI configuration. With
+// no names, every profile is imported.
+func (a *Agent) ImportCredentials(ctx context.Context, overwrite bool, awsProfiles ...string) (*ImportResult, error) {
+	var result ImportResult
+	body := map[string]interface{}{
+		"aws_profiles": awsProfiles,
+		"all":          len(awsProfiles) == 0,
+		"overwrite":    overwrite,
+	}
+	if err := a.do(ctx, "", http.MethodPost, "/api/credentials/import", body, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// RekeyCredentials re-encrypts stored credentials with a new key file, or a
+// key derived from passphrase if it is not empty
+func (a *Agent) RekeyCredentials(ctx context.Context, passphrase string) error {
+	return a.do(ctx, "", http.MethodPost, "/api/credentials/rekey", map[string]string{"passphrase": passphrase}, nil)
+}
+
+// MigrateCredentials moves stored credentials to another backend: bolt,
+// keyring or file
+func (a *Agent) MigrateCredentials(ctx context.Context, to string) (*Migration, error) {
+	var migration Migration
+	if err := a.do(ctx, "", http.MethodPost, "/api/credentials/migrate", map[string]string{"to": to}, &migration); err != nil {
+		return nil, err
+	}
+	return &migration, nil
+}
+
+// StartSSOLogin starts an IAM Identity Center device-code login. The start
+// URL and region may be empty when profile is an existing SSO profile.
+func (a *Agent) StartSSOLogin(ctx context.Context, startURL, ssoRegion, profile string) (*SSOLogin, error) {
+	var login SSOLogin
+	body := map[string]string{
+		"start_url":  startURL,
+		"sso_region": ssoRegion,
+		"profile":    profile,
+	}
+	if err := a.do(ctx, "", http.MethodPost, "/api/sso/login", body, &login); err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+// PollSSOLogin checks once whether the user has approved a login. Poll no
+// more often than SSOLogin.Interval, and back off on "slow_down".
+func (a *Agent) PollSSOLogin(ctx context.Context, loginID string) (*SSOLoginResult, error) {
+	var result SSOLoginResult
+	if err := a.do(ctx, "", http.MethodPost, "/api/sso/login/"+url.PathEscape(loginID)+"/poll", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// SetSSOProfile stores an SSO profile and confirms it can get credentials
+func (a *Agent) SetSSOProfile(ctx context.Context, input SSOProfileInput) (*SSOProfile, error) {
+	var profile SSOProfile
+	if err := a.do(ctx, "", http.MethodPost, "/api/sso/profiles", input, &profile); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// CreateBucket creates an S3 bucket and applies the agent's bucket
+// baseline. It fails with *TrainingRequiredError when the training gate
+// blocks it, and with an *APIError with status 502 when a baseline step
+// failed and the bucket was rolled back.
+func (a *Agent) CreateBucket(ctx context.Context, input CreateBucketInput) (*Bucket, error) {
+	if input.Encryption.Type == "" {
+		input.Encryption.Type = "AES256"
+	}
+
+	var bucket Bucket
+	if err := a.do(ctx, "s3:CreateBucket", http.MethodPost, "/api/s3/buckets", input, &bucket); err != nil {
+		return nil, err
+	}
+	return &bucket, nil
+}
+
+// ListBuckets lists the buckets in a profile's account; an empty profile
+// means "default"
+func (a *Agent) ListBuckets(ctx context.Context, profile string) ([]BucketSummary, error) {
+	var buckets []BucketSummary
+	path := "/api/s3/buckets?" + url.Values{"profile": {profile}}.Encode()
+	if err := a.do(ctx, "s3:ListBuckets", http.MethodGet, path, nil, &buckets); err != nil {
+		return nil, err
+	}
+	return buckets, nil
+}
+
+// DescribeBucket reads a bucket's region, encryption, versioning, public
+// access block, tags and policy
+func (a *Agent) DescribeBucket(ctx context.Context, profile, bucket string) (*BucketDetails, error) {
+	var details BucketDetails
+	path := "/api/s3/buckets/" + url.PathEscape(bucket) + "?" + url.Values{"profile": {profile}}.Encode()
+	if err := a.do(ctx, "s3:DescribeBucket", http.MethodGet, path, nil, &details); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// DeleteBucket deletes a bucket. With force, its objects, versions and
+// delete markers are deleted first; otherwise a bucket that is not empty
+// fails with an *APIError with status 409.
+func (a *Agent) DeleteBucket(ctx context.Context, profile, bucket string, force bool) (*DeletedBucket, error) {
+	query := url.Values{"profile": {profile}}
+	if force {
+		query.Set("force", "true")
+	}
+
+	var deleted DeletedBucket
+	path := "/api/s3/buckets/" + url.PathEscape(bucket) + "?" + query.Encode()
+	if err := a.do(ctx, "s3:DeleteBucket", http.MethodDelete, path, nil, &deleted); err != nil {
+		return nil, err
+	}
+	return &deleted, nil
+}
+
+// StartTransfer starts copying a file to or from S3 as an agent job. Follow
+// it with Job until it is done. Starting an interrupted transfer again
+// resumes it; a transfer that is already running fails with an *APIError
+// with status 409.
+func (a *Agent) StartTransfer(ctx context.Context, input TransferInput) (*Job, error) {
+	action := "s3:PutObject"
+	if strings.HasPrefix(input.Source, "s3://") {
+		action = "s3:GetObject"
+	}
+
+	var job Job
+	if err := a.do(ctx, action, http.MethodPost, "/api/s3/transfers", input, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// StartSync starts syncing a local directory with an S3 prefix as an agent
+// job. Follow it with Job until it is done; the job's result is a
+// SyncResult. With DryRun, the result is the plan and nothing is changed.
+func (a *Agent) StartSync(ctx context.Context, input SyncInput) (*Job, error) {
+	var job Job
+	if err := a.do(ctx, "s3:Sync", http.MethodPost, "/api/s3/sync", input, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Jobs lists the agent's jobs, newest first. Finished jobs are kept for a
+// week.
+func (a *Agent) Jobs(ctx context.Context) ([]Job, error) {
+	var resp struct {
+		Jobs []Job `json:"jobs"`
+	}
+	if err := a.do(ctx, "", http.MethodGet, "/api/jobs", nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Jobs, nil
+}
+
+// Job returns a job's status and progress
+func (a *Agent) Job(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := a.do(ctx, "", http.MethodGet, "/api/jobs/"+url.PathEscape(id), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WatchJob calls fn with a job's state now and after every change until
+// the job finishes, fn returns an error or ctx is cancelled. It returns
+// the last state seen.
+func (a *Agent) WatchJob(ctx context.Context, id string, fn func(*Job) error) (*Job, error) {
+	var job Job
+	err := a.client.Stream(ctx, "/api/jobs/"+url.PathEscape(id)+"/events", func(data []byte) error {
+		if err := json.Unmarshal(data, &job); err != nil {
+			return fmt.Errorf("decode agent event: %w", err)
+		}
+		if fn != nil {
+			return fn(&job)
+		}
+		return nil
+	})
+	if err != nil {
+		return &job, fromAgent("", err)
+	}
+	return &job, nil
+}
+
+// CancelJob stops a queued or running job. A running job finishes as
+// canceled once its work has stopped.
+func (a *Agent) CancelJob(ctx context.Context, id string) (*Job, error) {
+	var job Job
+	if err := a.do(ctx, "", http.MethodDelete, "/api/jobs/"+url.PathEscape(id), nil, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -0,0 +1,164 @@
+package arkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/agent/daemon"
+	"github.com/scttfrdmn/ark/internal/agentclient"
+)
+
+// newTestAgent returns an agent client for an agent stand-in serving h on
+// TCP, with an agent token in a temporary data directory
+func newTestAgent(t *testing.T, h http.HandlerFunc, opts AgentOptions) *Agent {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	dataDir := t.TempDir()
+	t.Setenv("ARK_AGENT_DATA", dataDir)
+	if err := os.WriteFile(filepath.Join(dataDir, daemon.TokenFileName), []byte("secret-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.ConfigPath = filepath.Join(t.TempDir(), "config.yaml")
+	config := fmt.Sprintf("agent:\n  host: %s\n  port: %s\n  listen: tcp\n", host, port)
+	if err := os.WriteFile(opts.ConfigPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts.Timeout = 2 * time.Second
+
+	agent, err := NewAgent(opts)
+	if err != nil {
+		t.Fatalf("new agent client: %v", err)
+	}
+	return agent
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestAgentListBuckets(t *testing.T) {
+	agent := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/api/s3/buckets" || r.URL.Query().Get("profile") != "research" {
+			http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret-token" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "Invalid agent token"})
+			return
+		}
+		writeJSON(w, http.StatusOK, []map[string]string{
+			{"name": "lab-data", "region": "us-west-2", "created_at": "2026-01-02T03:04:05Z"},
+		})
+	}, AgentOptions{})
+
+	buckets, err := agent.ListBuckets(context.Background(), "research")
+	if err != nil {
+		t.Fatalf("list buckets: %v", err)
+	}
+	if len(buckets) != 1 || buckets[0].Name != "lab-data" || buckets[0].Region != "us-west-2" {
+		t.Fatalf("buckets = %+v", buckets)
+	}
+}
+
+func TestAgentTrainingRequired(t *testing.T) {
+	agent := newTestAgent(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusForbidden, map[string]interface{}{
+			"status": "blocked",
+			"reason": "training_required",
+			"required_modules": []Module{
+				{ID: "m1", Name: "s3-basics", Title: "S3 Basics", EstimatedMinutes: 10},
+				{ID: "m2", Name: "data-classification", Title: "Data Classification"},
+			},
+		})
+	}, AgentOptions{})
+
+	_, err := agent.CreateBucket(context.Background(), CreateBucketInput{BucketName: "lab-data"})
+	var training *TrainingRequiredError
+	if !errors.As(err, &training) {
+		t.Fatalf("create bucket: got %v, want *TrainingRequiredError", err)
+	}
+	if !errors.Is(err, ErrTrainingRequired) {
+		t.Fatal("error does not match ErrTrainingRequired")
+	}
+	if training.Action != "s3:CreateBucket" || len(training.Modules) != 2 || training.Modules[0].Title != "S3 Basics" {
+		t.Fatalf("training error = %+v", training)
+	}
+	if want := "training required for s3:CreateBucket: s3-basics, data-classification"; err.Error() != want {
+		t.Fatalf("message = %q, want %q", err.Error(), want)
+	}
+}
+
+// mfaAgent is an agent stand-in that wants code 123456 for every request
+func mfaAgent(codes *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code := r.Header.Get(agentclient.MFACodeHeader)
+		*codes = append(*codes, code)
+		if code != "123456" {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{
+				"status":     "blocked",
+				"reason":     "mfa_required",
+				"profile":    "research",
+				"mfa_serial": "arn:aws:iam::123456789012:mfa/researcher",
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, []BucketSummary{{Name: "lab-data"}})
+	}
+}
+
+func TestAgentMFARequired(t *testing.T) {
+	var codes []string
+	agent := newTestAgent(t, mfaAgent(&codes), AgentOptions{})
+
+	_, err := agent.ListBuckets(context.Background(), "research")
+	var mfa *MFARequiredError
+	if !errors.As(err, &mfa) || !errors.Is(err, ErrMFARequired) {
+		t.Fatalf("list buckets: got %v, want *MFARequiredError", err)
+	}
+	if mfa.Profile != "research" || mfa.SerialNumber != "arn:aws:iam::123456789012:mfa/researcher" {
+		t.Fatalf("MFA error = %+v", mfa)
+	}
+	if len(codes) != 1 {
+		t.Fatalf("agent got %d requests without a prompt, want 1", len(codes))
+	}
+}
+
+func TestAgentPromptsForMFACode(t *testing.T) {
+	var codes []string
+	var prompted []string
+	agent := newTestAgent(t, mfaAgent(&codes), AgentOptions{
+		PromptMFA: func(profile, serialNumber string) (string, error) {
+			prompted = append(prompted, profile+" "+serialNumber)
+			return "123456", nil
+		},
+	})
+
+	buckets, err := agent.ListBuckets(context.Background(), "research")
+	if err != nil || len(buckets) != 1 {
+		t.Fatalf("list buckets = %v, %v", buckets, err)
+	}
+	if len(prompted) != 1 || prompted[0] != "research arn:aws:iam::123456789012:mfa/researcher" {
+		t.Fatalf("prompted for %v", prompted)
+	}
+	if len(codes) != 2 || codes[1] != "123456" {
+		t.Fatalf("agent got codes %q, want a retry with the code", codes)
+	}
+}
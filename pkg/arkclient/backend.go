@@ -0,0 +1,195 @@
+package arkclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultBackendURL is used when neither a URL nor ARK_BACKEND_URL is set
+const DefaultBackendURL = "http://localhost:8080"
+
+// BackendOptions configures a Backend client
+type BackendOptions struct {
+	// HTTPClient sends the requests (a client with Timeout if nil)
+	HTTPClient *http.Client
+
+	// Timeout bounds each request when HTTPClient is nil (30 seconds if zero)
+	Timeout time.Duration
+}
+
+// Backend is a client for the Ark backend, which evaluates training policy
+// and keeps the audit log
+type Backend struct {
+	baseURL string
+	http    *http.Client
+}
+
+// NewBackend creates a backend client. An empty baseURL means
+// ARK_BACKEND_URL, or DefaultBackendURL if that is not set.
+func NewBackend(baseURL string, opts BackendOptions) *Backend {
+	if baseURL == "" {
+		baseURL = os.Getenv("ARK_BACKEND_URL")
+	}
+	if baseURL == "" {
+		baseURL = DefaultBackendURL
+	}
+
+	client := opts.HTTPClient
+	if client == nil {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+		client = &http.Client{Timeout: timeout}
+	}
+
+	return &Backend{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		http:    client,
+	}
+}
+
+// Health checks that the backend is running
+func (b *Backend) Health(ctx context.Context) (*Health, error) {
+	var health Health
+	if err := b.do(ctx, http.MethodGet, "/api/system/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// Version returns the backend's build information
+func (b *Backend) Version(ctx context.Context) (*Version, error) {
+	var version Version
+	if err := b.do(ctx, http.MethodGet, "/api/system/version", nil, &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// CheckPolicy asks whether a user may perform an action. A block is
+// returned both as the decision and as *TrainingRequiredError.
+func (b *Backend) CheckPolicy(ctx context.Context, check PolicyCheck) (*PolicyDecision, error) {
+	var decision PolicyDecision
+	if err := b.do(ctx, http.MethodPost, "/api/policies/check", check, &decision); err != nil {
+		return nil, err
+	}
+
+	if decision.Action == "block" {
+		return &decision, &TrainingRequiredError{
+			Action:  check.Action,
+			Message: decision.Message,
+			Modules: decision.RequiredModules,
+		}
+	}
+	return &decision, nil
+}
+
+// PolicyPublicKey returns the key the backend signs policy decisions with.
+// Verify a decision with PolicyPublicKey.Key and PolicyDecision.Verify.
+func (b *Backend) PolicyPublicKey(ctx context.Context) (*PolicyPublicKey, error) {
+	var key PolicyPublicKey
+	if err := b.do(ctx, http.MethodGet, "/api/policies/public-key", nil, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// LogAudit records an audit log entry and returns its ID
+func (b *Backend) LogAudit(ctx context.Context, entry AuditEntry) (string, error) {
+	var resp struct {
+		LogID string `json:"log_id"`
+	}
+	if err := b.do(ctx, http.MethodPost, "/api/audit/log", entry, &resp); err != nil {
+		return "", err
+	}
+	return resp.LogID, nil
+}
+
+// QueryAudit returns the most recent audit log entries matching query
+func (b *Backend) QueryAudit(ctx context.Context, query AuditQuery) ([]AuditEntry, error) {
+	params := url.Values{}
+	for key, value := range map[string]string{
+		"user_id":       query.UserID,
+		"action":        query.Action,
+		"resource_type": query.ResourceType,
+		"status":        query.Status,
+	} {
+		if value != "" {
+			params.Set(key, value)
+		}
+	}
+
+	var entries []AuditEntry
+	if err := b.do(ctx, http.MethodGet, "/api/audit/logs?"+params.Encode(), nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// TrainingProgress returns a user's progress on each training module
+func (b *Backend) TrainingProgress(ctx context.Context, userID string) ([]Progress, error) {
+	var resp struct {
+		Progress []Progress `json:"progress"`
+	}
+	if err := b.do(ctx, http.MethodGet, "/api/training/progress/"+url.PathEscape(userID), nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Progress, nil
+}
+
+// do sends a request to the backend. Error responses become *APIError, and
+// transport failures wrap ErrUnavailable.
+func (b *Backend) do(ctx context.Context, method, path string, body, dest interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, b.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := b.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: backend at %s: %v", ErrUnavailable, b.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read backend response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(respBody, &errResp)
+		return &APIError{StatusCode: resp.StatusCode, Message: errResp.Error}
+	}
+
+	if dest == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, dest); err != nil {
+		return fmt.Errorf("decode backend response: %w", err)
+	}
+	return nil
+}
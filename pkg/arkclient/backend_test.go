@@ -0,0 +1,74 @@
+package arkclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendCheckPolicy(t *testing.T) {
+	var checks []PolicyCheck
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/policies/check" {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no route"})
+			return
+		}
+		var check PolicyCheck
+		if err := json.NewDecoder(r.Body).Decode(&check); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+			return
+		}
+		checks = append(checks, check)
+		if check.Action == "s3:DeleteBucket" {
+			writeJSON(w, http.StatusOK, PolicyDecision{
+				Action:          "block",
+				Message:         "Complete the required training",
+				RequiredModules: []Module{{Name: "s3-basics"}},
+			})
+			return
+		}
+		writeJSON(w, http.StatusOK, PolicyDecision{Action: "allow"})
+	}))
+	defer srv.Close()
+	backend := NewBackend(srv.URL+"/", BackendOptions{})
+	ctx := context.Background()
+
+	decision, err := backend.CheckPolicy(ctx, PolicyCheck{UserID: "researcher", Action: "s3:CreateBucket", ResourceType: "s3:bucket"})
+	if err != nil || decision.Action != "allow" {
+		t.Fatalf("allowed check = %+v, %v", decision, err)
+	}
+	if len(checks) != 1 || checks[0].UserID != "researcher" || checks[0].ResourceType != "s3:bucket" {
+		t.Fatalf("backend got %+v", checks)
+	}
+
+	// A block is both the decision and an error
+	decision, err = backend.CheckPolicy(ctx, PolicyCheck{UserID: "researcher", Action: "s3:DeleteBucket"})
+	var training *TrainingRequiredError
+	if !errors.As(err, &training) || decision == nil || decision.Action != "block" {
+		t.Fatalf("blocked check = %+v, %v", decision, err)
+	}
+	if training.Action != "s3:DeleteBucket" || training.Message != "Complete the required training" || len(training.Modules) != 1 {
+		t.Fatalf("training error = %+v", training)
+	}
+
+	// Error responses carry the backend's message and status
+	_, err = backend.TrainingProgress(ctx, "researcher")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Message != "no route" || !errors.Is(err, ErrNotFound) {
+		t.Fatalf("progress: got %v, want a 404 *APIError", err)
+	}
+}
+
+func TestBackendUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, err := NewBackend(srv.URL, BackendOptions{Timeout: time.Second}).Health(context.Background())
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("health: got %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,142 @@
+package arkclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/scttfrdmn/ark/internal/agentclient"
+)
+
+// Sentinel errors for errors.Is; the typed errors below match them
+var (
+	ErrTrainingRequired = errors.New("training required")
+	ErrMFARequired      = errors.New("MFA code required")
+	ErrSSOLoginRequired = errors.New("SSO login required")
+	ErrNotFound         = errors.New("not found")
+	ErrUnavailable      = errors.New("service unavailable")
+)
+
+// TrainingRequiredError means the training gate blocked an action until the
+// listed modules are completed
+type TrainingRequiredError struct {
+	Action  string
+	Message string
+	Modules []Module
+}
+
+func (e *TrainingRequiredError) Error() string {
+	names := make([]string, len(e.Modules))
+	for i, m := range e.Modules {
+		names[i] = m.Name
+	}
+	msg := "training required"
+	if e.Action != "" {
+		msg += " for " + e.Action
+	}
+	if len(names) > 0 {
+		msg += ": " + strings.Join(names, ", ")
+	}
+	return msg
+}
+
+func (e *TrainingRequiredError) Is(target error) bool {
+	return target == ErrTrainingRequired
+}
+
+// MFARequiredError means the profile needs a code from its MFA device. Set
+// AgentOptions.PromptMFA to have the client ask for one and retry.
+type MFARequiredError struct {
+	Profile      string
+	SerialNumber string
+	Message      string // set when a code was rejected
+}
+
+func (e *MFARequiredError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("profile %s requires an MFA code: %s", e.Profile, e.Message)
+	}
+	return fmt.Sprintf("profile %s requires an MFA code from %s", e.Profile, e.SerialNumber)
+}
+
+func (e *MFARequiredError) Is(target error) bool {
+	return target == ErrMFARequired
+}
+
+// SSOLoginRequiredError means the profile's Identity Center session has
+// expired; log in again with 'ark login --sso'
+type SSOLoginRequiredError struct {
+	Profile  string
+	StartURL string
+}
+
+func (e *SSOLoginRequiredError) Error() string {
+	return fmt.Sprintf("profile %s requires an SSO login at %s", e.Profile, e.StartURL)
+}
+
+func (e *SSOLoginRequiredError) Is(target error) bool {
+	return target == ErrSSOLoginRequired
+}
+
+// APIError is any other error response from the agent or backend
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("request failed with status %d", e.StatusCode)
+	}
+	return e.Message
+}
+
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// blockedResponse is the body of a blocked agent request
+type blockedResponse struct {
+	Status          string   `json:"status"`
+	Reason          string   `json:"reason"`
+	Error           string   `json:"error"`
+	Message         string   `json:"message"`
+	Profile         string   `json:"profile"`
+	MFASerial       string   `json:"mfa_serial"`
+	StartURL        string   `json:"start_url"`
+	RequiredModules []Module `json:"required_modules"`
+}
+
+// fromAgent converts agent client errors into the typed errors above
+func fromAgent(action string, err error) error {
+	var unavailable *agentclient.UnavailableError
+	if errors.As(err, &unavailable) {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	var apiErr *agentclient.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	var body blockedResponse
+	json.Unmarshal(apiErr.Body, &body)
+
+	switch body.Reason {
+	case "training_required":
+		return &TrainingRequiredError{Action: action, Message: body.Message, Modules: body.RequiredModules}
+	case "mfa_required":
+		return &MFARequiredError{Profile: body.Profile, SerialNumber: body.MFASerial, Message: body.Error}
+	case "sso_login_required":
+		return &SSOLoginRequiredError{Profile: body.Profile, StartURL: body.StartURL}
+	}
+	return &APIError{StatusCode: apiErr.StatusCode, Message: apiErr.Message}
+}
@@ -0,0 +1,404 @@
+package arkclient
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/scttfrdmn/ark/internal/audit"
+	"github.com/scttfrdmn/ark/internal/training"
+)
+
+// Types shared with the backend
+type (
+	// Module is a training module a user may be required to complete
+	Module = training.Module
+
+	// PolicyDecision is the backend's ruling on an action, signed with the
+	// backend's policy key
+	PolicyDecision = training.PolicyDecision
+
+	// PolicyPublicKey is the key policy decisions are signed with
+	PolicyPublicKey = training.PublicKey
+
+	// Progress is a user's progress on one training module
+	Progress = training.Progress
+
+	// AuditEntry is an audit log entry
+	AuditEntry = audit.LogEntry
+)
+
+// Health is the health check response of the agent or backend
+type Health struct {
+	Status  string `json:"status"`
+	Version string `json:"version"`
+	Time    string `json:"time"`
+}
+
+// Version describes an agent or backend build
+type Version struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+}
+
+// CredentialsInput stores a profile. Set access keys, a role to assume from
+// a source profile, or a credential_process command.
+type CredentialsInput struct {
+	Profile           string    `json:"profile"`
+	Region            string    `json:"region,omitempty"`
+	AccessKeyID       string    `json:"access_key_id,omitempty"`
+	SecretAccessKey   string    `json:"secret_access_key,omitempty"`
+	SessionToken      string    `json:"session_token,omitempty"`
+	Expiration        time.Time `json:"expiration,omitempty"`
+	RoleARN           string    `json:"role_arn,omitempty"`
+	SourceProfile     string    `json:"source_profile,omitempty"`
+	ExternalID        string    `json:"external_id,omitempty"`
+	MFASerial         string    `json:"mfa_serial,omitempty"`
+	RoleSessionName   string    `json:"role_session_name,omitempty"`
+	CredentialProcess string    `json:"credential_process,omitempty"`
+
+	// Validate checks the credentials with AWS STS before storing them
+	Validate bool `json:"validate,omitempty"`
+}
+
+// StoredCredentials is the identity of a newly stored profile; AccountID and
+// ARN are set only when the credentials were validated
+type StoredCredentials struct {
+	Profile   string `json:"profile"`
+	AccountID string `json:"account_id"`
+	ARN       string `json:"arn"`
+}
+
+// CredentialProfile is a stored profile, without its secrets
+type CredentialProfile struct {
+	Profile     string     `json:"profile"`
+	Type        string     `json:"type"` // static, role, sso, process
+	Region      string     `json:"region"`
+	AccountID   string     `json:"account_id,omitempty"`
+	ARN         string     `json:"arn,omitempty"`
+	ValidatedAt *time.Time `json:"validated_at,omitempty"`
+}
+
+// CredentialStatus is the lifecycle state of a stored profile
+type CredentialStatus struct {
+	Profile     string     `json:"profile"`
+	Type        string     `json:"type"`
+	Region      string     `json:"region,omitempty"`
+	AccountID   string     `json:"account_id,omitempty"`
+	ARN         string     `json:"arn,omitempty"`
+	StoredAt    *time.Time `json:"stored_at,omitempty"`
+	ValidatedAt *time.Time `json:"validated_at,omitempty"`
+	Expiration  *time.Time `json:"expiration,omitempty"`
+	AgeDays     *int       `json:"age_days,omitempty"`
+	MaxAgeDays  int        `json:"max_age_days"`
+	State       string     `json:"state"` // ok, expiring, expired, rotation_due, invalid
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// RotationStep is the outcome of one access key rotation step
+type RotationStep struct {
+	Name        string `json:"name"`
+	AccessKeyID string `json:"access_key_id"`
+	Status      string `json:"status"` // success, failure
+	Error       string `json:"error,omitempty"`
+}
+
+// Rotation is the result of an access key rotation
+type Rotation struct {
+	Status         string         `json:"status"` // success, partial, failure
+	Profile        string         `json:"profile"`
+	Rotated        bool           `json:"rotated"`
+	OldAccessKeyID string         `json:"old_access_key_id"`
+	NewAccessKeyID string         `json:"new_access_key_id"`
+	Steps          []RotationStep `json:"steps"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// ImportResult lists the profiles imported from the AWS CLI configuration
+type ImportResult struct {
+	Imported []struct {
+		Profile string `json:"profile"`
+		Type    string `json:"type"`
+		Region  string `json:"region,omitempty"`
+	} `json:"imported"`
+	Skipped []struct {
+		Profile string `json:"profile"`
+		Reason  string `json:"reason"`
+	} `json:"skipped"`
+}
+
+// Migration is the result of moving credentials to another backend
+type Migration struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Migrated int    `json:"migrated"`
+
+	// Warning is set when old copies could not be removed from the
+	// source backend
+	Warning string `json:"warning,omitempty"`
+}
+
+// SSOLogin is a pending IAM Identity Center device-code login
+type SSOLogin struct {
+	LoginID                 string    `json:"login_id"`
+	StartURL                string    `json:"start_url"`
+	SSORegion               string    `json:"sso_region"`
+	UserCode                string    `json:"user_code"`
+	VerificationURI         string    `json:"verification_uri"`
+	VerificationURIComplete string    `json:"verification_uri_complete"`
+	Interval                int       `json:"interval"`
+	ExpiresAt               time.Time `json:"expires_at"`
+
+	// AccountID and RoleName come from the profile being logged in, if any
+	AccountID string `json:"account_id"`
+	RoleName  string `json:"role_name"`
+}
+
+// SSORole is an account and role reachable through Identity Center
+type SSORole struct {
+	AccountID    string `json:"account_id"`
+	AccountName  string `json:"account_name"`
+	EmailAddress string `json:"email_address,omitempty"`
+	RoleName     string `json:"role_name"`
+}
+
+// SSOLoginResult is the outcome of polling a login; Roles is set once the
+// login is complete
+type SSOLoginResult struct {
+	Status    string    `json:"status"` // pending, slow_down, complete
+	StartURL  string    `json:"start_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Roles     []SSORole `json:"roles"`
+}
+
+// SSOProfileInput stores an SSO profile for an account and role
+type SSOProfileInput struct {
+	Profile   string `json:"profile"`
+	StartURL  string `json:"start_url"`
+	SSORegion string `json:"sso_region"`
+	AccountID string `json:"account_id"`
+	RoleName  string `json:"role_name"`
+	Region    string `json:"region,omitempty"`
+}
+
+// SSOProfile is a stored SSO profile and the expiry of its role session
+type SSOProfile struct {
+	Profile    string    `json:"profile"`
+	AccountID  string    `json:"account_id"`
+	RoleName   string    `json:"role_name"`
+	Expiration time.Time `json:"expiration"`
+}
+
+// CreateBucketInput describes a bucket to create
+type CreateBucketInput struct {
+	Profile           string            `json:"profile,omitempty"`
+	BucketName        string            `json:"bucket_name"`
+	Region            string            `json:"region,omitempty"`
+	Encryption        BucketEncryption  `json:"encryption"`
+	VersioningEnabled bool              `json:"versioning_enabled"`
+	Tags              map[string]string `json:"tags,omitempty"`
+}
+
+// BucketEncryption is a bucket's default encryption
+type BucketEncryption struct {
+	Type     string `json:"type"` // AES256 or aws:kms
+	KMSKeyID string `json:"kms_key_id,omitempty"`
+}
+
+// Bucket is a newly created S3 bucket
+type Bucket struct {
+	BucketName string    `json:"bucket_name"`
+	Region     string    `json:"region"`
+	Location   string    `json:"location"`
+	CreatedAt  time.Time `json:"created_at"`
+
+	// Steps lists every step of the creation and the baseline applied to
+	// the bucket. BaselineIncomplete is set when one failed and the agent
+	// is configured to keep the bucket, tagged ark:baseline=incomplete.
+	Steps              []OperationStep `json:"steps"`
+	BaselineIncomplete bool            `json:"baseline_incomplete,omitempty"`
+}
+
+// OperationStep is the outcome of one step of a multi-step operation, or
+// of a compensating action undoing one after a later step failed
+type OperationStep struct {
+	Name         string `json:"name"`
+	Status       string `json:"status"` // success, failure, or interrupted
+	Error        string `json:"error,omitempty"`
+	Compensation bool   `json:"compensation,omitempty"`
+}
+
+// BucketSummary is a bucket in a listing
+type BucketSummary struct {
+	Name      string    `json:"name"`
+	Region    string    `json:"region,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BucketEncryptionDetails is a bucket's default encryption as described
+type BucketEncryptionDetails struct {
+	Type             string `json:"type"` // AES256, aws:kms, aws:kms:dsse, or none
+	KMSKeyID         string `json:"kms_key_id,omitempty"`
+	BucketKeyEnabled bool   `json:"bucket_key_enabled,omitempty"`
+}
+
+// PublicAccessBlock is a bucket's public access block configuration
+type PublicAccessBlock struct {
+	BlockPublicACLs       bool `json:"block_public_acls"`
+	IgnorePublicACLs      bool `json:"ignore_public_acls"`
+	BlockPublicPolicy     bool `json:"block_public_policy"`
+	RestrictPublicBuckets bool `json:"restrict_public_buckets"`
+}
+
+// BucketDetails describes a bucket's configuration. PublicAccessBlock is nil
+// when the bucket has no configuration of its own.
+type BucketDetails struct {
+	Name              string                  `json:"name"`
+	Region            string                  `json:"region"`
+	Encryption        BucketEncryptionDetails `json:"encryption"`
+	Versioning        string                  `json:"versioning"` // Enabled, Suspended, or Disabled
+	PublicAccessBlock *PublicAccessBlock      `json:"public_access_block"`
+	Tags              map[string]string       `json:"tags"`
+	Policy            string                  `json:"policy,omitempty"`
+}
+
+// DeletedBucket is the result of deleting a bucket
+type DeletedBucket struct {
+	BucketName     string `json:"bucket_name"`
+	Region         string `json:"region"`
+	ObjectsDeleted int    `json:"objects_deleted"`
+}
+
+// PolicyCheck asks the backend whether a user may perform an action
+type PolicyCheck struct {
+	UserID          string                 `json:"user_id"`
+	Action          string                 `json:"action"`
+	ResourceType    string                 `json:"resource_type,omitempty"`
+	ResourceDetails map[string]interface{} `json:"resource_details,omitempty"`
+}
+
+// AuditQuery filters audit log entries
+type AuditQuery struct {
+	UserID       string
+	Action       string
+	ResourceType string
+	Status       string
+}
+
+// TransferInput copies a file to or from S3. One of Source and Destination
+// is an s3://bucket/key URL and the other an absolute local path.
+type TransferInput struct {
+	Profile     string `json:"profile,omitempty"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Workers     int    `json:"workers,omitempty"`      // parts transferred at once (default 4)
+	PartSizeMB  int64  `json:"part_size_mb,omitempty"` // part size for multipart transfers (default 64)
+}
+
+// TransferResult is the result of a completed transfer job
+type TransferResult struct {
+	Bucket       string `json:"bucket"`
+	Key          string `json:"key"`
+	LocalPath    string `json:"local_path"`
+	Size         int64  `json:"size"`
+	Parts        int    `json:"parts"`
+	PartsResumed int    `json:"parts_resumed"`
+	ETag         string `json:"etag,omitempty"`
+}
+
+// SyncInput syncs a local directory with an S3 prefix. One of Source and
+// Destination is an s3://bucket/prefix URL and the other an absolute local
+// directory.
+type SyncInput struct {
+	Profile     string   `json:"profile,omitempty"`
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Include     []string `json:"include,omitempty"` // glob patterns; empty means everything
+	Exclude     []string `json:"exclude,omitempty"`
+	Delete      bool     `json:"delete,omitempty"`  // delete destination files not at the source
+	DryRun      bool     `json:"dry_run,omitempty"` // only work out the plan
+	Workers     int      `json:"workers,omitempty"`
+	PartSizeMB  int64    `json:"part_size_mb,omitempty"`
+}
+
+// SyncAction is one change in a sync plan
+type SyncAction struct {
+	Action string `json:"action"` // upload, download, or delete
+	Path   string `json:"path"`   // relative to the local directory and prefix
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"` // new, size, modified, or extraneous
+}
+
+// SyncFailure is a change that a sync could not make
+type SyncFailure struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+	Error  string `json:"error"`
+}
+
+// SyncResult is the result of a sync job. A sync with failures still
+// reports the changes it made.
+type SyncResult struct {
+	Direction  string        `json:"direction"`
+	LocalDir   string        `json:"local_dir"`
+	Bucket     string        `json:"bucket"`
+	Prefix     string        `json:"prefix"`
+	DryRun     bool          `json:"dry_run"`
+	Plan       []SyncAction  `json:"plan"`
+	Unchanged  int           `json:"unchanged"`
+	Uploaded   int           `json:"uploaded"`
+	Downloaded int           `json:"downloaded"`
+	Deleted    int           `json:"deleted"`
+	Bytes      int64         `json:"bytes"`
+	Failures   []SyncFailure `json:"failures,omitempty"`
+}
+
+// Job states
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCanceled  = "canceled"
+)
+
+// JobProgress is how far a job has got
+type JobProgress struct {
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Enrollment is the backend policy key the agent has pinned. The agent
+// only accepts training gate decisions signed with it.
+type Enrollment struct {
+	Algorithm  string    `json:"algorithm"`
+	KeyID      string    `json:"key_id"`
+	PublicKey  string    `json:"public_key"`
+	BackendURL string    `json:"backend_url"`
+	EnrolledAt time.Time `json:"enrolled_at"`
+}
+
+// Job is a long-running operation in the agent. Result holds the job's
+// result as JSON once it has succeeded, e.g. a TransferResult or SyncResult.
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Status      string          `json:"status"`
+	Progress    JobProgress     `json:"progress"`
+	Error       string          `json:"error,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+
+	// Resumed counts the times the job was picked up again after the
+	// agent restarted
+	Resumed int `json:"resumed,omitempty"`
+}
+
+// Done reports whether the job has finished
+func (j *Job) Done() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}